@@ -0,0 +1,91 @@
+// Command smoke loads the plugin through the Yaegi interpreter the way
+// Traefik does, without the test files and testify, and exercises it against
+// a fake Traefik API and webhook device. It catches constructs that work with
+// go test but fail in Traefik. Run it with "make yaegi_smoke".
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+
+	"github.com/horknfbr/traefikunifidns"
+)
+
+func main() {
+	traefik := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/http/routers" {
+			http.NotFound(w, r)
+			return
+		}
+		_ = json.NewEncoder(w).Encode([]map[string]interface{}{
+			{"name": "app", "rule": "Host(`app.lan`)", "middlewares": []string{"traefikunifidns"}},
+		})
+	}))
+	defer traefik.Close()
+
+	var mu sync.Mutex
+	var changes []map[string]interface{}
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var change map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&change); err != nil {
+			fail("decoding webhook change: %v", err)
+		}
+		mu.Lock()
+		changes = append(changes, change)
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer webhook.Close()
+
+	config := traefikunifidns.CreateConfig()
+	config.TraefikAPIURL = traefik.URL
+	config.ConfigVersion = 2
+	config.Devices = []traefikunifidns.UnifiDeviceConfig{{WebhookURL: webhook.URL, Patterns: []string{`\.lan$`}}}
+	config.IPOverrides = map[string]string{"app.lan": "192.168.1.10"}
+	config.AdminPath = "/.unifidns"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	handler, err := traefikunifidns.New(ctx, next, config, "smoke")
+	if err != nil {
+		fail("creating the plugin: %v", err)
+	}
+
+	mu.Lock()
+	published := len(changes)
+	mu.Unlock()
+	if published != 1 {
+		fail("expected 1 record change on startup, got %d", published)
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusTeapot {
+		fail("expected requests to reach the next handler, got status %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/.unifidns/status", nil))
+	var status map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil || w.Code != http.StatusOK {
+		fail("reading the status document: %d %v", w.Code, err)
+	}
+	if status["cycles"] != float64(1) {
+		fail("expected 1 cycle in the status document, got %v", status["cycles"])
+	}
+
+	log.Printf("Yaegi smoke test passed")
+}
+
+func fail(format string, args ...interface{}) {
+	log.Printf("FAIL: "+format, args...)
+	os.Exit(1)
+}
@@ -0,0 +1,580 @@
+package traefikunifidns
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// syncStats tracks the outcome of update cycles for the admin endpoints. It
+// has its own lock so status requests never wait for a running cycle.
+type syncStats struct {
+	mu          sync.Mutex
+	startedAt   time.Time
+	cycles      int // Full cycles only, like failures, lastSuccess and lastError
+	failures    int
+	lastSuccess time.Time
+	lastError   string
+	stale       bool
+	actions     map[string]actionCounts // Outcomes of all cycles by device
+	lastCycle   *cycleSummary
+	records     []recordMapping // Of the last complete cycle
+
+	discoveries   int // Polls of the Traefik routers between full cycles
+	lastDiscovery time.Time
+
+	targetedCycles   int // Cycles limited to some hostnames
+	targetedFailures int
+
+	schedule scheduleState
+	nodes    map[string]bool // Health of the targetIPs by address, with nodeHealthCheck
+}
+
+// statusDocument is the JSON document served by the status endpoint.
+type statusDocument struct {
+	Name          string          `json:"name"`
+	Build         buildInfo       `json:"build"`
+	Cycles        int             `json:"cycles"`
+	Failures      int             `json:"failures"`
+	Targeted      targetedStatus  `json:"targeted"`
+	LastSuccess   time.Time       `json:"lastSuccess"`
+	LastError     string          `json:"lastError,omitempty"`
+	Stale         bool            `json:"stale"`
+	LastCycle     *cycleSummary   `json:"lastCycle,omitempty"`
+	Discoveries   int             `json:"discoveries"`
+	LastDiscovery time.Time       `json:"lastDiscovery"`
+	LastUpdate    time.Time       `json:"lastUpdate"` // End of the last cycle, successful or not
+	NextUpdate    time.Time       `json:"nextUpdate"` // Of the next periodic update, zero while paused
+	Schedule      scheduleStatus  `json:"schedule"`
+	Nodes         map[string]bool `json:"nodes,omitempty"` // Health of the targetIPs, with nodeHealthCheck
+	Devices       []deviceStatus  `json:"devices"`
+	Records       []recordMapping `json:"records"`
+}
+
+// targetedStatus counts the cycles limited to some hostnames, which don't
+// count towards the cycles, failures and staleness of full cycles.
+type targetedStatus struct {
+	Cycles   int `json:"cycles"`
+	Failures int `json:"failures"`
+}
+
+// recordMapping is a row of the hostname to device table in the status
+// document.
+type recordMapping struct {
+	Hostname      string `json:"hostname"`
+	Device        string `json:"device,omitempty"`
+	Type          string `json:"type"`
+	Target        string `json:"target,omitempty"`
+	LastAction    string `json:"lastAction"`
+	Reason        string `json:"reason,omitempty"`
+	NotPropagated bool   `json:"notPropagated,omitempty"` // Written, but verifyResolver didn't serve it in time
+}
+
+// newRecordMappings returns the table rows of the hostnames of a cycle.
+// Deleted records are left out.
+func newRecordMappings(results []hostResult) []recordMapping {
+	records := make([]recordMapping, 0, len(results))
+	for _, result := range results {
+		if result.Action == resultDeleted {
+			continue
+		}
+		recordType := result.Type
+		if recordType == "" {
+			recordType = "A"
+		}
+		records = append(records, recordMapping{
+			Hostname:      result.Hostname,
+			Device:        result.Device,
+			Type:          recordType,
+			Target:        result.Value,
+			LastAction:    result.Action,
+			Reason:        result.Reason,
+			NotPropagated: result.NotPropagated,
+		})
+	}
+	return records
+}
+
+// sync runs one DNS update cycle bounded by the cycle timeout, records its
+// outcome and reports it to the heartbeat URL, MQTT broker, cycle report,
+// error reporter and Pushgateway if configured. The records of successful
+// cycles are exported to files.
+func (r *reconciler) sync(ctx context.Context) SyncResult {
+	return r.syncHostnames(ctx, nil)
+}
+
+// syncHostnames is sync limited to the given hostnames, or a full cycle if
+// hostnames is nil. Targeted cycles export no records and leave the records
+// table of the status document alone, as they only cover some hostnames.
+func (r *reconciler) syncHostnames(ctx context.Context, hostnames []string) SyncResult {
+	cycleCtx := ctx
+	if r.cycleTimeout > 0 {
+		var cancel context.CancelFunc
+		cycleCtx, cancel = context.WithTimeout(ctx, r.cycleTimeout)
+		defer cancel()
+	}
+	result := r.updateHostnames(cycleCtx, hostnames)
+	results, err := result.Hosts, result.Err
+	summary := result.summary()
+	log.Printf("INFO: Cycle summary: %s", summary)
+
+	// A cycle that ran into cycleTimeout is still reported, so the heartbeat
+	// is only bound to ctx
+	if r.heartbeat != nil {
+		if hbErr := r.heartbeat.ping(ctx, err != nil); hbErr != nil {
+			logError("%v", hbErr)
+		}
+	}
+	if r.mqtt != nil {
+		if mqttErr := r.mqtt.publishCycle(results, summary); mqttErr != nil {
+			logError("Failed to publish DNS change events to MQTT: %v", mqttErr)
+		}
+	}
+	if r.report != nil {
+		if reportErr := r.report.write(summary, results); reportErr != nil {
+			logError("Failed to write cycle report: %v", reportErr)
+		}
+	}
+	if r.audit != nil {
+		if auditErr := r.audit.write(results, summary.Time); auditErr != nil {
+			logError("Failed to write audit log: %v", auditErr)
+		}
+	}
+	if r.errorReporter != nil {
+		r.reportErrors(results, err)
+	}
+	if err == nil && hostnames == nil {
+		r.exportRecords(results)
+	}
+
+	r.recordCycle(result)
+	if hostnames == nil {
+		r.watchStaleness()
+	}
+
+	if r.pushgateway != nil {
+		if pushErr := r.pushgateway.push(r.metrics()); pushErr != nil {
+			logError("%v", pushErr)
+		}
+	}
+	return result
+}
+
+// recordCycle updates the sync stats and action counters with the outcome of
+// a cycle. Targeted cycles only cover some hostnames, so they are counted
+// apart and leave the last success, last error and staleness alone.
+func (r *reconciler) recordCycle(result SyncResult) {
+	r.stats.mu.Lock()
+	defer r.stats.mu.Unlock()
+	summary := result.summary()
+	r.stats.lastCycle = &summary
+	if r.stats.actions == nil {
+		r.stats.actions = make(map[string]actionCounts)
+	}
+	for _, host := range result.Hosts {
+		counts := r.stats.actions[host.Device]
+		counts.add(host)
+		r.stats.actions[host.Device] = counts
+	}
+	if result.Err == nil && result.Hostnames == nil {
+		r.stats.records = newRecordMappings(result.Hosts)
+	}
+	r.stats.schedule.lastUpdate = time.Now()
+	if result.Hostnames != nil {
+		r.stats.targetedCycles++
+		if result.Err != nil {
+			r.stats.targetedFailures++
+		}
+		return
+	}
+	r.stats.cycles++
+	if err := result.Err; err != nil {
+		r.stats.failures++
+		r.stats.lastError = err.Error()
+	} else {
+		r.stats.lastSuccess = time.Now()
+		r.stats.lastError = ""
+	}
+}
+
+// watchStaleness raises an alert when the last successful update became older
+// than maxStaleness, and again once updates recover. Alerts are logged, sent
+// to the error reporter and published to MQTT if configured. It runs after
+// every full cycle and on a ticker of the update loop, so updates that stop
+// altogether, e.g. while paused, are noticed too.
+func (r *reconciler) watchStaleness() {
+	r.stats.mu.Lock()
+	stale := r.isStale(time.Now())
+	changed := stale != r.stats.stale
+	r.stats.stale = stale
+	since := r.lastSuccessOrStart()
+	r.stats.mu.Unlock()
+	if !changed {
+		return
+	}
+
+	if stale {
+		detail := fmt.Sprintf("since %s (maxStaleness: %s)", since.Format(time.RFC3339), r.maxStaleness)
+		log.Printf("ERROR: STALE: No successful DNS update %s", detail)
+		if r.errorReporter != nil {
+			event := newErrorEvent(fmt.Errorf("no successful DNS update %s", detail), "", "")
+			if reportErr := r.errorReporter.report(event); reportErr != nil {
+				logError("Failed to report error: %v", reportErr)
+			}
+		}
+	} else {
+		log.Printf("INFO: DNS updates recovered from stale state")
+	}
+	if r.mqtt != nil {
+		if mqttErr := r.mqtt.publishStaleness(stale, since, r.maxStaleness); mqttErr != nil {
+			logError("Failed to publish staleness to MQTT: %v", mqttErr)
+		}
+	}
+}
+
+// stalenessCheckInterval returns how often the update loop checks for stale
+// updates, a fraction of maxStaleness so an alert is raised soon after it
+// passed, but at least every minute. It is zero without maxStaleness.
+func (r *reconciler) stalenessCheckInterval() time.Duration {
+	if r.maxStaleness <= 0 {
+		return 0
+	}
+	if interval := r.maxStaleness / 4; interval < time.Minute {
+		return interval
+	}
+	return time.Minute
+}
+
+// isStale reports whether the last successful update, or the plugin start if
+// there was none, is older than maxStaleness. It expects u.stats.mu to be held.
+func (r *reconciler) isStale(now time.Time) bool {
+	if r.maxStaleness <= 0 {
+		return false
+	}
+	return now.Sub(r.lastSuccessOrStart()) > r.maxStaleness
+}
+
+func (r *reconciler) lastSuccessOrStart() time.Time {
+	if r.stats.lastSuccess.IsZero() {
+		return r.stats.startedAt
+	}
+	return r.stats.lastSuccess
+}
+
+// requestSync queues an update cycle for the update loop. It returns false if
+// a cycle is already queued.
+func (r *reconciler) requestSync() bool {
+	select {
+	case r.syncCh <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (u *UniFiDNS) status() statusDocument {
+	status := u.reconciler.status()
+	status.Name = u.name
+	return status
+}
+
+func (r *reconciler) status() statusDocument {
+	devices := make([]deviceStatus, 0, len(r.config.Devices))
+	for i, device := range r.config.Devices {
+		clientID := fmt.Sprintf("device-%d", i)
+		if provider, ok := r.providers[clientID]; ok {
+			host := device.Host
+			if device.WebhookURL != "" {
+				host = device.WebhookURL
+			}
+			status := provider.health().snapshot(clientID, host)
+			if client, ok := provider.(*UniFiClient); ok {
+				status.StaticDNS = client.staticDNSState()
+			}
+			devices = append(devices, status)
+		}
+	}
+
+	r.stats.mu.Lock()
+	defer r.stats.mu.Unlock()
+	records := r.stats.records
+	if records == nil {
+		records = []recordMapping{}
+	}
+	var nextUpdate time.Time
+	if !r.stats.schedule.paused {
+		nextUpdate = r.stats.schedule.nextUpdate
+	}
+	return statusDocument{
+		Build:         currentBuild(),
+		Cycles:        r.stats.cycles,
+		Failures:      r.stats.failures,
+		Targeted:      targetedStatus{Cycles: r.stats.targetedCycles, Failures: r.stats.targetedFailures},
+		LastSuccess:   r.stats.lastSuccess,
+		LastError:     r.stats.lastError,
+		Stale:         r.isStale(time.Now()),
+		LastCycle:     r.stats.lastCycle,
+		Discoveries:   r.stats.discoveries,
+		LastDiscovery: r.stats.lastDiscovery,
+		LastUpdate:    r.stats.schedule.lastUpdate,
+		NextUpdate:    nextUpdate,
+		Schedule:      r.scheduleStatus(),
+		Nodes:         r.stats.nodes,
+		Devices:       devices,
+		Records:       records,
+	}
+}
+
+func (u *UniFiDNS) isAdminRequest(req *http.Request) bool {
+	prefix := strings.TrimSuffix(u.config.AdminPath, "/")
+	if prefix == "" {
+		return false
+	}
+	return req.URL.Path == prefix || strings.HasPrefix(req.URL.Path, prefix+"/")
+}
+
+// serveAdmin handles the status, metrics, match, sync and schedule endpoints
+// below AdminPath.
+func (u *UniFiDNS) serveAdmin(rw http.ResponseWriter, req *http.Request) {
+	if !u.adminAuthorized(req) {
+		log.Printf("WARN: Rejected unauthorized admin request: %s %s", req.Method, req.URL.Path)
+		if u.config.AdminUsername != "" {
+			rw.Header().Set("WWW-Authenticate", `Basic realm="traefikunifidns"`)
+		}
+		http.Error(rw, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	rw.Header().Set(versionHeader, Version)
+
+	endpoint := strings.TrimPrefix(req.URL.Path, strings.TrimSuffix(u.config.AdminPath, "/"))
+	switch endpoint {
+	case "/status":
+		rw.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(rw).Encode(u.status()); err != nil {
+			log.Printf("ERROR: Failed to encode status: %v", err)
+		}
+	case "/metrics":
+		rw.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		if _, err := io.WriteString(rw, u.metrics()); err != nil {
+			log.Printf("ERROR: Failed to write metrics: %v", err)
+		}
+	case "/match":
+		hostname := req.URL.Query().Get("hostname")
+		if hostname == "" {
+			http.Error(rw, "missing hostname", http.StatusBadRequest)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(rw).Encode(u.explain(req.Context(), hostname)); err != nil {
+			log.Printf("ERROR: Failed to encode match report: %v", err)
+		}
+	case "/sync":
+		if req.Method != http.MethodPost {
+			rw.Header().Set("Allow", http.MethodPost)
+			http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if u.requestSync() {
+			log.Printf("INFO: DNS update requested through admin endpoint")
+		}
+		rw.WriteHeader(http.StatusAccepted)
+	case "/pause", "/resume", "/interval":
+		u.serveSchedule(rw, req, endpoint)
+	default:
+		http.NotFound(rw, req)
+	}
+}
+
+// defaultSyncHeader is the request header checked for the sync token.
+const defaultSyncHeader = "X-UniFiDNS-Sync"
+
+// checkSyncHeader queues an update when req carries the configured sync
+// token, so that deployment pipelines can refresh DNS with any request through
+// the middleware. The header is removed before the request is passed on, so
+// the token never reaches the service.
+func (u *UniFiDNS) checkSyncHeader(req *http.Request) {
+	if u.syncToken.empty() {
+		return
+	}
+	header := u.config.SyncHeader
+	if header == "" {
+		header = defaultSyncHeader
+	}
+	token := req.Header.Get(header)
+	if token == "" {
+		return
+	}
+	req.Header.Del(header)
+	if subtle.ConstantTimeCompare([]byte(token), []byte(u.syncToken.reveal())) != 1 {
+		log.Printf("WARN: Ignoring %s header with an invalid token", header)
+		return
+	}
+	if u.requestSync() {
+		log.Printf("INFO: DNS update requested through %s header", header)
+	}
+}
+
+// debugStatusHeader reports the sync health of the requested hostname on
+// proxied responses when debugHeader is enabled.
+const debugStatusHeader = "X-UniFiDNS-Status"
+
+// Values of the debug status header.
+const (
+	debugStatusOK    = "ok"
+	debugStatusStale = "stale"
+	debugStatusError = "error"
+)
+
+// setDebugHeader adds the sync health of the requested hostname to the
+// response, so a curl from a client shows whether DNS for the route is in
+// order.
+func (u *UniFiDNS) setDebugHeader(rw http.ResponseWriter, req *http.Request) {
+	if !u.config.DebugHeader {
+		return
+	}
+	hostname := req.Host
+	if host, _, err := net.SplitHostPort(hostname); err == nil {
+		hostname = host
+	}
+	rw.Header().Set(debugStatusHeader, u.hostStatus(hostname))
+}
+
+// hostStatus reports error when the last cycle failed or the record of
+// hostname failed in the last complete cycle, stale when the last successful
+// update is older than maxStaleness and ok otherwise. It only takes the
+// stats lock, so requests never wait for a running cycle.
+func (r *reconciler) hostStatus(hostname string) string {
+	r.stats.mu.Lock()
+	defer r.stats.mu.Unlock()
+	if r.stats.lastError != "" {
+		return debugStatusError
+	}
+	for _, record := range r.stats.records {
+		if strings.EqualFold(record.Hostname, hostname) && record.LastAction == resultFailed {
+			return debugStatusError
+		}
+	}
+	if r.isStale(time.Now()) {
+		return debugStatusStale
+	}
+	return debugStatusOK
+}
+
+// adminAuthorized checks the request against the configured bearer token or
+// basic auth credentials. Without any configured credentials every request is
+// allowed.
+func (u *UniFiDNS) adminAuthorized(req *http.Request) bool {
+	username := u.config.AdminUsername
+	if u.adminToken.empty() && username == "" {
+		return true
+	}
+
+	if !u.adminToken.empty() {
+		if bearer, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer "); ok &&
+			subtle.ConstantTimeCompare([]byte(bearer), []byte(u.adminToken.reveal())) == 1 {
+			return true
+		}
+	}
+
+	if username != "" && !u.adminPassword.empty() {
+		if user, pass, ok := req.BasicAuth(); ok &&
+			subtle.ConstantTimeCompare([]byte(user), []byte(username)) == 1 &&
+			subtle.ConstantTimeCompare([]byte(pass), []byte(u.adminPassword.reveal())) == 1 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// metrics returns the sync counters in the Prometheus text format.
+func (r *reconciler) metrics() string {
+	status := r.status()
+	lastSuccess := float64(0)
+	if !status.LastSuccess.IsZero() {
+		lastSuccess = float64(status.LastSuccess.Unix())
+	}
+
+	var b strings.Builder
+	writeMetric(&b, "unifidns_sync_cycles_total", "counter", "Total number of DNS update cycles.", float64(status.Cycles))
+	writeMetric(&b, "unifidns_sync_failures_total", "counter", "Total number of failed DNS update cycles.", float64(status.Failures))
+	writeMetric(&b, "unifidns_targeted_cycles_total", "counter", "Total number of DNS update cycles limited to some hostnames.", float64(status.Targeted.Cycles))
+	writeMetric(&b, "unifidns_targeted_failures_total", "counter", "Total number of failed DNS update cycles limited to some hostnames.", float64(status.Targeted.Failures))
+	writeMetric(&b, "unifidns_last_success_timestamp_seconds", "gauge", "Unix time of the last successful DNS update cycle.", lastSuccess)
+	writeMetric(&b, "unifidns_stale", "gauge", "Whether the last successful DNS update is older than maxStaleness.", boolToFloat(status.Stale))
+	fmt.Fprintf(&b, "# HELP unifidns_build_info Build of the plugin, always 1.\n# TYPE unifidns_build_info gauge\n")
+	fmt.Fprintf(&b, "unifidns_build_info{%s} 1\n", formatLabels([]string{"version", status.Build.Version, "goversion", status.Build.GoVersion}))
+	writeMetric(&b, "unifidns_discoveries_total", "counter", "Total number of polls for changed Traefik routers between update cycles.", float64(status.Discoveries))
+
+	r.writeActionCounters(&b)
+
+	var latency, size []histogramSeries
+	for _, device := range status.Devices {
+		endpoints, latencies, sizes := r.providers[device.ID].health().endpointHistograms()
+		for i, endpoint := range endpoints {
+			labels := []string{"device", device.ID, "host", device.Host, "endpoint", endpoint}
+			latency = append(latency, histogramSeries{labels: labels, hist: latencies[i]})
+			size = append(size, histogramSeries{labels: labels, hist: sizes[i]})
+		}
+	}
+	if r.traefikClient != nil {
+		endpoints, latencies, sizes := r.traefikClient.stats.endpointHistograms()
+		for i, endpoint := range endpoints {
+			labels := []string{"device", "traefik", "host", r.traefikClient.baseURL, "endpoint", endpoint}
+			latency = append(latency, histogramSeries{labels: labels, hist: latencies[i]})
+			size = append(size, histogramSeries{labels: labels, hist: sizes[i]})
+		}
+	}
+	writeHistogram(&b, "unifidns_api_request_duration_seconds", "Latency of API requests by device and endpoint.", latency)
+	writeHistogram(&b, "unifidns_api_response_size_bytes", "Size of API responses by device and endpoint.", size)
+	return b.String()
+}
+
+// writeActionCounters appends the outcomes of all cycles by device and action.
+// Hostnames no device pattern matches are counted with an empty device.
+func (r *reconciler) writeActionCounters(b *strings.Builder) {
+	r.stats.mu.Lock()
+	defer r.stats.mu.Unlock()
+	if len(r.stats.actions) == 0 {
+		return
+	}
+	devices := make([]string, 0, len(r.stats.actions))
+	for device := range r.stats.actions {
+		devices = append(devices, device)
+	}
+	sort.Strings(devices)
+
+	const name = "unifidns_record_actions_total"
+	fmt.Fprintf(b, "# HELP %s Total number of hostname outcomes by device and action.\n", name)
+	fmt.Fprintf(b, "# TYPE %s counter\n", name)
+	for _, device := range devices {
+		actions, counts := r.stats.actions[device].byAction()
+		for i, action := range actions {
+			fmt.Fprintf(b, "%s{%s} %d\n", name, formatLabels([]string{"device", device, "action", action}), counts[i])
+		}
+	}
+}
+
+// writeMetric appends a single metric in the Prometheus text format.
+func writeMetric(b *strings.Builder, name, metricType, help string, value float64) {
+	fmt.Fprintf(b, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(b, "# TYPE %s %s\n", name, metricType)
+	fmt.Fprintf(b, "%s %s\n", name, strconv.FormatFloat(value, 'f', -1, 64))
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,425 @@
+package traefikunifidns
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestAdminPlugin(config *Config) *UniFiDNS {
+	return &UniFiDNS{
+		reconciler: &reconciler{
+			config:        config,
+			syncCh:        make(chan struct{}, 1),
+			adminToken:    newSecret(config.AdminToken),
+			adminPassword: newSecret(config.AdminPassword),
+			syncToken:     newSecret(config.SyncToken),
+		},
+		next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+		}),
+		name: "test",
+	}
+}
+
+func TestAdminDisabled(t *testing.T) {
+	u := newTestAdminPlugin(CreateConfig())
+
+	w := httptest.NewRecorder()
+	u.ServeHTTP(w, httptest.NewRequest("GET", "/status", nil))
+	assert.Equal(t, http.StatusTeapot, w.Code)
+}
+
+func TestAdminPassthrough(t *testing.T) {
+	config := CreateConfig()
+	config.AdminPath = "/.unifidns"
+	u := newTestAdminPlugin(config)
+
+	for _, path := range []string{"/", "/app", "/.unifidnsx/status"} {
+		w := httptest.NewRecorder()
+		u.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		assert.Equal(t, http.StatusTeapot, w.Code, path)
+	}
+}
+
+func TestAdminAuthentication(t *testing.T) {
+	config := CreateConfig()
+	config.AdminPath = "/.unifidns/"
+	config.AdminToken = "secret-token"
+	config.AdminUsername = "admin"
+	config.AdminPassword = "secret-password"
+	u := newTestAdminPlugin(config)
+
+	tests := []struct {
+		name   string
+		setup  func(r *http.Request)
+		status int
+	}{
+		{
+			name:   "no credentials",
+			setup:  func(r *http.Request) {},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "wrong token",
+			setup:  func(r *http.Request) { r.Header.Set("Authorization", "Bearer wrong") },
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "valid token",
+			setup:  func(r *http.Request) { r.Header.Set("Authorization", "Bearer secret-token") },
+			status: http.StatusOK,
+		},
+		{
+			name:   "wrong password",
+			setup:  func(r *http.Request) { r.SetBasicAuth("admin", "wrong") },
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "valid basic auth",
+			setup:  func(r *http.Request) { r.SetBasicAuth("admin", "secret-password") },
+			status: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/.unifidns/status", nil)
+			tt.setup(req)
+			w := httptest.NewRecorder()
+			u.ServeHTTP(w, req)
+			assert.Equal(t, tt.status, w.Code)
+			if tt.status == http.StatusUnauthorized {
+				assert.NotEmpty(t, w.Header().Get("WWW-Authenticate"))
+				assert.Empty(t, w.Header().Get(versionHeader), "the version isn't disclosed without credentials")
+			}
+		})
+	}
+}
+
+func TestAdminStatus(t *testing.T) {
+	config := CreateConfig()
+	config.AdminPath = "/.unifidns"
+	u := newTestAdminPlugin(config)
+	u.stats.cycles = 3
+	u.stats.failures = 1
+	u.stats.lastError = "failed to get Traefik routers"
+
+	w := httptest.NewRecorder()
+	u.ServeHTTP(w, httptest.NewRequest("GET", "/.unifidns/status", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.Equal(t, Version, w.Header().Get(versionHeader))
+
+	var status statusDocument
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&status))
+	assert.Equal(t, "test", status.Name)
+	assert.Equal(t, Version, status.Build.Version)
+	assert.Equal(t, runtime.Version(), status.Build.GoVersion)
+	assert.Equal(t, 3, status.Cycles)
+	assert.Equal(t, 1, status.Failures)
+	assert.Equal(t, "failed to get Traefik routers", status.LastError)
+	assert.Empty(t, status.Records)
+}
+
+func TestStatusRecords(t *testing.T) {
+	u := newTestAdminPlugin(CreateConfig())
+	u.recordCycle(newSyncResult(time.Now(), []hostResult{
+		{Hostname: "app.lan", Device: "https://unifi.lan", Action: recordUnchanged, Value: "192.168.1.10"},
+		{Hostname: "old.lan", Device: "https://unifi.lan", Action: resultDeleted},
+		{Hostname: "other.com", Action: resultSkipped, Reason: reasonNoMatch},
+	}, nil))
+	expected := []recordMapping{
+		{Hostname: "app.lan", Device: "https://unifi.lan", Type: "A", Target: "192.168.1.10", LastAction: recordUnchanged},
+		{Hostname: "other.com", Type: "A", LastAction: resultSkipped, Reason: reasonNoMatch},
+	}
+	assert.Equal(t, expected, u.status().Records)
+
+	// Aborted cycles keep the table of the last complete one
+	u.recordCycle(newSyncResult(time.Now(), nil, errors.New("DNS update cycle aborted")))
+	assert.Equal(t, expected, u.status().Records)
+}
+
+func TestAdminMetrics(t *testing.T) {
+	config := CreateConfig()
+	config.AdminPath = "/.unifidns"
+	u := newTestAdminPlugin(config)
+	u.stats.cycles = 5
+	u.stats.failures = 2
+
+	w := httptest.NewRecorder()
+	u.ServeHTTP(w, httptest.NewRequest("GET", "/.unifidns/metrics", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	body := w.Body.String()
+	assert.Contains(t, body, "# TYPE unifidns_sync_cycles_total counter\n")
+	assert.Contains(t, body, "unifidns_sync_cycles_total 5\n")
+	assert.Contains(t, body, "unifidns_sync_failures_total 2\n")
+	assert.Contains(t, body, "unifidns_last_success_timestamp_seconds 0\n")
+	assert.Contains(t, body, `unifidns_build_info{version="`+Version+`",goversion="`+runtime.Version()+`"} 1`+"\n")
+	assert.NotContains(t, body, "unifidns_record_actions_total")
+
+	u.recordCycle(newSyncResult(time.Now(), []hostResult{
+		{Hostname: "a.lan", Device: "https://unifi.lan", Action: recordCreated},
+		{Hostname: "other.com", Action: resultSkipped, Reason: reasonNoMatch},
+	}, nil))
+	u.recordCycle(newSyncResult(time.Now(), []hostResult{{Hostname: "a.lan", Device: "https://unifi.lan", Action: recordCreated}}, nil))
+
+	w = httptest.NewRecorder()
+	u.ServeHTTP(w, httptest.NewRequest("GET", "/.unifidns/metrics", nil))
+	body = w.Body.String()
+	assert.Contains(t, body, "# TYPE unifidns_record_actions_total counter\n")
+	assert.Contains(t, body, `unifidns_record_actions_total{device="https://unifi.lan",action="created"} 2`+"\n")
+	assert.Contains(t, body, `unifidns_record_actions_total{device="https://unifi.lan",action="failed"} 0`+"\n")
+	assert.Contains(t, body, `unifidns_record_actions_total{device="",action="no_match"} 1`+"\n")
+}
+
+func TestAdminSync(t *testing.T) {
+	config := CreateConfig()
+	config.AdminPath = "/.unifidns"
+	u := newTestAdminPlugin(config)
+
+	w := httptest.NewRecorder()
+	u.ServeHTTP(w, httptest.NewRequest("GET", "/.unifidns/sync", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+
+	w = httptest.NewRecorder()
+	u.ServeHTTP(w, httptest.NewRequest("POST", "/.unifidns/sync", nil))
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Len(t, u.syncCh, 1)
+
+	// A second request while one is queued is coalesced
+	w = httptest.NewRecorder()
+	u.ServeHTTP(w, httptest.NewRequest("POST", "/.unifidns/sync", nil))
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Len(t, u.syncCh, 1)
+
+	w = httptest.NewRecorder()
+	u.ServeHTTP(w, httptest.NewRequest("GET", "/.unifidns/unknown", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestSyncHeader(t *testing.T) {
+	config := CreateConfig()
+	config.SyncToken = "deploy"
+	u := newTestAdminPlugin(config)
+	var forwarded http.Header
+	u.next = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r.Header.Clone()
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-UniFiDNS-Sync", "wrong")
+	w := httptest.NewRecorder()
+	u.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusTeapot, w.Code)
+	assert.Empty(t, forwarded.Get("X-UniFiDNS-Sync"), "the token is never forwarded")
+	assert.Len(t, u.syncCh, 0)
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-UniFiDNS-Sync", "deploy")
+	w = httptest.NewRecorder()
+	u.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusTeapot, w.Code, "the request still reaches the service")
+	assert.Empty(t, forwarded.Get("X-UniFiDNS-Sync"))
+	assert.Len(t, u.syncCh, 1)
+
+	// Custom header name
+	<-u.syncCh
+	config.SyncHeader = "X-Deploy"
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-UniFiDNS-Sync", "deploy")
+	u.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Len(t, u.syncCh, 0)
+	req.Header.Set("X-Deploy", "deploy")
+	u.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Len(t, u.syncCh, 1)
+
+	// Disabled without a token
+	<-u.syncCh
+	u.syncToken = newSecret("")
+	u.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Len(t, u.syncCh, 0)
+}
+
+func TestDebugHeader(t *testing.T) {
+	config := CreateConfig()
+	u := newTestAdminPlugin(config)
+	status := func(host string) string {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Host = host
+		u.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusTeapot, w.Code)
+		return w.Header().Get("X-UniFiDNS-Status")
+	}
+
+	assert.Empty(t, status("app.lan"), "disabled by default")
+
+	config.DebugHeader = true
+	u.stats.records = []recordMapping{
+		{Hostname: "app.lan", LastAction: recordUnchanged},
+		{Hostname: "nas.lan", LastAction: resultFailed},
+	}
+	assert.Equal(t, "ok", status("app.lan:8443"))
+	assert.Equal(t, "error", status("NAS.lan"))
+
+	u.maxStaleness = time.Minute
+	u.stats.startedAt = time.Now().Add(-time.Hour)
+	assert.Equal(t, "stale", status("app.lan"))
+
+	u.stats.lastError = "failed to collect hostnames"
+	assert.Equal(t, "error", status("app.lan"))
+}
+
+func TestSyncRecordsStats(t *testing.T) {
+	u := newTestAdminPlugin(CreateConfig())
+	u.traefikClient = &TraefikClient{client: &http.Client{}, baseURL: "http://invalid-url-that-will-fail:12345"}
+
+	err := u.sync(context.Background()).Err
+	require.Error(t, err)
+
+	status := u.status()
+	assert.Equal(t, 1, status.Cycles)
+	assert.Equal(t, 1, status.Failures)
+	assert.True(t, strings.Contains(status.LastError, "failed to get Traefik routers") ||
+		strings.Contains(status.LastError, "failed to get local IP"), status.LastError)
+	assert.True(t, status.LastSuccess.IsZero())
+	require.NotNil(t, status.LastCycle)
+	assert.Equal(t, status.LastError, status.LastCycle.Error)
+}
+
+func TestStaleness(t *testing.T) {
+	var logBuf bytes.Buffer
+	log.SetOutput(&logBuf)
+	defer log.SetOutput(os.Stderr)
+
+	u := newTestAdminPlugin(CreateConfig())
+	u.traefikClient = &TraefikClient{client: &http.Client{}, baseURL: "http://invalid-url-that-will-fail:12345"}
+	u.stats.startedAt = time.Now().Add(-2 * time.Minute)
+
+	// Disabled without maxStaleness
+	require.Error(t, u.sync(context.Background()).Err)
+	assert.False(t, u.status().Stale)
+
+	u.maxStaleness = time.Minute
+	require.Error(t, u.sync(context.Background()).Err)
+	assert.True(t, u.status().Stale)
+	assert.Contains(t, logBuf.String(), "ERROR: STALE: No successful DNS update since")
+
+	// The alert is only logged once per stale period
+	logBuf.Reset()
+	require.Error(t, u.sync(context.Background()).Err)
+	assert.NotContains(t, logBuf.String(), "ERROR: STALE")
+
+	u.stats.lastSuccess = time.Now()
+	assert.False(t, u.status().Stale)
+
+	w := httptest.NewRecorder()
+	u.config.AdminPath = "/.unifidns"
+	u.ServeHTTP(w, httptest.NewRequest("GET", "/.unifidns/metrics", nil))
+	assert.Contains(t, w.Body.String(), "unifidns_stale 0\n")
+}
+
+func TestStalenessAlerts(t *testing.T) {
+	addr, _, publishes := newTestMQTTBroker(t, 0)
+	publisher, err := newMQTTPublisher(MQTTConfig{Broker: addr, Topic: "home/dns"})
+	require.NoError(t, err)
+	reporter := &fakeErrorReporter{}
+
+	u := newTestAdminPlugin(CreateConfig())
+	u.errorReporter = reporter
+	u.mqtt = publisher
+	u.maxStaleness = time.Minute
+	u.stats.startedAt = time.Now().Add(-2 * time.Minute)
+
+	u.watchStaleness()
+	require.Len(t, reporter.events, 1)
+	assert.Contains(t, reporter.events[0].Message, "no successful DNS update since")
+	received := <-publishes
+	require.Len(t, received, 1)
+	assert.Equal(t, "home/dns/stale", received[0].topic)
+	var event stalenessEvent
+	require.NoError(t, json.Unmarshal([]byte(received[0].payload), &event))
+	assert.True(t, event.Stale)
+	assert.Equal(t, "1m0s", event.MaxStaleness)
+
+	// Alerts are only raised once per stale period
+	u.mqtt = nil
+	u.watchStaleness()
+	assert.Len(t, reporter.events, 1)
+}
+
+func TestStalenessCheckedWhilePaused(t *testing.T) {
+	reporter := &fakeErrorReporter{}
+	u := newTestAdminPlugin(CreateConfig())
+	u.errorReporter = reporter
+	u.scheduleCh = make(chan struct{}, 1)
+	u.updateInterval = time.Hour
+	u.maxStaleness = 40 * time.Millisecond
+	u.stats.startedAt = time.Now()
+	u.pause(0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		u.updateLoop(ctx)
+		close(done)
+	}()
+	time.Sleep(150 * time.Millisecond)
+	cancel()
+	<-done
+
+	require.Len(t, reporter.events, 1, "no cycle ran, but the alert is raised")
+	assert.Contains(t, reporter.events[0].Message, "maxStaleness: 40ms")
+}
+
+func TestStalenessIgnoresTargetedCycles(t *testing.T) {
+	u := newTestAdminPlugin(CreateConfig())
+	u.traefikClient = &TraefikClient{client: &http.Client{}, baseURL: "http://invalid-url-that-will-fail:12345"}
+	u.stats.startedAt = time.Now().Add(-2 * time.Minute)
+	u.maxStaleness = time.Minute
+
+	require.Error(t, u.sync(context.Background()).Err)
+	u.recordCycle(SyncResult{Hostnames: []string{"app.lan"}})
+
+	status := u.status()
+	assert.True(t, status.Stale)
+	assert.Equal(t, 1, status.Cycles)
+	assert.Equal(t, 1, status.Failures)
+	assert.NotEmpty(t, status.LastError)
+	assert.True(t, status.LastSuccess.IsZero())
+	assert.Equal(t, targetedStatus{Cycles: 1}, status.Targeted)
+	assert.Contains(t, u.metrics(), "unifidns_targeted_cycles_total 1\n")
+}
+
+func TestNewAdminUsernameWithoutPassword(t *testing.T) {
+	config := CreateConfig()
+	config.AdminPath = "/.unifidns"
+	config.AdminUsername = "admin"
+
+	_, err := New(context.Background(), nil, config, "test")
+	assert.EqualError(t, err, "invalid admin credentials: adminUsername without adminPassword")
+}
+
+func TestNewInvalidMaxStaleness(t *testing.T) {
+	config := CreateConfig()
+	config.MaxStaleness = "soon"
+
+	_, err := New(context.Background(), nil, config, "test")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid max staleness")
+}
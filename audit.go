@@ -0,0 +1,102 @@
+package traefikunifidns
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// auditEntry is a line of the audit log. PrevHash is the SHA-256 of the
+// previous line, without its newline, so removing or editing any entry breaks
+// the chain of all following entries. It is empty for the first entry.
+type auditEntry struct {
+	Time     time.Time `json:"time"`
+	Hostname string    `json:"hostname"`
+	Device   string    `json:"device"`
+	Action   string    `json:"action"`
+	Value    string    `json:"value"`
+	TTL      int       `json:"ttl,omitempty"`
+	PrevHash string    `json:"prevHash"`
+}
+
+// auditLog appends every record the plugin creates or updates to a
+// hash-chained JSONL file.
+type auditLog struct {
+	path     string
+	mu       sync.Mutex
+	lastHash string
+}
+
+// newAuditLog opens the audit log at path, continuing the chain of an
+// existing file.
+func newAuditLog(path string) (*auditLog, error) {
+	a := &auditLog{path: path}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return a, nil
+		}
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	lines := bytes.Split(bytes.TrimRight(data, "\n"), []byte("\n"))
+	if last := lines[len(lines)-1]; len(last) > 0 {
+		a.lastHash = auditHash(last)
+	}
+	return a, nil
+}
+
+func auditHash(line []byte) string {
+	sum := sha256.Sum256(line)
+	return hex.EncodeToString(sum[:])
+}
+
+// write appends an entry for every created or updated record in results.
+func (a *auditLog) write(results []hostResult, t time.Time) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var b bytes.Buffer
+	lastHash := a.lastHash
+	for _, result := range results {
+		if !result.changed() {
+			continue
+		}
+		line, err := json.Marshal(auditEntry{
+			Time:     t,
+			Hostname: result.Hostname,
+			Device:   result.Device,
+			Action:   result.Action,
+			Value:    result.Value,
+			TTL:      result.TTL,
+			PrevHash: lastHash,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to marshal audit entry: %w", err)
+		}
+		b.Write(line)
+		b.WriteByte('\n')
+		lastHash = auditHash(line)
+	}
+	if b.Len() == 0 {
+		return nil
+	}
+
+	f, err := os.OpenFile(a.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	if _, err := f.Write(b.Bytes()); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close audit log: %w", err)
+	}
+	a.lastHash = lastHash
+	return nil
+}
@@ -0,0 +1,73 @@
+package traefikunifidns
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readAuditLog returns the lines and decoded entries of the audit log at path.
+func readAuditLog(t *testing.T, path string) ([][]byte, []auditEntry) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := bytes.Split(bytes.TrimRight(data, "\n"), []byte("\n"))
+	entries := make([]auditEntry, len(lines))
+	for i, line := range lines {
+		require.NoError(t, json.Unmarshal(line, &entries[i]))
+	}
+	return lines, entries
+}
+
+func TestAuditLogChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	a, err := newAuditLog(path)
+	require.NoError(t, err)
+	require.NoError(t, a.write([]hostResult{
+		{Hostname: "app.lan", Device: "https://unifi.lan", Action: recordCreated, Value: "192.168.1.10"},
+		{Hostname: "nas.lan", Device: "https://unifi.lan", Action: recordUnchanged, Value: "192.168.1.20"},
+		{Hostname: "web.lan", Device: "https://unifi.lan", Action: recordUpdated, Value: "192.168.1.30", TTL: 60},
+	}, now))
+	require.NoError(t, a.write(nil, now))
+
+	// A new audit log continues the chain of the existing file
+	a, err = newAuditLog(path)
+	require.NoError(t, err)
+	require.NoError(t, a.write([]hostResult{
+		{Hostname: "app.lan", Device: "https://unifi.lan", Action: recordUpdated, Value: "192.168.1.11"},
+	}, now))
+
+	lines, entries := readAuditLog(t, path)
+	require.Len(t, entries, 3)
+	assert.Equal(t, auditEntry{Time: now, Hostname: "app.lan", Device: "https://unifi.lan", Action: recordCreated, Value: "192.168.1.10"}, entries[0])
+	assert.Equal(t, "web.lan", entries[1].Hostname)
+	assert.Equal(t, 60, entries[1].TTL)
+	for i := 1; i < len(entries); i++ {
+		assert.Equal(t, auditHash(lines[i-1]), entries[i].PrevHash, "entry %d", i)
+	}
+}
+
+func TestAuditLogTamperEvident(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	a, err := newAuditLog(path)
+	require.NoError(t, err)
+	require.NoError(t, a.write([]hostResult{
+		{Hostname: "app.lan", Action: recordCreated, Value: "192.168.1.10"},
+		{Hostname: "nas.lan", Action: recordCreated, Value: "192.168.1.20"},
+	}, time.Now()))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, bytes.Replace(data, []byte("192.168.1.10"), []byte("192.168.1.99"), 1), 0o600))
+
+	lines, entries := readAuditLog(t, path)
+	assert.NotEqual(t, auditHash(lines[0]), entries[1].PrevHash)
+}
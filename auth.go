@@ -0,0 +1,151 @@
+package traefikunifidns
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Authentication methods selected with authMethod.
+const (
+	authMethodAuto   = "auto"
+	authMethodCookie = "cookie"
+	authMethodBearer = "bearer"
+	authMethodAPIKey = "apikey"
+)
+
+// authStrategy authenticates the requests of a UniFiClient. Every request to
+// the controller is authorized through the strategy of its client, so the
+// request code is the same whichever credentials are used.
+type authStrategy interface {
+	// authorize adds the credentials to req, logging in first if the
+	// strategy needs a session.
+	authorize(c *UniFiClient, req *http.Request) error
+	// authenticated reports whether requests can be sent without logging in.
+	authenticated(c *UniFiClient) bool
+	// String describes the credentials in log messages.
+	String() string
+}
+
+// cookieAuth logs in with username and password, keeps the session cookie in
+// the cookie jar of the client and sends the CSRF token of the session with
+// every request. It is the default strategy.
+type cookieAuth struct {
+	username string
+}
+
+func (a cookieAuth) authorize(c *UniFiClient, req *http.Request) error {
+	csrfToken, err := c.session(req.Context())
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Csrf-Token", csrfToken)
+	return nil
+}
+
+func (a cookieAuth) authenticated(c *UniFiClient) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.csrfToken != ""
+}
+
+func (a cookieAuth) String() string {
+	return "account " + a.username
+}
+
+// bearerAuth sends a static token as "Authorization: Bearer" and never logs
+// in, for consoles behind a proxy that issues such tokens.
+type bearerAuth struct {
+	token secret
+}
+
+func (a bearerAuth) authorize(_ *UniFiClient, req *http.Request) error {
+	req.Header.Set("Authorization", "Bearer "+a.token.reveal())
+	return nil
+}
+
+func (a bearerAuth) authenticated(*UniFiClient) bool {
+	return true
+}
+
+func (a bearerAuth) String() string {
+	return "bearer token"
+}
+
+// apiKeyAuth sends an API key as "X-API-KEY" and never logs in, for UniFi
+// Network 9.x controllers with dedicated API keys.
+type apiKeyAuth struct {
+	key secret
+}
+
+func (a apiKeyAuth) authorize(_ *UniFiClient, req *http.Request) error {
+	req.Header.Set("X-API-KEY", a.key.reveal())
+	return nil
+}
+
+func (a apiKeyAuth) authenticated(*UniFiClient) bool {
+	return true
+}
+
+func (a apiKeyAuth) String() string {
+	return "API key"
+}
+
+// newAuthStrategy returns the strategy selected by the authMethod of device.
+// In auto mode a configured API key is used first, then a token for bearer
+// authentication, and username and password otherwise.
+func newAuthStrategy(device UnifiDeviceConfig) authStrategy {
+	switch device.AuthMethod {
+	case authMethodAPIKey:
+		return apiKeyAuth{key: newSecret(device.APIKey)}
+	case authMethodBearer:
+		return bearerAuth{token: newSecret(device.Token)}
+	case authMethodCookie:
+		return cookieAuth{username: device.Username}
+	}
+	if device.APIKey != "" {
+		return apiKeyAuth{key: newSecret(device.APIKey)}
+	}
+	if device.Token != "" {
+		return bearerAuth{token: newSecret(device.Token)}
+	}
+	return cookieAuth{username: device.Username}
+}
+
+// validateAuthMethod checks that the authMethod of device is known and that
+// its credentials are configured.
+func validateAuthMethod(device UnifiDeviceConfig) error {
+	switch device.AuthMethod {
+	case "", authMethodAuto, authMethodCookie:
+		return nil
+	case authMethodBearer:
+		if device.Token == "" {
+			return fmt.Errorf("authMethod %q requires a token", device.AuthMethod)
+		}
+		return nil
+	case authMethodAPIKey:
+		if device.APIKey == "" {
+			return fmt.Errorf("authMethod %q requires an apiKey", device.AuthMethod)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown authMethod %q", device.AuthMethod)
+	}
+}
+
+// auth returns the strategy of the client, cookieAuth if none was set.
+func (c *UniFiClient) auth() authStrategy {
+	if c.authStrategy == nil {
+		return cookieAuth{username: c.username}
+	}
+	return c.authStrategy
+}
+
+// setAuth replaces the default username and password authentication.
+func (c *UniFiClient) setAuth(strategy authStrategy) {
+	c.authStrategy = strategy
+}
+
+// authorize adds the credentials of the client strategy to req.
+func (c *UniFiClient) authorize(req *http.Request) error {
+	return c.auth().authorize(c, req)
+}
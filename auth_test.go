@@ -0,0 +1,101 @@
+package traefikunifidns
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewAuthStrategy(t *testing.T) {
+	assert.Equal(t, cookieAuth{username: "admin"}, newAuthStrategy(UnifiDeviceConfig{Username: "admin", Password: "password"}))
+	assert.IsType(t, bearerAuth{}, newAuthStrategy(UnifiDeviceConfig{Token: "token"}), "auto selects the token")
+	assert.IsType(t, bearerAuth{}, newAuthStrategy(UnifiDeviceConfig{AuthMethod: authMethodBearer, Username: "admin", Token: "token"}))
+	assert.IsType(t, cookieAuth{}, newAuthStrategy(UnifiDeviceConfig{AuthMethod: authMethodCookie, Username: "admin", Token: "token"}))
+	assert.IsType(t, apiKeyAuth{}, newAuthStrategy(UnifiDeviceConfig{APIKey: "key", Token: "token"}), "auto prefers the API key")
+	assert.IsType(t, apiKeyAuth{}, newAuthStrategy(UnifiDeviceConfig{AuthMethod: authMethodAPIKey, Username: "admin", APIKey: "key"}))
+
+	assert.Equal(t, "account admin", cookieAuth{username: "admin"}.String())
+	assert.Equal(t, "bearer token", fmt.Sprint(bearerAuth{token: newSecret("hunter2")}))
+	assert.Equal(t, "API key", fmt.Sprint(apiKeyAuth{key: newSecret("hunter2")}))
+}
+
+func TestValidateAuthMethod(t *testing.T) {
+	assert.NoError(t, validateAuthMethod(UnifiDeviceConfig{}))
+	assert.NoError(t, validateAuthMethod(UnifiDeviceConfig{AuthMethod: authMethodAuto}))
+	assert.NoError(t, validateAuthMethod(UnifiDeviceConfig{AuthMethod: authMethodCookie}))
+	assert.NoError(t, validateAuthMethod(UnifiDeviceConfig{AuthMethod: authMethodBearer, Token: "token"}))
+	assert.EqualError(t, validateAuthMethod(UnifiDeviceConfig{AuthMethod: authMethodBearer}), `authMethod "bearer" requires a token`)
+	assert.NoError(t, validateAuthMethod(UnifiDeviceConfig{AuthMethod: authMethodAPIKey, APIKey: "key"}))
+	assert.EqualError(t, validateAuthMethod(UnifiDeviceConfig{AuthMethod: authMethodAPIKey}), `authMethod "apikey" requires an apiKey`)
+	assert.EqualError(t, validateAuthMethod(UnifiDeviceConfig{AuthMethod: "kerberos"}), `unknown authMethod "kerberos"`)
+}
+
+func TestBearerAuthSkipsLogin(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		if r.Header.Get("Authorization") != "Bearer token" || r.Header.Get("X-Csrf-Token") != "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewEncoder(w).Encode([]DNSEntry{{Key: "app.lan", Value: "192.168.1.10"}})
+	}))
+	defer server.Close()
+
+	client := NewUniFiClient(server.URL, "", "", false)
+	client.setAuth(newAuthStrategy(UnifiDeviceConfig{Token: "token"}))
+	assert.True(t, client.hasSession())
+
+	entries, err := client.GetStaticDNSEntries(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, []string{"/proxy/network/v2/api/site/default/static-dns"}, paths, "no login request")
+}
+
+func TestAPIKeyAuthSkipsLogin(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		if r.Header.Get("X-API-KEY") != "key" || r.Header.Get("X-Csrf-Token") != "" || len(r.Cookies()) > 0 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewEncoder(w).Encode([]DNSEntry{{Key: "app.lan", Value: "192.168.1.10"}})
+	}))
+	defer server.Close()
+
+	client := NewUniFiClient(server.URL, "", "", false)
+	client.setAuth(newAuthStrategy(UnifiDeviceConfig{APIKey: "key"}))
+	assert.True(t, client.hasSession())
+
+	entries, err := client.GetStaticDNSEntries(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, []string{"/proxy/network/v2/api/site/default/static-dns"}, paths, "no login request")
+}
+
+func TestClientKeyAuthMethod(t *testing.T) {
+	device := UnifiDeviceConfig{Host: "unifi.lan", Token: "one"}
+	other := device
+	other.Token = "two"
+	assert.NotEqual(t, clientKey(device, false, 0), clientKey(other, false, 0))
+	other = device
+	other.APIKey = "key"
+	assert.NotEqual(t, clientKey(device, false, 0), clientKey(other, false, 0))
+	other = device
+	other.AuthMethod = authMethodCookie
+	assert.NotEqual(t, clientKey(device, false, 0), clientKey(other, false, 0))
+}
+
+func TestNewInvalidAuthMethod(t *testing.T) {
+	config := CreateConfig()
+	config.Devices = []UnifiDeviceConfig{{Host: "unifi.lan", AuthMethod: authMethodBearer, Pattern: `\.lan$`}}
+	_, err := New(context.Background(), nil, config, "test")
+	assert.EqualError(t, err, `invalid authentication for device 0: authMethod "bearer" requires a token`)
+}
@@ -0,0 +1,71 @@
+package traefikunifidns
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+)
+
+// minStaticDNSVersion is the first UniFi Network application release with
+// the static DNS API.
+const minStaticDNSVersion = "8.2"
+
+// Static DNS support of a controller, as found by probing it and reported in
+// the status document.
+const (
+	staticDNSUnknown     = ""
+	staticDNSSupported   = "supported"
+	staticDNSUnsupported = "unsupported"
+)
+
+// probeStaticDNS checks once whether the controller exposes the static DNS
+// API, which older firmware doesn't, and logs a single error if it doesn't.
+// Only definite answers are kept; the probe is repeated after connection
+// failures.
+func (c *UniFiClient) probeStaticDNS(ctx context.Context) string {
+	if state := c.staticDNSState(); state != staticDNSUnknown {
+		return state
+	}
+
+	state := staticDNSSupported
+	_, err := c.GetStaticDNSEntries(ctx)
+	var se *statusError
+	switch {
+	case errors.As(err, &se) && se.statusCode == http.StatusNotFound:
+		log.Printf("ERROR: Controller %s does not support static DNS; upgrade the UniFi Network application to %s or later", c.baseURL, minStaticDNSVersion)
+		state = staticDNSUnsupported
+	case err != nil:
+		log.Printf("WARN: Could not probe static DNS support of %s, probing again next cycle: %v", c.baseURL, err)
+		return staticDNSUnknown
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.staticDNS = state
+	return state
+}
+
+// staticDNSState returns the outcome of the last probe without probing.
+func (c *UniFiClient) staticDNSState() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.staticDNS
+}
+
+// probeCapabilities probes every UniFi controller that wasn't probed
+// successfully yet.
+func (r *reconciler) probeCapabilities(ctx context.Context) {
+	for _, provider := range r.providers {
+		if client, ok := provider.(*UniFiClient); ok {
+			client.probeStaticDNS(ctx)
+		}
+	}
+}
+
+// lacksStaticDNS reports whether provider is a controller known to
+// lack the static DNS API.
+func lacksStaticDNS(provider dnsProvider) bool {
+	client, ok := provider.(*UniFiClient)
+	return ok && client.staticDNSState() == staticDNSUnsupported
+}
@@ -0,0 +1,82 @@
+package traefikunifidns
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestCapabilityServer(t *testing.T, status *int, requests *int) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/auth/login" {
+			w.Header().Set("X-Csrf-Token", "test-csrf-token")
+			return
+		}
+		*requests++
+		w.WriteHeader(*status)
+		if *status == http.StatusOK {
+			_, _ = w.Write([]byte(`[]`))
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestProbeStaticDNS(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		want   string
+	}{
+		{name: "supported", status: http.StatusOK, want: staticDNSSupported},
+		{name: "old firmware", status: http.StatusNotFound, want: staticDNSUnsupported},
+		{name: "server error", status: http.StatusInternalServerError, want: staticDNSUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, requests := tt.status, 0
+			server := newTestCapabilityServer(t, &status, &requests)
+			client := NewUniFiClient(server.URL, "admin", "password", false)
+
+			assert.Equal(t, tt.want, client.probeStaticDNS(context.Background()))
+			assert.Equal(t, tt.want, client.probeStaticDNS(context.Background()))
+			if tt.want == staticDNSUnknown {
+				assert.Equal(t, 2, requests, "probed again after a failure")
+			} else {
+				assert.Equal(t, 1, requests, "the outcome is kept")
+			}
+		})
+	}
+}
+
+func TestUnsupportedControllerSkipsHostnames(t *testing.T) {
+	traefikServer := newTestTraefikServer(t, []map[string]interface{}{
+		{"name": "app", "rule": "Host(`app.lan`)", "middlewares": []string{"traefikunifidns"}},
+		{"name": "nas", "rule": "Host(`nas.lan`)", "middlewares": []string{"traefikunifidns"}},
+	})
+	status, requests := http.StatusNotFound, 0
+	unifiServer := newTestCapabilityServer(t, &status, &requests)
+
+	config := CreateConfig()
+	config.TraefikAPIURL = traefikServer.URL
+	config.Devices = []UnifiDeviceConfig{
+		{Host: unifiServer.URL, Username: "admin", Password: "password", Pattern: `\.lan$`},
+	}
+
+	plugin, err := New(context.Background(), nil, config, "test")
+	require.NoError(t, err)
+	u := plugin.(*UniFiDNS)
+	require.Len(t, u.results, 2)
+	for _, result := range u.results {
+		assert.Equal(t, resultSkipped, result.Action)
+		assert.Equal(t, "static DNS unsupported", result.Reason)
+	}
+	assert.Equal(t, 1, requests, "a single probe, no per-hostname requests")
+	assert.Equal(t, staticDNSUnsupported, u.status().Devices[0].StaticDNS)
+}
@@ -0,0 +1,64 @@
+package traefikunifidns
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Record modes selected with recordMode.
+const (
+	recordModeA     = "a"
+	recordModeCNAME = "cname"
+)
+
+// cnameRecordProvider is implemented by providers that can publish a
+// hostname as a CNAME record.
+type cnameRecordProvider interface {
+	updateCNAMERecord(ctx context.Context, hostname, target string, ttl int) (string, error)
+}
+
+// validateRecordMode checks the recordMode of device and the settings it
+// requires.
+func validateRecordMode(device UnifiDeviceConfig) error {
+	switch strings.ToLower(device.RecordMode) {
+	case "", recordModeA:
+		if device.CNAMETarget != "" {
+			return fmt.Errorf("cnameTarget requires recordMode %q", recordModeCNAME)
+		}
+		return nil
+	case recordModeCNAME:
+		if device.CNAMETarget == "" {
+			return fmt.Errorf("recordMode %q requires a cnameTarget", recordModeCNAME)
+		}
+		if device.WebhookURL != "" {
+			return fmt.Errorf("recordMode %q requires a UniFi controller", recordModeCNAME)
+		}
+		if device.TargetIP != "" || device.TargetHostname != "" || device.TargetWANIP {
+			return fmt.Errorf("recordMode %q cannot be combined with a device target", recordModeCNAME)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown recordMode %q", device.RecordMode)
+	}
+}
+
+// cnameTarget returns the name hostname points at on device in CNAME mode,
+// or an empty string when it is published with A records. The target itself
+// keeps its A record, since a CNAME pointing at itself resolves nowhere.
+func (r *reconciler) cnameTarget(hostname string, device int) string {
+	config := r.config.Devices[device]
+	if !strings.EqualFold(config.RecordMode, recordModeCNAME) || strings.EqualFold(hostname, config.CNAMETarget) {
+		return ""
+	}
+	return config.CNAMETarget
+}
+
+// recordTargets returns the values to publish for hostname on device: the
+// CNAME target in CNAME mode, and its addresses otherwise.
+func (r *reconciler) recordTargets(ctx context.Context, hostname string, device int, cname string, localIPs []string) ([]string, error) {
+	if cname != "" {
+		return []string{cname}, nil
+	}
+	return r.targetIPs(ctx, hostname, device, localIPs)
+}
@@ -0,0 +1,96 @@
+package traefikunifidns
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateRecordMode(t *testing.T) {
+	assert.NoError(t, validateRecordMode(UnifiDeviceConfig{}))
+	assert.NoError(t, validateRecordMode(UnifiDeviceConfig{RecordMode: recordModeA}))
+	assert.NoError(t, validateRecordMode(UnifiDeviceConfig{RecordMode: "CNAME", CNAMETarget: "traefik.lan"}))
+
+	for _, tc := range []struct {
+		device UnifiDeviceConfig
+		err    string
+	}{
+		{UnifiDeviceConfig{RecordMode: "mx"}, `unknown recordMode "mx"`},
+		{UnifiDeviceConfig{RecordMode: recordModeCNAME}, `recordMode "cname" requires a cnameTarget`},
+		{UnifiDeviceConfig{CNAMETarget: "traefik.lan"}, `cnameTarget requires recordMode "cname"`},
+		{UnifiDeviceConfig{RecordMode: recordModeCNAME, CNAMETarget: "traefik.lan", WebhookURL: "http://hook"}, `recordMode "cname" requires a UniFi controller`},
+		{UnifiDeviceConfig{RecordMode: recordModeCNAME, CNAMETarget: "traefik.lan", TargetIP: "192.168.1.10"}, `recordMode "cname" cannot be combined with a device target`},
+	} {
+		assert.EqualError(t, validateRecordMode(tc.device), tc.err)
+	}
+}
+
+func TestUpdateCNAMERecord(t *testing.T) {
+	var writes []map[string]interface{}
+	server := newTestUniFiServer(t, []DNSEntry{
+		{ID: "1", Key: "app.lan", RecordType: "A", Value: "192.168.1.10"},
+		{ID: "2", Key: "nas.lan", RecordType: "CNAME", Value: "traefik.lan"},
+	}, &writes)
+	client := NewUniFiClient(server.URL, "admin", "password", false)
+
+	action, err := client.updateCNAMERecord(context.Background(), "nas.lan", "traefik.lan", 0)
+	require.NoError(t, err)
+	assert.Equal(t, recordUnchanged, action)
+	assert.Empty(t, writes)
+
+	// The A record cannot exist next to the CNAME
+	action, err = client.updateCNAMERecord(context.Background(), "app.lan", "traefik.lan", 0)
+	require.NoError(t, err)
+	assert.Equal(t, recordCreated, action)
+	require.Len(t, writes, 2)
+	assert.Equal(t, map[string]interface{}{"deleted": "1"}, writes[0])
+	assert.Equal(t, "CNAME", writes[1]["record_type"])
+	assert.Equal(t, "traefik.lan", writes[1]["value"])
+
+	// And the other way round
+	writes = nil
+	action, err = client.updateDNSRecord(context.Background(), "nas.lan", "192.168.1.20", 0)
+	require.NoError(t, err)
+	assert.Equal(t, recordCreated, action)
+	require.Len(t, writes, 2)
+	assert.Equal(t, map[string]interface{}{"deleted": "2"}, writes[0])
+	assert.Equal(t, "A", writes[1]["record_type"])
+}
+
+func TestUpdateDNSCNAMEMode(t *testing.T) {
+	traefikServer := newTestTraefikServer(t, []map[string]interface{}{
+		{"name": "app", "rule": "Host(`app.lan`)", "middlewares": []string{"traefikunifidns"}},
+		{"name": "traefik", "rule": "Host(`traefik.lan`)", "middlewares": []string{"traefikunifidns"}},
+	})
+	var writes []map[string]interface{}
+	unifiServer := newTestUniFiServer(t, []DNSEntry{}, &writes)
+
+	config := CreateConfig()
+	config.TraefikAPIURL = traefikServer.URL
+	config.IPOverrides = map[string]string{"traefik.lan": "192.168.1.10"}
+	config.Devices = []UnifiDeviceConfig{{
+		Host: unifiServer.URL, Username: "admin", Password: "password", Pattern: `\.lan$`,
+		RecordMode: recordModeCNAME, CNAMETarget: "traefik.lan",
+	}}
+
+	plugin, err := New(context.Background(), nil, config, "test")
+	require.NoError(t, err)
+
+	types := make(map[string]string)
+	for _, write := range writes {
+		types[write["key"].(string)] = write["record_type"].(string) + " " + write["value"].(string)
+	}
+	assert.Equal(t, map[string]string{"app.lan": "CNAME traefik.lan", "traefik.lan": "A 192.168.1.10"}, types)
+	for _, result := range plugin.(*UniFiDNS).results {
+		assert.Equal(t, recordCreated, result.Action, result.Hostname)
+	}
+}
+
+func TestNewInvalidRecordMode(t *testing.T) {
+	config := CreateConfig()
+	config.Devices = []UnifiDeviceConfig{{Host: "unifi.lan", Pattern: `\.lan$`, RecordMode: recordModeCNAME}}
+	_, err := New(context.Background(), nil, config, "test")
+	assert.EqualError(t, err, `invalid recordMode for device 0: recordMode "cname" requires a cnameTarget`)
+}
@@ -0,0 +1,83 @@
+package traefikunifidns
+
+import "fmt"
+
+// currentConfigVersion is the configuration shape this plugin understands.
+// Configurations without a configVersion are version 1.
+const currentConfigVersion = 2
+
+// configMigration upgrades a configuration from version-1 to version and
+// returns a deprecation warning for every setting it rewrote.
+type configMigration struct {
+	version int
+	migrate func(config *Config) []string
+}
+
+var configMigrations = []configMigration{
+	{version: 2, migrate: migrateV2},
+}
+
+// migrateConfig upgrades config in place to the current shape and returns a
+// deprecation warning for every setting it rewrote. configVersion keeps the
+// declared version, so migrating again changes nothing. Settings that were
+// replaced in the declared version are rejected.
+func migrateConfig(config *Config) ([]string, error) {
+	version := config.ConfigVersion
+	if version == 0 {
+		version = 1
+	}
+	if version > currentConfigVersion {
+		return nil, fmt.Errorf("configVersion %d is newer than this plugin supports (%d), upgrade the plugin", version, currentConfigVersion)
+	}
+
+	if err := checkReplaced(config, version); err != nil {
+		return nil, err
+	}
+	var warnings []string
+	for _, m := range configMigrations {
+		if m.version > version {
+			warnings = append(warnings, m.migrate(config)...)
+		}
+	}
+	return warnings, nil
+}
+
+// checkReplaced rejects settings that no longer exist in version.
+func checkReplaced(config *Config, version int) error {
+	if version < 2 {
+		return nil
+	}
+	if config.InsecureSkipVerifyTLS {
+		return fmt.Errorf("insecureSkipVerifyTLS was replaced by traefikInsecureSkipVerifyTLS and the setting of each device in configVersion 2")
+	}
+	for i, device := range config.Devices {
+		if device.Pattern != "" {
+			return fmt.Errorf("pattern of device %d was replaced by patterns in configVersion 2", i)
+		}
+	}
+	return nil
+}
+
+// migrateV2 splits the global insecureSkipVerifyTLS, which used to apply to
+// the Traefik API and every device at once, and moves the single device
+// pattern into the patterns list.
+func migrateV2(config *Config) []string {
+	var warnings []string
+	if config.InsecureSkipVerifyTLS {
+		config.TraefikInsecureSkipVerifyTLS = true
+		for i := range config.Devices {
+			config.Devices[i].InsecureSkipVerifyTLS = true
+		}
+		config.InsecureSkipVerifyTLS = false
+		warnings = append(warnings, "the global insecureSkipVerifyTLS is replaced by traefikInsecureSkipVerifyTLS and insecureSkipVerifyTLS of each device")
+	}
+	for i, device := range config.Devices {
+		if device.Pattern == "" {
+			continue
+		}
+		config.Devices[i].Patterns = append([]string{device.Pattern}, device.Patterns...)
+		config.Devices[i].Pattern = ""
+		warnings = append(warnings, fmt.Sprintf("pattern of device %d is replaced by patterns", i))
+	}
+	return warnings
+}
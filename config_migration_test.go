@@ -0,0 +1,84 @@
+package traefikunifidns
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrateConfig(t *testing.T) {
+	config := CreateConfig()
+	config.InsecureSkipVerifyTLS = true
+	config.Devices = []UnifiDeviceConfig{
+		{Host: "192.168.1.1", Pattern: `\.lan$`},
+		{Host: "192.168.1.2", Patterns: []string{`\.home$`}},
+	}
+
+	warnings, err := migrateConfig(config)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"the global insecureSkipVerifyTLS is replaced by traefikInsecureSkipVerifyTLS and insecureSkipVerifyTLS of each device",
+		"pattern of device 0 is replaced by patterns",
+	}, warnings)
+	assert.False(t, config.InsecureSkipVerifyTLS)
+	assert.True(t, config.TraefikInsecureSkipVerifyTLS)
+	assert.True(t, config.Devices[0].InsecureSkipVerifyTLS)
+	assert.True(t, config.Devices[1].InsecureSkipVerifyTLS)
+	assert.Empty(t, config.Devices[0].Pattern)
+	assert.Equal(t, []string{`\.lan$`}, config.Devices[0].Patterns)
+
+	warnings, err = migrateConfig(config)
+	require.NoError(t, err)
+	assert.Empty(t, warnings, "migrating again changes nothing")
+	assert.Equal(t, []string{`\.lan$`}, config.Devices[0].Patterns)
+}
+
+func TestMigrateConfigVersions(t *testing.T) {
+	config := CreateConfig()
+	config.ConfigVersion = 2
+	config.Devices = []UnifiDeviceConfig{{Host: "192.168.1.1", Pattern: `\.lan$`}}
+	_, err := migrateConfig(config)
+	assert.EqualError(t, err, "pattern of device 0 was replaced by patterns in configVersion 2")
+
+	config.Devices[0].Pattern = ""
+	config.InsecureSkipVerifyTLS = true
+	_, err = migrateConfig(config)
+	assert.EqualError(t, err, "insecureSkipVerifyTLS was replaced by traefikInsecureSkipVerifyTLS and the setting of each device in configVersion 2")
+
+	config.ConfigVersion = 3
+	_, err = New(context.Background(), nil, config, "test")
+	assert.EqualError(t, err, "invalid configuration: configVersion 3 is newer than this plugin supports (2), upgrade the plugin")
+}
+
+func TestConfigKeyMigrated(t *testing.T) {
+	legacy := CreateConfig()
+	legacy.Devices = []UnifiDeviceConfig{{Host: "192.168.1.1", Pattern: `\.lan$`}}
+	current := CreateConfig()
+	current.Devices = []UnifiDeviceConfig{{Host: "192.168.1.1", Patterns: []string{`\.lan$`}}}
+
+	legacyKey, err := configKey(legacy)
+	require.NoError(t, err)
+	currentKey, err := configKey(current)
+	require.NoError(t, err)
+	assert.Equal(t, currentKey, legacyKey)
+	assert.Equal(t, `\.lan$`, legacy.Devices[0].Pattern, "computing the key leaves config alone")
+}
+
+func TestPatterns(t *testing.T) {
+	config := CreateConfig()
+	config.ConfigVersion = 2
+	config.Devices = []UnifiDeviceConfig{{Host: "192.168.1.1", Username: "admin", Password: "password", Patterns: []string{`\.lan$`, `^nas\.`}}}
+
+	plugin, err := New(context.Background(), nil, config, "test")
+	require.NoError(t, err)
+	u := plugin.(*UniFiDNS)
+	assert.Equal(t, []int{0}, u.matchingDevices("app.lan"))
+	assert.Equal(t, []int{0}, u.matchingDevices("nas.example.com"))
+	assert.Nil(t, u.matchingDevices("app.example.com"))
+
+	config.Devices[0].Patterns = []string{`\.lan$`, `(`}
+	_, err = New(context.Background(), nil, config, "test")
+	assert.ErrorContains(t, err, "invalid pattern for device 0")
+}
@@ -0,0 +1,160 @@
+package traefikunifidns
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// recordReader is implemented by providers that can list the A records they
+// store, to compare the devices a hostname is published to.
+type recordReader interface {
+	// storedRecords returns the addresses of every A record by name, sorted
+	// and joined by commas.
+	storedRecords(ctx context.Context) (map[string]string, error)
+}
+
+// sharedRecord is the record of a hostname on one of the devices it is
+// published to.
+type sharedRecord struct {
+	provider dnsProvider
+	device   int
+	name     string
+	target   string // Desired addresses joined by commas, empty if unknown
+	ttl      int
+}
+
+// inconsistency describes a hostname whose devices store different values.
+type inconsistency struct {
+	Hostname string            `json:"hostname"`
+	Values   map[string]string `json:"values"`           // Stored addresses by device, empty when missing
+	Healed   []string          `json:"healed,omitempty"` // Devices rewritten with the desired addresses
+}
+
+func (i inconsistency) String() string {
+	devices := make([]string, 0, len(i.Values))
+	for device := range i.Values {
+		devices = append(devices, device)
+	}
+	sort.Strings(devices)
+	values := make([]string, 0, len(devices))
+	for _, device := range devices {
+		value := i.Values[device]
+		if value == "" {
+			value = "missing"
+		}
+		values = append(values, fmt.Sprintf("%s=%s", device, value))
+	}
+	return strings.Join(values, " ")
+}
+
+// checkConsistency compares the records stored for every hostname published
+// to several devices, and reports those that differ. Hostnames whose devices
+// publish different targets on purpose, for example with a per-device
+// targetIP, are expected to differ and aren't compared. With
+// healInconsistencies the lagging devices are rewritten with the desired
+// addresses. Each device is listed at most once.
+func (r *reconciler) checkConsistency(ctx context.Context, shared map[string][]sharedRecord) []inconsistency {
+	hostnames := make([]string, 0, len(shared))
+	for hostname, records := range shared {
+		if len(records) > 1 && sameTarget(records) {
+			hostnames = append(hostnames, hostname)
+		}
+	}
+	sort.Strings(hostnames)
+
+	stored := make(map[dnsProvider]map[string]string)
+	var inconsistencies []inconsistency
+	for _, hostname := range hostnames {
+		values := make(map[string]string)
+		distinct := make(map[string]bool)
+		for _, record := range shared[hostname] {
+			reader, ok := record.provider.(recordReader)
+			if !ok {
+				continue
+			}
+			records, read := stored[record.provider]
+			if !read {
+				var err error
+				if records, err = reader.storedRecords(ctx); err != nil {
+					logError("Failed to list records of %s for the consistency check: %v", record.provider, err)
+				}
+				stored[record.provider] = records
+			}
+			if records == nil {
+				continue
+			}
+			values[record.provider.String()] = records[record.name]
+			distinct[records[record.name]] = true
+		}
+		if len(distinct) < 2 {
+			continue
+		}
+
+		found := inconsistency{Hostname: hostname, Values: values}
+		log.Printf("WARN: INCONSISTENT: Devices store different records for %s: %s", hostname, found)
+		if r.config.HealInconsistencies {
+			found.Healed = r.heal(ctx, shared[hostname], values)
+		}
+		inconsistencies = append(inconsistencies, found)
+	}
+	return inconsistencies
+}
+
+// heal rewrites the records that differ from their desired addresses, and
+// returns the devices it rewrote.
+func (r *reconciler) heal(ctx context.Context, records []sharedRecord, values map[string]string) []string {
+	var healed []string
+	for _, record := range records {
+		value, compared := values[record.provider.String()]
+		if !compared || record.target == "" || value == record.target ||
+			strings.Contains(record.target, ",") || r.config.Devices[record.device].DryRun {
+			continue
+		}
+		if _, err := record.provider.updateDNSRecord(ctx, record.name, record.target, record.ttl); err != nil {
+			logError("Failed to heal %s on %s: %v", record.name, record.provider, err)
+			continue
+		}
+		log.Printf("INFO: Healed %s on %s to %s", record.name, record.provider, record.target)
+		healed = append(healed, record.provider.String())
+	}
+	return healed
+}
+
+// sameTarget reports whether all devices with a known target publish the
+// same one.
+func sameTarget(records []sharedRecord) bool {
+	target := ""
+	for _, record := range records {
+		if record.target == "" {
+			continue
+		}
+		if target != "" && record.target != target {
+			return false
+		}
+		target = record.target
+	}
+	return target != ""
+}
+
+// storedRecords lists the A records stored on the controller.
+func (c *UniFiClient) storedRecords(ctx context.Context) (map[string]string, error) {
+	entries, err := c.GetStaticDNSEntries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	addresses := make(map[string][]string)
+	for _, entry := range entries {
+		if entry.RecordType == "" || entry.RecordType == "A" {
+			addresses[entry.Key] = append(addresses[entry.Key], entry.Value)
+		}
+	}
+	records := make(map[string]string, len(addresses))
+	for name, values := range addresses {
+		sort.Strings(values)
+		records[name] = strings.Join(values, ",")
+	}
+	return records, nil
+}
@@ -0,0 +1,81 @@
+package traefikunifidns
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConsistencyCheck(t *testing.T) {
+	traefikServer := newTestTraefikServer(t, []map[string]interface{}{
+		{"name": "nas", "rule": "Host(`nas.lan`)", "middlewares": []string{"traefikunifidns"}},
+		{"name": "app", "rule": "Host(`app.lan`)", "middlewares": []string{"traefikunifidns"}},
+	})
+	var primaryWrites, secondaryWrites []map[string]interface{}
+	primary := newTestUniFiServer(t, []DNSEntry{
+		{ID: "1", Key: "nas.lan", Value: "192.168.1.20", RecordType: "A"},
+		{ID: "2", Key: "app.lan", Value: "192.168.1.10", RecordType: "A"},
+	}, &primaryWrites)
+	// The secondary controller keeps serving a stale record, as if the
+	// write of the cycle didn't stick
+	secondary := newTestUniFiServer(t, []DNSEntry{
+		{ID: "1", Key: "nas.lan", Value: "192.168.1.99", RecordType: "A"},
+		{ID: "2", Key: "app.lan", Value: "192.168.1.10", RecordType: "A"},
+	}, &secondaryWrites)
+
+	updateOnStartup := false
+	config := CreateConfig()
+	config.TraefikAPIURL = traefikServer.URL
+	config.Devices = []UnifiDeviceConfig{
+		{Host: primary.URL, Username: "admin", Password: "password", Pattern: `\.lan$`},
+		{Host: secondary.URL, Username: "admin", Password: "password", Pattern: `\.lan$`},
+	}
+	config.IPOverrides = map[string]string{"nas.lan": "192.168.1.20", "app.lan": "192.168.1.10"}
+	config.UpdateOnStartup = &updateOnStartup
+	config.AdminPath = "/.unifidns"
+	config.ConsistencyCheck = true
+
+	plugin, err := New(context.Background(), nil, config, "test")
+	require.NoError(t, err)
+	u := plugin.(*UniFiDNS)
+
+	result := u.sync(context.Background())
+	require.NoError(t, result.Err)
+	assert.Empty(t, primaryWrites)
+	require.Len(t, secondaryWrites, 1)
+	require.Len(t, result.Inconsistencies, 1)
+	assert.Equal(t, inconsistency{
+		Hostname: "nas.lan",
+		Values:   map[string]string{primary.URL: "192.168.1.20", secondary.URL: "192.168.1.99"},
+	}, result.Inconsistencies[0])
+
+	status := u.status()
+	require.NotNil(t, status.LastCycle)
+	assert.Equal(t, result.Inconsistencies, status.LastCycle.Inconsistencies)
+
+	// Healing rewrites the lagging device only
+	config.HealInconsistencies = true
+	result = u.sync(context.Background())
+	require.Len(t, result.Inconsistencies, 1)
+	assert.Equal(t, []string{secondary.URL}, result.Inconsistencies[0].Healed)
+	assert.Empty(t, primaryWrites)
+	require.Len(t, secondaryWrites, 3)
+	assert.Equal(t, "192.168.1.20", secondaryWrites[2]["value"])
+}
+
+func TestConsistencyCheckSkipsPerDeviceTargets(t *testing.T) {
+	r := &reconciler{config: CreateConfig()}
+	assert.Empty(t, r.checkConsistency(context.Background(), map[string][]sharedRecord{
+		"nas.lan": {
+			{provider: &UniFiClient{baseURL: "https://a"}, name: "nas.lan", target: "192.168.1.20"},
+			{provider: &UniFiClient{baseURL: "https://b"}, name: "nas.lan", target: "10.0.0.20"},
+		},
+	}), "devices publishing different targets aren't compared")
+}
+
+func TestInconsistencyString(t *testing.T) {
+	found := inconsistency{Hostname: "nas.lan", Values: map[string]string{"https://b": "", "https://a": "192.168.1.20"}}
+	assert.Equal(t, "https://a=192.168.1.20 https://b=missing", found.String())
+}
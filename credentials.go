@@ -0,0 +1,49 @@
+package traefikunifidns
+
+import (
+	"fmt"
+	"io"
+)
+
+const redacted = "[redacted]"
+
+// secret holds a password, token or API key in the clients that send it: it
+// formats as "[redacted]" with every fmt verb and marshals to "[redacted]",
+// so a secret that ends up in a log line, error message or JSON document
+// never reveals its value. The value is only read with reveal, right where
+// it is put on the wire. This guards against leaks through output, not
+// against reading memory: the Config a reconciler keeps, which configKey
+// hashes as JSON, still holds the plain values.
+//
+// The value is kept behind a pointer because fmt cannot call Format on
+// unexported struct fields; it prints nested pointers as addresses instead.
+type secret struct {
+	value *string
+}
+
+func newSecret(value string) secret {
+	return secret{value: &value}
+}
+
+// reveal returns the plain value.
+func (s secret) reveal() string {
+	if s.value == nil {
+		return ""
+	}
+	return *s.value
+}
+
+// empty reports whether no value is set.
+func (s secret) empty() bool {
+	return s.reveal() == ""
+}
+
+// Format implements fmt.Formatter.
+func (s secret) Format(f fmt.State, _ rune) {
+	_, _ = io.WriteString(f, redacted)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (s secret) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + redacted + `"`), nil
+}
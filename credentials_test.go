@@ -0,0 +1,38 @@
+package traefikunifidns
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecretNeverFormatsValue(t *testing.T) {
+	s := newSecret("hunter2")
+	assert.Equal(t, "hunter2", s.reveal())
+
+	for _, verb := range []string{"%v", "%+v", "%#v", "%s", "%q", "%x", "%d"} {
+		assert.Equal(t, redacted, fmt.Sprintf(verb, s), verb)
+	}
+
+	client := NewUniFiClient("unifi.lan", "admin", "hunter2", false)
+	assert.NotContains(t, fmt.Sprintf("%+v %#v", client.password, client.password), "hunter2")
+	assert.NotContains(t, fmt.Sprintf("%+v", struct{ Password secret }{s}), "hunter2", "exported fields")
+	assert.NotContains(t, fmt.Sprintf("%+v", struct{ password secret }{s}), "hunter2", "unexported fields")
+
+	data, err := json.Marshal(struct {
+		Password secret `json:"password"`
+	}{s})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"password":"[redacted]"}`, string(data))
+}
+
+func TestMQTTPublisherKeepsPasswordSecret(t *testing.T) {
+	p, err := newMQTTPublisher(MQTTConfig{Broker: "broker.lan", Username: "user", Password: "hunter2"})
+	require.NoError(t, err)
+	assert.Empty(t, p.config.Password)
+	assert.Contains(t, string(p.connectPacket()), "hunter2")
+	assert.NotContains(t, fmt.Sprintf("%+v", *p), "hunter2")
+}
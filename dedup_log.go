@@ -0,0 +1,83 @@
+package traefikunifidns
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// errorLogWindow is the period over which identical error messages are
+// collapsed into a single summary line.
+const errorLogWindow = 10 * time.Minute
+
+// errorLog deduplicates the error lines of all plugin instances, so a
+// controller that stays down doesn't produce the same lines every cycle.
+var errorLog = newDedupLogger(errorLogWindow)
+
+// logError logs an error-level message through errorLog.
+func logError(format string, args ...interface{}) {
+	errorLog.Printf("ERROR: "+format, args...)
+}
+
+type dedupEntry struct {
+	since      time.Time
+	suppressed int
+}
+
+// dedupLogger logs the first occurrence of a message and suppresses identical
+// messages for the rest of the window. The next occurrence after the window
+// is logged together with the number of suppressed repetitions.
+type dedupLogger struct {
+	mu        sync.Mutex
+	window    time.Duration
+	entries   map[string]*dedupEntry
+	lastPrune time.Time
+	now       func() time.Time
+}
+
+func newDedupLogger(window time.Duration) *dedupLogger {
+	return &dedupLogger{
+		window:  window,
+		entries: make(map[string]*dedupEntry),
+		now:     time.Now,
+	}
+}
+
+func (d *dedupLogger) Printf(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	d.prune(now)
+
+	entry, ok := d.entries[msg]
+	if ok && now.Sub(entry.since) < d.window {
+		entry.suppressed++
+		return
+	}
+
+	if ok && entry.suppressed > 0 {
+		log.Printf("%s (same error %d times in last %s)", msg, entry.suppressed+1, d.window)
+	} else {
+		log.Print(msg)
+	}
+	d.entries[msg] = &dedupEntry{since: now}
+}
+
+// prune drops entries that have not been seen for a full window so messages
+// containing changing values don't accumulate forever. It expects d.mu to be
+// held.
+func (d *dedupLogger) prune(now time.Time) {
+	if now.Sub(d.lastPrune) < d.window {
+		return
+	}
+	for msg, entry := range d.entries {
+		if now.Sub(entry.since) >= 2*d.window {
+			delete(d.entries, msg)
+		}
+	}
+	d.lastPrune = now
+}
@@ -0,0 +1,56 @@
+package traefikunifidns
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDedupLogger(t *testing.T) {
+	var logBuf bytes.Buffer
+	log.SetOutput(&logBuf)
+	defer log.SetOutput(os.Stderr)
+
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	d := newDedupLogger(10 * time.Minute)
+	d.now = func() time.Time { return now }
+
+	d.Printf("ERROR: Failed to update DNS record for %s: %s", "a.lan", "connection refused")
+	for i := 0; i < 41; i++ {
+		now = now.Add(time.Second)
+		d.Printf("ERROR: Failed to update DNS record for %s: %s", "a.lan", "connection refused")
+	}
+	d.Printf("ERROR: Failed to update DNS record for %s: %s", "b.lan", "connection refused")
+	assert.Equal(t, 2, strings.Count(logBuf.String(), "\n"))
+
+	// The next occurrence after the window carries the summary
+	logBuf.Reset()
+	now = now.Add(10 * time.Minute)
+	d.Printf("ERROR: Failed to update DNS record for %s: %s", "a.lan", "connection refused")
+	assert.Contains(t, logBuf.String(), "ERROR: Failed to update DNS record for a.lan: connection refused (same error 42 times in last 10m0s)")
+
+	// Without repetitions the message is logged as is
+	logBuf.Reset()
+	d.Printf("ERROR: Failed to update DNS record for %s: %s", "b.lan", "connection refused")
+	assert.NotContains(t, logBuf.String(), "same error")
+	assert.Contains(t, logBuf.String(), "ERROR: Failed to update DNS record for b.lan: connection refused")
+}
+
+func TestDedupLoggerPrune(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	d := newDedupLogger(time.Minute)
+	d.now = func() time.Time { return now }
+
+	d.Printf("ERROR: one")
+	d.Printf("ERROR: two")
+	assert.Len(t, d.entries, 2)
+
+	now = now.Add(2 * time.Minute)
+	d.Printf("ERROR: three")
+	assert.Len(t, d.entries, 1)
+}
@@ -0,0 +1,265 @@
+package traefikunifidns
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// circuitBreakerThreshold is the number of consecutive failures after
+	// which a device is skipped until circuitBreakerCooldown has passed.
+	circuitBreakerThreshold = 5
+	circuitBreakerCooldown  = 5 * time.Minute
+
+	// latencySamples is the number of recent API calls kept per device for
+	// the latency percentiles in the status document.
+	latencySamples = 256
+)
+
+// Circuit breaker states reported in the status document.
+const (
+	circuitClosed   = "closed"
+	circuitOpen     = "open"
+	circuitHalfOpen = "half-open"
+)
+
+// deviceStats tracks the health of a single UniFi device across cycles.
+type deviceStats struct {
+	mu                  sync.Mutex
+	lastLogin           time.Time
+	lastError           string
+	consecutiveFailures int
+	openedAt            time.Time
+	trialStartedAt      time.Time // Of the half-open trial in flight, if any
+	managedRecords      int
+	degraded            bool // Writes are forbidden while reads succeed
+	latencies           []time.Duration
+	next                int
+	endpoints           map[string]*endpointStats
+}
+
+// deviceStatus is the per-device section of the status document.
+type deviceStatus struct {
+	ID                  string    `json:"id"`
+	Host                string    `json:"host"`
+	LastLogin           time.Time `json:"lastLogin"`
+	LastError           string    `json:"lastError,omitempty"`
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+	CircuitState        string    `json:"circuitState"`
+	ManagedRecords      int       `json:"managedRecords"`
+	Degraded            bool      `json:"degraded"`
+	StaticDNS           string    `json:"staticDns,omitempty"` // Whether the controller supports static DNS, empty until probed
+	LatencyP50Ms        float64   `json:"latencyP50Ms"`
+	LatencyP90Ms        float64   `json:"latencyP90Ms"`
+	LatencyP99Ms        float64   `json:"latencyP99Ms"`
+}
+
+func (s *deviceStats) recordLogin() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastLogin = time.Now()
+}
+
+func (s *deviceStats) recordLatency(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.latencies) < latencySamples {
+		s.latencies = append(s.latencies, d)
+		return
+	}
+	s.latencies[s.next] = d
+	s.next = (s.next + 1) % latencySamples
+}
+
+// recordRequest records the latency of a request to endpoint, e.g. "login" or
+// "update", in the latency percentiles and the endpoint's histogram.
+func (s *deviceStats) recordRequest(endpoint string, d time.Duration) {
+	s.recordLatency(d)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.endpoint(endpoint).latency.observe(d.Seconds())
+}
+
+// recordResponseSize records the size of a response from endpoint.
+func (s *deviceStats) recordResponseSize(endpoint string, n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.endpoint(endpoint).size.observe(float64(n))
+}
+
+// trackResponseSize makes resp record its body size for endpoint once it is
+// closed.
+func (s *deviceStats) trackResponseSize(endpoint string, resp *http.Response) {
+	resp.Body = &countingBody{
+		body:    resp.Body,
+		onClose: func(n int64) { s.recordResponseSize(endpoint, n) },
+	}
+}
+
+// countingBody counts the bytes of a response body. Unread bytes are drained
+// on Close so the full size is reported. The body is a named field rather
+// than an embedded io.ReadCloser, which Yaegi can't pass to net/http.
+type countingBody struct {
+	body    io.ReadCloser
+	n       int64
+	onClose func(n int64)
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+func (b *countingBody) Close() error {
+	n, _ := io.Copy(io.Discard, b.body)
+	b.onClose(b.n + n)
+	return b.body.Close()
+}
+
+// endpoint returns the stats of endpoint, creating them if needed. It expects
+// s.mu to be held.
+func (s *deviceStats) endpoint(endpoint string) *endpointStats {
+	if s.endpoints == nil {
+		s.endpoints = make(map[string]*endpointStats)
+	}
+	stats, ok := s.endpoints[endpoint]
+	if !ok {
+		stats = newEndpointStats()
+		s.endpoints[endpoint] = stats
+	}
+	return stats
+}
+
+// endpointHistograms returns copies of the histograms of every endpoint,
+// sorted by endpoint.
+func (s *deviceStats) endpointHistograms() (endpoints []string, latency, size []*histogram) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for endpoint := range s.endpoints {
+		endpoints = append(endpoints, endpoint)
+	}
+	sort.Strings(endpoints)
+	for _, endpoint := range endpoints {
+		latency = append(latency, s.endpoints[endpoint].latency.clone())
+		size = append(size, s.endpoints[endpoint].size.clone())
+	}
+	return endpoints, latency, size
+}
+
+func (s *deviceStats) recordSuccess() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.consecutiveFailures = 0
+	s.openedAt = time.Time{}
+	s.trialStartedAt = time.Time{}
+}
+
+func (s *deviceStats) recordFailure(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastError = err.Error()
+	s.consecutiveFailures++
+	s.trialStartedAt = time.Time{}
+	if s.consecutiveFailures >= circuitBreakerThreshold {
+		// Opens the circuit, or re-opens it after a failed half-open trial
+		s.openedAt = time.Now()
+	}
+}
+
+func (s *deviceStats) setManagedRecords(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.managedRecords = n
+}
+
+// setDegraded sets whether the device only accepts reads, and reports whether
+// that changed.
+func (s *deviceStats) setDegraded(degraded bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changed := s.degraded != degraded
+	s.degraded = degraded
+	return changed
+}
+
+// allow reports whether requests may be sent to the device. Once the circuit
+// is open, a single trial is allowed after the cooldown has passed, and all
+// other requests are rejected until its outcome is recorded. A trial whose
+// outcome is never recorded, as its hostname was skipped, expires after
+// another cooldown.
+func (s *deviceStats) allow() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch s.state() {
+	case circuitClosed:
+		return true
+	case circuitHalfOpen:
+		if !s.trialStartedAt.IsZero() && time.Since(s.trialStartedAt) < circuitBreakerCooldown {
+			return false
+		}
+		s.trialStartedAt = time.Now()
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *deviceStats) circuitState() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state()
+}
+
+// state returns the circuit breaker state. It expects s.mu to be held.
+func (s *deviceStats) state() string {
+	switch {
+	case s.consecutiveFailures < circuitBreakerThreshold:
+		return circuitClosed
+	case time.Since(s.openedAt) < circuitBreakerCooldown:
+		return circuitOpen
+	default:
+		return circuitHalfOpen
+	}
+}
+
+func (s *deviceStats) snapshot(id, host string) deviceStatus {
+	state := s.circuitState()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sorted := make([]time.Duration, len(s.latencies))
+	copy(sorted, s.latencies)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	return deviceStatus{
+		ID:                  id,
+		Host:                host,
+		LastLogin:           s.lastLogin,
+		LastError:           s.lastError,
+		ConsecutiveFailures: s.consecutiveFailures,
+		CircuitState:        state,
+		ManagedRecords:      s.managedRecords,
+		Degraded:            s.degraded,
+		LatencyP50Ms:        percentileMs(sorted, 0.50),
+		LatencyP90Ms:        percentileMs(sorted, 0.90),
+		LatencyP99Ms:        percentileMs(sorted, 0.99),
+	}
+}
+
+// percentileMs returns the nearest-rank percentile of sorted in milliseconds.
+func percentileMs(sorted []time.Duration, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	return float64(sorted[idx]) / float64(time.Millisecond)
+}
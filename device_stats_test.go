@@ -0,0 +1,153 @@
+package traefikunifidns
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeviceStatsCircuitBreaker(t *testing.T) {
+	var s deviceStats
+	assert.Equal(t, circuitClosed, s.circuitState())
+
+	for i := 0; i < circuitBreakerThreshold-1; i++ {
+		s.recordFailure(errors.New("connection refused"))
+	}
+	assert.Equal(t, circuitClosed, s.circuitState())
+	assert.True(t, s.allow())
+
+	s.recordFailure(errors.New("connection refused"))
+	assert.Equal(t, circuitOpen, s.circuitState())
+	assert.False(t, s.allow())
+
+	// After the cooldown a single trial is allowed
+	s.openedAt = time.Now().Add(-circuitBreakerCooldown)
+	assert.Equal(t, circuitHalfOpen, s.circuitState())
+	assert.True(t, s.allow())
+	assert.False(t, s.allow(), "only one trial at a time")
+	assert.Equal(t, circuitHalfOpen, s.circuitState())
+
+	// A failed trial re-opens the circuit
+	s.recordFailure(errors.New("connection refused"))
+	assert.Equal(t, circuitOpen, s.circuitState())
+
+	s.openedAt = time.Now().Add(-circuitBreakerCooldown)
+	assert.True(t, s.allow())
+	s.recordSuccess()
+	assert.Equal(t, circuitClosed, s.circuitState())
+	assert.True(t, s.allow())
+	assert.True(t, s.allow())
+	assert.Equal(t, "connection refused", s.snapshot("device-0", "192.168.1.1").LastError)
+}
+
+func TestDeviceStatsLatencyPercentiles(t *testing.T) {
+	var s deviceStats
+	for i := 1; i <= 100; i++ {
+		s.recordLatency(time.Duration(i) * time.Millisecond)
+	}
+
+	status := s.snapshot("device-0", "192.168.1.1")
+	assert.Equal(t, 50.0, status.LatencyP50Ms)
+	assert.Equal(t, 90.0, status.LatencyP90Ms)
+	assert.Equal(t, 99.0, status.LatencyP99Ms)
+
+	// Only the most recent samples are kept
+	for i := 0; i < latencySamples; i++ {
+		s.recordLatency(time.Second)
+	}
+	assert.Len(t, s.latencies, latencySamples)
+	assert.Equal(t, 1000.0, s.snapshot("device-0", "192.168.1.1").LatencyP50Ms)
+}
+
+func TestDeviceStatsLogin(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Csrf-Token", "test-csrf-token")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client := &UniFiClient{
+		client:   &http.Client{},
+		baseURL:  server.URL,
+		username: "admin",
+		password: newSecret("password"),
+	}
+	require.NoError(t, client.login(context.Background()))
+
+	status := client.stats.snapshot("device-0", server.URL)
+	assert.False(t, status.LastLogin.IsZero())
+	assert.Len(t, client.stats.latencies, 1)
+
+	endpoints, latency, size := client.stats.endpointHistograms()
+	assert.Equal(t, []string{endpointLogin}, endpoints)
+	assert.Equal(t, uint64(1), latency[0].count)
+	assert.Equal(t, uint64(1), size[0].count)
+}
+
+func TestDeviceStatsResponseSize(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(make([]byte, 2000))
+	}))
+	defer server.Close()
+
+	var s deviceStats
+	resp, err := http.Get(server.URL)
+	require.NoError(t, err)
+	s.trackResponseSize(endpointList, resp)
+	// Unread bytes are counted as well
+	_, err = resp.Body.Read(make([]byte, 10))
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	_, _, size := s.endpointHistograms()
+	require.Len(t, size, 1)
+	assert.Equal(t, 2000.0, size[0].sum)
+	assert.Equal(t, []uint64{0, 0, 1, 0, 0, 0, 0}, size[0].counts)
+}
+
+func TestUpdateDNSSkipsOpenCircuit(t *testing.T) {
+	traefikServer := newTestTraefikServer(t, []map[string]interface{}{
+		{"name": "app", "rule": "Host(`app.lan`)", "middlewares": []string{"traefikunifidns"}},
+	})
+	var writes []map[string]interface{}
+	unifiServer := newTestUniFiServer(t, []DNSEntry{}, &writes)
+
+	u := newTestAdminPlugin(CreateConfig())
+	u.traefikClient = NewTraefikClient(traefikServer.URL, false)
+	u.config.IPOverrides = map[string]string{"app.lan": "192.168.1.20"}
+	client := NewUniFiClient(unifiServer.URL, "admin", "password", false)
+	u.config.Devices = []UnifiDeviceConfig{{Host: unifiServer.URL, Pattern: `\.lan$`}}
+	u.providers = map[string]dnsProvider{"device-0": client}
+	u.devicePatterns = map[string]*regexp.Regexp{"device-0": regexp.MustCompile(`\.lan$`)}
+
+	for i := 0; i < circuitBreakerThreshold; i++ {
+		client.stats.recordFailure(errors.New("connection refused"))
+	}
+	require.NoError(t, u.updateDNS(context.Background()).Err)
+	assert.Empty(t, writes)
+
+	client.stats.recordSuccess()
+	require.NoError(t, u.updateDNS(context.Background()).Err)
+	assert.Len(t, writes, 1)
+
+	status := u.status()
+	require.Len(t, status.Devices, 1)
+	assert.Equal(t, "device-0", status.Devices[0].ID)
+	assert.Equal(t, 1, status.Devices[0].ManagedRecords)
+	assert.Equal(t, circuitClosed, status.Devices[0].CircuitState)
+
+	metrics := u.metrics()
+	for _, endpoint := range []string{endpointLogin, endpointList, endpointCreate} {
+		assert.Contains(t, metrics, `unifidns_api_request_duration_seconds_count{device="device-0",host="`+unifiServer.URL+`",endpoint="`+endpoint+`"}`)
+		assert.Contains(t, metrics, `unifidns_api_response_size_bytes_count{device="device-0",host="`+unifiServer.URL+`",endpoint="`+endpoint+`"}`)
+	}
+	assert.Contains(t, metrics, `unifidns_api_request_duration_seconds_count{device="traefik",host="`+traefikServer.URL+`",endpoint="routers"} 2`)
+	assert.Contains(t, metrics, `unifidns_api_response_size_bytes_count{device="traefik",host="`+traefikServer.URL+`",endpoint="routers"} 2`)
+}
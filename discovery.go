@@ -0,0 +1,114 @@
+package traefikunifidns
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// routerSignature captures everything about a router that affects the record
+// published for its hostname.
+func routerSignature(router TraefikRouter) string {
+	return strings.Join([]string{router.Rule, router.Service, strings.Join(router.EntryPoints, ",")}, "\x00")
+}
+
+// routerSignatures returns the signatures of the routers of every hostname.
+// Hostnames served by several routers get the sorted signatures of all of
+// them.
+func routerSignatures(pending []routerHostname) map[string]string {
+	byHostname := make(map[string][]string)
+	for _, p := range pending {
+		byHostname[p.hostname] = append(byHostname[p.hostname], routerSignature(p.router))
+	}
+	signatures := make(map[string]string, len(byHostname))
+	for hostname, sigs := range byHostname {
+		sort.Strings(sigs)
+		signatures[hostname] = strings.Join(sigs, "\x01")
+	}
+	return signatures
+}
+
+// changedHostnames returns the sorted hostnames whose routers were added or
+// changed between previous and current. Removed hostnames are left to the
+// next full cycle, which removes their records.
+func changedHostnames(previous, current map[string]string) []string {
+	var changed []string
+	for hostname, signature := range current {
+		if previous[hostname] != signature {
+			changed = append(changed, hostname)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// mergeTargeted records the outcome of a targeted update of the hostnames in
+// only. It expects r.mu to be held.
+func (r *reconciler) mergeTargeted(only, published, unmatched map[string]bool, signatures map[string]string) {
+	if r.knownHosts == nil {
+		r.knownHosts = make(map[string]bool)
+	}
+	if r.unmatched == nil {
+		r.unmatched = make(map[string]bool)
+	}
+	if r.routerSignatures == nil {
+		r.routerSignatures = make(map[string]string)
+	}
+	for hostname := range only {
+		if published[hostname] {
+			r.knownHosts[hostname] = true
+		} else {
+			delete(r.knownHosts, hostname)
+		}
+		if unmatched[hostname] {
+			r.unmatched[hostname] = true
+		} else {
+			delete(r.unmatched, hostname)
+		}
+		if signature, ok := signatures[hostname]; ok {
+			r.routerSignatures[hostname] = signature
+		}
+	}
+}
+
+// discover polls the Traefik routers and immediately updates the hostnames
+// whose routers were added or changed since the last update, instead of
+// waiting for the next full cycle. It reports whether an update ran.
+func (r *reconciler) discover(ctx context.Context) bool {
+	r.traefikClient.resetRawData()
+	pending, err := r.collectHostnames(ctx)
+	if err != nil {
+		logError("Failed to discover hostnames: %v", err)
+		return false
+	}
+	r.recordDiscovery()
+	current := routerSignatures(pending)
+
+	r.mu.RLock()
+	previous := r.routerSignatures
+	r.mu.RUnlock()
+	if previous == nil {
+		// Nothing to compare with before the first full cycle
+		return false
+	}
+
+	changed := changedHostnames(previous, current)
+	if len(changed) == 0 {
+		return false
+	}
+	log.Printf("INFO: Discovered %d added or changed hostnames", len(changed))
+	if result := r.syncHostnames(ctx, changed); result.Err != nil {
+		logError("DNS update of changed hostnames failed: %v", result.Err)
+	}
+	return true
+}
+
+// recordDiscovery counts a successful poll of the Traefik routers.
+func (r *reconciler) recordDiscovery() {
+	r.stats.mu.Lock()
+	defer r.stats.mu.Unlock()
+	r.stats.discoveries++
+	r.stats.lastDiscovery = time.Now()
+}
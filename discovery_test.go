@@ -0,0 +1,86 @@
+package traefikunifidns
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChangedHostnames(t *testing.T) {
+	previous := routerSignatures([]routerHostname{
+		{router: TraefikRouter{Rule: "Host(`app.lan`)", EntryPoints: []string{"web"}}, hostname: "app.lan"},
+		{router: TraefikRouter{Rule: "Host(`nas.lan`)"}, hostname: "nas.lan"},
+		{router: TraefikRouter{Rule: "Host(`old.lan`)"}, hostname: "old.lan"},
+	})
+	current := routerSignatures([]routerHostname{
+		{router: TraefikRouter{Rule: "Host(`app.lan`)", EntryPoints: []string{"lan"}}, hostname: "app.lan"},
+		{router: TraefikRouter{Rule: "Host(`nas.lan`)"}, hostname: "nas.lan"},
+		{router: TraefikRouter{Rule: "Host(`new.lan`)"}, hostname: "new.lan"},
+	})
+	assert.Equal(t, []string{"app.lan", "new.lan"}, changedHostnames(previous, current))
+	assert.Empty(t, changedHostnames(current, current))
+}
+
+func TestDiscover(t *testing.T) {
+	rules := []string{"Host(`app.lan`)"}
+	traefikServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		routers := make([]map[string]interface{}, 0, len(rules))
+		for _, rule := range rules {
+			routers = append(routers, map[string]interface{}{"rule": rule, "middlewares": []string{"traefikunifidns"}})
+		}
+		_ = json.NewEncoder(w).Encode(routers)
+	}))
+	defer traefikServer.Close()
+	var changes []webhookChange
+	webhookServer := newTestWebhookServer(t, &changes)
+
+	config := CreateConfig()
+	config.TraefikAPIURL = traefikServer.URL
+	config.Devices = []UnifiDeviceConfig{{WebhookURL: webhookServer.URL, Pattern: `\.lan$`}}
+	config.IPOverrides = map[string]string{"app.lan": "192.168.1.10", "nas.lan": "192.168.1.20"}
+	config.DiscoveryInterval = "1h"
+
+	plugin, err := New(context.Background(), nil, config, "test")
+	require.NoError(t, err)
+	u := plugin.(*UniFiDNS)
+	require.Len(t, changes, 1)
+	assert.False(t, u.discover(context.Background()), "nothing changed")
+
+	rules = append(rules, "Host(`nas.lan`)")
+	assert.True(t, u.discover(context.Background()))
+	require.Len(t, changes, 2)
+	assert.Equal(t, webhookCreate, changes[1].Action)
+	assert.Equal(t, "nas.lan", changes[1].Record.Hostname)
+	require.Len(t, u.results, 1, "only the new hostname is updated")
+	assert.False(t, u.discover(context.Background()))
+
+	status := u.status()
+	require.NotNil(t, status.LastCycle)
+	assert.Equal(t, []string{"nas.lan"}, status.LastCycle.Hostnames)
+	require.Len(t, status.Records, 1, "targeted updates leave the table alone")
+	assert.Equal(t, "app.lan", status.Records[0].Hostname)
+
+	// Removed hostnames are left to the next full cycle
+	rules = rules[1:]
+	assert.False(t, u.discover(context.Background()))
+	assert.Len(t, changes, 2)
+
+	status = u.status()
+	assert.Equal(t, 4, status.Discoveries)
+	assert.False(t, status.LastDiscovery.IsZero())
+	assert.Contains(t, u.metrics(), "unifidns_discoveries_total 4\n")
+}
+
+func TestNewInvalidDiscoveryInterval(t *testing.T) {
+	for _, interval := range []string{"soon", "0s"} {
+		config := CreateConfig()
+		config.DiscoveryInterval = interval
+		_, err := New(context.Background(), nil, config, "test")
+		assert.ErrorContains(t, err, "invalid discovery interval")
+	}
+}
@@ -0,0 +1,124 @@
+//go:build e2e
+
+// Package e2e runs the plugin inside a real Traefik against a mock UniFi
+// controller, started with docker compose. Run with "make e2e".
+package e2e
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/cookiejar"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+const (
+	controllerURL = "http://localhost:18080"
+	traefikURL    = "http://localhost:18000"
+)
+
+type dnsEntry struct {
+	Key        string `json:"key"`
+	Value      string `json:"value"`
+	RecordType string `json:"record_type"`
+}
+
+func TestRecordCreatedEndToEnd(t *testing.T) {
+	startFixture(t)
+
+	waitFor(t, 3*time.Minute, "the record on the mock controller", func() bool {
+		entries, err := controllerEntries()
+		if err != nil {
+			return false
+		}
+		for _, entry := range entries {
+			if entry.Key == "app.e2e.lan" && entry.Value == "192.0.2.10" && entry.RecordType == "A" {
+				return true
+			}
+		}
+		return false
+	})
+
+	// The middleware passes requests on to the service
+	req, err := http.NewRequest(http.MethodGet, traefikURL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Host = "app.e2e.lan"
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request through Traefik failed: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected status 200 through Traefik, got %d", resp.StatusCode)
+	}
+}
+
+// startFixture starts the compose project and tears it down when the test
+// ends, printing the logs if it failed.
+func startFixture(t *testing.T) {
+	t.Helper()
+	compose(t, "up", "--detach", "--wait")
+	t.Cleanup(func() {
+		if t.Failed() {
+			compose(t, "logs")
+		}
+		compose(t, "down", "--volumes")
+	})
+}
+
+func compose(t *testing.T, args ...string) {
+	t.Helper()
+	cmd := exec.Command("docker", append([]string{"compose", "--project-name", "traefikunifidns-e2e"}, args...)...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("docker compose %v failed: %v", args, err)
+	}
+}
+
+// waitFor polls condition every second until it holds or timeout expires.
+func waitFor(t *testing.T, timeout time.Duration, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out after %s waiting for %s", timeout, what)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// controllerEntries logs in to the mock controller and lists its records.
+func controllerEntries() ([]dnsEntry, error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Jar: jar, Timeout: 5 * time.Second}
+
+	body, _ := json.Marshal(map[string]string{"username": "admin", "password": "password"})
+	resp, err := client.Post(controllerURL+"/api/auth/login", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	_ = resp.Body.Close()
+
+	req, err := http.NewRequest(http.MethodGet, controllerURL+"/proxy/network/v2/api/site/default/static-dns", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Csrf-Token", resp.Header.Get("X-Csrf-Token"))
+	resp, err = client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var entries []dnsEntry
+	err = json.NewDecoder(resp.Body).Decode(&entries)
+	return entries, err
+}
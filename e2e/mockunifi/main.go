@@ -0,0 +1,163 @@
+// Command mockunifi is a minimal in-memory UniFi Network controller for the
+// end-to-end fixture. It implements the login, static DNS and health
+// endpoints used by the plugin, with the same session cookie and CSRF token
+// checks as a console.
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+const staticDNSPath = "/proxy/network/v2/api/site/default/static-dns"
+
+type entry struct {
+	ID         string `json:"_id"`
+	Key        string `json:"key"`
+	Value      string `json:"value"`
+	RecordType string `json:"record_type,omitempty"`
+	TTL        int    `json:"ttl,omitempty"`
+	Enabled    bool   `json:"enabled"`
+}
+
+type controller struct {
+	username, password string
+
+	mu       sync.Mutex
+	sessions map[string]string // CSRF token by session cookie
+	entries  []entry
+}
+
+func main() {
+	addr := os.Getenv("MOCKUNIFI_ADDR")
+	if addr == "" {
+		addr = ":8080"
+	}
+	c := &controller{
+		username: envOr("MOCKUNIFI_USERNAME", "admin"),
+		password: envOr("MOCKUNIFI_PASSWORD", "password"),
+		sessions: make(map[string]string),
+	}
+	log.Printf("Mock UniFi controller listening on %s", addr)
+	log.Fatal(http.ListenAndServe(addr, c))
+}
+
+func (c *controller) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Printf("%s %s", r.Method, r.URL.Path)
+	if r.URL.Path == "/api/auth/login" && r.Method == http.MethodPost {
+		c.login(w, r)
+		return
+	}
+	if !c.authorized(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case r.URL.Path == "/proxy/network/api/s/default/stat/health":
+		writeJSON(w, map[string]interface{}{"data": []map[string]string{{"subsystem": "wan", "wan_ip": "203.0.113.1"}}})
+	case r.URL.Path == staticDNSPath && r.Method == http.MethodGet:
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		writeJSON(w, c.entries)
+	case r.URL.Path == staticDNSPath && r.Method == http.MethodPost:
+		var e entry
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		e.ID = randomHex(12)
+		c.entries = append(c.entries, e)
+		writeJSON(w, e)
+	case strings.HasPrefix(r.URL.Path, staticDNSPath+"/"):
+		c.modify(w, r, strings.TrimPrefix(r.URL.Path, staticDNSPath+"/"))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (c *controller) login(w http.ResponseWriter, r *http.Request) {
+	var credentials struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&credentials); err != nil ||
+		credentials.Username != c.username || credentials.Password != c.password {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	session, csrf := randomHex(16), randomHex(16)
+	c.mu.Lock()
+	c.sessions[session] = csrf
+	c.mu.Unlock()
+	http.SetCookie(w, &http.Cookie{Name: "TOKEN", Value: session, Path: "/"})
+	w.Header().Set("X-Csrf-Token", csrf)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (c *controller) authorized(r *http.Request) bool {
+	cookie, err := r.Cookie("TOKEN")
+	if err != nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	csrf, ok := c.sessions[cookie.Value]
+	return ok && r.Header.Get("X-Csrf-Token") == csrf
+}
+
+func (c *controller) modify(w http.ResponseWriter, r *http.Request, id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, e := range c.entries {
+		if e.ID != id {
+			continue
+		}
+		switch r.Method {
+		case http.MethodDelete:
+			c.entries = append(c.entries[:i], c.entries[i+1:]...)
+			w.WriteHeader(http.StatusOK)
+		case http.MethodPut:
+			var updated entry
+			if err := json.NewDecoder(r.Body).Decode(&updated); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			updated.ID = id
+			c.entries[i] = updated
+			writeJSON(w, updated)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNotFound)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to encode response: %v", err)
+	}
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
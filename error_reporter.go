@@ -0,0 +1,127 @@
+package traefikunifidns
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// errTargetNotAllowed is reported for records whose target is outside the
+// allowedTargetCIDRs.
+var errTargetNotAllowed = errors.New("target is outside allowedTargetCIDRs")
+
+// statusError is returned when an API responds with an unexpected status code.
+type statusError struct {
+	message    string
+	statusCode int
+}
+
+func newStatusError(statusCode int, format string) *statusError {
+	return &statusError{message: fmt.Sprintf(format, statusCode), statusCode: statusCode}
+}
+
+func (e *statusError) Error() string {
+	return e.message
+}
+
+// retryable reports whether err may go away on its own in a later cycle.
+// Client errors such as rejected credentials or payloads, and records refused
+// by the configuration, are not retryable.
+func retryable(err error) bool {
+	if errors.Is(err, errTargetNotAllowed) {
+		return false
+	}
+	var se *statusError
+	if errors.As(err, &se) && se.statusCode >= 400 && se.statusCode < 500 {
+		return se.statusCode == http.StatusRequestTimeout || se.statusCode == http.StatusTooManyRequests
+	}
+	return true
+}
+
+// errorEvent is the structured context passed to an errorReporter.
+type errorEvent struct {
+	Time       time.Time `json:"time"`
+	Message    string    `json:"message"`
+	Hostname   string    `json:"hostname,omitempty"`
+	Device     string    `json:"device,omitempty"`
+	StatusCode int       `json:"statusCode,omitempty"`
+}
+
+func newErrorEvent(err error, hostname, device string) errorEvent {
+	event := errorEvent{Time: time.Now(), Message: err.Error(), Hostname: hostname, Device: device}
+	var se *statusError
+	if errors.As(err, &se) {
+		event.StatusCode = se.statusCode
+	}
+	return event
+}
+
+// errorReporter is notified of every failure that isn't retryable, so it can
+// be forwarded to error tracking tools.
+type errorReporter interface {
+	report(event errorEvent) error
+}
+
+// reportErrors passes the non-retryable failures of a cycle to the error
+// reporter. Failed records are reported once, with their hostname, rather
+// than again as part of the cycle error.
+func (r *reconciler) reportErrors(results []hostResult, cycleErr error) {
+	var events []errorEvent
+	if cycleErr != nil && !errors.Is(cycleErr, errRecordsFailed) && !retryable(cycleErr) {
+		events = append(events, newErrorEvent(cycleErr, "", ""))
+	}
+	for _, result := range results {
+		if result.err != nil && !retryable(result.err) {
+			events = append(events, newErrorEvent(result.err, result.Hostname, result.Device))
+		}
+	}
+	for _, event := range events {
+		if err := r.errorReporter.report(event); err != nil {
+			logError("Failed to report error: %v", err)
+		}
+	}
+}
+
+// httpErrorReporter POSTs every errorEvent as JSON to a collector URL.
+type httpErrorReporter struct {
+	client *http.Client
+	url    string
+}
+
+func newHTTPErrorReporter(url string) *httpErrorReporter {
+	log.Printf("INFO: Reporting non-retryable errors to: %s", url)
+	return &httpErrorReporter{
+		client: &http.Client{Timeout: 10 * time.Second},
+		url:    url,
+	}
+}
+
+func (h *httpErrorReporter) report(event errorEvent) error {
+	jsonData, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal error event: %w", err)
+	}
+
+	resp, err := h.client.Post(h.url, "application/json", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return fmt.Errorf("failed to send error event: %w", err)
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			log.Printf("ERROR: Failed to close response body: %v", closeErr)
+		}
+	}()
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return fmt.Errorf("failed to read error collector response: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("error collector failed with status: %d", resp.StatusCode)
+	}
+	return nil
+}
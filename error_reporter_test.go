@@ -0,0 +1,96 @@
+package traefikunifidns
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeErrorReporter struct {
+	events []errorEvent
+}
+
+func (f *fakeErrorReporter) report(event errorEvent) error {
+	f.events = append(f.events, event)
+	return nil
+}
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{errors.New("connection refused"), true},
+		{newStatusError(http.StatusInternalServerError, "DNS operation failed with status: %d"), true},
+		{newStatusError(http.StatusTooManyRequests, "DNS operation failed with status: %d"), true},
+		{newStatusError(http.StatusRequestTimeout, "DNS operation failed with status: %d"), true},
+		{newStatusError(http.StatusBadRequest, "DNS operation failed with status: %d"), false},
+		{fmt.Errorf("failed to login: %w", newStatusError(http.StatusForbidden, "login failed with status: %d")), false},
+		{fmt.Errorf("refusing to publish: %w", errTargetNotAllowed), false},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, retryable(tt.err), tt.err.Error())
+	}
+}
+
+func TestStatusErrorMessage(t *testing.T) {
+	err := newStatusError(http.StatusBadRequest, "DNS operation failed with status: %d")
+	assert.EqualError(t, err, "DNS operation failed with status: 400")
+}
+
+func TestHTTPErrorReporter(t *testing.T) {
+	var received errorEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		if received.Hostname == "broken.lan" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	reporter := newHTTPErrorReporter(server.URL)
+	statusErr := newStatusError(http.StatusBadRequest, "DNS operation failed with status: %d")
+	require.NoError(t, reporter.report(newErrorEvent(statusErr, "app.lan", "https://unifi.lan")))
+	assert.Equal(t, "DNS operation failed with status: 400", received.Message)
+	assert.Equal(t, "app.lan", received.Hostname)
+	assert.Equal(t, "https://unifi.lan", received.Device)
+	assert.Equal(t, http.StatusBadRequest, received.StatusCode)
+
+	err := reporter.report(newErrorEvent(errors.New("boom"), "broken.lan", ""))
+	assert.EqualError(t, err, "error collector failed with status: 503")
+}
+
+func TestSyncReportsNonRetryableErrors(t *testing.T) {
+	traefikServer := newTestTraefikServer(t, []map[string]interface{}{
+		{"name": "app", "rule": "Host(`app.lan`)", "middlewares": []string{"traefikunifidns"}},
+		{"name": "nas", "rule": "Host(`nas.lan`)", "middlewares": []string{"traefikunifidns"}},
+	})
+	var writes []map[string]interface{}
+	unifiServer := newTestUniFiServer(t, []DNSEntry{}, &writes)
+
+	config := CreateConfig()
+	config.TraefikAPIURL = traefikServer.URL
+	config.Devices = []UnifiDeviceConfig{
+		{Host: unifiServer.URL, Username: "admin", Password: "password", Pattern: `\.lan$`},
+	}
+	config.IPOverrides = map[string]string{"app.lan": "172.17.0.2", "nas.lan": "192.168.1.20"}
+	config.AllowedTargetCIDRs = []string{"192.168.0.0/16"}
+	r, err := newReconciler(config)
+	require.NoError(t, err)
+	reporter := &fakeErrorReporter{}
+	r.errorReporter = reporter
+
+	require.ErrorIs(t, r.sync(context.Background()).Err, errRecordsFailed)
+	require.Len(t, reporter.events, 1)
+	assert.Equal(t, "app.lan", reporter.events[0].Hostname)
+	assert.Equal(t, "refusing to publish 172.17.0.2 for app.lan: target is outside allowedTargetCIDRs", reporter.events[0].Message)
+}
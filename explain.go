@@ -0,0 +1,108 @@
+package traefikunifidns
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// actionUnknown is reported for devices that can't tell what publishing a
+// record would do without doing it.
+const actionUnknown = "unknown"
+
+// matchReport describes which devices a hostname would be published to, in
+// the order they are processed, and what each of them would do with it.
+type matchReport struct {
+	Hostname string          `json:"hostname"`
+	Devices  []hostnameMatch `json:"devices"`
+}
+
+// hostnameMatch is the outcome a hostname would have on a single device.
+type hostnameMatch struct {
+	ID      string   `json:"id"`
+	Host    string   `json:"host"`
+	Default bool     `json:"default,omitempty"` // Matched as the default device, not by its pattern
+	Name    string   `json:"name,omitempty"`    // Record name after applying the name template
+	Targets []string `json:"targets,omitempty"`
+	Action  string   `json:"action"`
+	Reason  string   `json:"reason,omitempty"`
+}
+
+// explain evaluates hostname against the device patterns and plans its record
+// on every matching device without changing anything. Routers may publish a
+// different target when entryPointTargets is enabled, which isn't known here.
+func (r *reconciler) explain(ctx context.Context, hostname string) matchReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	report := matchReport{Hostname: hostname, Devices: []hostnameMatch{}}
+	devices := r.matchingDevices(hostname)
+	if len(devices) == 0 {
+		return report
+	}
+
+	localIPs, localErr := r.resolveDefaultTargets(ctx)
+
+	for _, device := range devices {
+		id := fmt.Sprintf("device-%d", device)
+		config := r.config.Devices[device]
+		match := hostnameMatch{ID: id, Host: config.Host, Action: resultSkipped}
+		if config.WebhookURL != "" {
+			match.Host = config.WebhookURL
+		}
+		if pattern, ok := r.devicePatterns[id]; config.Default && (!ok || !pattern.MatchString(hostname)) {
+			match.Default = true
+		}
+		report.Devices = append(report.Devices, r.planMatch(ctx, match, hostname, device, localIPs, localErr))
+	}
+	return report
+}
+
+// planMatch fills in the record name, targets and planned action of match.
+func (r *reconciler) planMatch(ctx context.Context, match hostnameMatch, hostname string, device int, localIPs []string, localErr error) hostnameMatch {
+	name, err := r.recordName(hostname, device)
+	if err != nil {
+		match.Action, match.Reason = resultFailed, err.Error()
+		return match
+	}
+	match.Name = name
+
+	switch {
+	case containsFold(r.config.NeverManage, strings.TrimSuffix(name, ".")):
+		match.Reason = "never managed"
+		return match
+	case r.apexProtected(name):
+		match.Reason = "protected zone apex"
+		return match
+	}
+
+	if localErr != nil {
+		match.Action, match.Reason = resultFailed, fmt.Sprintf("failed to get target IP: %v", localErr)
+		return match
+	}
+	targets, err := r.targetIPs(ctx, name, device, localIPs)
+	if err != nil {
+		match.Action, match.Reason = resultFailed, err.Error()
+		return match
+	}
+	match.Targets = targets
+	for _, target := range targets {
+		if !r.targetAllowed(target) {
+			match.Reason = "target outside allowedTargetCIDRs"
+			return match
+		}
+	}
+
+	p, ok := r.providers[fmt.Sprintf("device-%d", device)].(planner)
+	if !ok || len(targets) > 1 {
+		match.Action = actionUnknown
+		return match
+	}
+	action, err := r.plannedAction(ctx, p, name, targets[0], r.config.TTLOverrides[name])
+	if err != nil {
+		match.Action, match.Reason = resultFailed, err.Error()
+		return match
+	}
+	match.Action = action
+	return match
+}
@@ -0,0 +1,65 @@
+package traefikunifidns
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExplain(t *testing.T) {
+	hook := newWebhookProvider("http://hook.lan", false)
+	hook.published["app.lan"] = webhookRecord{Hostname: "app.lan", Type: "A", Value: "192.168.1.10"}
+
+	config := CreateConfig()
+	config.Devices = []UnifiDeviceConfig{
+		{WebhookURL: "http://hook.lan", Pattern: `\.lan$`},
+		{WebhookURL: "http://other.lan", Default: true},
+	}
+	config.IPOverrides = map[string]string{"app.lan": "192.168.1.11", "nas.lan": "192.168.1.20", "example.com": "192.168.1.30"}
+	config.NeverManage = []string{"gateway.lan"}
+	r := &reconciler{
+		config: config,
+		providers: map[string]dnsProvider{
+			"device-0": hook,
+			"device-1": newWebhookProvider("http://other.lan", false),
+		},
+		devicePatterns: map[string]*regexp.Regexp{"device-0": regexp.MustCompile(`\.lan$`)},
+	}
+
+	assert.Equal(t, matchReport{Hostname: "app.lan", Devices: []hostnameMatch{
+		{ID: "device-0", Host: "http://hook.lan", Name: "app.lan", Targets: []string{"192.168.1.11"}, Action: recordUpdated},
+	}}, r.explain(context.Background(), "app.lan"))
+	assert.Equal(t, matchReport{Hostname: "gateway.lan", Devices: []hostnameMatch{
+		{ID: "device-0", Host: "http://hook.lan", Name: "gateway.lan", Action: resultSkipped, Reason: "never managed"},
+	}}, r.explain(context.Background(), "gateway.lan"))
+	assert.Equal(t, matchReport{Hostname: "example.com", Devices: []hostnameMatch{
+		{ID: "device-1", Host: "http://other.lan", Default: true, Name: "example.com", Targets: []string{"192.168.1.30"}, Action: recordCreated},
+	}}, r.explain(context.Background(), "example.com"))
+
+	config.Devices = config.Devices[:1]
+	assert.Equal(t, matchReport{Hostname: "example.com", Devices: []hostnameMatch{}}, r.explain(context.Background(), "example.com"))
+	assert.Empty(t, hook.published["nas.lan"], "nothing is published")
+}
+
+func TestAdminMatch(t *testing.T) {
+	config := CreateConfig()
+	config.AdminPath = "/.unifidns"
+	u := newTestAdminPlugin(config)
+
+	w := httptest.NewRecorder()
+	u.ServeHTTP(w, httptest.NewRequest("GET", "/.unifidns/match", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	u.ServeHTTP(w, httptest.NewRequest("GET", "/.unifidns/match?hostname=app.lan", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var report matchReport
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&report))
+	assert.Equal(t, matchReport{Hostname: "app.lan", Devices: []hostnameMatch{}}, report)
+}
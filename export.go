@@ -0,0 +1,184 @@
+package traefikunifidns
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const defaultExportTTL = 300
+
+// ZoneFileConfig configures the export of managed records as a BIND zone file
+// snippet.
+type ZoneFileConfig struct {
+	Path   string `json:"path"`
+	Origin string `json:"origin,omitempty"` // Names below the origin are written relative to it
+	TTL    int    `json:"ttl,omitempty"`    // Default TTL of the snippet, defaults to 300
+}
+
+// UnboundFileConfig configures the export of managed records as an Unbound
+// local-data include file.
+type UnboundFileConfig struct {
+	Path string `json:"path"`
+	TTL  int    `json:"ttl,omitempty"` // TTL of records without an override, defaults to 300
+}
+
+// exportRecords writes the records published in a cycle to the configured
+// export files.
+func (r *reconciler) exportRecords(results []hostResult) {
+	if r.config.ZoneFile == nil && r.config.UnboundFile == nil {
+		return
+	}
+	records := publishedRecords(results)
+
+	if r.config.ZoneFile != nil {
+		if err := writeFileIfChanged(r.config.ZoneFile.Path, renderZone(records, r.config.ZoneFile)); err != nil {
+			logError("Failed to export zone file: %v", err)
+		}
+	}
+	if r.config.UnboundFile != nil {
+		if err := writeFileIfChanged(r.config.UnboundFile.Path, renderUnbound(records, r.config.UnboundFile)); err != nil {
+			logError("Failed to export Unbound include file: %v", err)
+		}
+	}
+}
+
+// publishedRecords returns the published records of results sorted by
+// hostname, keeping the first record of every hostname.
+func publishedRecords(results []hostResult) []hostResult {
+	seen := make(map[string]bool, len(results))
+	sorted := make([]hostResult, 0, len(results))
+	for _, record := range results {
+		if record.published() && !seen[record.Hostname] {
+			seen[record.Hostname] = true
+			sorted = append(sorted, record)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Hostname < sorted[j].Hostname })
+	return sorted
+}
+
+// exportedRecord is a resource record written to an export file.
+type exportedRecord struct {
+	rrType string
+	data   string
+}
+
+// exportedRecords returns the resource records exported for record: its
+// CNAME or SRV record, or one A record per address followed by the AAAA
+// record published with enableIPv6.
+func exportedRecords(record hostResult) []exportedRecord {
+	switch record.Type {
+	case "CNAME":
+		return []exportedRecord{{rrType: "CNAME", data: strings.TrimSuffix(record.Value, ".") + "."}}
+	case "SRV":
+		target, port, _ := strings.Cut(record.Value, ":")
+		return []exportedRecord{{rrType: "SRV", data: "0 0 " + port + " " + strings.TrimSuffix(target, ".") + "."}}
+	}
+	var records []exportedRecord
+	for _, ip := range strings.Split(record.Value, ",") {
+		records = append(records, exportedRecord{rrType: "A", data: ip})
+	}
+	if record.IPv6 != "" {
+		records = append(records, exportedRecord{rrType: "AAAA", data: record.IPv6})
+	}
+	return records
+}
+
+// renderZone renders records as a zone file snippet, with one A record per
+// address of a hostname and its AAAA record, or its CNAME in recordMode
+// cname, and the SRV records of srvRecords. Records with a TTL override carry
+// it explicitly, all others use the $TTL of the snippet.
+func renderZone(records []hostResult, config *ZoneFileConfig) []byte {
+	ttl := config.TTL
+	if ttl <= 0 {
+		ttl = defaultExportTTL
+	}
+	origin := strings.TrimSuffix(config.Origin, ".")
+
+	var b bytes.Buffer
+	b.WriteString("; Generated by traefikunifidns, do not edit\n")
+	if origin != "" {
+		fmt.Fprintf(&b, "$ORIGIN %s.\n", origin)
+	}
+	fmt.Fprintf(&b, "$TTL %d\n", ttl)
+
+	for _, record := range records {
+		name := record.Hostname + "."
+		if origin != "" {
+			if record.Hostname == origin {
+				name = "@"
+			} else if relative, ok := strings.CutSuffix(record.Hostname, "."+origin); ok {
+				name = relative
+			}
+		}
+		for _, rr := range exportedRecords(record) {
+			if record.TTL > 0 {
+				fmt.Fprintf(&b, "%s\t%d\tIN\t%s\t%s\n", name, record.TTL, rr.rrType, rr.data)
+			} else {
+				fmt.Fprintf(&b, "%s\tIN\t%s\t%s\n", name, rr.rrType, rr.data)
+			}
+		}
+	}
+	return b.Bytes()
+}
+
+// renderUnbound renders records as local-data statements to be included from
+// the server clause of an Unbound configuration.
+func renderUnbound(records []hostResult, config *UnboundFileConfig) []byte {
+	defaultTTL := config.TTL
+	if defaultTTL <= 0 {
+		defaultTTL = defaultExportTTL
+	}
+
+	var b bytes.Buffer
+	b.WriteString("# Generated by traefikunifidns, do not edit\n")
+	for _, record := range records {
+		ttl := record.TTL
+		if ttl <= 0 {
+			ttl = defaultTTL
+		}
+		for _, rr := range exportedRecords(record) {
+			fmt.Fprintf(&b, "local-data: \"%s. %d IN %s %s\"\n", record.Hostname, ttl, rr.rrType, rr.data)
+		}
+	}
+	return b.Bytes()
+}
+
+// writeFileIfChanged atomically replaces the file at path with data, unless it
+// already has that content. The data is written to a temporary file in the
+// same directory first, so readers never see a partially written file.
+func writeFileIfChanged(path string, data []byte) error {
+	if current, err := os.ReadFile(path); err == nil && bytes.Equal(current, data) {
+		return nil
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer func() {
+		// Only fails if the rename below succeeded
+		_ = os.Remove(tmp.Name())
+	}()
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", tmp.Name(), err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %w", tmp.Name(), err)
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return fmt.Errorf("failed to set permissions of %s: %w", tmp.Name(), err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+	log.Printf("INFO: Wrote %s", path)
+	return nil
+}
@@ -0,0 +1,214 @@
+package traefikunifidns
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderZone(t *testing.T) {
+	records := publishedRecords([]hostResult{
+		{Hostname: "nas.home.lan", Action: recordCreated, Value: "192.168.1.20", TTL: 60},
+		{Hostname: "app.home.lan", Action: recordUnchanged, Value: "192.168.1.10"},
+		{Hostname: "app.home.lan", Action: recordUpdated, Value: "192.168.1.11"},
+		{Hostname: "home.lan", Action: recordUnchanged, Value: "192.168.1.1"},
+		{Hostname: "other.lan", Action: recordUnchanged, Value: "192.168.1.30"},
+		{Hostname: "failed.home.lan", Action: resultFailed, Value: "192.168.1.40"},
+	})
+
+	zone := renderZone(records, &ZoneFileConfig{Origin: "home.lan."})
+	assert.Equal(t, `; Generated by traefikunifidns, do not edit
+$ORIGIN home.lan.
+$TTL 300
+app	IN	A	192.168.1.10
+@	IN	A	192.168.1.1
+nas	60	IN	A	192.168.1.20
+other.lan.	IN	A	192.168.1.30
+`, string(zone))
+
+	zone = renderZone(records[:1], &ZoneFileConfig{TTL: 3600})
+	assert.Equal(t, `; Generated by traefikunifidns, do not edit
+$TTL 3600
+app.home.lan.	IN	A	192.168.1.10
+`, string(zone))
+}
+
+func TestRenderUnbound(t *testing.T) {
+	records := publishedRecords([]hostResult{
+		{Hostname: "nas.lan", Action: recordUnchanged, Value: "192.168.1.20", TTL: 60},
+		{Hostname: "app.lan", Action: recordUnchanged, Value: "192.168.1.10,192.168.1.11"},
+		{Hostname: "web.lan", Action: resultSkipped, Reason: "not owned"},
+	})
+
+	data := renderUnbound(records, &UnboundFileConfig{})
+	assert.Equal(t, `# Generated by traefikunifidns, do not edit
+local-data: "app.lan. 300 IN A 192.168.1.10"
+local-data: "app.lan. 300 IN A 192.168.1.11"
+local-data: "nas.lan. 60 IN A 192.168.1.20"
+`, string(data))
+}
+
+func TestExportAAAARecords(t *testing.T) {
+	records := publishedRecords([]hostResult{
+		{Hostname: "app.lan", Action: recordUnchanged, Value: "192.168.1.10", IPv6: "2001:db8::10", TTL: 60},
+	})
+
+	zone := renderZone(records, &ZoneFileConfig{Origin: "lan"})
+	assert.Equal(t, `; Generated by traefikunifidns, do not edit
+$ORIGIN lan.
+$TTL 300
+app	60	IN	A	192.168.1.10
+app	60	IN	AAAA	2001:db8::10
+`, string(zone))
+
+	data := renderUnbound(records, &UnboundFileConfig{})
+	assert.Equal(t, `# Generated by traefikunifidns, do not edit
+local-data: "app.lan. 60 IN A 192.168.1.10"
+local-data: "app.lan. 60 IN AAAA 2001:db8::10"
+`, string(data))
+}
+
+func TestExportCNAMERecords(t *testing.T) {
+	records := publishedRecords([]hostResult{
+		{Hostname: "app.lan", Action: recordCreated, Type: "CNAME", Value: "traefik.lan"},
+		{Hostname: "traefik.lan", Action: recordUnchanged, Type: "A", Value: "192.168.1.10"},
+	})
+
+	zone := renderZone(records, &ZoneFileConfig{Origin: "lan"})
+	assert.Equal(t, `; Generated by traefikunifidns, do not edit
+$ORIGIN lan.
+$TTL 300
+app	IN	CNAME	traefik.lan.
+traefik	IN	A	192.168.1.10
+`, string(zone))
+
+	data := renderUnbound(records, &UnboundFileConfig{})
+	assert.Equal(t, `# Generated by traefikunifidns, do not edit
+local-data: "app.lan. 300 IN CNAME traefik.lan."
+local-data: "traefik.lan. 300 IN A 192.168.1.10"
+`, string(data))
+}
+
+func TestExportSRVRecords(t *testing.T) {
+	records := publishedRecords([]hostResult{
+		{Hostname: "ha.lan", Action: recordUnchanged, Value: "192.168.1.10"},
+		{Hostname: "_ha._tcp.ha.lan", Action: recordCreated, Type: "SRV", Value: "ha.lan:443"},
+	})
+
+	zone := renderZone(records, &ZoneFileConfig{Origin: "lan"})
+	assert.Equal(t, `; Generated by traefikunifidns, do not edit
+$ORIGIN lan.
+$TTL 300
+_ha._tcp.ha	IN	SRV	0 0 443 ha.lan.
+ha	IN	A	192.168.1.10
+`, string(zone))
+
+	data := renderUnbound(records, &UnboundFileConfig{})
+	assert.Equal(t, `# Generated by traefikunifidns, do not edit
+local-data: "_ha._tcp.ha.lan. 300 IN SRV 0 0 443 ha.lan."
+local-data: "ha.lan. 300 IN A 192.168.1.10"
+`, string(data))
+}
+
+func TestWriteFileIfChanged(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "records.zone")
+
+	require.NoError(t, writeFileIfChanged(path, []byte("one\n")))
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "one\n", string(data))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o644), info.Mode().Perm())
+
+	require.NoError(t, writeFileIfChanged(path, []byte("two\n")))
+	data, err = os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "two\n", string(data))
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "temporary files must be cleaned up")
+
+	assert.Error(t, writeFileIfChanged(filepath.Join(dir, "missing", "records.zone"), nil))
+}
+
+func TestSyncExportsZoneFile(t *testing.T) {
+	traefikServer := newTestTraefikServer(t, []map[string]interface{}{
+		{"name": "nas", "rule": "Host(`nas.lan`)", "middlewares": []string{"traefikunifidns"}},
+	})
+	var writes []map[string]interface{}
+	unifiServer := newTestUniFiServer(t, []DNSEntry{}, &writes)
+
+	path := filepath.Join(t.TempDir(), "lan.zone")
+	config := CreateConfig()
+	config.TraefikAPIURL = traefikServer.URL
+	config.Devices = []UnifiDeviceConfig{
+		{Host: unifiServer.URL, Username: "admin", Password: "password", Pattern: `\.lan$`},
+	}
+	config.IPOverrides = map[string]string{"nas.lan": "192.168.1.20"}
+	config.ZoneFile = &ZoneFileConfig{Path: path, Origin: "lan"}
+	unboundPath := filepath.Join(t.TempDir(), "lan.conf")
+	config.UnboundFile = &UnboundFileConfig{Path: unboundPath, TTL: 120}
+
+	_, err := New(context.Background(), nil, config, "test")
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "nas\tIN\tA\t192.168.1.20\n")
+
+	data, err = os.ReadFile(unboundPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `local-data: "nas.lan. 120 IN A 192.168.1.20"`)
+}
+
+func TestNewExportMissingPath(t *testing.T) {
+	config := CreateConfig()
+	config.ZoneFile = &ZoneFileConfig{Origin: "lan"}
+
+	_, err := New(context.Background(), nil, config, "test")
+	assert.EqualError(t, err, "zone file export is missing a path")
+
+	config = CreateConfig()
+	config.UnboundFile = &UnboundFileConfig{TTL: 60}
+
+	_, err = New(context.Background(), nil, config, "test")
+	assert.EqualError(t, err, "unbound include file export is missing a path")
+}
+
+func TestSyncExportsCNAMEMode(t *testing.T) {
+	traefikServer := newTestTraefikServer(t, []map[string]interface{}{
+		{"name": "app", "rule": "Host(`app.lan`)", "middlewares": []string{"traefikunifidns"}},
+	})
+	var writes []map[string]interface{}
+	unifiServer := newTestUniFiServer(t, []DNSEntry{}, &writes)
+
+	path := filepath.Join(t.TempDir(), "lan.zone")
+	unboundPath := filepath.Join(t.TempDir(), "lan.conf")
+	config := CreateConfig()
+	config.TraefikAPIURL = traefikServer.URL
+	config.Devices = []UnifiDeviceConfig{
+		{Host: unifiServer.URL, Username: "admin", Password: "password", Pattern: `\.lan$`, RecordMode: recordModeCNAME, CNAMETarget: "traefik.lan"},
+	}
+	config.ZoneFile = &ZoneFileConfig{Path: path, Origin: "lan"}
+	config.UnboundFile = &UnboundFileConfig{Path: unboundPath}
+
+	_, err := New(context.Background(), nil, config, "test")
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "app\tIN\tCNAME\ttraefik.lan.\n")
+	assert.NotContains(t, string(data), "\tA\ttraefik.lan")
+
+	data, err = os.ReadFile(unboundPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `local-data: "app.lan. 300 IN CNAME traefik.lan."`)
+}
@@ -0,0 +1,82 @@
+package traefikunifidns
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// compileGlob converts a hostname glob into an anchored, case-insensitive
+// regular expression. "*" matches within a single label, "**" across labels
+// and "?" a single character other than a dot, so "*.example.com" matches
+// "app.example.com" but neither "evilexample.com" nor "a.b.example.com".
+func compileGlob(glob string) (*regexp.Regexp, error) {
+	if glob == "" {
+		return nil, fmt.Errorf("empty glob")
+	}
+	var b strings.Builder
+	b.WriteString(`(?i)^`)
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				b.WriteString(`.+`)
+				i++
+			} else {
+				b.WriteString(`[^.]+`)
+			}
+		case '?':
+			b.WriteString(`[^.]`)
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString(`\.?$`)
+	return regexp.Compile(b.String())
+}
+
+// hostnameFilter selects the hostnames published by globs, applied to the
+// hostnames of every source. Without include globs every hostname not
+// excluded is selected.
+type hostnameFilter struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+// newHostnameFilter compiles the include and exclude globs.
+func newHostnameFilter(include, exclude []string) (hostnameFilter, error) {
+	var f hostnameFilter
+	for _, glob := range include {
+		re, err := compileGlob(glob)
+		if err != nil {
+			return hostnameFilter{}, fmt.Errorf("includeHostnames %q: %w", glob, err)
+		}
+		f.include = append(f.include, re)
+	}
+	for _, glob := range exclude {
+		re, err := compileGlob(glob)
+		if err != nil {
+			return hostnameFilter{}, fmt.Errorf("excludeHostnames %q: %w", glob, err)
+		}
+		f.exclude = append(f.exclude, re)
+	}
+	return f, nil
+}
+
+// allows reports whether hostname is selected: matched by an include glob,
+// if there are any, and by no exclude glob.
+func (f hostnameFilter) allows(hostname string) bool {
+	if len(f.include) > 0 && !matchesAny(f.include, hostname) {
+		return false
+	}
+	return !matchesAny(f.exclude, hostname)
+}
+
+func matchesAny(res []*regexp.Regexp, hostname string) bool {
+	for _, re := range res {
+		if re.MatchString(hostname) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package traefikunifidns
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompileGlob(t *testing.T) {
+	for _, tc := range []struct {
+		glob, hostname string
+		want           bool
+	}{
+		{"*.example.com", "app.example.com", true},
+		{"*.example.com", "APP.Example.com", true},
+		{"*.example.com", "app.example.com.", true},
+		{"*.example.com", "evilexample.com", false},
+		{"*.example.com", "example.com", false},
+		{"*.example.com", "a.b.example.com", false},
+		{"*.example.com", "app.example.com.evil.org", false},
+		{"**.example.com", "a.b.example.com", true},
+		{"app-?.lan", "app-1.lan", true},
+		{"app-?.lan", "app-10.lan", false},
+		{"nas.lan", "nas.lan", true},
+		{"nas.lan", "nasxlan", false},
+	} {
+		re, err := compileGlob(tc.glob)
+		require.NoError(t, err)
+		assert.Equal(t, tc.want, re.MatchString(tc.hostname), "%s ~ %s", tc.glob, tc.hostname)
+	}
+
+	_, err := compileGlob("")
+	assert.Error(t, err)
+}
+
+func TestHostnameFilter(t *testing.T) {
+	f, err := newHostnameFilter(nil, nil)
+	require.NoError(t, err)
+	assert.True(t, f.allows("app.lan"), "every hostname without globs")
+
+	f, err = newHostnameFilter([]string{"*.lan", "**.home.arpa"}, []string{"internal.lan", "*.cloud.home.arpa"})
+	require.NoError(t, err)
+	assert.True(t, f.allows("app.lan"))
+	assert.True(t, f.allows("nas.office.home.arpa"))
+	assert.False(t, f.allows("internal.lan"), "excludes win over includes")
+	assert.False(t, f.allows("app.cloud.home.arpa"))
+	assert.False(t, f.allows("app.example.com"), "not included")
+
+	_, err = newHostnameFilter(nil, []string{""})
+	assert.ErrorContains(t, err, "excludeHostnames")
+}
+
+func TestCollectHostnamesFiltered(t *testing.T) {
+	f, err := newHostnameFilter(nil, []string{"*.example.com"})
+	require.NoError(t, err)
+	r := &reconciler{hostnames: f, sources: []HostnameSource{staticSource{"app.lan", "www.example.com"}}}
+
+	pending, err := r.collectHostnames(context.Background())
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, "app.lan", pending[0].hostname)
+}
+
+func TestNewInvalidHostnameFilter(t *testing.T) {
+	config := CreateConfig()
+	config.IncludeHostnames = []string{""}
+
+	_, err := New(context.Background(), nil, config, "test")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid hostname filter")
+}
@@ -0,0 +1,73 @@
+package traefikunifidns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// heartbeat pings a dead man's switch URL (healthchecks.io style) after every
+// update cycle: the URL itself on success and the URL with "/fail" appended on
+// failure.
+type heartbeat struct {
+	client *http.Client
+	url    string
+}
+
+func newHeartbeat(rawURL string) *heartbeat {
+	log.Printf("INFO: Creating heartbeat for host: %s", heartbeatHost(rawURL))
+	return &heartbeat{
+		client: &http.Client{Timeout: 10 * time.Second},
+		url:    strings.TrimSuffix(rawURL, "/"),
+	}
+}
+
+// heartbeatHost returns the host of the heartbeat URL for log messages, as
+// the path usually holds the check token.
+func heartbeatHost(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return redacted
+	}
+	return u.Host
+}
+
+func (h *heartbeat) ping(ctx context.Context, failed bool) error {
+	pingURL := h.url
+	if failed {
+		pingURL += "/fail"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pingURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create heartbeat request for %s", heartbeatHost(h.url))
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		// Leave out the URL, and with it the token
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("failed to send heartbeat to %s: %w", heartbeatHost(h.url), err)
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			log.Printf("ERROR: Failed to close response body: %v", closeErr)
+		}
+	}()
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return fmt.Errorf("failed to read heartbeat response: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("heartbeat failed with status: %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package traefikunifidns
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeartbeatPing(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		if r.URL.Path == "/broken" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	h := newHeartbeat(server.URL + "/ping/abc/")
+	require.NoError(t, h.ping(context.Background(), false))
+	require.NoError(t, h.ping(context.Background(), true))
+	assert.Equal(t, []string{"/ping/abc", "/ping/abc/fail"}, paths)
+
+	err := newHeartbeat(server.URL+"/broken").ping(context.Background(), false)
+	assert.EqualError(t, err, "heartbeat failed with status: 404")
+
+	err = newHeartbeat("http://invalid-url-that-will-fail:12345/ping/secret").ping(context.Background(), false)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid-url-that-will-fail:12345")
+	assert.NotContains(t, err.Error(), "secret", "the token in the path is not logged")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = h.ping(ctx, false)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestHeartbeatHost(t *testing.T) {
+	assert.Equal(t, "hc-ping.com", heartbeatHost("https://hc-ping.com/0f5c3a7e-token"))
+	assert.Equal(t, redacted, heartbeatHost("hc-ping.com/0f5c3a7e-token"))
+}
+
+func TestSyncPingsHeartbeat(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+	}))
+	defer server.Close()
+
+	u := newTestAdminPlugin(CreateConfig())
+	u.traefikClient = &TraefikClient{client: &http.Client{}, baseURL: "http://invalid-url-that-will-fail:12345"}
+	u.heartbeat = newHeartbeat(server.URL + "/uuid")
+
+	require.Error(t, u.sync(context.Background()).Err)
+	assert.Equal(t, []string{"/uuid/fail"}, paths)
+
+	traefikServer := newTestTraefikServer(t, []map[string]interface{}{})
+	u.traefikClient = NewTraefikClient(traefikServer.URL, false)
+	require.NoError(t, u.sync(context.Background()).Err)
+	assert.Equal(t, []string{"/uuid/fail", "/uuid"}, paths)
+}
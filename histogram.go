@@ -0,0 +1,92 @@
+package traefikunifidns
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Bucket upper bounds of the per-endpoint API histograms.
+var (
+	latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	sizeBuckets    = []float64{256, 1024, 4096, 16384, 65536, 262144, 1048576}
+)
+
+// histogram is a Prometheus style histogram. It is not safe for concurrent
+// use; callers guard it with their own lock.
+type histogram struct {
+	buckets []float64
+	counts  []uint64 // Observations per bucket, not cumulative
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, bound := range h.buckets {
+		if v <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *histogram) clone() *histogram {
+	c := *h
+	c.counts = append([]uint64(nil), h.counts...)
+	return &c
+}
+
+// endpointStats holds the histograms of a single API endpoint of a device.
+type endpointStats struct {
+	latency *histogram // Seconds
+	size    *histogram // Response bytes
+}
+
+func newEndpointStats() *endpointStats {
+	return &endpointStats{
+		latency: newHistogram(latencyBuckets),
+		size:    newHistogram(sizeBuckets),
+	}
+}
+
+// histogramSeries is a histogram together with its label pairs.
+type histogramSeries struct {
+	labels []string // Alternating label names and values
+	hist   *histogram
+}
+
+// writeHistogram appends a histogram metric with all its series in the
+// Prometheus text format.
+func writeHistogram(b *strings.Builder, name, help string, series []histogramSeries) {
+	fmt.Fprintf(b, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(b, "# TYPE %s histogram\n", name)
+	for _, s := range series {
+		labels := formatLabels(s.labels)
+		cumulative := uint64(0)
+		for i, bound := range s.hist.buckets {
+			cumulative += s.hist.counts[i]
+			fmt.Fprintf(b, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, strconv.FormatFloat(bound, 'f', -1, 64), cumulative)
+		}
+		fmt.Fprintf(b, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, s.hist.count)
+		fmt.Fprintf(b, "%s_sum{%s} %s\n", name, labels, strconv.FormatFloat(s.hist.sum, 'f', -1, 64))
+		fmt.Fprintf(b, "%s_count{%s} %d\n", name, labels, s.hist.count)
+	}
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels formats alternating label names and values as name="value"
+// pairs.
+func formatLabels(pairs []string) string {
+	parts := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		parts = append(parts, fmt.Sprintf("%s=\"%s\"", pairs[i], labelValueEscaper.Replace(pairs[i+1])))
+	}
+	return strings.Join(parts, ",")
+}
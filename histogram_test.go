@@ -0,0 +1,43 @@
+package traefikunifidns
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistogramObserve(t *testing.T) {
+	h := newHistogram([]float64{1, 5})
+	h.observe(0.5)
+	h.observe(1)
+	h.observe(3)
+	h.observe(10)
+
+	assert.Equal(t, []uint64{2, 1}, h.counts)
+	assert.Equal(t, uint64(4), h.count)
+	assert.Equal(t, 14.5, h.sum)
+
+	c := h.clone()
+	h.observe(1)
+	assert.Equal(t, []uint64{2, 1}, c.counts, "clones are independent")
+}
+
+func TestWriteHistogram(t *testing.T) {
+	h := newHistogram([]float64{0.1, 1})
+	h.observe(0.05)
+	h.observe(2)
+
+	var b strings.Builder
+	writeHistogram(&b, "test_seconds", "Test histogram.", []histogramSeries{
+		{labels: []string{"device", "device-0", "host", `a"b\c`}, hist: h},
+	})
+	assert.Equal(t, `# HELP test_seconds Test histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{device="device-0",host="a\"b\\c",le="0.1"} 1
+test_seconds_bucket{device="device-0",host="a\"b\\c",le="1"} 1
+test_seconds_bucket{device="device-0",host="a\"b\\c",le="+Inf"} 2
+test_seconds_sum{device="device-0",host="a\"b\\c"} 2.05
+test_seconds_count{device="device-0",host="a\"b\\c"} 2
+`, b.String())
+}
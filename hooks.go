@@ -0,0 +1,87 @@
+package traefikunifidns
+
+import (
+	"net/http"
+	"sync"
+)
+
+// RequestHook is called with every request right before it is sent, after
+// the client added its own headers, and may modify it, e.g. to sign it.
+type RequestHook func(req *http.Request)
+
+// ResponseHook is called with every request once it completed, with either
+// the response or the error. It must not consume the response body.
+type ResponseHook func(req *http.Request, resp *http.Response, err error)
+
+// hooks holds the hooks registered on a client.
+type hooks struct {
+	mu     sync.RWMutex
+	before []RequestHook
+	after  []ResponseHook
+}
+
+func (h *hooks) addBefore(hook RequestHook) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.before = append(h.before, hook)
+}
+
+func (h *hooks) addAfter(hook ResponseHook) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.after = append(h.after, hook)
+}
+
+func (h *hooks) snapshot() ([]RequestHook, []ResponseHook) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.before, h.after
+}
+
+// hookTransport runs the registered hooks around every request. It sits
+// directly above the network transport, so hooks see requests exactly as
+// they are sent, including retries and logins.
+type hookTransport struct {
+	next  http.RoundTripper
+	hooks *hooks
+}
+
+func (t *hookTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	before, after := t.hooks.snapshot()
+	if len(before) > 0 {
+		// A RoundTripper must not modify the request it was given
+		req = req.Clone(req.Context())
+		for _, hook := range before {
+			hook(req)
+		}
+	}
+	resp, err := t.next.RoundTrip(req)
+	for _, hook := range after {
+		hook(req, resp, err)
+	}
+	return resp, err
+}
+
+// OnBeforeRequest registers a hook called with every request sent to the
+// controller, for signing, extra headers or request mutation.
+func (c *UniFiClient) OnBeforeRequest(hook RequestHook) {
+	c.hooks.addBefore(hook)
+}
+
+// OnAfterResponse registers a hook called once every request to the
+// controller completed, e.g. for custom metrics.
+func (c *UniFiClient) OnAfterResponse(hook ResponseHook) {
+	c.hooks.addAfter(hook)
+}
+
+// OnBeforeRequest registers a hook called with every request sent to the
+// Traefik API.
+func (c *TraefikClient) OnBeforeRequest(hook RequestHook) {
+	c.hooks.addBefore(hook)
+}
+
+// OnAfterResponse registers a hook called once every request to the Traefik
+// API completed.
+func (c *TraefikClient) OnAfterResponse(hook ResponseHook) {
+	c.hooks.addAfter(hook)
+}
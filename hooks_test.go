@@ -0,0 +1,64 @@
+package traefikunifidns
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUniFiClientHooks(t *testing.T) {
+	var signatures []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signatures = append(signatures, r.Header.Get("X-Signature"))
+		if r.URL.Path == "/api/auth/login" {
+			w.Header().Set("X-Csrf-Token", "token")
+			return
+		}
+		_ = json.NewEncoder(w).Encode([]DNSEntry{})
+	}))
+	defer server.Close()
+
+	client := NewUniFiClient(server.URL, "admin", "password", false)
+	client.setHeaders("unifidns-test", nil)
+	var userAgents []string
+	client.OnBeforeRequest(func(req *http.Request) {
+		userAgents = append(userAgents, req.Header.Get("User-Agent"))
+		req.Header.Set("X-Signature", "signed")
+	})
+	var statuses []int
+	client.OnAfterResponse(func(req *http.Request, resp *http.Response, err error) {
+		require.NoError(t, err)
+		statuses = append(statuses, resp.StatusCode)
+	})
+
+	_, err := client.GetStaticDNSEntries(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"signed", "signed"}, signatures, "the login is hooked too")
+	assert.Equal(t, []string{"unifidns-test", "unifidns-test"}, userAgents, "hooks see the headers added by the client")
+	assert.Equal(t, []int{http.StatusOK, http.StatusOK}, statuses)
+}
+
+func TestTraefikClientHooks(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	serverURL := server.URL
+	server.Close()
+
+	client := NewTraefikClient(serverURL, false)
+	var hooked []error
+	client.OnAfterResponse(func(req *http.Request, resp *http.Response, err error) {
+		assert.Nil(t, resp)
+		hooked = append(hooked, err)
+	})
+
+	_, err := client.GetRouters(context.Background())
+	require.Error(t, err)
+	require.Len(t, hooked, 1)
+	assert.Error(t, hooked[0], "transport errors are passed to the hook")
+}
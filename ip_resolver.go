@@ -0,0 +1,307 @@
+package traefikunifidns
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// publicIPTimeout bounds a request to a public IP lookup service.
+const publicIPTimeout = 10 * time.Second
+
+// IPResolver selects the addresses hostnames are published with. The
+// built-in resolvers cover the local interface, static addresses, preferred
+// subnets, public lookup services and the gateway's WAN address; library
+// users can supply their own with SetIPResolver.
+type IPResolver interface {
+	ResolveIP(ctx context.Context) ([]string, error)
+}
+
+// staticResolver always returns the same addresses.
+type staticResolver []string
+
+// NewStaticIPResolver returns a resolver that always returns ips.
+func NewStaticIPResolver(ips ...string) IPResolver {
+	return staticResolver(ips)
+}
+
+func (s staticResolver) ResolveIP(context.Context) ([]string, error) {
+	return s, nil
+}
+
+// localResolver returns the first non-loopback IPv4 address of this host, or
+// of a single interface, preferring addresses inside the preferred subnets.
+type localResolver struct {
+	iface     string // Empty for all interfaces
+	preferred []*net.IPNet
+	sources   sourceFilter
+}
+
+// sourceFilter restricts the local addresses that may be published, so
+// addresses of Docker or Kubernetes overlay networks are never picked.
+type sourceFilter struct {
+	allowed  []*net.IPNet // Empty to allow any address
+	excluded []*net.IPNet
+}
+
+// permits reports whether ip may be published.
+func (f sourceFilter) permits(ip net.IP) bool {
+	if len(f.allowed) > 0 && !ipInNets(ip, f.allowed) {
+		return false
+	}
+	return !ipInNets(ip, f.excluded)
+}
+
+// apply returns the addresses of addrs the filter permits.
+func (f sourceFilter) apply(addrs []net.Addr) []net.Addr {
+	if len(f.allowed) == 0 && len(f.excluded) == 0 {
+		return addrs
+	}
+	var permitted []net.Addr
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && f.permits(ipnet.IP) {
+			permitted = append(permitted, addr)
+		}
+	}
+	return permitted
+}
+
+// NewLocalIPResolver returns a resolver for the first non-loopback IPv4
+// address of this host, preferring addresses inside preferredSubnets when
+// any match.
+func NewLocalIPResolver(preferredSubnets ...string) (IPResolver, error) {
+	preferred, err := parseCIDRs(preferredSubnets)
+	if err != nil {
+		return nil, err
+	}
+	return &localResolver{preferred: preferred}, nil
+}
+
+// NewInterfaceIPResolver returns a resolver for the first non-loopback IPv4
+// address of the network interface name, preferring addresses inside
+// preferredSubnets when any match.
+func NewInterfaceIPResolver(name string, preferredSubnets ...string) (IPResolver, error) {
+	preferred, err := parseCIDRs(preferredSubnets)
+	if err != nil {
+		return nil, err
+	}
+	return &localResolver{iface: name, preferred: preferred}, nil
+}
+
+func (l *localResolver) ResolveIP(context.Context) ([]string, error) {
+	ip, err := getLocalIP(l.iface, l.preferred, l.sources)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get local IP: %w", err)
+	}
+	return []string{ip}, nil
+}
+
+// virtualIPResolver returns the virtual IP while it accepts connections and
+// the addresses of next otherwise.
+type virtualIPResolver struct {
+	vip  *virtualIP
+	next IPResolver
+}
+
+func (v *virtualIPResolver) ResolveIP(ctx context.Context) ([]string, error) {
+	ips, err := v.next.ResolveIP(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if v.vip.target(strings.Join(ips, ", ")) == v.vip.ip {
+		return []string{v.vip.ip}, nil
+	}
+	return ips, nil
+}
+
+// hostnameResolver returns the first IPv4 address of a hostname.
+type hostnameResolver struct {
+	hostname string
+}
+
+// NewHostnameIPResolver returns a resolver for the first IPv4 address
+// hostname resolves to.
+func NewHostnameIPResolver(hostname string) IPResolver {
+	return &hostnameResolver{hostname: hostname}
+}
+
+func (h *hostnameResolver) ResolveIP(context.Context) ([]string, error) {
+	ip, err := resolveIPv4(h.hostname)
+	if err != nil {
+		return nil, err
+	}
+	return []string{ip}, nil
+}
+
+// wanResolver returns the WAN address reported by a UniFi controller.
+type wanResolver struct {
+	client *UniFiClient
+}
+
+// NewWANIPResolver returns a resolver for the WAN address of the gateway
+// managed by client.
+func NewWANIPResolver(client *UniFiClient) IPResolver {
+	return &wanResolver{client: client}
+}
+
+func (w *wanResolver) ResolveIP(ctx context.Context) ([]string, error) {
+	ip, err := w.client.GetWANIP(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get WAN IP: %w", err)
+	}
+	return []string{ip}, nil
+}
+
+// publicResolver asks a lookup service such as https://ifconfig.co for the
+// public address of this host. The service must answer with the bare
+// address.
+type publicResolver struct {
+	url    string
+	client *http.Client
+}
+
+// NewPublicIPResolver returns a resolver that asks the lookup service at url
+// for the public address of this host.
+func NewPublicIPResolver(url string) IPResolver {
+	return &publicResolver{url: url, client: &http.Client{Timeout: publicIPTimeout}}
+}
+
+// validatePublicIPURL checks that raw is an HTTP or HTTPS URL of a public IP
+// lookup service.
+func validatePublicIPURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("missing host")
+	}
+	return nil
+}
+
+func (p *publicResolver) ResolveIP(ctx context.Context) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/plain")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up public IP: %w", err)
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			logError("Failed to close response body: %v", closeErr)
+		}
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to look up public IP with status: %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 256))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read public IP: %w", err)
+	}
+	ip := net.ParseIP(strings.TrimSpace(string(body)))
+	if ip == nil {
+		return nil, fmt.Errorf("public IP lookup at %s returned no address", p.url)
+	}
+	return []string{ip.String()}, nil
+}
+
+// SetIPResolver replaces the detection of the local IP for every plugin
+// instance sharing this configuration. Device targets and IP overrides
+// still take precedence.
+func (u *UniFiDNS) SetIPResolver(resolver IPResolver) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.ipResolver = resolver
+}
+
+// defaultResolver returns the resolver of the addresses published without a
+// device target, detecting the local IP unless one was set.
+func (r *reconciler) defaultResolver() IPResolver {
+	if r.ipResolver != nil {
+		return r.ipResolver
+	}
+	if r.config.PublicIPURL != "" {
+		return NewPublicIPResolver(r.config.PublicIPURL)
+	}
+	var resolver IPResolver = &localResolver{iface: r.config.TargetInterface, preferred: r.preferredNets, sources: r.localSources}
+	if r.virtualIP != nil {
+		resolver = &virtualIPResolver{vip: r.virtualIP, next: resolver}
+	}
+	return resolver
+}
+
+// deviceResolver returns the resolver of the addresses published on device,
+// or nil when it publishes the default addresses.
+func (r *reconciler) deviceResolver(device int) (IPResolver, error) {
+	config := r.config.Devices[device]
+	switch {
+	case config.TargetIP != "":
+		return NewStaticIPResolver(config.TargetIP), nil
+	case config.TargetHostname != "":
+		return NewHostnameIPResolver(config.TargetHostname), nil
+	case config.TargetWANIP:
+		client, ok := r.providers[fmt.Sprintf("device-%d", device)].(*UniFiClient)
+		if !ok {
+			return nil, fmt.Errorf("device %d has no UniFi controller to report a WAN IP", device)
+		}
+		return NewWANIPResolver(client), nil
+	}
+	return nil, nil
+}
+
+// deviceTargets returns the addresses published on device, resolving them
+// at most once per cycle. ok is false when the device publishes the default
+// addresses.
+func (r *reconciler) deviceTargets(ctx context.Context, device int) (ips []string, ok bool, err error) {
+	r.targetsMu.Lock()
+	ips, ok = r.targets[device]
+	r.targetsMu.Unlock()
+	if ok {
+		return ips, true, nil
+	}
+	resolver, err := r.deviceResolver(device)
+	if resolver == nil || err != nil {
+		return nil, err != nil, err
+	}
+	ips, err = resolver.ResolveIP(ctx)
+	if err != nil {
+		return nil, true, err
+	}
+	r.targetsMu.Lock()
+	defer r.targetsMu.Unlock()
+	if r.targets == nil {
+		r.targets = make(map[int][]string)
+	}
+	r.targets[device] = ips
+	return ips, true, nil
+}
+
+// resolveDefaultTargets returns the addresses published without a device
+// target for this cycle.
+func (r *reconciler) resolveDefaultTargets(ctx context.Context) ([]string, error) {
+	if len(r.config.TargetIPs) > 0 {
+		return r.nodeTargets(), nil
+	}
+	ips, err := r.defaultResolver().ResolveIP(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if r.config.PublicIPURL != "" && r.ipResolver == nil {
+		log.Printf("INFO: Using public IP: %s", strings.Join(ips, ", "))
+	} else {
+		log.Printf("INFO: Using local IP: %s", strings.Join(ips, ", "))
+	}
+	return ips, nil
+}
@@ -0,0 +1,208 @@
+package traefikunifidns
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStaticIPResolver(t *testing.T) {
+	ips, err := NewStaticIPResolver("192.168.1.10", "192.168.1.11").ResolveIP(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"192.168.1.10", "192.168.1.11"}, ips)
+}
+
+func TestLocalIPResolver(t *testing.T) {
+	resolver, err := NewLocalIPResolver("0.0.0.0/0")
+	require.NoError(t, err)
+	ips, err := resolver.ResolveIP(context.Background())
+	require.NoError(t, err)
+	require.Len(t, ips, 1)
+	assert.NotNil(t, net.ParseIP(ips[0]).To4())
+
+	_, err = NewLocalIPResolver("192.168.0.0/33")
+	assert.Error(t, err)
+}
+
+func TestInterfaceIPResolver(t *testing.T) {
+	ifaces, err := net.Interfaces()
+	require.NoError(t, err)
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		require.NoError(t, err)
+		if _, err := selectLocalIP(addrs, nil); err != nil {
+			continue
+		}
+		resolver, err := NewInterfaceIPResolver(iface.Name)
+		require.NoError(t, err)
+		ips, err := resolver.ResolveIP(context.Background())
+		require.NoError(t, err)
+		require.Len(t, ips, 1)
+		var found bool
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.String() == ips[0] {
+				found = true
+			}
+		}
+		assert.True(t, found, "%s is an address of %s", ips[0], iface.Name)
+		break
+	}
+
+	resolver, err := NewInterfaceIPResolver("missing0")
+	require.NoError(t, err)
+	_, err = resolver.ResolveIP(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "interface missing0")
+}
+
+func TestSourceFilter(t *testing.T) {
+	addrs := []net.Addr{
+		&net.IPNet{IP: net.ParseIP("172.17.0.2"), Mask: net.CIDRMask(16, 32)},
+		&net.IPNet{IP: net.ParseIP("10.42.0.7"), Mask: net.CIDRMask(24, 32)},
+		&net.IPNet{IP: net.ParseIP("192.168.10.5"), Mask: net.CIDRMask(24, 32)},
+	}
+
+	excluded, err := parseCIDRs([]string{"172.17.0.0/16"})
+	require.NoError(t, err)
+	ip, err := selectLocalIP(sourceFilter{excluded: excluded}.apply(addrs), nil)
+	require.NoError(t, err)
+	assert.Equal(t, "10.42.0.7", ip)
+
+	allowed, err := parseCIDRs([]string{"192.168.0.0/16"})
+	require.NoError(t, err)
+	ip, err = selectLocalIP(sourceFilter{allowed: allowed}.apply(addrs), nil)
+	require.NoError(t, err)
+	assert.Equal(t, "192.168.10.5", ip)
+
+	// Excluded ranges win over allowed ones
+	excluded, err = parseCIDRs([]string{"192.168.10.0/24"})
+	require.NoError(t, err)
+	_, err = selectLocalIP(sourceFilter{allowed: allowed, excluded: excluded}.apply(addrs), nil)
+	assert.EqualError(t, err, "no suitable IP address found")
+
+	assert.Equal(t, addrs, sourceFilter{}.apply(addrs))
+}
+
+func TestNewInvalidSourceCIDRs(t *testing.T) {
+	config := CreateConfig()
+	config.Devices = []UnifiDeviceConfig{{WebhookURL: "http://localhost:8080", Pattern: `\.lan$`}}
+	config.ExcludedSourceCIDRs = []string{"172.17.0.0/33"}
+
+	_, err := New(context.Background(), nil, config, "test")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid excludedSourceCIDRs")
+}
+
+func TestVirtualIPResolver(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := listener.Addr().(*net.TCPAddr).Port
+	require.NoError(t, listener.Close())
+
+	resolver := &virtualIPResolver{vip: newVirtualIP("127.0.0.1", port), next: NewStaticIPResolver("192.168.1.10")}
+	ips, err := resolver.ResolveIP(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"192.168.1.10"}, ips, "falls back while the virtual IP is down")
+}
+
+func TestPublicIPResolver(t *testing.T) {
+	body, status := "203.0.113.9\n", http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(body))
+	}))
+	defer server.Close()
+	resolver := NewPublicIPResolver(server.URL)
+
+	ips, err := resolver.ResolveIP(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"203.0.113.9"}, ips)
+
+	body = "<html>rate limited</html>"
+	_, err = resolver.ResolveIP(context.Background())
+	assert.EqualError(t, err, "public IP lookup at "+server.URL+" returned no address")
+
+	status = http.StatusTooManyRequests
+	_, err = resolver.ResolveIP(context.Background())
+	assert.EqualError(t, err, "failed to look up public IP with status: 429")
+}
+
+func TestSetIPResolver(t *testing.T) {
+	traefikServer := newTestTraefikServer(t, []map[string]interface{}{
+		{"name": "app", "rule": "Host(`app.lan`)", "middlewares": []string{"traefikunifidns"}},
+	})
+	var changes []webhookChange
+	webhookServer := newTestWebhookServer(t, &changes)
+
+	updateOnStartup := false
+	config := CreateConfig()
+	config.TraefikAPIURL = traefikServer.URL
+	config.Devices = []UnifiDeviceConfig{{WebhookURL: webhookServer.URL, Pattern: `\.lan$`}}
+	config.UpdateOnStartup = &updateOnStartup
+	config.AdminPath = "/.unifidns"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	plugin, err := New(ctx, nil, config, "test")
+	require.NoError(t, err)
+	u := plugin.(*UniFiDNS)
+	u.SetIPResolver(NewStaticIPResolver("192.168.1.50"))
+
+	require.True(t, u.requestSync())
+	assert.Eventually(t, func() bool { return u.status().Cycles == 1 }, time.Second, 10*time.Millisecond)
+	require.Len(t, changes, 1)
+	assert.Equal(t, "192.168.1.50", changes[0].Record.Value)
+}
+
+func TestPublicIPURL(t *testing.T) {
+	traefikServer := newTestTraefikServer(t, []map[string]interface{}{
+		{"name": "app", "rule": "Host(`app.lan`)", "middlewares": []string{"traefikunifidns"}},
+	})
+	lookupServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("203.0.113.9\n"))
+	}))
+	defer lookupServer.Close()
+	var changes []webhookChange
+	webhookServer := newTestWebhookServer(t, &changes)
+
+	config := CreateConfig()
+	config.TraefikAPIURL = traefikServer.URL
+	config.Devices = []UnifiDeviceConfig{{WebhookURL: webhookServer.URL, Pattern: `\.lan$`}}
+	config.PublicIPURL = lookupServer.URL
+	r, err := newReconciler(config)
+	require.NoError(t, err)
+
+	require.NoError(t, r.sync(context.Background()).Err)
+	require.Len(t, changes, 1)
+	assert.Equal(t, "203.0.113.9", changes[0].Record.Value)
+}
+
+func TestNewInvalidPublicIPURL(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		url       string
+		targetIPs []string
+		want      string
+	}{
+		{"scheme", "ftp://ifconfig.co", nil, "invalid publicIPURL: unsupported scheme"},
+		{"host", "https://", nil, "invalid publicIPURL: missing host"},
+		{"targetIPs", "https://ifconfig.co", []string{"192.168.1.11"}, "publicIPURL cannot be combined with targetIPs"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			config := CreateConfig()
+			config.Devices = []UnifiDeviceConfig{{WebhookURL: "http://localhost:8080", Pattern: `\.lan$`}}
+			config.PublicIPURL = tt.url
+			config.TargetIPs = tt.targetIPs
+
+			_, err := New(context.Background(), nil, config, "test")
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.want)
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package traefikunifidns
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+)
+
+// publishIPv6 publishes the AAAA record of a hostname whose A record points
+// at this host, after its A record was published. Hostnames pointing
+// elsewhere, through an IP override, targetIPs or a device target, only get
+// their A record. It expects r.mu to be held.
+func (r *reconciler) publishIPv6(ctx context.Context, provider dnsProvider, result *hostResult, targets, localIPs []string) {
+	if r.localIPv6 == "" || len(r.config.TargetIPs) > 0 || strings.Join(targets, ",") != strings.Join(localIPs, ",") {
+		return
+	}
+	p, ok := provider.(aaaaRecordProvider)
+	if !ok {
+		return
+	}
+
+	if client, ok := provider.(*UniFiClient); ok && r.registry != nil {
+		claimed, err := r.registry.claim(ctx, client, result.Hostname, "AAAA")
+		if err != nil {
+			logError("Failed to check ownership of %s: %v", result.Hostname, err)
+			provider.health().recordFailure(err)
+			result.Action, result.Reason, result.err = resultFailed, err.Error(), err
+			return
+		}
+		if !claimed {
+			return
+		}
+	}
+
+	action, err := p.updateAAAARecord(ctx, result.Hostname, r.localIPv6, result.TTL)
+	if errors.Is(err, errReadOnly) || errors.Is(err, errWriteForbidden) {
+		log.Printf("WARN: AAAA record for %s differs from the desired state, but %s doesn't accept writes", result.Hostname, provider)
+		return
+	}
+	if err != nil {
+		logError("Failed to update AAAA record for %s: %v", result.Hostname, err)
+		provider.health().recordFailure(err)
+		result.Action, result.Reason, result.err = resultFailed, err.Error(), err
+		return
+	}
+	result.IPv6 = r.localIPv6
+	result.Action = mergeActions(result.Action, action)
+}
+
+// mergeActions returns the action of a hostname whose A and AAAA records
+// were published with the given actions: the action both agree on, and
+// updated otherwise.
+func mergeActions(a, b string) string {
+	if a == b {
+		return a
+	}
+	return recordUpdated
+}
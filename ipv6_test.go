@@ -0,0 +1,115 @@
+package traefikunifidns
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelectLocalIPv6(t *testing.T) {
+	addrs := []net.Addr{
+		&net.IPNet{IP: net.ParseIP("::1"), Mask: net.CIDRMask(128, 128)},
+		&net.IPNet{IP: net.ParseIP("192.168.1.10"), Mask: net.CIDRMask(24, 32)},
+		&net.IPNet{IP: net.ParseIP("fe80::1"), Mask: net.CIDRMask(64, 128)},
+		&net.IPNet{IP: net.ParseIP("2001:db8::10"), Mask: net.CIDRMask(64, 128)},
+	}
+
+	ip, err := selectLocalIPv6(addrs)
+	require.NoError(t, err)
+	assert.Equal(t, "2001:db8::10", ip)
+
+	_, err = selectLocalIPv6(addrs[:3])
+	assert.EqualError(t, err, "no suitable IPv6 address found")
+}
+
+func TestUpdateAAAARecord(t *testing.T) {
+	var writes []map[string]interface{}
+	server := newTestUniFiServer(t, []DNSEntry{
+		{ID: "1", Key: "app.lan", RecordType: "A", Value: "192.168.1.10"},
+		{ID: "2", Key: "nas.lan", RecordType: "AAAA", Value: "2001:db8::10"},
+	}, &writes)
+	client := NewUniFiClient(server.URL, "admin", "password", false)
+
+	action, err := client.updateAAAARecord(context.Background(), "nas.lan", "2001:db8::10", 0)
+	require.NoError(t, err)
+	assert.Equal(t, recordUnchanged, action)
+
+	// The A record of the hostname is left alone
+	action, err = client.updateAAAARecord(context.Background(), "app.lan", "2001:db8::10", 0)
+	require.NoError(t, err)
+	assert.Equal(t, recordCreated, action)
+	require.Len(t, writes, 1)
+	assert.Equal(t, "AAAA", writes[0]["record_type"])
+	assert.Nil(t, writes[0]["_id"])
+}
+
+func TestPublishIPv6(t *testing.T) {
+	var writes []map[string]interface{}
+	server := newTestUniFiServer(t, []DNSEntry{
+		{ID: "1", Key: "app.lan", RecordType: "AAAA", Value: "2001:db8::1"},
+	}, &writes)
+	client := NewUniFiClient(server.URL, "admin", "password", false)
+	r := &reconciler{config: CreateConfig(), localIPv6: "2001:db8::10"}
+	local := []string{"192.168.1.10"}
+
+	result := hostResult{Hostname: "app.lan", Action: recordUnchanged}
+	r.publishIPv6(context.Background(), client, &result, local, local)
+	assert.Equal(t, recordUpdated, result.Action)
+	assert.Equal(t, "2001:db8::10", result.IPv6)
+	require.Len(t, writes, 1)
+	assert.Equal(t, "1", writes[0]["_id"])
+
+	// Hostnames pointing elsewhere only get their A record
+	result = hostResult{Hostname: "nas.lan", Action: recordCreated}
+	r.publishIPv6(context.Background(), client, &result, []string{"192.168.1.20"}, local)
+	assert.Equal(t, recordCreated, result.Action)
+	assert.Empty(t, result.IPv6)
+	assert.Len(t, writes, 1)
+
+	r.config.TargetIPs = []string{"192.168.1.11"}
+	result = hostResult{Hostname: "app.lan", Action: recordUnchanged}
+	r.publishIPv6(context.Background(), client, &result, r.config.TargetIPs, r.config.TargetIPs)
+	assert.Equal(t, recordUnchanged, result.Action)
+	assert.Len(t, writes, 1)
+}
+
+func TestPublishIPv6Ownership(t *testing.T) {
+	var writes []map[string]interface{}
+	server := newTestUniFiServer(t, []DNSEntry{
+		{ID: "1", Key: "app.lan", RecordType: "A", Value: "192.168.1.10"},
+		{ID: "2", Key: "a-app.lan", RecordType: "TXT", Value: `"heritage=external-dns,external-dns/owner=traefik"`},
+		{ID: "3", Key: "nas.lan", RecordType: "AAAA", Value: "2001:db8::20"},
+		{ID: "4", Key: "aaaa-nas.lan", RecordType: "TXT", Value: `"heritage=external-dns,external-dns/owner=k8s"`},
+	}, &writes)
+	client := NewUniFiClient(server.URL, "admin", "password", false)
+	r := &reconciler{config: CreateConfig(), localIPv6: "2001:db8::10", registry: &txtRegistry{ownerID: "traefik"}}
+	local := []string{"192.168.1.10"}
+
+	// The AAAA record added to an owned hostname gets its own ownership record
+	result := hostResult{Hostname: "app.lan", Action: recordUnchanged}
+	r.publishIPv6(context.Background(), client, &result, local, local)
+	assert.Equal(t, recordUpdated, result.Action)
+	require.Len(t, writes, 2)
+	assert.Equal(t, "aaaa-app.lan", writes[0]["key"])
+	assert.Equal(t, "TXT", writes[0]["record_type"])
+	assert.Equal(t, "app.lan", writes[1]["key"])
+	assert.Equal(t, "AAAA", writes[1]["record_type"])
+
+	// An AAAA record owned by someone else is left alone
+	writes = nil
+	result = hostResult{Hostname: "nas.lan", Action: recordUnchanged}
+	r.publishIPv6(context.Background(), client, &result, local, local)
+	assert.Equal(t, recordUnchanged, result.Action)
+	assert.Empty(t, result.IPv6)
+	assert.Empty(t, writes)
+}
+
+func TestMergeActions(t *testing.T) {
+	assert.Equal(t, recordCreated, mergeActions(recordCreated, recordCreated))
+	assert.Equal(t, recordUnchanged, mergeActions(recordUnchanged, recordUnchanged))
+	assert.Equal(t, recordUpdated, mergeActions(recordUnchanged, recordCreated))
+	assert.Equal(t, recordUpdated, mergeActions(recordCreated, recordUnchanged))
+}
@@ -0,0 +1,100 @@
+package traefikunifidns
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// rateLimiter spaces requests evenly so that no more than a fixed number
+// start per second. It is shared by every UniFi client using the same
+// maxRequestsPerSecond.
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+	refs     int
+}
+
+func newRateLimiter(perSecond int) *rateLimiter {
+	return &rateLimiter{interval: time.Second / time.Duration(perSecond)}
+}
+
+// wait blocks until the next request may start or ctx is done.
+func (l *rateLimiter) wait(ctx context.Context) error {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// limitTransport bounds the number of requests in flight to a controller and
+// the rate at which they start. A request counts as in flight until its
+// response body is closed.
+type limitTransport struct {
+	next    http.RoundTripper
+	slots   chan struct{} // nil without a concurrency limit
+	limiter *rateLimiter  // nil without a rate limit
+}
+
+func (t *limitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	release := func() {}
+	if t.slots != nil {
+		select {
+		case t.slots <- struct{}{}:
+			var once sync.Once
+			release = func() { once.Do(func() { <-t.slots }) }
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if t.limiter != nil {
+		if err := t.limiter.wait(ctx); err != nil {
+			release()
+			return nil, err
+		}
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	resp.Body = &releasingBody{body: resp.Body, release: release}
+	return resp, nil
+}
+
+// releasingBody frees the concurrency slot of a request once its response
+// body is closed.
+type releasingBody struct {
+	body    io.ReadCloser
+	release func()
+}
+
+func (b *releasingBody) Read(p []byte) (int, error) {
+	return b.body.Read(p)
+}
+
+func (b *releasingBody) Close() error {
+	defer b.release()
+	return b.body.Close()
+}
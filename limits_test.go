@@ -0,0 +1,71 @@
+package traefikunifidns
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(20)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		require.NoError(t, l.wait(context.Background()))
+	}
+	// The first request starts immediately, the others 50ms apart
+	assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	l = newRateLimiter(1)
+	require.NoError(t, l.wait(ctx))
+	assert.ErrorIs(t, l.wait(ctx), context.Canceled)
+}
+
+func TestLimitTransportConcurrency(t *testing.T) {
+	var inFlight, peak int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: &limitTransport{next: http.DefaultTransport, slots: make(chan struct{}, 2)}}
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get(server.URL)
+			if assert.NoError(t, err) {
+				_ = resp.Body.Close()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(2), atomic.LoadInt32(&peak))
+}
+
+func TestLimitTransportReleasesOnError(t *testing.T) {
+	transport := &limitTransport{next: http.DefaultTransport, slots: make(chan struct{}, 1)}
+	client := &http.Client{Transport: transport}
+	for i := 0; i < 2; i++ {
+		_, err := client.Get("http://invalid-url-that-will-fail:12345")
+		require.Error(t, err)
+	}
+	assert.Empty(t, transport.slots)
+}
@@ -0,0 +1,316 @@
+package traefikunifidns
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// MQTTConfig configures publishing of DNS change events to an MQTT broker.
+type MQTTConfig struct {
+	Broker                string `json:"broker"` // tcp://host:1883 or tls://host:8883
+	Username              string `json:"username,omitempty"`
+	Password              string `json:"password,omitempty"`
+	ClientID              string `json:"clientId,omitempty"`
+	Topic                 string `json:"topic,omitempty"` // Topic prefix, defaults to "traefikunifidns"
+	QoS                   int    `json:"qos,omitempty"`   // 0 or 1
+	InsecureSkipVerifyTLS bool   `json:"insecureSkipVerifyTLS,omitempty"`
+}
+
+const (
+	defaultMQTTTopic    = "traefikunifidns"
+	defaultMQTTClientID = "traefikunifidns"
+	mqttTimeout         = 10 * time.Second
+)
+
+// MQTT 3.1.1 control packet types, already shifted into the fixed header.
+const (
+	mqttConnect    = 0x10
+	mqttConnack    = 0x20
+	mqttPublish    = 0x30
+	mqttPuback     = 0x40
+	mqttDisconnect = 0xe0
+)
+
+// recordChange is published for every record created or updated in a cycle.
+type recordChange struct {
+	Hostname string    `json:"hostname"`
+	Device   string    `json:"device"`
+	Action   string    `json:"action"`
+	Value    string    `json:"value"`
+	Time     time.Time `json:"time"`
+}
+
+// stalenessEvent is published when updates become stale or recover.
+type stalenessEvent struct {
+	Stale        bool      `json:"stale"`
+	LastSuccess  time.Time `json:"lastSuccess"` // Of the last successful update, or the plugin start without one
+	MaxStaleness string    `json:"maxStaleness"`
+	Time         time.Time `json:"time"`
+}
+
+// mqttMessage is a single message to publish.
+type mqttMessage struct {
+	topic   string
+	payload []byte
+}
+
+// mqttPublisher publishes change events to an MQTT broker. It opens a fresh
+// connection for each batch, so it never needs to keep a session alive
+// between cycles. Only QoS 0 and 1 are supported.
+type mqttPublisher struct {
+	config   MQTTConfig // Without the password
+	password secret
+	address  string
+	useTLS   bool
+	topic    string
+	clientID string
+}
+
+func newMQTTPublisher(config MQTTConfig) (*mqttPublisher, error) {
+	if config.QoS < 0 || config.QoS > 1 {
+		return nil, fmt.Errorf("unsupported MQTT QoS %d, must be 0 or 1", config.QoS)
+	}
+
+	broker := config.Broker
+	if !strings.Contains(broker, "://") {
+		broker = "tcp://" + broker
+	}
+	u, err := url.Parse(broker)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MQTT broker: %w", err)
+	}
+
+	var useTLS bool
+	port := "1883"
+	switch u.Scheme {
+	case "tcp", "mqtt":
+	case "tls", "ssl", "mqtts":
+		useTLS = true
+		port = "8883"
+	default:
+		return nil, fmt.Errorf("unsupported MQTT broker scheme %q", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("MQTT broker is missing a host")
+	}
+	if u.Port() != "" {
+		port = u.Port()
+	}
+
+	p := &mqttPublisher{
+		password: newSecret(config.Password),
+		address:  net.JoinHostPort(u.Hostname(), port),
+		useTLS:   useTLS,
+		topic:    strings.TrimSuffix(config.Topic, "/"),
+		clientID: config.ClientID,
+	}
+	config.Password = ""
+	p.config = config
+	if p.topic == "" {
+		p.topic = defaultMQTTTopic
+	}
+	if p.clientID == "" {
+		p.clientID = defaultMQTTClientID
+	}
+	log.Printf("INFO: Publishing DNS change events to MQTT broker %s under topic %s", p.address, p.topic)
+	return p, nil
+}
+
+// publishCycle publishes the records created or updated in a cycle to
+// <topic>/records and its summary to <topic>/cycle.
+func (p *mqttPublisher) publishCycle(results []hostResult, summary cycleSummary) error {
+	messages := make([]mqttMessage, 0, summary.Changes+1)
+	for _, result := range results {
+		if !result.changed() {
+			continue
+		}
+		payload, err := json.Marshal(recordChange{
+			Hostname: result.Hostname,
+			Device:   result.Device,
+			Action:   result.Action,
+			Value:    result.Value,
+			Time:     summary.Time,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to marshal MQTT record event: %w", err)
+		}
+		messages = append(messages, mqttMessage{topic: p.topic + "/records", payload: payload})
+	}
+	payload, err := json.Marshal(summary)
+	if err != nil {
+		return fmt.Errorf("failed to marshal MQTT cycle summary: %w", err)
+	}
+	messages = append(messages, mqttMessage{topic: p.topic + "/cycle", payload: payload})
+	return p.publish(messages)
+}
+
+// publishStaleness publishes to <topic>/stale that updates became stale, or
+// recovered, with the time of the last successful update.
+func (p *mqttPublisher) publishStaleness(stale bool, lastSuccess time.Time, maxStaleness time.Duration) error {
+	payload, err := json.Marshal(stalenessEvent{
+		Stale:        stale,
+		LastSuccess:  lastSuccess,
+		MaxStaleness: maxStaleness.String(),
+		Time:         time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal MQTT staleness event: %w", err)
+	}
+	return p.publish([]mqttMessage{{topic: p.topic + "/stale", payload: payload}})
+}
+
+// publish connects to the broker, publishes messages and disconnects.
+func (p *mqttPublisher) publish(messages []mqttMessage) error {
+	dialer := &net.Dialer{Timeout: mqttTimeout}
+	var conn net.Conn
+	var err error
+	if p.useTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", p.address, &tls.Config{
+			InsecureSkipVerify: p.config.InsecureSkipVerifyTLS,
+		})
+	} else {
+		conn, err = dialer.Dial("tcp", p.address)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to MQTT broker: %w", err)
+	}
+	defer func() {
+		if closeErr := conn.Close(); closeErr != nil {
+			log.Printf("ERROR: Failed to close MQTT connection: %v", closeErr)
+		}
+	}()
+	if err := conn.SetDeadline(time.Now().Add(mqttTimeout)); err != nil {
+		return fmt.Errorf("failed to set MQTT deadline: %w", err)
+	}
+	reader := bufio.NewReader(conn)
+
+	if _, err := conn.Write(p.connectPacket()); err != nil {
+		return fmt.Errorf("failed to send MQTT connect: %w", err)
+	}
+	packetType, body, err := readMQTTPacket(reader)
+	if err != nil {
+		return fmt.Errorf("failed to read MQTT connack: %w", err)
+	}
+	if packetType != mqttConnack || len(body) != 2 {
+		return fmt.Errorf("unexpected MQTT packet 0x%x while waiting for connack", packetType)
+	}
+	if body[1] != 0 {
+		return fmt.Errorf("MQTT broker refused connection with code %d", body[1])
+	}
+
+	for i, message := range messages {
+		packetID := uint16(i + 1)
+		if _, err := conn.Write(p.publishPacket(message, packetID)); err != nil {
+			return fmt.Errorf("failed to publish MQTT message: %w", err)
+		}
+		if p.config.QoS == 0 {
+			continue
+		}
+		packetType, body, err := readMQTTPacket(reader)
+		if err != nil {
+			return fmt.Errorf("failed to read MQTT puback: %w", err)
+		}
+		if packetType != mqttPuback || len(body) != 2 || binary.BigEndian.Uint16(body) != packetID {
+			return fmt.Errorf("unexpected MQTT packet 0x%x while waiting for puback", packetType)
+		}
+	}
+
+	if _, err := conn.Write([]byte{mqttDisconnect, 0}); err != nil {
+		return fmt.Errorf("failed to send MQTT disconnect: %w", err)
+	}
+	return nil
+}
+
+func (p *mqttPublisher) connectPacket() []byte {
+	flags := byte(0x02) // clean session
+	var payload []byte
+	payload = appendMQTTString(payload, p.clientID)
+	if p.config.Username != "" {
+		flags |= 0x80
+		payload = appendMQTTString(payload, p.config.Username)
+		if !p.password.empty() {
+			flags |= 0x40
+			payload = appendMQTTString(payload, p.password.reveal())
+		}
+	}
+
+	var body []byte
+	body = appendMQTTString(body, "MQTT")
+	body = append(body, 4, flags)                  // protocol level 3.1.1
+	body = binary.BigEndian.AppendUint16(body, 60) // keep alive in seconds
+	body = append(body, payload...)
+	return mqttPacket(mqttConnect, body)
+}
+
+func (p *mqttPublisher) publishPacket(message mqttMessage, packetID uint16) []byte {
+	var body []byte
+	body = appendMQTTString(body, message.topic)
+	if p.config.QoS > 0 {
+		body = binary.BigEndian.AppendUint16(body, packetID)
+	}
+	body = append(body, message.payload...)
+	return mqttPacket(mqttPublish|byte(p.config.QoS)<<1, body)
+}
+
+// mqttPacket prepends the fixed header to body.
+func mqttPacket(header byte, body []byte) []byte {
+	packet := []byte{header}
+	length := len(body)
+	for {
+		b := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			b |= 0x80
+		}
+		packet = append(packet, b)
+		if length == 0 {
+			break
+		}
+	}
+	return append(packet, body...)
+}
+
+func appendMQTTString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+// readMQTTPacket reads a single packet and returns its type, without flags,
+// and its body.
+func readMQTTPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		if i == 4 {
+			return 0, nil, fmt.Errorf("malformed MQTT remaining length")
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length += int(b&0x7f) * multiplier
+		if b&0x80 == 0 {
+			break
+		}
+		multiplier *= 128
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return header & 0xf0, body, nil
+}
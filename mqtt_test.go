@@ -0,0 +1,167 @@
+package traefikunifidns
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testMQTTPublish is a PUBLISH packet received by the test broker.
+type testMQTTPublish struct {
+	qos     byte
+	topic   string
+	payload string
+}
+
+// newTestMQTTBroker accepts a single connection, answers CONNECT with the
+// given return code and acknowledges QoS 1 publishes. The received CONNECT
+// body and publishes are sent on the returned channels once the client
+// disconnects.
+func newTestMQTTBroker(t *testing.T, returnCode byte) (string, <-chan []byte, <-chan []testMQTTPublish) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	connects := make(chan []byte, 1)
+	publishes := make(chan []testMQTTPublish, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		reader := bufio.NewReader(conn)
+
+		var received []testMQTTPublish
+		defer func() { publishes <- received }()
+		for {
+			header, err := reader.Peek(1)
+			if err != nil {
+				return
+			}
+			flags := header[0] & 0x0f
+			packetType, body, err := readMQTTPacket(reader)
+			if err != nil {
+				return
+			}
+			switch packetType {
+			case mqttConnect:
+				connects <- body
+				_, _ = conn.Write([]byte{mqttConnack, 2, 0, returnCode})
+			case mqttPublish:
+				qos := flags >> 1
+				topicLen := int(binary.BigEndian.Uint16(body))
+				publish := testMQTTPublish{qos: qos, topic: string(body[2 : 2+topicLen])}
+				rest := body[2+topicLen:]
+				if qos > 0 {
+					_, _ = conn.Write([]byte{mqttPuback, 2, rest[0], rest[1]})
+					rest = rest[2:]
+				}
+				publish.payload = string(rest)
+				received = append(received, publish)
+			case mqttDisconnect:
+				return
+			}
+		}
+	}()
+	return listener.Addr().String(), connects, publishes
+}
+
+func TestNewMQTTPublisher(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  MQTTConfig
+		address string
+		useTLS  bool
+		wantErr string
+	}{
+		{name: "bare host", config: MQTTConfig{Broker: "broker.lan"}, address: "broker.lan:1883"},
+		{name: "tcp with port", config: MQTTConfig{Broker: "tcp://broker.lan:1884"}, address: "broker.lan:1884"},
+		{name: "tls default port", config: MQTTConfig{Broker: "tls://broker.lan"}, address: "broker.lan:8883", useTLS: true},
+		{name: "unsupported scheme", config: MQTTConfig{Broker: "ws://broker.lan"}, wantErr: `unsupported MQTT broker scheme "ws"`},
+		{name: "missing host", config: MQTTConfig{Broker: "tcp://:1883"}, wantErr: "MQTT broker is missing a host"},
+		{name: "QoS 2", config: MQTTConfig{Broker: "broker.lan", QoS: 2}, wantErr: "unsupported MQTT QoS 2, must be 0 or 1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := newMQTTPublisher(tt.config)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.address, p.address)
+			assert.Equal(t, tt.useTLS, p.useTLS)
+			assert.Equal(t, defaultMQTTTopic, p.topic)
+			assert.Equal(t, defaultMQTTClientID, p.clientID)
+		})
+	}
+}
+
+func TestMQTTPublishCycle(t *testing.T) {
+	for _, qos := range []int{0, 1} {
+		addr, connects, publishes := newTestMQTTBroker(t, 0)
+		p, err := newMQTTPublisher(MQTTConfig{
+			Broker:   addr,
+			Username: "user",
+			Password: "secret",
+			Topic:    "home/dns/",
+			QoS:      qos,
+		})
+		require.NoError(t, err)
+
+		results := []hostResult{
+			{Hostname: "app.lan", Device: "https://unifi.lan", Action: recordCreated, Value: "192.168.1.10"},
+			{Hostname: "web.lan", Device: "https://unifi.lan", Action: recordUnchanged, Value: "192.168.1.10"},
+		}
+		summary := cycleSummary{Time: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), Changes: 1}
+		require.NoError(t, p.publishCycle(results, summary))
+
+		connect := <-connects
+		assert.Equal(t, byte(0xc2), connect[7], "username, password and clean session flags")
+		assert.Contains(t, string(connect), "user")
+		assert.Contains(t, string(connect), "secret")
+
+		received := <-publishes
+		require.Len(t, received, 2)
+		assert.Equal(t, byte(qos), received[0].qos)
+		assert.Equal(t, "home/dns/records", received[0].topic)
+		var change recordChange
+		require.NoError(t, json.Unmarshal([]byte(received[0].payload), &change))
+		assert.Equal(t, recordChange{
+			Hostname: "app.lan",
+			Device:   "https://unifi.lan",
+			Action:   recordCreated,
+			Value:    "192.168.1.10",
+			Time:     summary.Time,
+		}, change)
+		assert.Equal(t, "home/dns/cycle", received[1].topic)
+		assert.JSONEq(t, `{"time":"2024-01-01T00:00:00Z","durationSeconds":0,"counts":{"created":0,"updated":0,"deleted":0,"unchanged":0,"noMatch":0,"skipped":0,"failed":0},"changes":1}`, received[1].payload)
+	}
+}
+
+func TestMQTTConnectionRefused(t *testing.T) {
+	addr, _, _ := newTestMQTTBroker(t, 5)
+	p, err := newMQTTPublisher(MQTTConfig{Broker: addr})
+	require.NoError(t, err)
+	assert.EqualError(t, p.publishCycle(nil, cycleSummary{}), "MQTT broker refused connection with code 5")
+}
+
+func TestMQTTPacketLength(t *testing.T) {
+	packet := mqttPacket(mqttPublish, make([]byte, 321))
+	assert.Equal(t, []byte{mqttPublish, 0xc1, 0x02}, packet[:3])
+
+	packetType, body, err := readMQTTPacket(bufio.NewReader(bytes.NewReader(packet)))
+	require.NoError(t, err)
+	assert.Equal(t, byte(mqttPublish), packetType)
+	assert.Len(t, body, 321)
+}
@@ -0,0 +1,64 @@
+package traefikunifidns
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// nameTemplateFuncs are available to name templates in addition to the
+// text/template builtins.
+var nameTemplateFuncs = template.FuncMap{
+	"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+	"replace":    func(old, replacement, s string) string { return strings.ReplaceAll(s, old, replacement) },
+	"lower":      strings.ToLower,
+}
+
+// nameTemplateData is passed to name templates.
+type nameTemplateData struct {
+	Hostname string // Hostname discovered in the router rule
+}
+
+// parseNameTemplates parses the name template of every device, falling back
+// to the global template. Devices without either get a nil entry.
+func parseNameTemplates(config *Config) ([]*template.Template, error) {
+	var global *template.Template
+	if config.NameTemplate != "" {
+		var err error
+		global, err = template.New("nameTemplate").Funcs(nameTemplateFuncs).Parse(config.NameTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid name template: %w", err)
+		}
+	}
+
+	templates := make([]*template.Template, len(config.Devices))
+	for i, device := range config.Devices {
+		templates[i] = global
+		if device.NameTemplate == "" {
+			continue
+		}
+		tmpl, err := template.New(fmt.Sprintf("device-%d", i)).Funcs(nameTemplateFuncs).Parse(device.NameTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid name template for device %d: %w", i, err)
+		}
+		templates[i] = tmpl
+	}
+	return templates, nil
+}
+
+// recordName returns the name hostname is published under on device.
+func (r *reconciler) recordName(hostname string, device int) (string, error) {
+	if device >= len(r.nameTemplates) || r.nameTemplates[device] == nil {
+		return hostname, nil
+	}
+	var b strings.Builder
+	if err := r.nameTemplates[device].Execute(&b, nameTemplateData{Hostname: hostname}); err != nil {
+		return "", fmt.Errorf("failed to apply name template to %s: %w", hostname, err)
+	}
+	name := strings.TrimSpace(b.String())
+	if name == "" {
+		return "", fmt.Errorf("name template produced an empty name for %s", hostname)
+	}
+	return name, nil
+}
@@ -0,0 +1,77 @@
+package traefikunifidns
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordName(t *testing.T) {
+	config := CreateConfig()
+	config.NameTemplate = "{{.Hostname}}.lan"
+	config.Devices = []UnifiDeviceConfig{
+		{},
+		{NameTemplate: `lab-{{trimPrefix "staging-" .Hostname}}`},
+		{NameTemplate: `{{trimSuffix ".example.com" .Hostname | lower}}`},
+		{NameTemplate: `{{if false}}x{{end}}`},
+	}
+	templates, err := parseNameTemplates(config)
+	require.NoError(t, err)
+	r := &reconciler{config: config, nameTemplates: templates}
+
+	tests := []struct {
+		device   int
+		hostname string
+		want     string
+		wantErr  string
+	}{
+		{device: 0, hostname: "app", want: "app.lan"},
+		{device: 1, hostname: "staging-app", want: "lab-app"},
+		{device: 2, hostname: "App.example.com", want: "app"},
+		{device: 3, hostname: "app", wantErr: "name template produced an empty name for app"},
+	}
+	for _, tt := range tests {
+		name, err := r.recordName(tt.hostname, tt.device)
+		if tt.wantErr != "" {
+			assert.EqualError(t, err, tt.wantErr)
+			continue
+		}
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, name)
+	}
+
+	name, err := (&reconciler{config: CreateConfig()}).recordName("app.lan", 0)
+	require.NoError(t, err)
+	assert.Equal(t, "app.lan", name, "names are published as discovered without a template")
+}
+
+func TestNewInvalidNameTemplate(t *testing.T) {
+	config := CreateConfig()
+	config.Devices = []UnifiDeviceConfig{{Host: "https://unifi.lan", Pattern: ".*", NameTemplate: "{{.Hostname"}}
+
+	_, err := New(context.Background(), nil, config, "test")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid name template for device 0")
+}
+
+func TestUpdateDNSNameTemplate(t *testing.T) {
+	traefikServer := newTestTraefikServer(t, []map[string]interface{}{
+		{"name": "app", "rule": "Host(`app`)", "middlewares": []string{"traefikunifidns"}},
+	})
+	var writes []map[string]interface{}
+	unifiServer := newTestUniFiServer(t, []DNSEntry{}, &writes)
+
+	config := CreateConfig()
+	config.TraefikAPIURL = traefikServer.URL
+	config.NameTemplate = "{{.Hostname}}.lan"
+	config.Devices = []UnifiDeviceConfig{
+		{Host: unifiServer.URL, Username: "admin", Password: "password", Pattern: `^app$`},
+	}
+
+	_, err := New(context.Background(), nil, config, "test")
+	require.NoError(t, err)
+	require.Len(t, writes, 1)
+	assert.Equal(t, "app.lan", writes[0]["key"], "patterns match the discovered hostname")
+}
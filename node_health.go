@@ -0,0 +1,178 @@
+package traefikunifidns
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// NodeHealthCheckConfig enables health checks of the Traefik nodes listed in
+// targetIPs. Only nodes passing the check are published.
+type NodeHealthCheckConfig struct {
+	Type    string `json:"type,omitempty"`    // tcp or http, defaults to tcp
+	Port    int    `json:"port,omitempty"`    // Defaults to 443 for tcp and 80 for http
+	Path    string `json:"path,omitempty"`    // Requested by http checks, defaults to /
+	Timeout string `json:"timeout,omitempty"` // Per check, defaults to 2s
+}
+
+const (
+	nodeCheckTCP  = "tcp"
+	nodeCheckHTTP = "http"
+
+	defaultNodeCheckTimeout = 2 * time.Second
+)
+
+// nodeChecker checks whether Traefik nodes are able to serve traffic.
+type nodeChecker struct {
+	checkType string
+	port      string
+	path      string
+	timeout   time.Duration
+	client    *http.Client
+
+	healthy map[string]bool // Outcome of the last check by address, only used by the update loop
+}
+
+func newNodeChecker(config NodeHealthCheckConfig) (*nodeChecker, error) {
+	c := &nodeChecker{checkType: config.Type, path: config.Path, timeout: defaultNodeCheckTimeout}
+	port := 443
+	switch c.checkType {
+	case "", nodeCheckTCP:
+		c.checkType = nodeCheckTCP
+	case nodeCheckHTTP:
+		port = 80
+	default:
+		return nil, fmt.Errorf("unsupported health check type %q, must be tcp or http", config.Type)
+	}
+	if config.Port < 0 || config.Port > 65535 {
+		return nil, fmt.Errorf("invalid health check port %d", config.Port)
+	}
+	if config.Port != 0 {
+		port = config.Port
+	}
+	c.port = strconv.Itoa(port)
+	if c.path == "" {
+		c.path = "/"
+	}
+	if config.Timeout != "" {
+		timeout, err := time.ParseDuration(config.Timeout)
+		if err == nil && timeout <= 0 {
+			err = fmt.Errorf("must be positive")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid health check timeout: %w", err)
+		}
+		c.timeout = timeout
+	}
+	c.client = &http.Client{
+		Timeout: c.timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return c, nil
+}
+
+// check reports whether the node at ip accepts connections or, for http
+// checks, answers without a server error.
+func (c *nodeChecker) check(ctx context.Context, ip string) error {
+	address := net.JoinHostPort(ip, c.port)
+	if c.checkType == nodeCheckTCP {
+		dialer := net.Dialer{Timeout: c.timeout}
+		conn, err := dialer.DialContext(ctx, "tcp", address)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", "http://"+address+c.path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			logError("Failed to close response body: %v", closeErr)
+		}
+	}()
+	// Drain the body so the connection can be reused by the next check
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// healthyNodes checks all ips in parallel and returns those passing, in
+// their original order. Nodes changing state are logged. When no node passes,
+// all of them are returned, as removing every record wouldn't help anyone.
+func (c *nodeChecker) healthyNodes(ctx context.Context, ips []string) []string {
+	errs := make([]error, len(ips))
+	var wg sync.WaitGroup
+	for i, ip := range ips {
+		wg.Add(1)
+		go func(i int, ip string) {
+			defer wg.Done()
+			errs[i] = c.check(ctx, ip)
+		}(i, ip)
+	}
+	wg.Wait()
+
+	healthy := make(map[string]bool, len(ips))
+	var passing []string
+	for i, ip := range ips {
+		healthy[ip] = errs[i] == nil
+		previous, known := c.healthy[ip]
+		switch {
+		case errs[i] != nil && (previous || !known):
+			log.Printf("WARN: Traefik node %s failed its health check, removing its records: %v", ip, errs[i])
+		case errs[i] == nil && known && !previous:
+			log.Printf("INFO: Traefik node %s recovered, publishing its records again", ip)
+		}
+		if errs[i] == nil {
+			passing = append(passing, ip)
+		}
+	}
+	c.healthy = healthy
+
+	if len(passing) == 0 {
+		logError("ALERT: All Traefik nodes failed their health checks, keeping the records of all of them")
+		return ips
+	}
+	return passing
+}
+
+// nodeTargets returns the addresses of the configured targetIPs to publish:
+// all of them, or the healthy ones of the last check with nodeHealthCheck.
+func (r *reconciler) nodeTargets() []string {
+	if r.liveNodes != nil {
+		return r.liveNodes
+	}
+	return r.config.TargetIPs
+}
+
+// checkNodes runs the health checks of the targetIPs for a cycle. It expects
+// r.mu to be held.
+func (r *reconciler) checkNodes(ctx context.Context) {
+	if r.nodeChecker == nil || len(r.config.TargetIPs) == 0 {
+		return
+	}
+	r.liveNodes = r.nodeChecker.healthyNodes(ctx, r.config.TargetIPs)
+
+	r.stats.mu.Lock()
+	defer r.stats.mu.Unlock()
+	r.stats.nodes = make(map[string]bool, len(r.nodeChecker.healthy))
+	for ip, healthy := range r.nodeChecker.healthy {
+		r.stats.nodes[ip] = healthy
+	}
+}
@@ -0,0 +1,105 @@
+package traefikunifidns
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// listenerPort returns the port of a test server listening on 127.0.0.1.
+func listenerPort(t *testing.T, server *httptest.Server) int {
+	t.Helper()
+	_, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	require.NoError(t, err)
+	n, err := strconv.Atoi(port)
+	require.NoError(t, err)
+	return n
+}
+
+func TestNodeChecker(t *testing.T) {
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/ping", r.URL.Path)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+	port := listenerPort(t, server)
+
+	for _, checkType := range []string{nodeCheckTCP, nodeCheckHTTP} {
+		t.Run(checkType, func(t *testing.T) {
+			status = http.StatusOK
+			c, err := newNodeChecker(NodeHealthCheckConfig{Type: checkType, Port: port, Path: "/ping", Timeout: "1s"})
+			require.NoError(t, err)
+
+			// Nothing listens on 127.0.0.2
+			nodes := []string{"127.0.0.2", "127.0.0.1"}
+			assert.Equal(t, []string{"127.0.0.1"}, c.healthyNodes(context.Background(), nodes))
+			assert.Equal(t, map[string]bool{"127.0.0.1": true, "127.0.0.2": false}, c.healthy)
+		})
+	}
+
+	c, err := newNodeChecker(NodeHealthCheckConfig{Type: nodeCheckHTTP, Port: port, Path: "/ping"})
+	require.NoError(t, err)
+	status = http.StatusServiceUnavailable
+	assert.Error(t, c.check(context.Background(), "127.0.0.1"))
+	status = http.StatusUnauthorized
+	assert.NoError(t, c.check(context.Background(), "127.0.0.1"), "the node answers")
+
+	// Without any healthy node, all records are kept
+	server.Close()
+	assert.Equal(t, []string{"127.0.0.1", "127.0.0.2"}, c.healthyNodes(context.Background(), []string{"127.0.0.1", "127.0.0.2"}))
+}
+
+func TestNewNodeCheckerInvalid(t *testing.T) {
+	for _, config := range []NodeHealthCheckConfig{
+		{Type: "icmp"},
+		{Port: 70000},
+		{Timeout: "soon"},
+		{Timeout: "0s"},
+	} {
+		_, err := newNodeChecker(config)
+		assert.Error(t, err, "%+v", config)
+	}
+
+	config := CreateConfig()
+	config.NodeHealthCheck = &NodeHealthCheckConfig{}
+	config.Devices = []UnifiDeviceConfig{{WebhookURL: "http://localhost:8080", Pattern: `\.lan$`}}
+	_, err := newReconciler(config)
+	assert.EqualError(t, err, "nodeHealthCheck requires targetIPs")
+}
+
+func TestUpdateDNSNodeHealthCheck(t *testing.T) {
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer node.Close()
+	traefikServer := newTestTraefikServer(t, []map[string]interface{}{
+		{"name": "app", "rule": "Host(`app.lan`)", "middlewares": []string{"traefikunifidns"}},
+	})
+	var writes []map[string]interface{}
+	unifiServer := newTestUniFiServer(t, []DNSEntry{
+		{ID: "1", Key: "app.lan", RecordType: "A", Value: "127.0.0.1"},
+		{ID: "2", Key: "app.lan", RecordType: "A", Value: "127.0.0.2"},
+	}, &writes)
+
+	config := CreateConfig()
+	config.TraefikAPIURL = traefikServer.URL
+	config.TargetIPs = []string{"127.0.0.1", "127.0.0.2"}
+	config.NodeHealthCheck = &NodeHealthCheckConfig{Port: listenerPort(t, node)}
+	config.Devices = []UnifiDeviceConfig{
+		{Host: unifiServer.URL, Username: "admin", Password: "password", Pattern: `\.lan$`},
+	}
+
+	plugin, err := New(context.Background(), nil, config, "test")
+	require.NoError(t, err)
+
+	require.Len(t, writes, 1)
+	assert.Equal(t, map[string]interface{}{"deleted": "2"}, writes[0], "the record of the failing node is removed")
+	u := plugin.(*UniFiDNS)
+	assert.Equal(t, "127.0.0.1", u.results[0].Value)
+	assert.Equal(t, map[string]bool{"127.0.0.1": true, "127.0.0.2": false}, u.status().Nodes)
+}
@@ -0,0 +1,150 @@
+package traefikunifidns
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+)
+
+// reasonPendingRemoval is the reason of orphaned records kept during the
+// orphan grace period.
+const reasonPendingRemoval = "pending removal"
+
+// pluginState is the content of the state file.
+type pluginState struct {
+	// PendingRemovals holds, per device, when each orphaned record was
+	// first seen without a router.
+	PendingRemovals map[string]map[string]time.Time `json:"pendingRemovals,omitempty"`
+	// PendingRecords holds, per device, the records pending removal, so
+	// they can still be deleted after a restart.
+	PendingRecords map[string]map[string]webhookRecord `json:"pendingRecords,omitempty"`
+	// NodeAddresses holds the addresses ever published from targetIPs, so
+	// their records are still deleted after they were removed from it.
+	NodeAddresses []string `json:"nodeAddresses,omitempty"`
+}
+
+// loadState reads the state file at path. A missing file is an empty state.
+func loadState(path string) (pluginState, error) {
+	var state pluginState
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return state, fmt.Errorf("failed to read state file: %w", err)
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, fmt.Errorf("failed to parse state file %s: %w", path, err)
+	}
+	return state, nil
+}
+
+// saveState writes the pending removals and their records, and the node
+// addresses, to the state file, if one is configured. It expects r.mu to be
+// held.
+func (r *reconciler) saveState() {
+	if r.config.StateFile == "" {
+		return
+	}
+	state := pluginState{PendingRemovals: r.pendingRemovals}
+	for ip := range r.nodeAddresses {
+		state.NodeAddresses = append(state.NodeAddresses, ip)
+	}
+	for _, ip := range r.config.TargetIPs {
+		if !r.nodeAddresses[ip] {
+			state.NodeAddresses = append(state.NodeAddresses, ip)
+		}
+	}
+	sort.Strings(state.NodeAddresses)
+	for _, provider := range r.providers {
+		w, ok := provider.(*webhookProvider)
+		if !ok || len(r.pendingRemovals[w.String()]) == 0 {
+			continue
+		}
+		if state.PendingRecords == nil {
+			state.PendingRecords = make(map[string]map[string]webhookRecord)
+		}
+		state.PendingRecords[w.String()] = w.records(r.pendingRemovals[w.String()])
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		logError("Failed to marshal state: %v", err)
+		return
+	}
+	if err := writeFileIfChanged(r.config.StateFile, append(data, '\n')); err != nil {
+		logError("Failed to write state file: %v", err)
+	}
+}
+
+// restoreState hands the records pending removal of the state file back to
+// the webhook providers, which only know the records they published since
+// the start, so they are deleted once the grace period ends. The node
+// addresses of the state file are added to the current targetIPs.
+func (r *reconciler) restoreState(state pluginState) {
+	r.nodeAddresses = make(map[string]bool)
+	for _, ip := range append(state.NodeAddresses, r.config.TargetIPs...) {
+		r.nodeAddresses[ip] = true
+	}
+	for _, provider := range r.providers {
+		if w, ok := provider.(*webhookProvider); ok {
+			w.restore(state.PendingRecords[w.String()])
+		}
+	}
+}
+
+// holdOrphans adds the orphaned records of provider that are still within
+// the orphan grace period to keep, so pruning leaves them alone, and reports
+// them as pending removal. Records whose router came back are forgotten. It
+// expects r.mu to be held.
+func (r *reconciler) holdOrphans(provider dnsProvider, p pruner, keep map[string]bool) {
+	if r.orphanGracePeriod <= 0 {
+		return
+	}
+	device := provider.String()
+	previous := r.pendingRemovals[device]
+	pending := make(map[string]time.Time)
+	now := time.Now()
+	orphans := p.orphans(keep)
+	sort.Strings(orphans)
+	for _, hostname := range orphans {
+		since, ok := previous[hostname]
+		if !ok {
+			since = now
+			log.Printf("INFO: %s lost its router, deleting it from %s after %s", hostname, provider, r.orphanGracePeriod)
+		}
+		pending[hostname] = since
+		if now.Sub(since) < r.orphanGracePeriod {
+			keep[hostname] = true
+			r.results = append(r.results, hostResult{Hostname: hostname, Device: device, Action: resultSkipped, Reason: reasonPendingRemoval})
+		}
+	}
+	for hostname := range previous {
+		if _, ok := pending[hostname]; !ok {
+			log.Printf("INFO: %s is published again, no longer pending removal from %s", hostname, provider)
+		}
+	}
+
+	if len(pending) == 0 {
+		delete(r.pendingRemovals, device)
+	} else {
+		if r.pendingRemovals == nil {
+			r.pendingRemovals = make(map[string]map[string]time.Time)
+		}
+		r.pendingRemovals[device] = pending
+	}
+}
+
+// forgetRemoved drops deleted records of provider from the pending removals.
+// It expects r.mu to be held.
+func (r *reconciler) forgetRemoved(provider dnsProvider, deleted []string) {
+	pending := r.pendingRemovals[provider.String()]
+	for _, hostname := range deleted {
+		delete(pending, hostname)
+	}
+	if len(pending) == 0 {
+		delete(r.pendingRemovals, provider.String())
+	}
+}
@@ -0,0 +1,149 @@
+package traefikunifidns
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrphanGracePeriod(t *testing.T) {
+	rules := []string{"Host(`app.lan`)", "Host(`nas.lan`)"}
+	traefikServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		routers := make([]map[string]interface{}, 0, len(rules))
+		for _, rule := range rules {
+			routers = append(routers, map[string]interface{}{"rule": rule, "middlewares": []string{"traefikunifidns"}})
+		}
+		_ = json.NewEncoder(w).Encode(routers)
+	}))
+	defer traefikServer.Close()
+	var changes []webhookChange
+	webhookServer := newTestWebhookServer(t, &changes)
+	statePath := filepath.Join(t.TempDir(), "state.json")
+
+	config := CreateConfig()
+	config.TraefikAPIURL = traefikServer.URL
+	config.Devices = []UnifiDeviceConfig{{WebhookURL: webhookServer.URL, Pattern: `\.lan$`}}
+	config.IPOverrides = map[string]string{"app.lan": "192.168.1.10", "nas.lan": "192.168.1.20"}
+	config.OrphanGracePeriod = "1h"
+	config.StateFile = statePath
+
+	plugin, err := New(context.Background(), nil, config, "test")
+	require.NoError(t, err)
+	u := plugin.(*UniFiDNS)
+	require.Len(t, changes, 2)
+
+	// A router flapping away and back never deletes its record
+	rules = rules[:1]
+	require.NoError(t, u.updateDNS(context.Background()).Err)
+	assert.Len(t, changes, 2)
+	assert.Contains(t, u.results, hostResult{Hostname: "nas.lan", Device: webhookServer.URL, Action: resultSkipped, Reason: reasonPendingRemoval})
+
+	state, err := loadState(statePath)
+	require.NoError(t, err)
+	require.Contains(t, state.PendingRemovals[webhookServer.URL], "nas.lan")
+
+	rules = append(rules, "Host(`nas.lan`)")
+	require.NoError(t, u.updateDNS(context.Background()).Err)
+	assert.Len(t, changes, 2)
+	assert.Empty(t, u.pendingRemovals)
+
+	// Once the grace period is over, the record is deleted
+	rules = rules[:1]
+	require.NoError(t, u.updateDNS(context.Background()).Err)
+	u.pendingRemovals[webhookServer.URL]["nas.lan"] = time.Now().Add(-2 * time.Hour)
+	require.NoError(t, u.updateDNS(context.Background()).Err)
+	require.Len(t, changes, 3)
+	assert.Equal(t, webhookDelete, changes[2].Action)
+	assert.Equal(t, "nas.lan", changes[2].Record.Hostname)
+	assert.Empty(t, u.pendingRemovals)
+}
+
+func TestStateFileSurvivesRestart(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	since := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	data, err := json.Marshal(pluginState{PendingRemovals: map[string]map[string]time.Time{
+		"http://localhost:8080": {"nas.lan": since},
+	}})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(statePath, data, 0o600))
+
+	config := CreateConfig()
+	config.Devices = []UnifiDeviceConfig{{WebhookURL: "http://localhost:8080", Pattern: `\.lan$`}}
+	config.OrphanGracePeriod = "1h"
+	config.StateFile = statePath
+
+	r, err := newReconciler(config)
+	require.NoError(t, err)
+	assert.True(t, since.Equal(r.pendingRemovals["http://localhost:8080"]["nas.lan"]))
+
+	require.NoError(t, os.WriteFile(statePath, []byte("not json"), 0o600))
+	_, err = newReconciler(config)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid state file")
+}
+
+func TestOrphanGracePeriodAcrossRestart(t *testing.T) {
+	rules := []string{"Host(`app.lan`)", "Host(`nas.lan`)"}
+	traefikServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		routers := make([]map[string]interface{}, 0, len(rules))
+		for _, rule := range rules {
+			routers = append(routers, map[string]interface{}{"rule": rule, "middlewares": []string{"traefikunifidns"}})
+		}
+		_ = json.NewEncoder(w).Encode(routers)
+	}))
+	defer traefikServer.Close()
+	var changes []webhookChange
+	webhookServer := newTestWebhookServer(t, &changes)
+	statePath := filepath.Join(t.TempDir(), "state.json")
+
+	config := CreateConfig()
+	config.TraefikAPIURL = traefikServer.URL
+	config.Devices = []UnifiDeviceConfig{{WebhookURL: webhookServer.URL, Pattern: `\.lan$`}}
+	config.IPOverrides = map[string]string{"app.lan": "192.168.1.10", "nas.lan": "192.168.1.20"}
+	config.OrphanGracePeriod = "1h"
+	config.StateFile = statePath
+
+	r, err := newReconciler(config)
+	require.NoError(t, err)
+	require.NoError(t, r.updateDNS(context.Background()).Err)
+	rules = rules[:1]
+	require.NoError(t, r.updateDNS(context.Background()).Err)
+	require.Len(t, changes, 2)
+
+	// Backdate the pending removal as if Traefik was down past the grace period
+	state, err := loadState(statePath)
+	require.NoError(t, err)
+	assert.Equal(t, webhookRecord{Hostname: "nas.lan", Type: "A", Value: "192.168.1.20"}, state.PendingRecords[webhookServer.URL]["nas.lan"])
+	state.PendingRemovals[webhookServer.URL]["nas.lan"] = time.Now().Add(-2 * time.Hour)
+	data, err := json.Marshal(state)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(statePath, data, 0o600))
+
+	restarted, err := newReconciler(config)
+	require.NoError(t, err)
+	require.NoError(t, restarted.updateDNS(context.Background()).Err)
+	require.Len(t, changes, 4)
+	assert.Equal(t, webhookCreate, changes[2].Action, "records are sent again after a restart")
+	assert.Equal(t, webhookChange{Action: webhookDelete, Record: webhookRecord{Hostname: "nas.lan", Type: "A", Value: "192.168.1.20"}}, changes[3])
+	assert.Empty(t, restarted.pendingRemovals)
+}
+
+func TestNewInvalidOrphanGracePeriod(t *testing.T) {
+	config := CreateConfig()
+	config.Devices = []UnifiDeviceConfig{{WebhookURL: "http://localhost:8080", Pattern: `\.lan$`}}
+
+	for _, period := range []string{"soon", "-1h"} {
+		config.OrphanGracePeriod = period
+		_, err := newReconciler(config)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid orphan grace period")
+	}
+}
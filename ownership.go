@@ -0,0 +1,133 @@
+package traefikunifidns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// txtRegistry implements the external-dns TXT registry convention: every
+// record the plugin manages has a companion TXT record naming its owner, and
+// records without one, or owned by someone else, are never modified. This
+// lets the plugin, external-dns and manual edits share the same zone data.
+type txtRegistry struct {
+	ownerID string
+	prefix  string
+}
+
+// ownedRecordTypes are the record types the plugin publishes for a hostname,
+// each with its own ownership record.
+var ownedRecordTypes = []string{"A", "AAAA", "CNAME", "SRV"}
+
+// txtKey returns the name of the ownership record for the record of
+// recordType of hostname, using the record-type prefixed format of current
+// external-dns releases. DNS names are case-insensitive, so the name is
+// lower-cased like external-dns does.
+func (t *txtRegistry) txtKey(recordType, hostname string) string {
+	return strings.ToLower(t.prefix + recordType + "-" + hostname)
+}
+
+func (t *txtRegistry) txtValue() string {
+	return fmt.Sprintf(`"heritage=external-dns,external-dns/owner=%s"`, t.ownerID)
+}
+
+// owner returns the owner recorded for hostname in entries. The current
+// record names of every published record type, and the legacy (unprefixed)
+// external-dns record name are recognized, so a hostname switching between
+// A and CNAME records keeps its owner. Names are compared case-insensitively.
+func (t *txtRegistry) owner(entries []DNSEntry, hostname string) (string, bool) {
+	keys := map[string]bool{strings.ToLower(t.prefix + hostname): true}
+	for _, recordType := range ownedRecordTypes {
+		keys[t.txtKey(recordType, hostname)] = true
+	}
+	for _, entry := range entries {
+		if entry.RecordType != "TXT" || !keys[strings.ToLower(entry.Key)] {
+			continue
+		}
+		if owner, ok := parseTXTOwner(entry.Value); ok {
+			return owner, true
+		}
+	}
+	return "", false
+}
+
+// claim reports whether the plugin may write the record of recordType for
+// hostname on client. Hostnames without any record are claimed by creating
+// the ownership record of recordType first, as external-dns does. Owned
+// hostnames get the ownership record of recordType too when it is missing,
+// e.g. for an AAAA record published next to the A record.
+func (t *txtRegistry) claim(ctx context.Context, client *UniFiClient, hostname, recordType string) (bool, error) {
+	entries, err := client.GetStaticDNSEntries(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to get DNS entries before ownership check: %w", err)
+	}
+
+	owner, owned := t.owner(entries, hostname)
+	if owned {
+		if owner != t.ownerID {
+			log.Printf("WARN: Skipping %s: record is owned by %q", hostname, owner)
+			return false, nil
+		}
+		if t.hasTXT(entries, recordType, hostname) {
+			return true, nil
+		}
+	} else {
+		for _, entry := range entries {
+			if strings.EqualFold(entry.Key, hostname) {
+				log.Printf("WARN: Skipping %s: existing %s record is not owned by this plugin", hostname, entry.RecordType)
+				return false, nil
+			}
+		}
+	}
+
+	err = client.createDNSEntry(ctx, DNSEntry{
+		Key:        t.txtKey(recordType, hostname),
+		RecordType: "TXT",
+		Value:      t.txtValue(),
+	})
+	if owned && (errors.Is(err, errReadOnly) || errors.Is(err, errWriteForbidden)) {
+		// The record itself can't be written either, and stays ours
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to create ownership record: %w", err)
+	}
+	return true, nil
+}
+
+// hasTXT reports whether entries hold the ownership record of this plugin
+// for the record of recordType of hostname.
+func (t *txtRegistry) hasTXT(entries []DNSEntry, recordType, hostname string) bool {
+	key := t.txtKey(recordType, hostname)
+	for _, entry := range entries {
+		if entry.RecordType == "TXT" && strings.ToLower(entry.Key) == key {
+			if owner, ok := parseTXTOwner(entry.Value); ok && owner == t.ownerID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseTXTOwner extracts the owner from an external-dns registry TXT value
+// such as "heritage=external-dns,external-dns/owner=default".
+func parseTXTOwner(value string) (string, bool) {
+	value = strings.Trim(value, `"`)
+	var heritage bool
+	var owner string
+	for _, label := range strings.Split(value, ",") {
+		key, val, ok := strings.Cut(label, "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "heritage":
+			heritage = val == "external-dns"
+		case "external-dns/owner":
+			owner = val
+		}
+	}
+	return owner, heritage
+}
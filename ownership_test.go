@@ -0,0 +1,130 @@
+package traefikunifidns
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTXTOwner(t *testing.T) {
+	tests := []struct {
+		name      string
+		value     string
+		wantOwner string
+		wantOK    bool
+	}{
+		{
+			name:      "quoted",
+			value:     `"heritage=external-dns,external-dns/owner=traefik"`,
+			wantOwner: "traefik",
+			wantOK:    true,
+		},
+		{
+			name:      "with resource",
+			value:     "heritage=external-dns,external-dns/owner=k8s,external-dns/resource=ingress/default/app",
+			wantOwner: "k8s",
+			wantOK:    true,
+		},
+		{
+			name:   "not a registry record",
+			value:  "v=spf1 -all",
+			wantOK: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			owner, ok := parseTXTOwner(tt.value)
+			assert.Equal(t, tt.wantOK, ok)
+			if tt.wantOK {
+				assert.Equal(t, tt.wantOwner, owner)
+			}
+		})
+	}
+}
+
+func TestTXTRegistryClaim(t *testing.T) {
+	registry := &txtRegistry{ownerID: "traefik", prefix: "reg-"}
+	entries := []DNSEntry{
+		{Key: "ours.lan", Value: "192.168.1.10", ID: "1", RecordType: "A"},
+		{Key: "reg-a-ours.lan", Value: `"heritage=external-dns,external-dns/owner=traefik"`, ID: "2", RecordType: "TXT"},
+		{Key: "theirs.lan", Value: "192.168.1.11", ID: "3", RecordType: "A"},
+		{Key: "reg-theirs.lan", Value: `"heritage=external-dns,external-dns/owner=k8s"`, ID: "4", RecordType: "TXT"},
+		{Key: "manual.lan", Value: "192.168.1.12", ID: "5", RecordType: "A"},
+		{Key: "alias.lan", Value: "traefik.lan", ID: "6", RecordType: "CNAME"},
+		{Key: "reg-cname-alias.lan", Value: `"heritage=external-dns,external-dns/owner=traefik"`, ID: "7", RecordType: "TXT"},
+		{Key: "Mixed.lan", Value: "192.168.1.13", ID: "8", RecordType: "A"},
+		{Key: "REG-A-mixed.LAN", Value: `"heritage=external-dns,external-dns/owner=traefik"`, ID: "9", RecordType: "TXT"},
+		{Key: "Manual.lan", Value: "2001:db8::12", ID: "10", RecordType: "AAAA"},
+	}
+
+	tests := []struct {
+		hostname    string
+		recordType  string
+		wantClaimed bool
+		wantWrites  int
+	}{
+		{hostname: "ours.lan", recordType: "A", wantClaimed: true},
+		{hostname: "ours.lan", recordType: "AAAA", wantClaimed: true, wantWrites: 1},
+		{hostname: "ours.lan", recordType: "CNAME", wantClaimed: true, wantWrites: 1},
+		{hostname: "alias.lan", recordType: "CNAME", wantClaimed: true},
+		{hostname: "alias.lan", recordType: "A", wantClaimed: true, wantWrites: 1},
+		{hostname: "theirs.lan", recordType: "A", wantClaimed: false},
+		{hostname: "theirs.lan", recordType: "AAAA", wantClaimed: false},
+		{hostname: "manual.lan", recordType: "A", wantClaimed: false},
+		{hostname: "manual.lan", recordType: "AAAA", wantClaimed: false},
+		{hostname: "mixed.lan", recordType: "A", wantClaimed: true},
+		{hostname: "MIXED.lan", recordType: "AAAA", wantClaimed: true, wantWrites: 1},
+		{hostname: "new.lan", recordType: "A", wantClaimed: true, wantWrites: 1},
+		{hostname: "new.lan", recordType: "CNAME", wantClaimed: true, wantWrites: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.hostname+" "+tt.recordType, func(t *testing.T) {
+			var writes []map[string]interface{}
+			server := newTestUniFiServer(t, entries, &writes)
+			client := NewUniFiClient(server.URL, "admin", "password", false)
+
+			claimed, err := registry.claim(context.Background(), client, tt.hostname, tt.recordType)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantClaimed, claimed)
+			require.Len(t, writes, tt.wantWrites)
+			if tt.wantWrites > 0 {
+				assert.Equal(t, "reg-"+strings.ToLower(tt.recordType+"-"+tt.hostname), writes[0]["key"])
+				assert.Equal(t, "TXT", writes[0]["record_type"])
+				assert.Equal(t, `"heritage=external-dns,external-dns/owner=traefik"`, writes[0]["value"])
+			}
+		})
+	}
+}
+
+func TestUpdateDNSOwnership(t *testing.T) {
+	traefikServer := newTestTraefikServer(t, []map[string]interface{}{
+		{"name": "new", "rule": "Host(`new.lan`)", "middlewares": []string{"traefikunifidns"}},
+		{"name": "manual", "rule": "Host(`manual.lan`)", "middlewares": []string{"traefikunifidns"}},
+	})
+	var writes []map[string]interface{}
+	unifiServer := newTestUniFiServer(t, []DNSEntry{
+		{Key: "manual.lan", Value: "192.168.1.12", ID: "5", RecordType: "A"},
+	}, &writes)
+
+	config := CreateConfig()
+	config.TraefikAPIURL = traefikServer.URL
+	config.Devices = []UnifiDeviceConfig{
+		{Host: unifiServer.URL, Username: "admin", Password: "password", Pattern: `\.lan$`},
+	}
+	config.IPOverrides = map[string]string{"new.lan": "192.168.1.20", "manual.lan": "192.168.1.21"}
+	config.OwnerID = "traefik"
+
+	_, err := New(context.Background(), nil, config, "test")
+	require.NoError(t, err)
+
+	require.Len(t, writes, 2)
+	assert.Equal(t, "a-new.lan", writes[0]["key"])
+	assert.Equal(t, "TXT", writes[0]["record_type"])
+	assert.Equal(t, "new.lan", writes[1]["key"])
+	assert.Equal(t, "A", writes[1]["record_type"])
+}
@@ -0,0 +1,97 @@
+package traefikunifidns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// errRecordsFailed is returned for a cycle in which records of a device
+// failed. It wraps the failures of the single hostnames.
+var errRecordsFailed = errors.New("DNS records failed")
+
+// defaultMaxParallelDevices is the number of devices updated at once without
+// maxParallelDevices.
+const defaultMaxParallelDevices = 4
+
+// publishJob is a record publishRecord, or publishSRV for SRV records,
+// writes to a device in a cycle.
+type publishJob struct {
+	slot      int    // Index of the outcome in the results of the cycle
+	hostname  string // Hostname of the router
+	name      string // Record name on the device
+	device    int
+	routerIPs []string
+	srv       *srvRecord // Set for the SRV record of the router
+}
+
+// maxParallelDevices returns how many devices are updated at once.
+func (r *reconciler) maxParallelDevices() int {
+	if r.config.MaxParallelDevices > 0 {
+		return r.config.MaxParallelDevices
+	}
+	return defaultMaxParallelDevices
+}
+
+// publishAll publishes jobs with one worker per provider, running at most
+// maxParallelDevices workers at once, so a slow controller doesn't hold up
+// the others. Devices on the same controller share a pooled client, and with
+// it a worker, so they never write the same record at once. Jobs of a
+// provider run in order, and every outcome is written to the slot of its
+// job; slots of jobs skipped because the cycle was aborted stay empty. It
+// returns the failures of every provider combined.
+func (r *reconciler) publishAll(ctx context.Context, jobs []publishJob, results []hostResult) error {
+	var providers []dnsProvider
+	byProvider := make(map[dnsProvider][]publishJob)
+	for _, job := range jobs {
+		provider := r.providers[fmt.Sprintf("device-%d", job.device)]
+		if _, ok := byProvider[provider]; !ok {
+			providers = append(providers, provider)
+		}
+		byProvider[provider] = append(byProvider[provider], job)
+	}
+
+	errs := make([]error, len(providers))
+	workers := make(chan struct{}, r.maxParallelDevices())
+	var wg sync.WaitGroup
+	for i, provider := range providers {
+		wg.Add(1)
+		go func(i int, jobs []publishJob) {
+			defer wg.Done()
+			workers <- struct{}{}
+			defer func() { <-workers }()
+			errs[i] = r.publishDevice(ctx, jobs, results)
+		}(i, byProvider[provider])
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// publishDevice publishes the jobs of a single provider in order, stopping
+// once ctx is done or the change quota is exceeded. It returns the failures
+// of the provider combined.
+func (r *reconciler) publishDevice(ctx context.Context, jobs []publishJob, results []hostResult) error {
+	var failures []error
+	for _, job := range jobs {
+		if ctx.Err() != nil || r.quotaError() != nil {
+			break
+		}
+		var result hostResult
+		if job.srv != nil {
+			result = r.publishSRV(ctx, job)
+		} else {
+			result = r.publishRecord(ctx, job.name, job.device, job.routerIPs)
+		}
+		r.countChange(result)
+		results[job.slot] = result
+		if result.err != nil {
+			failures = append(failures, result.err)
+		}
+	}
+	if len(failures) == 0 {
+		return nil
+	}
+	provider := r.providers[fmt.Sprintf("device-%d", jobs[0].device)]
+	return fmt.Errorf("%d %w on %s: %w", len(failures), errRecordsFailed, provider, errors.Join(failures...))
+}
@@ -0,0 +1,165 @@
+package traefikunifidns
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParallelDevices(t *testing.T) {
+	traefikServer := newTestTraefikServer(t, []map[string]interface{}{
+		{"name": "app", "rule": "Host(`app.lan`)", "middlewares": []string{"traefikunifidns"}},
+	})
+
+	// Each webhook holds its request until the other one received its own,
+	// which only succeeds when both devices are updated at once
+	var mu sync.Mutex
+	arrived := 0
+	both := make(chan struct{})
+	barrier := func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		arrived++
+		if arrived == 2 {
+			close(both)
+		}
+		mu.Unlock()
+		select {
+		case <-both:
+			w.WriteHeader(http.StatusNoContent)
+		case <-time.After(2 * time.Second):
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}
+	first := httptest.NewServer(http.HandlerFunc(barrier))
+	defer first.Close()
+	second := httptest.NewServer(http.HandlerFunc(barrier))
+	defer second.Close()
+
+	config := CreateConfig()
+	config.TraefikAPIURL = traefikServer.URL
+	config.Devices = []UnifiDeviceConfig{
+		{WebhookURL: first.URL, Pattern: `\.lan$`},
+		{WebhookURL: second.URL, Pattern: `\.lan$`},
+	}
+	config.IPOverrides = map[string]string{"app.lan": "192.168.1.10"}
+	r, err := newReconciler(config)
+	require.NoError(t, err)
+
+	result := r.sync(context.Background())
+	require.NoError(t, result.Err)
+	assert.Equal(t, 2, result.Counts.Created)
+}
+
+func TestParallelDevicesCombineErrors(t *testing.T) {
+	traefikServer := newTestTraefikServer(t, []map[string]interface{}{
+		{"name": "app", "rule": "Host(`app.lan`)", "middlewares": []string{"traefikunifidns"}},
+		{"name": "nas", "rule": "Host(`nas.lan`)", "middlewares": []string{"traefikunifidns"}},
+	})
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer broken.Close()
+	var changes []webhookChange
+	webhookServer := newTestWebhookServer(t, &changes)
+
+	config := CreateConfig()
+	config.TraefikAPIURL = traefikServer.URL
+	config.Devices = []UnifiDeviceConfig{
+		{WebhookURL: broken.URL, Pattern: `\.lan$`},
+		{WebhookURL: webhookServer.URL, Pattern: `\.lan$`},
+	}
+	config.IPOverrides = map[string]string{"app.lan": "192.168.1.10", "nas.lan": "192.168.1.20"}
+	config.MaxParallelDevices = 1
+	r, err := newReconciler(config)
+	require.NoError(t, err)
+
+	result := r.sync(context.Background())
+	require.ErrorIs(t, result.Err, errRecordsFailed)
+	assert.Contains(t, result.Err.Error(), "2 DNS records failed on "+r.providers["device-0"].String())
+	assert.Len(t, changes, 2, "the other device is still updated")
+	assert.Len(t, result.Errors(), 2, "failures are not repeated by the cycle error")
+
+	// Results keep the order of the hostnames
+	var order []string
+	for _, host := range result.Hosts {
+		order = append(order, host.Hostname+"@"+host.Device)
+	}
+	assert.Equal(t, []string{
+		"app.lan@" + r.providers["device-0"].String(),
+		"app.lan@" + r.providers["device-1"].String(),
+		"nas.lan@" + r.providers["device-0"].String(),
+		"nas.lan@" + r.providers["device-1"].String(),
+	}, order)
+}
+
+func TestParallelDevicesSharedController(t *testing.T) {
+	traefikServer := newTestTraefikServer(t, []map[string]interface{}{
+		{"name": "app", "rule": "Host(`app.lan`)", "middlewares": []string{"traefikunifidns"}},
+	})
+
+	// A controller that keeps created records, answering slowly, so two
+	// devices checking the same record at once would both create it
+	var mu sync.Mutex
+	var entries []DNSEntry
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/auth/login":
+			w.Header().Set("X-Csrf-Token", "test-csrf-token")
+		case r.Method == http.MethodGet && r.URL.Path == "/proxy/network/v2/api/site/default/static-dns":
+			time.Sleep(50 * time.Millisecond)
+			mu.Lock()
+			defer mu.Unlock()
+			if err := json.NewEncoder(w).Encode(entries); err != nil {
+				t.Errorf("Failed to encode DNS entries: %v", err)
+			}
+		case r.Method == http.MethodPost && r.URL.Path == "/proxy/network/v2/api/site/default/static-dns":
+			var entry DNSEntry
+			if err := json.NewDecoder(r.Body).Decode(&entry); err != nil {
+				t.Errorf("Failed to decode DNS request body: %v", err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			entry.ID = fmt.Sprintf("%d", len(entries)+1)
+			entries = append(entries, entry)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	// Both devices match app.lan, on the same controller
+	config := CreateConfig()
+	config.TraefikAPIURL = traefikServer.URL
+	config.Devices = []UnifiDeviceConfig{
+		{Host: server.URL, Username: "admin", Password: "password", Pattern: `\.lan$`},
+		{Host: server.URL, Username: "admin", Password: "password", Pattern: `^app\.`},
+	}
+	config.IPOverrides = map[string]string{"app.lan": "192.168.1.10"}
+	r, err := newReconciler(config)
+	require.NoError(t, err)
+	require.Same(t, r.providers["device-0"], r.providers["device-1"])
+
+	result := r.sync(context.Background())
+	require.NoError(t, result.Err)
+	assert.Len(t, entries, 1, "the record is created once")
+	assert.Equal(t, 1, result.Counts.Created)
+	assert.Equal(t, 1, result.Counts.Unchanged)
+}
+
+func TestNewInvalidMaxParallelDevices(t *testing.T) {
+	config := CreateConfig()
+	config.MaxParallelDevices = -1
+	config.Devices = []UnifiDeviceConfig{{WebhookURL: "http://localhost:8080", Pattern: `\.lan$`}}
+
+	_, err := New(context.Background(), nil, config, "test")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid maxParallelDevices")
+}
@@ -0,0 +1,93 @@
+package traefikunifidns
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Default API paths of UniFi OS consoles. "{site}" is replaced with the site
+// of the device.
+const (
+	defaultLoginPath     = "/api/auth/login"
+	defaultStaticDNSPath = "/proxy/network/v2/api/site/{site}/static-dns"
+	defaultHealthPath    = "/proxy/network/api/s/{site}/stat/health"
+	defaultSitesPath     = "/proxy/network/api/self/sites"
+	defaultSite          = "default"
+)
+
+// APIPaths overrides the API paths of a controller, for consoles behind
+// proxies that rewrite paths. Paths may contain "{site}", which is replaced
+// with the site of the device.
+type APIPaths struct {
+	Login     string `json:"login,omitempty"`
+	StaticDNS string `json:"staticDns,omitempty"` // Records are created below it and updated at <staticDns>/<id>
+	Health    string `json:"health,omitempty"`
+	Sites     string `json:"sites,omitempty"` // Lists the sites, to check the site of the device
+}
+
+// apiPaths holds the expanded API paths of a client.
+type apiPaths struct {
+	login     string
+	staticDNS string
+	health    string
+	sites     string
+}
+
+var pathPlaceholder = regexp.MustCompile(`\{[^}]*\}`)
+
+// expandAPIPaths applies the defaults to the paths configured for a device and
+// replaces the placeholders.
+func expandAPIPaths(paths APIPaths, site string) (apiPaths, error) {
+	expand := func(name, path, fallback string) (string, error) {
+		if path == "" {
+			path = fallback
+		}
+		if !strings.HasPrefix(path, "/") {
+			return "", fmt.Errorf("%s path %q must start with /", name, path)
+		}
+		path = strings.ReplaceAll(path, "{site}", site)
+		if placeholder := pathPlaceholder.FindString(path); placeholder != "" {
+			return "", fmt.Errorf("%s path %q has unknown placeholder %s", name, path, placeholder)
+		}
+		return strings.TrimSuffix(path, "/"), nil
+	}
+
+	var expanded apiPaths
+	var err error
+	if expanded.login, err = expand("login", paths.Login, defaultLoginPath); err != nil {
+		return apiPaths{}, err
+	}
+	if expanded.staticDNS, err = expand("staticDns", paths.StaticDNS, defaultStaticDNSPath); err != nil {
+		return apiPaths{}, err
+	}
+	if expanded.health, err = expand("health", paths.Health, defaultHealthPath); err != nil {
+		return apiPaths{}, err
+	}
+	if expanded.sites, err = expand("sites", paths.Sites, defaultSitesPath); err != nil {
+		return apiPaths{}, err
+	}
+	return expanded, nil
+}
+
+// setAPIPaths replaces the default API paths. It must be called before
+// setReadOnly.
+func (c *UniFiClient) setAPIPaths(paths apiPaths) {
+	c.paths = paths
+}
+
+// isLoginRequest reports whether req logs in at loginPath, also when the
+// controller is reached below a base URL with a path, e.g. behind a proxy.
+func isLoginRequest(req *http.Request, loginPath string) bool {
+	return req.Method == http.MethodPost && strings.HasSuffix(req.URL.Path, loginPath)
+}
+
+// apiPaths returns the API paths of the client, the defaults if none were set.
+func (c *UniFiClient) apiPaths() apiPaths {
+	if c.paths == (apiPaths{}) {
+		paths, _ := expandAPIPaths(APIPaths{}, defaultSite)
+		return paths
+	}
+	return c.paths
+}
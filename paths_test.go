@@ -0,0 +1,85 @@
+package traefikunifidns
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpandAPIPaths(t *testing.T) {
+	paths, err := expandAPIPaths(APIPaths{}, defaultSite)
+	require.NoError(t, err)
+	assert.Equal(t, apiPaths{
+		login:     "/api/auth/login",
+		staticDNS: "/proxy/network/v2/api/site/default/static-dns",
+		health:    "/proxy/network/api/s/default/stat/health",
+		sites:     "/proxy/network/api/self/sites",
+	}, paths)
+
+	paths, err = expandAPIPaths(APIPaths{Login: "/unifi/api/auth/login", StaticDNS: "/unifi/dns/{site}/"}, "lab")
+	require.NoError(t, err)
+	assert.Equal(t, "/unifi/api/auth/login", paths.login)
+	assert.Equal(t, "/unifi/dns/lab", paths.staticDNS)
+	assert.Equal(t, "/proxy/network/api/s/lab/stat/health", paths.health)
+
+	_, err = expandAPIPaths(APIPaths{Login: "api/auth/login"}, defaultSite)
+	assert.EqualError(t, err, `login path "api/auth/login" must start with /`)
+	_, err = expandAPIPaths(APIPaths{Health: "/s/{sites}/health"}, defaultSite)
+	assert.EqualError(t, err, `health path "/s/{sites}/health" has unknown placeholder {sites}`)
+}
+
+func TestClientAPIPaths(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		switch r.URL.Path {
+		case "/unifi/login":
+			w.Header().Set("X-Csrf-Token", "token")
+		case "/unifi/dns":
+			_ = json.NewEncoder(w).Encode([]DNSEntry{{ID: "1", Key: "app.lan", Value: "192.168.1.9"}})
+		case "/unifi/dns/1":
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	paths, err := expandAPIPaths(APIPaths{Login: "/unifi/login", StaticDNS: "/unifi/dns"}, defaultSite)
+	require.NoError(t, err)
+	client := NewUniFiClient(server.URL, "admin", "password", false)
+	client.setAPIPaths(paths)
+
+	action, err := client.updateDNSRecord(context.Background(), "app.lan", "192.168.1.10", 0)
+	require.NoError(t, err)
+	assert.Equal(t, recordUpdated, action)
+	assert.Equal(t, []string{"POST /unifi/login", "GET /unifi/dns", "PUT /unifi/dns/1"}, requests)
+
+	// Read-only clients still log in at the custom path
+	client = NewUniFiClient(server.URL, "admin", "password", false)
+	client.setAPIPaths(paths)
+	client.setReadOnly()
+	_, err = client.updateDNSRecord(context.Background(), "app.lan", "192.168.1.10", 0)
+	assert.True(t, errors.Is(err, errReadOnly))
+	assert.Equal(t, "POST /unifi/login", requests[3])
+
+	// Also below a base URL with a path
+	requests = nil
+	client = NewUniFiClient(server.URL+"/unifi", "admin", "password", false)
+	client.setReadOnly()
+	_, _ = client.updateDNSRecord(context.Background(), "app.lan", "192.168.1.10", 0)
+	require.NotEmpty(t, requests)
+	assert.Equal(t, "POST /unifi/api/auth/login", requests[0])
+}
+
+func TestNewInvalidAPIPaths(t *testing.T) {
+	config := CreateConfig()
+	config.Devices = []UnifiDeviceConfig{{Host: "unifi.lan", Pattern: `\.lan$`, APIPaths: APIPaths{StaticDNS: "static-dns"}}}
+	_, err := New(context.Background(), nil, config, "test")
+	assert.EqualError(t, err, `invalid apiPaths for device 0: staticDns path "static-dns" must start with /`)
+}
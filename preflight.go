@@ -0,0 +1,72 @@
+package traefikunifidns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// preflightRecord is created and deleted again to verify write access. The
+// .invalid TLD is reserved, so it cannot clash with a real record.
+const preflightRecord = "traefikunifidns-preflight.invalid"
+
+// checkWriteAccess verifies that the account may write static DNS entries by
+// creating and deleting a sentinel record.
+func (c *UniFiClient) checkWriteAccess(ctx context.Context) error {
+	if !c.hasSession() {
+		if _, err := c.session(ctx); err != nil {
+			return err
+		}
+	}
+	if err := c.saveDNSEntry(ctx, "", aRecordPayload(preflightRecord, "127.0.0.1", 0)); err != nil {
+		return err
+	}
+
+	entries, err := c.GetStaticDNSEntries(ctx)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Key == preflightRecord {
+			if err := c.deleteDNSEntry(ctx, entry.ID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// preflight checks the write access of every UniFi device that will be
+// written to. Rejected credentials or missing permissions fail startup with an
+// actionable error, all other failures are left to the update cycles.
+func (r *reconciler) preflight(ctx context.Context) error {
+	for i, device := range r.config.Devices {
+		client, ok := r.providers[fmt.Sprintf("device-%d", i)].(*UniFiClient)
+		if !ok || device.ReadOnly || device.DryRun {
+			continue
+		}
+
+		credentials := client.auth()
+		log.Printf("INFO: Checking write access of %s on %s", credentials, client)
+		err := client.checkWriteAccess(ctx)
+		if err == nil {
+			log.Printf("INFO: Preflight: %s may write static DNS on %s", credentials, client)
+			continue
+		}
+
+		var se *statusError
+		if !errors.As(err, &se) || (se.statusCode != http.StatusUnauthorized && se.statusCode != http.StatusForbidden) {
+			log.Printf("WARN: Permission preflight for %s failed, continuing: %v", client, err)
+			continue
+		}
+		if client.hasSession() {
+			log.Printf("ERROR: Preflight: %s lacks Network admin rights on %s: %v", credentials, client, err)
+			return fmt.Errorf("%s lacks Network admin rights on %s: %w", credentials, client, err)
+		}
+		log.Printf("ERROR: Preflight: %s cannot log in to %s: %v", credentials, client, err)
+		return fmt.Errorf("%s cannot log in to %s: %w", credentials, client, err)
+	}
+	return nil
+}
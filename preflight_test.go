@@ -0,0 +1,111 @@
+package traefikunifidns
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestPreflightServer answers logins with loginStatus and static DNS
+// writes with writeStatus, and records the methods of all static DNS
+// requests.
+func newTestPreflightServer(t *testing.T, loginStatus, writeStatus int, methods *[]string) *httptest.Server {
+	t.Helper()
+	var entries []DNSEntry
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/auth/login" {
+			w.Header().Set("X-Csrf-Token", "test-csrf-token")
+			w.WriteHeader(loginStatus)
+			return
+		}
+		*methods = append(*methods, r.Method)
+		switch r.Method {
+		case http.MethodGet:
+			_ = json.NewEncoder(w).Encode(entries)
+			return
+		case http.MethodPost:
+			var entry DNSEntry
+			_ = json.NewDecoder(r.Body).Decode(&entry)
+			entry.ID = "sentinel"
+			entries = append(entries, entry)
+		case http.MethodDelete:
+			entries = nil
+		}
+		w.WriteHeader(writeStatus)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestPreflight(t *testing.T) {
+	tests := []struct {
+		name        string
+		loginStatus int
+		writeStatus int
+		methods     []string
+		wantErr     string
+	}{
+		{name: "write access", loginStatus: http.StatusOK, writeStatus: http.StatusOK, methods: []string{"POST", "GET", "DELETE"}},
+		{name: "missing rights", loginStatus: http.StatusOK, writeStatus: http.StatusForbidden, methods: []string{"POST"}, wantErr: "account viewer lacks Network admin rights on "},
+		{name: "rejected credentials", loginStatus: http.StatusUnauthorized, wantErr: "account viewer cannot log in to "},
+		{name: "server error", loginStatus: http.StatusOK, writeStatus: http.StatusInternalServerError, methods: []string{"POST"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var methods []string
+			server := newTestPreflightServer(t, tt.loginStatus, tt.writeStatus, &methods)
+			r := &reconciler{
+				config:    CreateConfig(),
+				providers: map[string]dnsProvider{"device-0": NewUniFiClient(server.URL, "viewer", "password", false)},
+			}
+			r.config.Devices = []UnifiDeviceConfig{{Host: server.URL, Username: "viewer"}}
+
+			err := r.preflight(context.Background())
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.methods, methods)
+		})
+	}
+}
+
+func TestPreflightSkipsReadOnlyDevices(t *testing.T) {
+	var methods []string
+	server := newTestPreflightServer(t, http.StatusOK, http.StatusForbidden, &methods)
+	r := &reconciler{
+		config:    CreateConfig(),
+		providers: map[string]dnsProvider{"device-0": NewUniFiClient(server.URL, "viewer", "password", false)},
+	}
+	r.config.Devices = []UnifiDeviceConfig{{Host: server.URL, Username: "viewer", ReadOnly: true}}
+
+	assert.NoError(t, r.preflight(context.Background()))
+	assert.Empty(t, methods)
+}
+
+func TestNewPermissionPreflight(t *testing.T) {
+	var methods []string
+	server := newTestPreflightServer(t, http.StatusOK, http.StatusForbidden, &methods)
+
+	config := CreateConfig()
+	config.PermissionPreflight = true
+	config.Devices = []UnifiDeviceConfig{{Host: server.URL, Username: "viewer", Password: "password", Pattern: ".*"}}
+
+	_, err := New(context.Background(), nil, config, "test")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "account viewer lacks Network admin rights")
+
+	key, err := configKey(config)
+	require.NoError(t, err)
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	assert.NotContains(t, reconcilers, key, "the reconciler is released again")
+}
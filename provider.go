@@ -0,0 +1,84 @@
+package traefikunifidns
+
+import "context"
+
+// dnsProvider is a backend the records of a device are published to. The
+// UniFi controller client is the primary implementation.
+type dnsProvider interface {
+	// updateDNSRecord creates or updates the A record for hostname if it
+	// differs from the desired state, and returns one of the record*
+	// outcomes.
+	updateDNSRecord(ctx context.Context, hostname, ip string, ttl int) (string, error)
+	// health returns the stats used for the circuit breaker and status.
+	health() *deviceStats
+	// String describes the provider in log messages.
+	String() string
+}
+
+// Outcomes of updateDNSRecord.
+const (
+	recordCreated   = "created"
+	recordUpdated   = "updated"
+	recordUnchanged = "unchanged"
+)
+
+// API endpoints the request histograms are broken down by.
+const (
+	endpointLogin  = "login"
+	endpointList   = "list"
+	endpointCreate = "create"
+	endpointUpdate = "update"
+	endpointDelete = "delete"
+	endpointHealth = "health"
+	endpointSites  = "sites"
+)
+
+// aaaaRecordProvider is implemented by providers that can publish AAAA
+// records next to the A record of a hostname.
+type aaaaRecordProvider interface {
+	updateAAAARecord(ctx context.Context, hostname, ip string, ttl int) (string, error)
+}
+
+// multiRecordProvider is implemented by providers that can publish several A
+// records for the same hostname.
+type multiRecordProvider interface {
+	// updateDNSRecords maintains exactly one A record per address in ips for
+	// hostname, deleting the records of other addresses stale reports.
+	updateDNSRecords(ctx context.Context, hostname string, ips []string, ttl int, stale func(ip string) bool) (string, error)
+}
+
+// pruningProvider is implemented by providers that can delete the stale A
+// records left next to the single A record of a hostname, such as those of
+// nodes removed from targetIPs.
+type pruningProvider interface {
+	// updateDNSRecordPruning is updateDNSRecord that also deletes the further
+	// A records of hostname whose address stale reports.
+	updateDNSRecordPruning(ctx context.Context, hostname, ip string, ttl int, stale func(ip string) bool) (string, error)
+	// plannedPruningAction is plannedAction for updateDNSRecordPruning.
+	plannedPruningAction(ctx context.Context, hostname, ip string, ttl int, stale func(ip string) bool) (string, error)
+}
+
+// planner is implemented by providers that can tell which outcome
+// updateDNSRecord would have without changing anything, for dry runs.
+type planner interface {
+	plannedAction(ctx context.Context, hostname, ip string, ttl int) (string, error)
+}
+
+// pruner is implemented by providers that remove records for hostnames that
+// are no longer published. It is called at the end of every complete cycle
+// with the hostnames published to the provider during that cycle, and returns
+// the hostnames it removed, also when it fails part way. When more than limit
+// records are stale it removes none and returns errChangeQuota; a negative
+// limit removes any number. orphans lists the records prune would remove.
+type pruner interface {
+	orphans(active map[string]bool) []string
+	prune(ctx context.Context, active map[string]bool, limit int) ([]string, error)
+}
+
+func (c *UniFiClient) health() *deviceStats {
+	return &c.stats
+}
+
+func (c *UniFiClient) String() string {
+	return c.baseURL
+}
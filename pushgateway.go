@@ -0,0 +1,60 @@
+package traefikunifidns
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const defaultPushgatewayJob = "traefikunifidns"
+
+// pushgateway pushes the sync metrics to a Prometheus Pushgateway after every
+// update cycle, for setups where the metrics endpoint can't be scraped.
+type pushgateway struct {
+	client *http.Client
+	url    string
+}
+
+func newPushgateway(baseURL, job string) *pushgateway {
+	if job == "" {
+		job = defaultPushgatewayJob
+	}
+	pushURL := fmt.Sprintf("%s/metrics/job/%s", strings.TrimSuffix(baseURL, "/"), url.PathEscape(job))
+	log.Printf("INFO: Pushing metrics to Pushgateway at: %s", pushURL)
+	return &pushgateway{
+		client: &http.Client{Timeout: 10 * time.Second},
+		url:    pushURL,
+	}
+}
+
+// push replaces all metrics of the job with metrics, which must be in the
+// Prometheus text format.
+func (p *pushgateway) push(metrics string) error {
+	req, err := http.NewRequest(http.MethodPut, p.url, strings.NewReader(metrics))
+	if err != nil {
+		return fmt.Errorf("failed to create Pushgateway request: %w", err)
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to push metrics: %w", err)
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			log.Printf("ERROR: Failed to close response body: %v", closeErr)
+		}
+	}()
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return fmt.Errorf("failed to read Pushgateway response: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("pushing metrics failed with status: %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package traefikunifidns
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPushgatewayPush(t *testing.T) {
+	var method, path, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, path = r.Method, r.URL.EscapedPath()
+		data, _ := io.ReadAll(r.Body)
+		body = string(data)
+		if r.URL.Path == "/broken/metrics/job/traefikunifidns" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	p := newPushgateway(server.URL+"/", "home lab")
+	require.NoError(t, p.push("unifidns_stale 0\n"))
+	assert.Equal(t, http.MethodPut, method)
+	assert.Equal(t, "/metrics/job/home%20lab", path)
+	assert.Equal(t, "unifidns_stale 0\n", body)
+
+	err := newPushgateway(server.URL+"/broken", "").push("")
+	assert.EqualError(t, err, "pushing metrics failed with status: 400")
+}
+
+func TestSyncPushesMetrics(t *testing.T) {
+	var pushed string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		pushed = string(data)
+	}))
+	defer server.Close()
+
+	r := &reconciler{
+		config:        CreateConfig(),
+		traefikClient: NewTraefikClient("http://invalid-url-that-will-fail:12345", false),
+		pushgateway:   newPushgateway(server.URL, ""),
+	}
+	require.Error(t, r.sync(context.Background()).Err)
+	assert.Contains(t, pushed, "unifidns_sync_cycles_total 1\n")
+	assert.Contains(t, pushed, "unifidns_sync_failures_total 1\n")
+}
@@ -0,0 +1,74 @@
+package traefikunifidns
+
+import (
+	"errors"
+	"fmt"
+)
+
+// errChangeQuota aborts a cycle that would change more records than
+// maxChangesPerCycle allows.
+var errChangeQuota = errors.New("change quota exceeded")
+
+// reasonChangeQuota is reported for hostnames left alone because the change
+// quota of the cycle was used up.
+const reasonChangeQuota = "change quota exceeded"
+
+// pruneLimit returns how many records pruning may still delete in this
+// cycle, or -1 without a quota. It expects r.mu to be held.
+func (r *reconciler) pruneLimit() int {
+	if r.config.MaxChangesPerCycle == 0 {
+		return -1
+	}
+	return r.config.MaxChangesPerCycle - r.changes
+}
+
+// reserveChange reports whether hostname may be published, reserving one
+// change of the quota for it. Once the quota of the cycle is used up, only
+// records unchanged reports as already matching are still checked, without a
+// reservation; anything else marks the quota as exceeded, which aborts the
+// cycle. Safe for the workers of a cycle updating devices in parallel.
+func (r *reconciler) reserveChange(hostname string, unchanged func() bool) (reserved, ok bool) {
+	if r.config.MaxChangesPerCycle == 0 {
+		return false, true
+	}
+	r.quotaMu.Lock()
+	if r.changes < r.config.MaxChangesPerCycle {
+		r.changes++
+		r.quotaMu.Unlock()
+		return true, true
+	}
+	r.quotaMu.Unlock()
+	if unchanged() {
+		return false, true
+	}
+	r.quotaMu.Lock()
+	defer r.quotaMu.Unlock()
+	if !r.quotaExceeded {
+		logError("ALERT: Aborting DNS update cycle after %d changes: %s would change too, exceeding maxChangesPerCycle", r.changes, hostname)
+		r.quotaExceeded = true
+	}
+	return false, false
+}
+
+// countChange settles the change quota with the outcome of a hostname,
+// returning the reservation of a record that didn't change.
+func (r *reconciler) countChange(result hostResult) {
+	r.quotaMu.Lock()
+	defer r.quotaMu.Unlock()
+	if result.changed() && !result.reserved {
+		r.changes++
+	} else if !result.changed() && result.reserved {
+		r.changes--
+	}
+}
+
+// quotaError returns errChangeQuota once a hostname exceeded the quota of
+// the running cycle.
+func (r *reconciler) quotaError() error {
+	r.quotaMu.Lock()
+	defer r.quotaMu.Unlock()
+	if !r.quotaExceeded {
+		return nil
+	}
+	return fmt.Errorf("%w: more than %d changes in one cycle", errChangeQuota, r.config.MaxChangesPerCycle)
+}
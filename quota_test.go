@@ -0,0 +1,69 @@
+package traefikunifidns
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaxChangesPerCycle(t *testing.T) {
+	rules := []string{"Host(`app.lan`)", "Host(`nas.lan`)"}
+	traefikServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		routers := make([]map[string]interface{}, 0, len(rules))
+		for _, rule := range rules {
+			routers = append(routers, map[string]interface{}{"rule": rule, "middlewares": []string{"traefikunifidns"}})
+		}
+		_ = json.NewEncoder(w).Encode(routers)
+	}))
+	defer traefikServer.Close()
+	var changes []webhookChange
+	webhookServer := newTestWebhookServer(t, &changes)
+
+	config := CreateConfig()
+	config.TraefikAPIURL = traefikServer.URL
+	config.Devices = []UnifiDeviceConfig{{WebhookURL: webhookServer.URL, Pattern: `\.lan$`}}
+	config.IPOverrides = map[string]string{"app.lan": "192.168.1.10", "nas.lan": "192.168.1.20", "new.lan": "192.168.1.30", "web.lan": "192.168.1.40"}
+	config.MaxChangesPerCycle = 2
+
+	plugin, err := New(context.Background(), nil, config, "test")
+	require.NoError(t, err)
+	u := plugin.(*UniFiDNS)
+	require.Len(t, changes, 2, "two creations fit the quota")
+
+	// Unchanged records do not count against the quota
+	require.NoError(t, u.updateDNS(context.Background()).Err)
+
+	// A third change aborts the cycle before it is made
+	rules = append(rules, "Host(`new.lan`)", "Host(`web.lan`)")
+	config.IPOverrides["app.lan"] = "192.168.1.11"
+	result := u.updateDNS(context.Background())
+	require.Error(t, result.Err)
+	assert.True(t, errors.Is(result.Err, errChangeQuota))
+	assert.Len(t, changes, 4, "only two more writes")
+
+	// Mass deletion is refused as a whole
+	config.MaxChangesPerCycle = 1
+	rules = nil
+	result = u.updateDNS(context.Background())
+	require.Error(t, result.Err)
+	assert.True(t, errors.Is(result.Err, errChangeQuota))
+	for _, change := range changes[4:] {
+		assert.NotEqual(t, "delete", change.Action)
+	}
+}
+
+func TestNewInvalidMaxChangesPerCycle(t *testing.T) {
+	config := CreateConfig()
+	config.MaxChangesPerCycle = -1
+	config.Devices = []UnifiDeviceConfig{{WebhookURL: "http://localhost:8080", Pattern: `\.lan$`}}
+
+	_, err := New(context.Background(), nil, config, "test")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid maxChangesPerCycle")
+}
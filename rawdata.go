@@ -0,0 +1,120 @@
+package traefikunifidns
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+)
+
+// traefikRawData is the part of /api/rawdata the plugin reads: the HTTP and
+// TCP routers and the HTTP services, all from a single request.
+type traefikRawData struct {
+	routers    []TraefikRouter
+	tcpRouters []TraefikRouter
+	services   []TraefikService
+}
+
+// setRawData makes the client read routers and services from /api/rawdata
+// instead of their own endpoints. The response is kept until resetRawData,
+// so a cycle sends a single request however many of them it reads.
+func (c *TraefikClient) setRawData() {
+	c.rawData = true
+}
+
+// resetRawData drops the kept /api/rawdata response, so the next read
+// fetches the current configuration. Reconcilers call it when a cycle
+// starts.
+func (c *TraefikClient) resetRawData() {
+	c.rawMu.Lock()
+	defer c.rawMu.Unlock()
+	c.raw = nil
+}
+
+// cachedRawData returns the kept /api/rawdata response, fetching it first
+// when there is none.
+func (c *TraefikClient) cachedRawData(ctx context.Context) (*traefikRawData, error) {
+	c.rawMu.Lock()
+	defer c.rawMu.Unlock()
+	if c.raw != nil {
+		return c.raw, nil
+	}
+	raw, err := c.fetchRawData(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.raw = raw
+	return raw, nil
+}
+
+// fetchRawData reads the routers and services from /api/rawdata. Objects
+// there are keyed by name, so routers and services are sorted by name.
+func (c *TraefikClient) fetchRawData(ctx context.Context) (*traefikRawData, error) {
+	url := fmt.Sprintf("%s/api/rawdata", c.baseURL)
+	log.Printf("INFO: Fetching raw configuration from Traefik API: %s", url)
+
+	resp, err := c.get(ctx, endpointRawData, url)
+	if err != nil {
+		logError("Failed to get raw configuration from Traefik API: %v", err)
+		return nil, fmt.Errorf("failed to get raw configuration: %w", err)
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			logError("Failed to close response body: %v", closeErr)
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		logError("Traefik API returned non-OK status code: %d", resp.StatusCode)
+		return nil, newStatusError(resp.StatusCode, "failed to get raw configuration: status code %d")
+	}
+
+	var payload struct {
+		Routers    map[string]map[string]interface{} `json:"routers"`
+		TCPRouters map[string]map[string]interface{} `json:"tcpRouters"`
+		Services   map[string]rawService             `json:"services"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		logError("Failed to decode raw configuration: %v", err)
+		return nil, fmt.Errorf("failed to decode raw configuration: %w", err)
+	}
+
+	raw := &traefikRawData{
+		routers:    rawRouters(payload.Routers, "http"),
+		tcpRouters: rawRouters(payload.TCPRouters, "tcp"),
+	}
+	names := make([]string, 0, len(payload.Services))
+	for name := range payload.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		raw.services = append(raw.services, payload.Services[name].service(name))
+	}
+	log.Printf("INFO: Successfully retrieved %d routers, %d TCP routers and %d services from Traefik API",
+		len(raw.routers), len(raw.tcpRouters), len(raw.services))
+	return raw, nil
+}
+
+// rawRouters converts the routers of protocol keyed by name.
+func rawRouters(byName map[string]map[string]interface{}, protocol string) []TraefikRouter {
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var routers []TraefikRouter
+	for _, name := range names {
+		raw := byName[name]
+		if _, ok := raw["name"]; !ok {
+			raw["name"] = name
+		}
+		if router, ok := parseRouter(raw, protocol); ok {
+			routers = append(routers, router)
+		}
+	}
+	return routers
+}
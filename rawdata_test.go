@@ -0,0 +1,111 @@
+package traefikunifidns
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testRawData = `{
+	"routers": {
+		"web@docker": {"rule": "Host(` + "`web.lan`" + `)", "service": "web", "middlewares": ["traefikunifidns@file"], "entryPoints": ["websecure"],
+			"tls": {"domains": [{"main": "lan", "sans": ["*.lan"]}]}, "status": "enabled"},
+		"app@docker": {"rule": "Host(` + "`app.lan`" + `)", "service": "app", "middlewares": ["traefikunifidns@file"]},
+		"other@docker": {"rule": "Host(` + "`other.lan`" + `)", "service": "other"}
+	},
+	"tcpRouters": {
+		"postgres@docker": {"rule": "HostSNI(` + "`db.lan`" + `)", "service": "postgres"}
+	},
+	"services": {
+		"web@docker": {"loadBalancer": {"servers": [{"url": "http://10.0.0.5:80"}]}},
+		"app@docker": {"loadBalancer": {"servers": []}}
+	}
+}`
+
+// newTestRawDataServer serves testRawData and counts its requests.
+func newTestRawDataServer(t *testing.T, requests *int32) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/rawdata" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		atomic.AddInt32(requests, 1)
+		_, _ = w.Write([]byte(testRawData))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestRawData(t *testing.T) {
+	var requests int32
+	server := newTestRawDataServer(t, &requests)
+	client := NewTraefikClient(server.URL, false)
+	client.setRawData()
+
+	routers, err := client.GetRouters(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []TraefikRouter{
+		{Name: "app@docker", Rule: "Host(`app.lan`)", Service: "app", Middlewares: []string{"traefikunifidns@file"}},
+		{Name: "web@docker", Rule: "Host(`web.lan`)", Service: "web", Middlewares: []string{"traefikunifidns@file"},
+			EntryPoints: []string{"websecure"}},
+	}, routers)
+
+	tcpRouters, err := client.GetTCPRouters(context.Background())
+	require.NoError(t, err)
+	require.Len(t, tcpRouters, 1)
+	assert.Equal(t, "postgres@docker", tcpRouters[0].Name)
+
+	services, err := client.GetServices(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []TraefikService{
+		{Name: "app@docker"},
+		{Name: "web@docker", Servers: []string{"http://10.0.0.5:80"}},
+	}, services)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests), "a single request serves every read")
+
+	client.resetRawData()
+	_, err = client.GetRouters(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests), "reset fetches the current configuration")
+}
+
+func TestRawDataError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+	client := NewTraefikClient(server.URL, false)
+	client.setRawData()
+
+	_, err := client.GetRouters(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to get raw configuration")
+}
+
+func TestSyncRawData(t *testing.T) {
+	var requests int32
+	server := newTestRawDataServer(t, &requests)
+	var changes []webhookChange
+	webhookServer := newTestWebhookServer(t, &changes)
+
+	config := CreateConfig()
+	config.TraefikAPIURL = server.URL
+	config.TraefikRawData = true
+	config.TCPRouters = true
+	config.SRVRecords = true
+	config.Devices = []UnifiDeviceConfig{{WebhookURL: webhookServer.URL, Pattern: `\.lan$`}}
+	config.TargetIPs = []string{"192.168.1.10"}
+	r, err := newReconciler(config)
+	require.NoError(t, err)
+
+	result := r.sync(context.Background())
+	require.NoError(t, result.Err)
+	assert.Equal(t, 3, result.Counts.Created, "app.lan, web.lan and db.lan")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests), "routers, TCP routers and services are read with one request")
+}
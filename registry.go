@@ -0,0 +1,215 @@
+package traefikunifidns
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Traefik creates one plugin instance per router using the middleware, and
+// again on every configuration reload. The registry makes sure only a single
+// reconciler, and thus a single update loop, runs per unique configuration.
+//
+// The same applies to UniFi clients: reconcilers talking to the same
+// controller with the same credentials share one authenticated client instead
+// of opening parallel sessions the console may evict.
+var (
+	registryMu   sync.Mutex
+	reconcilers  = make(map[string]*reconciler)
+	clientPool   = make(map[string]*pooledClient)
+	rateLimiters = make(map[string]*rateLimiter) // By controller and maxRequestsPerSecond
+)
+
+type pooledClient struct {
+	client    *UniFiClient
+	refs      int
+	rateLimit string // Key of the shared rate limiter, empty if none
+}
+
+// configKey returns a stable hash of config.
+func configKey(config *Config) (string, error) {
+	// Key the migrated shape, so the key doesn't change once newReconciler
+	// migrated config in place. Invalid configurations are reported there.
+	normalized := *config
+	normalized.Devices = append([]UnifiDeviceConfig(nil), config.Devices...)
+	_, _ = migrateConfig(&normalized)
+	data, err := json.Marshal(normalized)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal config: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// acquireReconciler returns the reconciler registered for config, creating
+// and registering a new one if none exists. created reports whether the
+// caller is responsible for starting it.
+func acquireReconciler(config *Config) (r *reconciler, created bool, err error) {
+	key, err := configKey(config)
+	if err != nil {
+		return nil, false, err
+	}
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if r, ok := reconcilers[key]; ok {
+		r.refs++
+		return r, false, nil
+	}
+
+	r, err = newReconciler(config)
+	if err != nil {
+		return nil, false, err
+	}
+	r.key = key
+	r.refs = 1
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+	reconcilers[key] = r
+	return r, true, nil
+}
+
+// releaseReconciler drops a reference to r and stops its update loop once no
+// plugin instance uses it anymore.
+func releaseReconciler(r *reconciler) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	r.refs--
+	if r.refs > 0 {
+		return
+	}
+	log.Printf("INFO: Last plugin instance for configuration released, stopping its update loop")
+	r.cancel()
+	if reconcilers[r.key] == r {
+		delete(reconcilers, r.key)
+	}
+	for _, key := range r.clientKeys {
+		releaseUniFiClient(key)
+	}
+}
+
+// clientKey returns a hash identifying a controller and the credentials, TLS
+// settings, access mode, headers, gateway tokens, authentication method, API
+// paths, request limits and retry policy used to connect to it.
+func clientKey(device UnifiDeviceConfig, insecureSkipVerify bool, maxRequestsPerSecond int) string {
+	// Hashed field by field, so the password never passes through fmt
+	h := sha256.New()
+	fields := []string{device.Host, device.Username, device.Password, strconv.FormatBool(insecureSkipVerify), device.TLSServerName, strconv.FormatBool(device.ReadOnly), device.UserAgent,
+		strconv.Itoa(device.MaxConcurrentRequests), strconv.Itoa(maxRequestsPerSecond), device.ClientCertFile, device.ClientKeyFile, device.CACert, device.CACertFile}
+	names := make([]string, 0, len(device.Headers))
+	for name := range device.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fields = append(fields, name, device.Headers[name])
+	}
+	names = names[:0]
+	for name := range device.ExtraCookies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fields = append(fields, name, device.ExtraCookies[name])
+	}
+	fields = append(fields, device.ProxyAuthHeader, device.AuthMethod, device.Token, device.APIKey,
+		device.APIPaths.Login, device.APIPaths.StaticDNS, device.APIPaths.Health, device.APIPaths.Sites, device.Site,
+		strconv.Itoa(device.MaxRetries), device.InitialBackoff, device.MaxBackoff)
+	for _, field := range fields {
+		h.Write([]byte(field))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// acquireUniFiClient returns the pooled client for device, creating it if
+// needed, together with the key to release it with. Clients with the same
+// maxRequestsPerSecond share one rate limiter. It expects registryMu to be
+// held.
+func acquireUniFiClient(device UnifiDeviceConfig, insecureSkipVerify bool, maxRequestsPerSecond int) (*UniFiClient, string) {
+	key := clientKey(device, insecureSkipVerify, maxRequestsPerSecond)
+	if pooled, ok := clientPool[key]; ok {
+		pooled.refs++
+		log.Printf("INFO: Reusing UniFi client for host: %s", device.Host)
+		return pooled.client, key
+	}
+
+	client := NewUniFiClient(device.Host, device.Username, device.Password, insecureSkipVerify)
+	if device.AllowInsecureHTTP && strings.HasPrefix(device.Host, "http://") {
+		log.Printf("WARN: INSECURE: Credentials and session cookies for %s are sent over plain HTTP, only use this behind a trusted proxy", device.Host)
+	}
+	client.setAuth(newAuthStrategy(device))
+	if device.TLSServerName != "" {
+		client.setTLSServerName(device.TLSServerName)
+	}
+	if cert, err := loadClientCertificate(device.ClientCertFile, device.ClientKeyFile); err == nil && cert != nil {
+		client.setClientCertificate(*cert)
+	}
+	if pool, err := loadCACertPool(device.CACert, device.CACertFile); err == nil && pool != nil {
+		client.setRootCAs(pool)
+	}
+	if device.UserAgent != "" || len(device.Headers) > 0 {
+		client.setHeaders(device.UserAgent, device.Headers)
+	}
+	if len(device.ExtraCookies) > 0 || device.ProxyAuthHeader != "" {
+		client.setProxyAuth(device.ExtraCookies, device.ProxyAuthHeader)
+	}
+	// Clients of the same controller, e.g. with other credentials, share the
+	// request budget of the controller
+	var limiter *rateLimiter
+	var limiterKey string
+	if maxRequestsPerSecond > 0 {
+		limiterKey = device.Host + " " + strconv.Itoa(maxRequestsPerSecond)
+		limiter = rateLimiters[limiterKey]
+		if limiter == nil {
+			limiter = newRateLimiter(maxRequestsPerSecond)
+			rateLimiters[limiterKey] = limiter
+		}
+		limiter.refs++
+	}
+	if device.MaxConcurrentRequests > 0 || limiter != nil {
+		client.setLimits(device.MaxConcurrentRequests, limiter)
+	}
+	if policy, err := newRetryPolicy(device); err == nil && policy.maxRetries > 0 {
+		client.setRetryPolicy(policy)
+	}
+	if paths, err := expandAPIPaths(device.APIPaths, defaultSite); err == nil {
+		client.setAPIPaths(paths)
+	}
+	if device.Site != "" {
+		client.setSite(device.Site, device.APIPaths)
+	}
+	if device.ReadOnly {
+		client.setReadOnly()
+	}
+	clientPool[key] = &pooledClient{client: client, refs: 1, rateLimit: limiterKey}
+	return client, key
+}
+
+// releaseUniFiClient drops a reference to a pooled client. It expects
+// registryMu to be held.
+func releaseUniFiClient(key string) {
+	pooled, ok := clientPool[key]
+	if !ok {
+		return
+	}
+	pooled.refs--
+	if pooled.refs > 0 {
+		return
+	}
+	delete(clientPool, key)
+	if limiter, ok := rateLimiters[pooled.rateLimit]; ok {
+		limiter.refs--
+		if limiter.refs <= 0 {
+			delete(rateLimiters, pooled.rateLimit)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package traefikunifidns
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigKey(t *testing.T) {
+	a := CreateConfig()
+	a.TTLOverrides = map[string]int{"a.lan": 60, "b.lan": 120}
+	b := CreateConfig()
+	b.TTLOverrides = map[string]int{"b.lan": 120, "a.lan": 60}
+
+	keyA, err := configKey(a)
+	require.NoError(t, err)
+	keyB, err := configKey(b)
+	require.NoError(t, err)
+	assert.Equal(t, keyA, keyB)
+
+	b.UpdateInterval = "1m"
+	keyB, err = configKey(b)
+	require.NoError(t, err)
+	assert.NotEqual(t, keyA, keyB)
+}
+
+func TestNewSharesReconciler(t *testing.T) {
+	config := CreateConfig()
+	config.TraefikAPIURL = "http://invalid-url-that-will-fail:12345"
+	config.UpdateInterval = "42m"
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	defer cancel1()
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+
+	plugin1, err := New(ctx1, nil, config, "router1")
+	require.NoError(t, err)
+	plugin2, err := New(ctx2, nil, config, "router2")
+	require.NoError(t, err)
+
+	r := plugin1.(*UniFiDNS).reconciler
+	assert.Same(t, r, plugin2.(*UniFiDNS).reconciler)
+	assert.Equal(t, "router2", plugin2.(*UniFiDNS).name)
+
+	registryMu.Lock()
+	assert.Equal(t, 2, r.refs)
+	registryMu.Unlock()
+
+	// The loop keeps running while any instance is alive
+	cancel1()
+	assert.Eventually(t, func() bool {
+		registryMu.Lock()
+		defer registryMu.Unlock()
+		return r.refs == 1
+	}, time.Second, 10*time.Millisecond)
+	assert.NoError(t, r.ctx.Err())
+
+	cancel2()
+	assert.Eventually(t, func() bool {
+		registryMu.Lock()
+		defer registryMu.Unlock()
+		_, ok := reconcilers[r.key]
+		return !ok
+	}, time.Second, 10*time.Millisecond)
+	assert.Error(t, r.ctx.Err())
+
+	// A new instance afterwards gets a fresh reconciler
+	plugin3, err := New(context.Background(), nil, config, "router3")
+	require.NoError(t, err)
+	assert.NotSame(t, r, plugin3.(*UniFiDNS).reconciler)
+}
+
+func TestNewInvalidConfigNotRegistered(t *testing.T) {
+	config := CreateConfig()
+	config.UpdateInterval = "invalid"
+
+	_, err := New(context.Background(), nil, config, "test")
+	require.Error(t, err)
+
+	key, err := configKey(config)
+	require.NoError(t, err)
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	assert.NotContains(t, reconcilers, key)
+}
+
+func TestNewSharesUniFiClients(t *testing.T) {
+	device := UnifiDeviceConfig{
+		Host:     "pool-test.invalid",
+		Username: "admin",
+		Password: "password",
+		Pattern:  `\.lan$`,
+	}
+
+	configA := CreateConfig()
+	configA.TraefikAPIURL = "http://invalid-url-that-will-fail:12345"
+	configA.Devices = []UnifiDeviceConfig{device}
+	configB := CreateConfig()
+	configB.TraefikAPIURL = "http://invalid-url-that-will-fail:12345"
+	configB.UpdateInterval = "10m"
+	configB.Devices = []UnifiDeviceConfig{device}
+	configC := CreateConfig()
+	configC.TraefikAPIURL = "http://invalid-url-that-will-fail:12345"
+	configC.Devices = []UnifiDeviceConfig{device}
+	configC.Devices[0].Username = "other"
+
+	ctxA, cancelA := context.WithCancel(context.Background())
+	defer cancelA()
+	ctxB, cancelB := context.WithCancel(context.Background())
+	defer cancelB()
+	ctxC, cancelC := context.WithCancel(context.Background())
+	defer cancelC()
+
+	pluginA, err := New(ctxA, nil, configA, "a")
+	require.NoError(t, err)
+	pluginB, err := New(ctxB, nil, configB, "b")
+	require.NoError(t, err)
+	pluginC, err := New(ctxC, nil, configC, "c")
+	require.NoError(t, err)
+
+	clientA := pluginA.(*UniFiDNS).providers["device-0"]
+	assert.NotSame(t, pluginA.(*UniFiDNS).reconciler, pluginB.(*UniFiDNS).reconciler)
+	assert.Same(t, clientA, pluginB.(*UniFiDNS).providers["device-0"])
+	assert.NotSame(t, clientA, pluginC.(*UniFiDNS).providers["device-0"])
+
+	key := clientKey(device, false, 0)
+	poolRefs := func() int {
+		registryMu.Lock()
+		defer registryMu.Unlock()
+		if pooled, ok := clientPool[key]; ok {
+			return pooled.refs
+		}
+		return 0
+	}
+	assert.Equal(t, 2, poolRefs())
+
+	cancelA()
+	assert.Eventually(t, func() bool { return poolRefs() == 1 }, time.Second, 10*time.Millisecond)
+	cancelB()
+	assert.Eventually(t, func() bool { return poolRefs() == 0 }, time.Second, 10*time.Millisecond)
+}
+
+func TestClientKey(t *testing.T) {
+	device := UnifiDeviceConfig{Host: "unifi.lan", Username: "admin", Password: "password"}
+	key := clientKey(device, false, 0)
+	assert.NotEqual(t, key, clientKey(device, true, 0))
+	assert.NotEqual(t, key, clientKey(device, false, 5))
+
+	for _, change := range []func(d *UnifiDeviceConfig){
+		func(d *UnifiDeviceConfig) { d.Password = "other" },
+		func(d *UnifiDeviceConfig) { d.ReadOnly = true },
+		func(d *UnifiDeviceConfig) { d.UserAgent = "curl/8.0" },
+		func(d *UnifiDeviceConfig) { d.Headers = map[string]string{"X-Api": "1"} },
+		func(d *UnifiDeviceConfig) { d.ExtraCookies = map[string]string{"session": "1"} },
+		func(d *UnifiDeviceConfig) { d.ProxyAuthHeader = "Remote-Token: 1" },
+		func(d *UnifiDeviceConfig) { d.MaxConcurrentRequests = 2 },
+	} {
+		changed := device
+		change(&changed)
+		assert.NotEqual(t, key, clientKey(changed, false, 0))
+	}
+
+	a := device
+	a.Headers = map[string]string{"X-A": "1", "X-B": "2"}
+	b := device
+	b.Headers = map[string]string{"X-B": "2", "X-A": "1"}
+	assert.Equal(t, clientKey(a, false, 0), clientKey(b, false, 0), "independent of map order")
+}
+
+func TestSharedRateLimiter(t *testing.T) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	a, keyA := acquireUniFiClient(UnifiDeviceConfig{Host: "a.lan", Username: "dns"}, false, 7)
+	b, keyB := acquireUniFiClient(UnifiDeviceConfig{Host: "a.lan", Username: "viewer", MaxConcurrentRequests: 1}, false, 7)
+	other, keyOther := acquireUniFiClient(UnifiDeviceConfig{Host: "b.lan"}, false, 7)
+	limitsA, ok := a.client.Transport.(*limitTransport)
+	require.True(t, ok)
+	limitsB, ok := b.client.Transport.(*limitTransport)
+	require.True(t, ok)
+	limitsOther, ok := other.client.Transport.(*limitTransport)
+	require.True(t, ok)
+	assert.Same(t, limitsA.limiter, limitsB.limiter, "clients of the same controller share its budget")
+	assert.NotSame(t, limitsA.limiter, limitsOther.limiter, "other controllers have their own")
+	assert.Nil(t, limitsA.slots)
+	assert.Equal(t, 1, cap(limitsB.slots))
+
+	releaseUniFiClient(keyA)
+	assert.Equal(t, 1, rateLimiters["a.lan 7"].refs)
+	releaseUniFiClient(keyB)
+	assert.NotContains(t, rateLimiters, "a.lan 7")
+	releaseUniFiClient(keyOther)
+	assert.Empty(t, rateLimiters)
+}
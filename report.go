@@ -0,0 +1,337 @@
+package traefikunifidns
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Outcomes of hostnames that were not published, in addition to the
+// updateDNSRecord outcomes.
+const (
+	resultSkipped = "skipped"
+	resultFailed  = "failed"
+	resultDeleted = "deleted" // Removed because no router publishes it anymore
+)
+
+// reasonNoMatch is the reason of hostnames no device pattern matches.
+const reasonNoMatch = "no matching device"
+
+const (
+	reportFormatJSON = "json"
+	reportFormatCSV  = "csv"
+
+	defaultReportMaxBytes   = 10 << 20
+	defaultReportMaxBackups = 3
+)
+
+// ReportConfig configures the machine-readable per-cycle report.
+type ReportConfig struct {
+	Path       string `json:"path"`
+	Format     string `json:"format,omitempty"`     // "json" (default) or "csv"
+	MaxBytes   int64  `json:"maxBytes,omitempty"`   // Rotate once the file would exceed this size, defaults to 10 MiB
+	MaxBackups int    `json:"maxBackups,omitempty"` // Number of rotated files to keep, defaults to 3
+}
+
+// hostResult is the outcome of processing a single hostname in a cycle.
+type hostResult struct {
+	Hostname      string `json:"hostname"`
+	Device        string `json:"device,omitempty"`
+	Action        string `json:"action"`
+	Type          string `json:"type,omitempty"` // Record type, "A" when empty
+	Value         string `json:"value,omitempty"`
+	IPv6          string `json:"ipv6,omitempty"` // Of the AAAA record published with enableIPv6
+	TTL           int    `json:"ttl,omitempty"`
+	Reason        string `json:"reason,omitempty"`        // Why the hostname was skipped or failed
+	NotPropagated bool   `json:"notPropagated,omitempty"` // Written, but verifyResolver didn't serve it in time
+	err           error  // Cause of a failure, used for error reporting
+	reserved      bool   // Holds a change of maxChangesPerCycle
+}
+
+// published reports whether the record is in its desired state.
+func (h hostResult) published() bool {
+	return h.Action == recordCreated || h.Action == recordUpdated || h.Action == recordUnchanged
+}
+
+// changed reports whether the record was created or updated.
+func (h hostResult) changed() bool {
+	return h.Action == recordCreated || h.Action == recordUpdated
+}
+
+// cycleSummary describes the outcome of an update cycle.
+type cycleSummary struct {
+	Time            time.Time               `json:"time"`
+	DurationSeconds float64                 `json:"durationSeconds"`
+	Changes         int                     `json:"changes"`
+	Counts          actionCounts            `json:"counts"`
+	Devices         map[string]actionCounts `json:"devices,omitempty"`   // Counts by device
+	Hostnames       []string                `json:"hostnames,omitempty"` // Only hostnames updated by a targeted cycle
+	Inconsistencies []inconsistency         `json:"inconsistencies,omitempty"`
+	Error           string                  `json:"error,omitempty"`
+}
+
+// SyncResult is the outcome of an update cycle.
+type SyncResult struct {
+	Start    time.Time
+	Duration time.Duration
+	Hosts    []hostResult            // Outcome of every hostname, in processing order
+	Counts   actionCounts            // Outcomes of all hostnames
+	Devices  map[string]actionCounts // Outcomes by device
+	Err      error                   // Why the cycle stopped early, nil if it completed
+
+	// Hostnames lists the only hostnames updated by a targeted cycle, which
+	// removes no records. It is nil for a full cycle.
+	Hostnames []string
+
+	// Inconsistencies lists the hostnames whose devices store different
+	// records, found by the consistency check of a full cycle.
+	Inconsistencies []inconsistency
+}
+
+func newSyncResult(start time.Time, hosts []hostResult, err error) SyncResult {
+	result := SyncResult{Start: start, Duration: time.Since(start), Hosts: hosts, Err: err}
+	for _, host := range hosts {
+		result.Counts.add(host)
+		if host.Device != "" {
+			if result.Devices == nil {
+				result.Devices = make(map[string]actionCounts)
+			}
+			counts := result.Devices[host.Device]
+			counts.add(host)
+			result.Devices[host.Device] = counts
+		}
+	}
+	return result
+}
+
+// Changes returns the number of records created or updated.
+func (s SyncResult) Changes() int {
+	return s.Counts.Created + s.Counts.Updated
+}
+
+// Errors returns why the cycle stopped early, if it did, followed by the
+// failures of single hostnames. A cycle error combining those failures isn't
+// repeated.
+func (s SyncResult) Errors() []error {
+	var errs []error
+	if s.Err != nil && !errors.Is(s.Err, errRecordsFailed) {
+		errs = append(errs, s.Err)
+	}
+	for _, host := range s.Hosts {
+		if host.err != nil {
+			errs = append(errs, host.err)
+		}
+	}
+	return errs
+}
+
+// summary returns the summary published to the report, MQTT and the status
+// endpoint.
+func (s SyncResult) summary() cycleSummary {
+	summary := cycleSummary{
+		Time:            s.Start.Add(s.Duration),
+		DurationSeconds: s.Duration.Seconds(),
+		Changes:         s.Changes(),
+		Counts:          s.Counts,
+		Devices:         s.Devices,
+		Hostnames:       s.Hostnames,
+		Inconsistencies: s.Inconsistencies,
+	}
+	if s.Err != nil {
+		summary.Error = s.Err.Error()
+	}
+	return summary
+}
+
+// String formats the counts of every device in one line, for the cycle log.
+func (s cycleSummary) String() string {
+	line := s.Counts.String()
+	devices := make([]string, 0, len(s.Devices))
+	for device := range s.Devices {
+		devices = append(devices, device)
+	}
+	sort.Strings(devices)
+	for i, device := range devices {
+		sep := "; "
+		if i == 0 {
+			sep = " ("
+		}
+		line += sep + device + ": " + s.Devices[device].String()
+	}
+	if len(devices) > 0 {
+		line += ")"
+	}
+	return line
+}
+
+// actionCounts counts the outcomes of the hostnames of a cycle.
+type actionCounts struct {
+	Created   int `json:"created"`
+	Updated   int `json:"updated"`
+	Deleted   int `json:"deleted"`
+	Unchanged int `json:"unchanged"`
+	NoMatch   int `json:"noMatch"`
+	Skipped   int `json:"skipped"`
+	Failed    int `json:"failed"`
+}
+
+func (c *actionCounts) add(result hostResult) {
+	switch result.Action {
+	case recordCreated:
+		c.Created++
+	case recordUpdated:
+		c.Updated++
+	case resultDeleted:
+		c.Deleted++
+	case recordUnchanged:
+		c.Unchanged++
+	case resultFailed:
+		c.Failed++
+	case resultSkipped:
+		if result.Reason == reasonNoMatch {
+			c.NoMatch++
+		} else {
+			c.Skipped++
+		}
+	}
+}
+
+// byAction returns the action names and their counts in a fixed order.
+func (c actionCounts) byAction() ([]string, []int) {
+	return []string{recordCreated, recordUpdated, resultDeleted, recordUnchanged, "no_match", resultSkipped, resultFailed},
+		[]int{c.Created, c.Updated, c.Deleted, c.Unchanged, c.NoMatch, c.Skipped, c.Failed}
+}
+
+func (c actionCounts) String() string {
+	actions, counts := c.byAction()
+	parts := make([]string, len(actions))
+	for i, action := range actions {
+		parts[i] = action + "=" + strconv.Itoa(counts[i])
+	}
+	return strings.Join(parts, " ")
+}
+
+// cycleReport is a line of the JSON report.
+type cycleReport struct {
+	cycleSummary
+	Hosts []hostResult `json:"hosts"`
+}
+
+// reportWriter appends the outcome of every cycle to a size-rotated file,
+// either as one JSON document per line and cycle, or as one CSV row per
+// hostname.
+type reportWriter struct {
+	config ReportConfig
+}
+
+func newReportWriter(config ReportConfig) (*reportWriter, error) {
+	if config.Path == "" {
+		return nil, fmt.Errorf("report is missing a path")
+	}
+	switch config.Format {
+	case "":
+		config.Format = reportFormatJSON
+	case reportFormatJSON, reportFormatCSV:
+	default:
+		return nil, fmt.Errorf("unsupported report format %q", config.Format)
+	}
+	if config.MaxBytes <= 0 {
+		config.MaxBytes = defaultReportMaxBytes
+	}
+	if config.MaxBackups <= 0 {
+		config.MaxBackups = defaultReportMaxBackups
+	}
+	return &reportWriter{config: config}, nil
+}
+
+func (w *reportWriter) write(summary cycleSummary, results []hostResult) error {
+	size := int64(0)
+	if info, err := os.Stat(w.config.Path); err == nil {
+		size = info.Size()
+	}
+
+	data, err := w.render(summary, results)
+	if err != nil {
+		return err
+	}
+	if size > 0 && size+int64(len(data)) > w.config.MaxBytes {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+		size = 0
+	}
+	if size == 0 && w.config.Format == reportFormatCSV {
+		data = append([]byte("time,hostname,device,action,value,ttl,reason\n"), data...)
+	}
+
+	f, err := os.OpenFile(w.config.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open report: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close report: %w", err)
+	}
+	return nil
+}
+
+func (w *reportWriter) render(summary cycleSummary, results []hostResult) ([]byte, error) {
+	if w.config.Format == reportFormatJSON {
+		if results == nil {
+			results = []hostResult{}
+		}
+		data, err := json.Marshal(cycleReport{cycleSummary: summary, Hosts: results})
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal report: %w", err)
+		}
+		return append(data, '\n'), nil
+	}
+
+	var b bytes.Buffer
+	cw := csv.NewWriter(&b)
+	timestamp := summary.Time.Format(time.RFC3339)
+	for _, result := range results {
+		ttl := ""
+		if result.TTL > 0 {
+			ttl = strconv.Itoa(result.TTL)
+		}
+		if err := cw.Write([]string{timestamp, result.Hostname, result.Device, result.Action, result.Value, ttl, result.Reason}); err != nil {
+			return nil, fmt.Errorf("failed to render report: %w", err)
+		}
+	}
+	if summary.Error != "" {
+		if err := cw.Write([]string{timestamp, "", "", resultFailed, "", "", summary.Error}); err != nil {
+			return nil, fmt.Errorf("failed to render report: %w", err)
+		}
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return nil, fmt.Errorf("failed to render report: %w", err)
+	}
+	return b.Bytes(), nil
+}
+
+// rotate shifts the report to <path>.1, <path>.1 to <path>.2 and so on,
+// dropping the oldest file.
+func (w *reportWriter) rotate() error {
+	for i := w.config.MaxBackups - 1; i >= 1; i-- {
+		from := fmt.Sprintf("%s.%d", w.config.Path, i)
+		if err := os.Rename(from, fmt.Sprintf("%s.%d", w.config.Path, i+1)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to rotate report: %w", err)
+		}
+	}
+	if err := os.Rename(w.config.Path, w.config.Path+".1"); err != nil {
+		return fmt.Errorf("failed to rotate report: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package traefikunifidns
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewReportWriter(t *testing.T) {
+	w, err := newReportWriter(ReportConfig{Path: "report.log"})
+	require.NoError(t, err)
+	assert.Equal(t, reportFormatJSON, w.config.Format)
+	assert.Equal(t, int64(defaultReportMaxBytes), w.config.MaxBytes)
+	assert.Equal(t, defaultReportMaxBackups, w.config.MaxBackups)
+
+	_, err = newReportWriter(ReportConfig{})
+	assert.EqualError(t, err, "report is missing a path")
+
+	_, err = newReportWriter(ReportConfig{Path: "report.log", Format: "xml"})
+	assert.EqualError(t, err, `unsupported report format "xml"`)
+}
+
+func TestSyncResult(t *testing.T) {
+	start := time.Now().Add(-time.Second)
+	hostErr := errors.New("rejected")
+	result := newSyncResult(start, []hostResult{
+		{Hostname: "a.lan", Action: recordCreated},
+		{Hostname: "b.lan", Action: recordUpdated},
+		{Hostname: "c.lan", Action: recordUnchanged},
+		{Hostname: "d.lan", Action: resultFailed, err: hostErr},
+	}, errors.New("boom"))
+	assert.Equal(t, 2, result.Changes())
+	assert.GreaterOrEqual(t, result.Duration, time.Second)
+	assert.Equal(t, []error{result.Err, hostErr}, result.Errors())
+
+	summary := result.summary()
+	assert.Equal(t, 2, summary.Changes)
+	assert.Equal(t, "boom", summary.Error)
+	assert.Equal(t, start.Add(result.Duration), summary.Time)
+}
+
+func TestCycleSummaryCounts(t *testing.T) {
+	summary := newSyncResult(time.Now(), []hostResult{
+		{Hostname: "a.lan", Device: "https://unifi.lan", Action: recordCreated},
+		{Hostname: "b.lan", Device: "https://unifi.lan", Action: recordUnchanged},
+		{Hostname: "c.lan", Device: "https://unifi.lan", Action: resultDeleted},
+		{Hostname: "d.lan", Device: "http://hook", Action: resultSkipped, Reason: "circuit breaker open"},
+		{Hostname: "e.lan", Device: "http://hook", Action: resultFailed},
+		{Hostname: "other.com", Action: resultSkipped, Reason: reasonNoMatch},
+	}, nil).summary()
+
+	assert.Equal(t, actionCounts{Created: 1, Deleted: 1, Unchanged: 1, NoMatch: 1, Skipped: 1, Failed: 1}, summary.Counts)
+	assert.Equal(t, map[string]actionCounts{
+		"https://unifi.lan": {Created: 1, Deleted: 1, Unchanged: 1},
+		"http://hook":       {Skipped: 1, Failed: 1},
+	}, summary.Devices)
+	assert.Equal(t, "created=1 updated=0 deleted=1 unchanged=1 no_match=1 skipped=1 failed=1"+
+		" (http://hook: created=0 updated=0 deleted=0 unchanged=0 no_match=0 skipped=1 failed=1;"+
+		" https://unifi.lan: created=1 updated=0 deleted=1 unchanged=1 no_match=0 skipped=0 failed=0)", summary.String())
+}
+
+func TestReportWriterJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.jsonl")
+	w, err := newReportWriter(ReportConfig{Path: path})
+	require.NoError(t, err)
+
+	summary := cycleSummary{Time: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), Changes: 1}
+	results := []hostResult{
+		{Hostname: "app.lan", Device: "https://unifi.lan", Action: recordCreated, Value: "192.168.1.10"},
+		{Hostname: "other.com", Action: resultSkipped, Reason: "no matching device"},
+	}
+	require.NoError(t, w.write(summary, results))
+	require.NoError(t, w.write(cycleSummary{Time: summary.Time, Error: "failed to get Traefik routers"}, nil))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 2)
+
+	var report cycleReport
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &report))
+	assert.Equal(t, results, report.Hosts)
+	assert.Equal(t, 1, report.Changes)
+	assert.JSONEq(t, `{"time":"2024-01-01T00:00:00Z","durationSeconds":0,"counts":{"created":0,"updated":0,"deleted":0,"unchanged":0,"noMatch":0,"skipped":0,"failed":0},"changes":0,"error":"failed to get Traefik routers","hosts":[]}`, lines[1])
+}
+
+func TestReportWriterCSV(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.csv")
+	w, err := newReportWriter(ReportConfig{Path: path, Format: reportFormatCSV})
+	require.NoError(t, err)
+
+	summary := cycleSummary{Time: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), Error: "aborted, deadline exceeded"}
+	require.NoError(t, w.write(summary, []hostResult{
+		{Hostname: "app.lan", Device: "https://unifi.lan", Action: recordUpdated, Value: "192.168.1.10", TTL: 60},
+	}))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, `time,hostname,device,action,value,ttl,reason
+2024-01-01T00:00:00Z,app.lan,https://unifi.lan,updated,192.168.1.10,60,
+2024-01-01T00:00:00Z,,,failed,,,"aborted, deadline exceeded"
+`, string(data))
+}
+
+func TestReportWriterRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "report.csv")
+	w, err := newReportWriter(ReportConfig{Path: path, Format: reportFormatCSV, MaxBytes: 100, MaxBackups: 2})
+	require.NoError(t, err)
+
+	results := []hostResult{{Hostname: "app.lan", Action: recordUnchanged, Value: "192.168.1.10"}}
+	for i := 0; i < 5; i++ {
+		require.NoError(t, w.write(cycleSummary{Time: time.Now()}, results))
+	}
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.Equal(t, []string{"report.csv", "report.csv.1", "report.csv.2"}, names)
+
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(string(data), "time,hostname,"), "every file starts with the CSV header")
+	}
+}
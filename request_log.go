@@ -0,0 +1,20 @@
+package traefikunifidns
+
+import (
+	"log"
+	"net/http"
+	"sync/atomic"
+)
+
+// logRequest logs a proxied request with logRequests, sampling one in
+// logSampleRate requests. Without logRequests nothing is done on the
+// request path.
+func (u *UniFiDNS) logRequest(req *http.Request) {
+	if !u.config.LogRequests {
+		return
+	}
+	if rate := u.config.LogSampleRate; rate > 1 && atomic.AddUint64(&u.requests, 1)%uint64(rate) != 1 {
+		return
+	}
+	log.Printf("INFO: Served HTTP request: %s %s", req.Method, req.URL.Path)
+}
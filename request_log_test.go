@@ -0,0 +1,48 @@
+package traefikunifidns
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogRequests(t *testing.T) {
+	var logBuf bytes.Buffer
+	log.SetOutput(&logBuf)
+	defer log.SetOutput(os.Stderr)
+
+	config := CreateConfig()
+	u := newTestAdminPlugin(config)
+	serve := func(n int) int {
+		logBuf.Reset()
+		for i := 0; i < n; i++ {
+			u.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/app", nil))
+		}
+		return strings.Count(logBuf.String(), "INFO: Served HTTP request: GET /app")
+	}
+
+	assert.Equal(t, 0, serve(10), "requests are not logged by default")
+
+	config.LogRequests = true
+	assert.Equal(t, 10, serve(10))
+
+	config.LogSampleRate = 4
+	assert.Equal(t, 3, serve(10), "one in four requests is logged")
+}
+
+func TestNewInvalidLogSampleRate(t *testing.T) {
+	config := CreateConfig()
+	config.LogSampleRate = -1
+	config.Devices = []UnifiDeviceConfig{{WebhookURL: "http://localhost:8080", Pattern: `\.lan$`}}
+
+	_, err := New(context.Background(), nil, config, "test")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid logSampleRate")
+}
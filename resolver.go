@@ -0,0 +1,103 @@
+package traefikunifidns
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	resolverTimeout      = 2 * time.Second
+	defaultVerifyTimeout = 10 * time.Second
+)
+
+// verifyRetryInterval is the pause between verification queries.
+var verifyRetryInterval = time.Second
+
+// ipLookup is the part of net.Resolver used to query a DNS server.
+type ipLookup interface {
+	LookupIP(ctx context.Context, network, host string) ([]net.IP, error)
+}
+
+// dnsResolver queries a fixed DNS server, typically the gateway serving the
+// published records, to tell whether a hostname already resolves to its
+// targets.
+type dnsResolver struct {
+	server string
+	lookup ipLookup
+}
+
+// newDNSResolver returns a resolver querying server, which defaults to port
+// 53 when it has none.
+func newDNSResolver(server string) *dnsResolver {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+	return &dnsResolver{
+		server: server,
+		lookup: &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, network, server)
+			},
+		},
+	}
+}
+
+// resolves reports whether hostname resolves to exactly the targets. Lookup
+// failures count as a mismatch.
+func (d *dnsResolver) resolves(hostname string, targets []string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), resolverTimeout)
+	defer cancel()
+	ips, err := d.lookup.LookupIP(ctx, "ip", strings.TrimSuffix(hostname, "."))
+	if err != nil {
+		return false
+	}
+	return sameAddresses(ips, targets)
+}
+
+// verify queries hostname until it resolves to exactly the targets or the
+// timeout expires, and reports whether it did.
+func (d *dnsResolver) verify(hostname string, targets []string, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		if d.resolves(hostname, targets) {
+			return true
+		}
+		if time.Now().Add(verifyRetryInterval).After(deadline) {
+			return false
+		}
+		time.Sleep(verifyRetryInterval)
+	}
+}
+
+// sameAddresses reports whether ips and targets hold the same addresses,
+// ignoring order and duplicates.
+func sameAddresses(ips []net.IP, targets []string) bool {
+	resolved := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		resolved = append(resolved, ip.String())
+	}
+	wanted := make([]string, 0, len(targets))
+	for _, target := range targets {
+		if ip := net.ParseIP(target); ip != nil {
+			target = ip.String()
+		}
+		wanted = append(wanted, target)
+	}
+	return strings.Join(uniqueSorted(resolved), ",") == strings.Join(uniqueSorted(wanted), ",")
+}
+
+func uniqueSorted(values []string) []string {
+	sort.Strings(values)
+	unique := values[:0]
+	for i, value := range values {
+		if i == 0 || value != values[i-1] {
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,156 @@
+package traefikunifidns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeLookup answers every query from a fixed table.
+type fakeLookup map[string][]string
+
+func (f fakeLookup) LookupIP(_ context.Context, _, host string) ([]net.IP, error) {
+	addresses, ok := f[host]
+	if !ok {
+		return nil, errors.New("no such host")
+	}
+	ips := make([]net.IP, 0, len(addresses))
+	for _, address := range addresses {
+		ips = append(ips, net.ParseIP(address))
+	}
+	return ips, nil
+}
+
+func TestNewDNSResolver(t *testing.T) {
+	assert.Equal(t, "192.168.1.1:53", newDNSResolver("192.168.1.1").server)
+	assert.Equal(t, "192.168.1.1:5353", newDNSResolver("192.168.1.1:5353").server)
+	assert.Equal(t, "[fd00::1]:53", newDNSResolver("fd00::1").server)
+}
+
+func TestResolverResolves(t *testing.T) {
+	resolver := &dnsResolver{lookup: fakeLookup{
+		"app.lan": {"192.168.1.10"},
+		"ha.lan":  {"192.168.1.11", "192.168.1.10", "192.168.1.11"},
+	}}
+
+	assert.True(t, resolver.resolves("app.lan", []string{"192.168.1.10"}))
+	assert.True(t, resolver.resolves("app.lan.", []string{"192.168.1.10"}))
+	assert.False(t, resolver.resolves("app.lan", []string{"192.168.1.20"}))
+	assert.True(t, resolver.resolves("ha.lan", []string{"192.168.1.10", "192.168.1.11"}))
+	assert.False(t, resolver.resolves("ha.lan", []string{"192.168.1.10"}))
+	assert.False(t, resolver.resolves("nas.lan", []string{"192.168.1.30"}), "lookup failures are a mismatch")
+}
+
+func TestPrecheckResolverSkipsDevice(t *testing.T) {
+	traefikServer := newTestTraefikServer(t, []map[string]interface{}{
+		{"name": "app", "rule": "Host(`app.lan`)", "middlewares": []string{"traefikunifidns"}},
+		{"name": "nas", "rule": "Host(`nas.lan`)", "middlewares": []string{"traefikunifidns"}},
+	})
+	var changes []webhookChange
+	webhookServer := newTestWebhookServer(t, &changes)
+
+	updateOnStartup := false
+	config := CreateConfig()
+	config.TraefikAPIURL = traefikServer.URL
+	config.Devices = []UnifiDeviceConfig{{WebhookURL: webhookServer.URL, Pattern: `\.lan$`}}
+	config.IPOverrides = map[string]string{"app.lan": "192.168.1.10", "nas.lan": "192.168.1.20"}
+	config.UpdateOnStartup = &updateOnStartup
+	config.AdminPath = "/.unifidns"
+	config.PrecheckResolver = "192.168.1.1"
+
+	plugin, err := New(context.Background(), nil, config, "test")
+	require.NoError(t, err)
+	u := plugin.(*UniFiDNS)
+	u.resolver.lookup = fakeLookup{"app.lan": {"192.168.1.10"}, "nas.lan": {"192.168.1.99"}}
+
+	result := u.updateDNS(context.Background())
+	require.NoError(t, result.Err)
+	require.Len(t, changes, 1, "only the hostname resolving elsewhere is sent")
+	assert.Equal(t, "nas.lan", changes[0].Record.Hostname)
+	assert.Equal(t, 1, result.Counts.Unchanged)
+	assert.Equal(t, 1, result.Counts.Created)
+	for _, host := range result.Hosts {
+		if host.Hostname == "app.lan" {
+			assert.Equal(t, "resolver answers target", host.Reason)
+		}
+	}
+}
+
+// countingLookup answers with the addresses of a fixed table only after the
+// given number of failed queries.
+type countingLookup struct {
+	fakeLookup
+	failures int
+	queries  int
+}
+
+func (c *countingLookup) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	c.queries++
+	if c.queries <= c.failures {
+		return nil, errors.New("no such host")
+	}
+	return c.fakeLookup.LookupIP(ctx, network, host)
+}
+
+func TestResolverVerify(t *testing.T) {
+	defer func(interval time.Duration) { verifyRetryInterval = interval }(verifyRetryInterval)
+	verifyRetryInterval = time.Millisecond
+
+	lookup := &countingLookup{fakeLookup: fakeLookup{"app.lan": {"192.168.1.10"}}, failures: 2}
+	resolver := &dnsResolver{lookup: lookup}
+	assert.True(t, resolver.verify("app.lan", []string{"192.168.1.10"}, time.Second))
+	assert.Equal(t, 3, lookup.queries)
+
+	assert.False(t, resolver.verify("app.lan", []string{"192.168.1.20"}, 10*time.Millisecond))
+}
+
+func TestVerifyResolverMarksNotPropagated(t *testing.T) {
+	defer func(interval time.Duration) { verifyRetryInterval = interval }(verifyRetryInterval)
+	verifyRetryInterval = time.Millisecond
+
+	traefikServer := newTestTraefikServer(t, []map[string]interface{}{
+		{"name": "app", "rule": "Host(`app.lan`)", "middlewares": []string{"traefikunifidns"}},
+		{"name": "nas", "rule": "Host(`nas.lan`)", "middlewares": []string{"traefikunifidns"}},
+	})
+	var changes []webhookChange
+	webhookServer := newTestWebhookServer(t, &changes)
+
+	updateOnStartup := false
+	config := CreateConfig()
+	config.TraefikAPIURL = traefikServer.URL
+	config.Devices = []UnifiDeviceConfig{{WebhookURL: webhookServer.URL, Pattern: `\.lan$`}}
+	config.IPOverrides = map[string]string{"app.lan": "192.168.1.10", "nas.lan": "192.168.1.20"}
+	config.UpdateOnStartup = &updateOnStartup
+	config.AdminPath = "/.unifidns"
+	config.VerifyResolver = "192.168.1.1"
+	config.VerifyTimeout = "20ms"
+
+	plugin, err := New(context.Background(), nil, config, "test")
+	require.NoError(t, err)
+	u := plugin.(*UniFiDNS)
+	u.verifier.lookup = fakeLookup{"app.lan": {"192.168.1.10"}}
+
+	result := u.sync(context.Background())
+	require.NoError(t, result.Err)
+	assert.Len(t, changes, 2, "verification never blocks writes")
+
+	records := u.status().Records
+	require.Len(t, records, 2)
+	for _, record := range records {
+		assert.Equal(t, record.Hostname == "nas.lan", record.NotPropagated, record.Hostname)
+	}
+}
+
+func TestNewInvalidVerifyTimeout(t *testing.T) {
+	for _, timeout := range []string{"soon", "0s"} {
+		config := CreateConfig()
+		config.VerifyTimeout = timeout
+		_, err := New(context.Background(), nil, config, "test")
+		assert.ErrorContains(t, err, "invalid verify timeout")
+	}
+}
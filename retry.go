@@ -0,0 +1,120 @@
+package traefikunifidns
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultInitialBackoff = 500 * time.Millisecond
+	defaultMaxBackoff     = 10 * time.Second
+)
+
+// retryPolicy decides how often and after which delay a failed controller
+// request is sent again. The zero value never retries.
+type retryPolicy struct {
+	maxRetries     int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+}
+
+// newRetryPolicy returns the retry policy configured for device.
+func newRetryPolicy(device UnifiDeviceConfig) (retryPolicy, error) {
+	p := retryPolicy{maxRetries: device.MaxRetries, initialBackoff: defaultInitialBackoff, maxBackoff: defaultMaxBackoff}
+	if device.MaxRetries < 0 {
+		return p, fmt.Errorf("maxRetries must not be negative")
+	}
+	var err error
+	if device.InitialBackoff != "" {
+		if p.initialBackoff, err = parseOptionalDuration(device.InitialBackoff); err != nil {
+			return p, fmt.Errorf("invalid initialBackoff: %w", err)
+		}
+	}
+	if device.MaxBackoff != "" {
+		if p.maxBackoff, err = parseOptionalDuration(device.MaxBackoff); err != nil {
+			return p, fmt.Errorf("invalid maxBackoff: %w", err)
+		}
+	}
+	if p.maxBackoff < p.initialBackoff {
+		return p, fmt.Errorf("maxBackoff %s is shorter than initialBackoff %s", p.maxBackoff, p.initialBackoff)
+	}
+	return p, nil
+}
+
+// backoff returns the delay before retry number attempt, counted from zero:
+// the initial backoff doubled with every attempt up to the maximum, of which
+// a random half is used so that clients failing together don't retry in
+// lockstep.
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	delay := p.initialBackoff
+	for i := 0; i < attempt && delay < p.maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > p.maxBackoff {
+		delay = p.maxBackoff
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(delay-half)+1))
+}
+
+// retryableAttempt reports whether a request that ended with resp and err may
+// succeed when sent again: network errors, server errors and rate limiting.
+// A request that is not idempotent, such as the POST creating a DNS entry,
+// may have been carried out despite a network or server error, so it is only
+// sent again after rate limiting, which rejects it before it is processed.
+// Requests blocked by read-only mode or a cancelled context never are.
+func retryableAttempt(req *http.Request, resp *http.Response, err error, idempotent bool) bool {
+	if req.Context().Err() != nil {
+		return false
+	}
+	if err != nil {
+		return idempotent && !errors.Is(err, errReadOnly)
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+	return idempotent && resp.StatusCode >= 500
+}
+
+// setRetryPolicy makes the client retry failed requests according to p.
+func (c *UniFiClient) setRetryPolicy(p retryPolicy) {
+	c.retry = p
+}
+
+// retryDelay prepares req to be sent again after a failed attempt, and
+// returns how long to wait first. It returns false when the request must not
+// be retried, because the policy is exhausted or its body cannot be replayed.
+func (c *UniFiClient) retryDelay(req *http.Request, resp *http.Response, err error, attempt int) (time.Duration, bool) {
+	// Logging in again is harmless, unlike creating an entry twice
+	idempotent := req.Method != http.MethodPost || isLoginRequest(req, c.apiPaths().login)
+	if attempt >= c.retry.maxRetries || !retryableAttempt(req, resp, err, idempotent) {
+		return 0, false
+	}
+	if req.Body != nil {
+		if req.GetBody == nil {
+			return 0, false
+		}
+		body, bodyErr := req.GetBody()
+		if bodyErr != nil {
+			return 0, false
+		}
+		req.Body = body
+	}
+
+	reason := fmt.Sprint(err)
+	if resp != nil {
+		reason = fmt.Sprintf("status %d", resp.StatusCode)
+		_, _ = io.Copy(io.Discard, resp.Body)
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			logError("Failed to close response body: %v", closeErr)
+		}
+	}
+	delay := c.retry.backoff(attempt)
+	log.Printf("WARN: %s %s failed with %s, retrying in %s (%d/%d)", req.Method, req.URL.Path, reason, delay.Round(time.Millisecond), attempt+1, c.retry.maxRetries)
+	return delay, true
+}
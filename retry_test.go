@@ -0,0 +1,133 @@
+package traefikunifidns
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := retryPolicy{maxRetries: 5, initialBackoff: 100 * time.Millisecond, maxBackoff: time.Second}
+	for attempt, want := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second} {
+		for i := 0; i < 20; i++ {
+			delay := p.backoff(attempt)
+			assert.GreaterOrEqual(t, delay, want/2, "attempt %d", attempt)
+			assert.LessOrEqual(t, delay, want, "attempt %d", attempt)
+		}
+	}
+}
+
+func TestNewRetryPolicy(t *testing.T) {
+	p, err := newRetryPolicy(UnifiDeviceConfig{MaxRetries: 3})
+	require.NoError(t, err)
+	assert.Equal(t, retryPolicy{maxRetries: 3, initialBackoff: defaultInitialBackoff, maxBackoff: defaultMaxBackoff}, p)
+
+	for _, device := range []UnifiDeviceConfig{
+		{MaxRetries: -1},
+		{InitialBackoff: "soon"},
+		{MaxBackoff: "-1s"},
+		{InitialBackoff: "5s", MaxBackoff: "1s"},
+	} {
+		_, err := newRetryPolicy(device)
+		assert.Error(t, err, "%+v", device)
+	}
+}
+
+func TestUniFiClientRetries(t *testing.T) {
+	failures, requests, status := 2, 0, http.StatusBadGateway
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/auth/login" {
+			w.Header().Set("X-Csrf-Token", "test-csrf-token")
+			return
+		}
+		requests++
+		if r.Method == "POST" {
+			var payload map[string]interface{}
+			_ = json.NewDecoder(r.Body).Decode(&payload)
+			bodies = append(bodies, payload["key"].(string))
+		}
+		if failures > 0 {
+			failures--
+			w.WriteHeader(status)
+			return
+		}
+		_, _ = w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	client := NewUniFiClient(server.URL, "admin", "password", false)
+	client.setRetryPolicy(retryPolicy{maxRetries: 2, initialBackoff: time.Millisecond, maxBackoff: time.Millisecond})
+
+	_, err := client.GetStaticDNSEntries(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 3, requests)
+
+	// Creates are only sent again after rate limiting, with their body
+	failures, requests, status = 1, 0, http.StatusTooManyRequests
+	require.NoError(t, client.createDNSEntry(context.Background(), DNSEntry{Key: "app.lan", RecordType: "A", Value: "192.168.1.10"}))
+	assert.Equal(t, []string{"app.lan", "app.lan"}, bodies)
+
+	// A create failing with a server error may have been carried out
+	failures, requests, status, bodies = 1, 0, http.StatusBadGateway, nil
+	assert.Error(t, client.createDNSEntry(context.Background(), DNSEntry{Key: "app.lan", RecordType: "A", Value: "192.168.1.10"}))
+	assert.Equal(t, []string{"app.lan"}, bodies)
+
+	// Gives up once the retries are used up
+	failures, requests = 3, 0
+	_, err = client.GetStaticDNSEntries(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, 3, requests)
+}
+
+func TestUniFiClientRetriesLoginBelowBasePath(t *testing.T) {
+	logins := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/unifi/api/auth/login":
+			logins++
+			if logins == 1 {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			w.Header().Set("X-Csrf-Token", "test-csrf-token")
+		case "/unifi/proxy/network/v2/api/site/default/static-dns":
+			_, _ = w.Write([]byte(`[]`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client := NewUniFiClient(server.URL+"/unifi", "admin", "password", false)
+	client.setRetryPolicy(retryPolicy{maxRetries: 2, initialBackoff: time.Millisecond, maxBackoff: time.Millisecond})
+
+	_, err := client.GetStaticDNSEntries(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 2, logins, "logging in again is harmless")
+}
+
+func TestUniFiClientNoRetryOnClientError(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/auth/login" {
+			w.Header().Set("X-Csrf-Token", "test-csrf-token")
+			return
+		}
+		requests++
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	client := NewUniFiClient(server.URL, "admin", "password", false)
+	client.setRetryPolicy(retryPolicy{maxRetries: 3, initialBackoff: time.Millisecond, maxBackoff: time.Millisecond})
+	_, err := client.GetStaticDNSEntries(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, 1, requests)
+}
@@ -0,0 +1,222 @@
+package traefikunifidns
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ruleNode is a node of a parsed Traefik rule: either a matcher such as
+// Host(`example.com`) or an operator combining other nodes.
+type ruleNode struct {
+	op       string   // "&&", "||" or "!"; empty for a matcher
+	matcher  string   // Matcher name, e.g. "Host" or "HostSNI"
+	args     []string // Unquoted matcher arguments
+	operands []*ruleNode
+}
+
+// ruleToken is a token of a Traefik rule.
+type ruleToken struct {
+	kind  byte // 'i' identifier, 's' string, or the operator/punctuation itself ('&', '|', '!', '(', ')', ',')
+	value string
+	pos   int
+}
+
+// tokenizeRule splits a Traefik rule into tokens. Strings may be quoted with
+// backticks, single or double quotes, and keep everything between the quotes,
+// so regular expressions containing parentheses or operators are safe.
+func tokenizeRule(rule string) ([]ruleToken, error) {
+	var tokens []ruleToken
+	for i := 0; i < len(rule); {
+		c := rule[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(' || c == ')' || c == ',' || c == '!':
+			tokens = append(tokens, ruleToken{kind: c, value: string(c), pos: i})
+			i++
+		case c == '&' || c == '|':
+			if i+1 >= len(rule) || rule[i+1] != c {
+				return nil, fmt.Errorf("unexpected %q at position %d", c, i)
+			}
+			tokens = append(tokens, ruleToken{kind: c, value: rule[i : i+2], pos: i})
+			i += 2
+		case c == '`' || c == '\'' || c == '"':
+			end := strings.IndexByte(rule[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string at position %d", i)
+			}
+			tokens = append(tokens, ruleToken{kind: 's', value: rule[i+1 : i+1+end], pos: i})
+			i += end + 2
+		case isRuleIdentifier(c):
+			start := i
+			for i < len(rule) && isRuleIdentifier(rule[i]) {
+				i++
+			}
+			tokens = append(tokens, ruleToken{kind: 'i', value: rule[start:i], pos: start})
+		default:
+			return nil, fmt.Errorf("unexpected %q at position %d", c, i)
+		}
+	}
+	return tokens, nil
+}
+
+func isRuleIdentifier(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_'
+}
+
+// ruleParser is a recursive descent parser for Traefik's rule grammar:
+//
+//	or      = and { "||" and }
+//	and     = unary { "&&" unary }
+//	unary   = "!" unary | "(" or ")" | matcher
+//	matcher = identifier "(" [ string { "," string } ] ")"
+type ruleParser struct {
+	tokens []ruleToken
+	pos    int
+}
+
+// parseRule parses a Traefik v2 or v3 rule.
+func parseRule(rule string) (*ruleNode, error) {
+	tokens, err := tokenizeRule(rule)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("empty rule")
+	}
+	p := &ruleParser{tokens: tokens}
+	node, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q at position %d", p.tokens[p.pos].value, p.tokens[p.pos].pos)
+	}
+	return node, nil
+}
+
+// peek returns the kind of the next token, or 0 at the end of the rule.
+func (p *ruleParser) peek() byte {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos].kind
+	}
+	return 0
+}
+
+// expect consumes the next token, failing unless it is of the given kind.
+func (p *ruleParser) expect(kind byte, what string) (ruleToken, error) {
+	if p.pos >= len(p.tokens) {
+		return ruleToken{}, fmt.Errorf("expected %s at end of rule", what)
+	}
+	token := p.tokens[p.pos]
+	if token.kind != kind {
+		return ruleToken{}, fmt.Errorf("expected %s at position %d, got %q", what, token.pos, token.value)
+	}
+	p.pos++
+	return token, nil
+}
+
+func (p *ruleParser) or() (*ruleNode, error) {
+	return p.binary("||", '|', p.and)
+}
+
+func (p *ruleParser) and() (*ruleNode, error) {
+	return p.binary("&&", '&', p.unary)
+}
+
+// binary parses operands joined by op, flattening them into a single node.
+func (p *ruleParser) binary(op string, kind byte, operand func() (*ruleNode, error)) (*ruleNode, error) {
+	first, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek() != kind {
+		return first, nil
+	}
+	node := &ruleNode{op: op, operands: []*ruleNode{first}}
+	for p.peek() == kind {
+		p.pos++
+		next, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		node.operands = append(node.operands, next)
+	}
+	return node, nil
+}
+
+func (p *ruleParser) unary() (*ruleNode, error) {
+	switch p.peek() {
+	case '!':
+		p.pos++
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &ruleNode{op: "!", operands: []*ruleNode{operand}}, nil
+	case '(':
+		p.pos++
+		node, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(')', `")"`); err != nil {
+			return nil, err
+		}
+		return node, nil
+	}
+	return p.matcher()
+}
+
+func (p *ruleParser) matcher() (*ruleNode, error) {
+	name, err := p.expect('i', "matcher")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect('(', `"(" after `+name.value); err != nil {
+		return nil, err
+	}
+	node := &ruleNode{matcher: name.value}
+	if p.peek() == ')' {
+		p.pos++
+		return node, nil
+	}
+	for {
+		arg, err := p.expect('s', "quoted argument of "+name.value)
+		if err != nil {
+			return nil, err
+		}
+		node.args = append(node.args, arg.value)
+		if p.peek() != ',' {
+			break
+		}
+		p.pos++
+	}
+	if _, err := p.expect(')', `")" after arguments of `+name.value); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// matcherArgs returns the arguments of the named matchers that can match a
+// request, in order. Matcher names are compared case-insensitively, like
+// Traefik v2 does. Matchers below a negation are skipped, since a
+// negated Host(`a.example.com`) excludes rather than serves that hostname.
+func (n *ruleNode) matcherArgs(names ...string) []string {
+	var args []string
+	switch n.op {
+	case "!":
+		return nil
+	case "&&", "||":
+		for _, operand := range n.operands {
+			args = append(args, operand.matcherArgs(names...)...)
+		}
+		return args
+	}
+	for _, name := range names {
+		if strings.EqualFold(n.matcher, name) {
+			return append(args, n.args...)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package traefikunifidns
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRule(t *testing.T) {
+	node, err := parseRule("(Host(`a.lan`) || Host(`b.lan`, 'c.lan')) && !PathPrefix(\"/admin\")")
+	require.NoError(t, err)
+
+	assert.Equal(t, &ruleNode{op: "&&", operands: []*ruleNode{
+		{op: "||", operands: []*ruleNode{
+			{matcher: "Host", args: []string{"a.lan"}},
+			{matcher: "Host", args: []string{"b.lan", "c.lan"}},
+		}},
+		{op: "!", operands: []*ruleNode{
+			{matcher: "PathPrefix", args: []string{"/admin"}},
+		}},
+	}}, node)
+}
+
+func TestParseRulePrecedence(t *testing.T) {
+	// && binds tighter than ||
+	node, err := parseRule("Host(`a.lan`) || Host(`b.lan`) && Path(`/b`)")
+	require.NoError(t, err)
+	require.Equal(t, "||", node.op)
+	require.Len(t, node.operands, 2)
+	assert.Equal(t, "&&", node.operands[1].op)
+}
+
+func TestParseRuleErrors(t *testing.T) {
+	testCases := []struct {
+		name string
+		rule string
+		err  string
+	}{
+		{name: "Empty rule", rule: "  ", err: "empty rule"},
+		{name: "Unquoted argument", rule: "Host(example)", err: "expected quoted argument of Host"},
+		{name: "Unexpected character", rule: "Host(example.com)", err: `unexpected '.'`},
+		{name: "Unterminated string", rule: "Host(`example.com)", err: "unterminated string"},
+		{name: "Single ampersand", rule: "Host(`a.lan`) & Path(`/`)", err: `unexpected '&'`},
+		{name: "Missing closing parenthesis", rule: "(Host(`a.lan`)", err: `expected ")" at end of rule`},
+		{name: "Trailing tokens", rule: "Host(`a.lan`) Path(`/`)", err: `unexpected "Path"`},
+		{name: "Missing operand", rule: "Host(`a.lan`) ||", err: "expected matcher at end of rule"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseRule(tc.rule)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.err)
+		})
+	}
+}
+
+func TestRuleMatcherArgs(t *testing.T) {
+	node, err := parseRule("host(`a.lan`) || HostRegexp(`^.+\\.lan$`) || (Host(`b.lan`) && !Host(`c.lan`))")
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"a.lan", "b.lan"}, node.matcherArgs("Host"))
+	assert.Equal(t, []string{`^.+\.lan$`}, node.matcherArgs("HostRegexp"))
+	assert.Nil(t, node.matcherArgs("HostSNI"))
+}
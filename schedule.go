@@ -0,0 +1,200 @@
+package traefikunifidns
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// scheduleState is the runtime schedule of the update loop, changed through
+// the admin endpoints. It is guarded by syncStats.mu.
+type scheduleState struct {
+	paused        bool
+	pausedUntil   time.Time     // Zero while paused until resumed
+	override      time.Duration // Replaces the update interval while set
+	overrideUntil time.Time     // Zero while overridden until reset
+	interval      time.Duration // Until the next update, as last scheduled
+	nextUpdate    time.Time
+	lastUpdate    time.Time
+}
+
+// scheduleStatus is the schedule section of the status document.
+type scheduleStatus struct {
+	Paused        bool      `json:"paused"`
+	PausedUntil   time.Time `json:"pausedUntil"`
+	Interval      string    `json:"interval"`
+	Override      string    `json:"override,omitempty"`
+	OverrideUntil time.Time `json:"overrideUntil"`
+}
+
+// notifySchedule wakes the update loop up to apply a schedule change.
+func (r *reconciler) notifySchedule() {
+	select {
+	case r.scheduleCh <- struct{}{}:
+	default:
+	}
+}
+
+// pause stops periodic, discovered and requested updates, for d or until
+// resumed if d is zero.
+func (r *reconciler) pause(d time.Duration) {
+	r.stats.mu.Lock()
+	r.stats.schedule.paused = true
+	r.stats.schedule.pausedUntil = time.Time{}
+	if d > 0 {
+		r.stats.schedule.pausedUntil = time.Now().Add(d)
+		log.Printf("INFO: DNS updates paused for %s", d)
+	} else {
+		log.Printf("INFO: DNS updates paused until resumed")
+	}
+	r.stats.mu.Unlock()
+	r.notifySchedule()
+}
+
+func (r *reconciler) resume() {
+	r.stats.mu.Lock()
+	if r.stats.schedule.paused {
+		log.Printf("INFO: DNS updates resumed")
+	}
+	r.stats.schedule.paused = false
+	r.stats.schedule.pausedUntil = time.Time{}
+	r.stats.mu.Unlock()
+	r.notifySchedule()
+}
+
+// overrideInterval replaces the update interval with interval, for d or
+// until reset if d is zero. A zero interval restores the configured one.
+func (r *reconciler) overrideInterval(interval, d time.Duration) {
+	r.stats.mu.Lock()
+	r.stats.schedule.override = interval
+	r.stats.schedule.overrideUntil = time.Time{}
+	switch {
+	case interval == 0:
+		log.Printf("INFO: DNS update interval restored to %s", r.updateInterval)
+	case d > 0:
+		r.stats.schedule.overrideUntil = time.Now().Add(d)
+		log.Printf("INFO: DNS update interval changed to %s for %s", interval, d)
+	default:
+		log.Printf("INFO: DNS update interval changed to %s", interval)
+	}
+	r.stats.mu.Unlock()
+	r.notifySchedule()
+}
+
+// paused reports whether updates are paused, ending a timed pause once it is
+// over.
+func (r *reconciler) paused() bool {
+	r.stats.mu.Lock()
+	defer r.stats.mu.Unlock()
+	s := &r.stats.schedule
+	if s.paused && !s.pausedUntil.IsZero() && !time.Now().Before(s.pausedUntil) {
+		log.Printf("INFO: DNS updates resumed after pause")
+		s.paused = false
+		s.pausedUntil = time.Time{}
+	}
+	return s.paused
+}
+
+// scheduledInterval returns the interval until the next update, which is
+// adaptive unless overridden, ending a timed override once it is over.
+func (r *reconciler) scheduledInterval(adaptive time.Duration) time.Duration {
+	r.stats.mu.Lock()
+	defer r.stats.mu.Unlock()
+	s := &r.stats.schedule
+	if s.override > 0 && !s.overrideUntil.IsZero() && !time.Now().Before(s.overrideUntil) {
+		log.Printf("INFO: DNS update interval override of %s ended", s.override)
+		s.override = 0
+		s.overrideUntil = time.Time{}
+	}
+	if s.override > 0 {
+		return s.override
+	}
+	return adaptive
+}
+
+// setNextUpdate records when the update loop runs the next periodic update.
+func (r *reconciler) setNextUpdate(interval time.Duration) {
+	r.stats.mu.Lock()
+	defer r.stats.mu.Unlock()
+	r.stats.schedule.interval = interval
+	r.stats.schedule.nextUpdate = time.Now().Add(interval)
+}
+
+// scheduleStatus returns the schedule section of the status document. It
+// expects r.stats.mu to be held.
+func (r *reconciler) scheduleStatus() scheduleStatus {
+	s := r.stats.schedule
+	interval := s.interval
+	if interval == 0 {
+		interval = r.updateInterval
+	}
+	status := scheduleStatus{
+		Paused:        s.paused,
+		PausedUntil:   s.pausedUntil,
+		Interval:      interval.String(),
+		OverrideUntil: s.overrideUntil,
+	}
+	if s.override > 0 {
+		status.Override = s.override.String()
+	}
+	return status
+}
+
+// serveSchedule handles the pause, resume and interval endpoints. Changing
+// the schedule requires admin credentials to be configured.
+func (u *UniFiDNS) serveSchedule(rw http.ResponseWriter, req *http.Request, endpoint string) {
+	if req.Method != http.MethodPost {
+		rw.Header().Set("Allow", http.MethodPost)
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if u.adminToken.empty() && u.config.AdminUsername == "" {
+		http.Error(rw, "schedule changes require adminToken or adminUsername", http.StatusForbidden)
+		return
+	}
+
+	duration, err := parseOptionalDuration(req.URL.Query().Get("duration"))
+	if err != nil {
+		http.Error(rw, fmt.Sprintf("invalid duration: %v", err), http.StatusBadRequest)
+		return
+	}
+	switch endpoint {
+	case "/pause":
+		u.pause(duration)
+	case "/resume":
+		u.resume()
+	case "/interval":
+		interval, err := parseOptionalDuration(req.URL.Query().Get("interval"))
+		if err != nil {
+			http.Error(rw, fmt.Sprintf("invalid interval: %v", err), http.StatusBadRequest)
+			return
+		}
+		u.overrideInterval(interval, duration)
+	}
+
+	u.stats.mu.Lock()
+	status := u.scheduleStatus()
+	u.stats.mu.Unlock()
+	rw.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(status); err != nil {
+		log.Printf("ERROR: Failed to encode schedule: %v", err)
+	}
+}
+
+// parseOptionalDuration parses a positive duration, or returns zero for an
+// empty value.
+func parseOptionalDuration(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("must be positive")
+	}
+	return d, nil
+}
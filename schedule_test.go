@@ -0,0 +1,82 @@
+package traefikunifidns
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminSchedule(t *testing.T) {
+	config := CreateConfig()
+	config.AdminPath = "/.unifidns"
+	u := newTestAdminPlugin(config)
+	u.updateInterval = 5 * time.Minute
+
+	// Without credentials anyone could stop the updates
+	w := httptest.NewRecorder()
+	u.ServeHTTP(w, httptest.NewRequest("POST", "/.unifidns/pause", nil))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.False(t, u.paused())
+
+	config.AdminToken = "secret-token"
+	u = newTestAdminPlugin(config)
+	u.updateInterval = 5 * time.Minute
+	request := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer secret-token")
+		w := httptest.NewRecorder()
+		u.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusMethodNotAllowed, request("GET", "/.unifidns/pause").Code)
+	assert.Equal(t, http.StatusBadRequest, request("POST", "/.unifidns/pause?duration=soon").Code)
+	assert.Equal(t, http.StatusBadRequest, request("POST", "/.unifidns/interval?interval=-1m").Code)
+
+	w = request("POST", "/.unifidns/pause?duration=1h")
+	require.Equal(t, http.StatusOK, w.Code)
+	var schedule scheduleStatus
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&schedule))
+	assert.True(t, schedule.Paused)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), schedule.PausedUntil, time.Minute)
+	assert.True(t, u.paused())
+	assert.True(t, u.status().NextUpdate.IsZero(), "no update is scheduled while paused")
+
+	require.Equal(t, http.StatusOK, request("POST", "/.unifidns/resume").Code)
+	assert.False(t, u.paused())
+
+	w = request("POST", "/.unifidns/interval?interval=30m")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&schedule))
+	assert.Equal(t, "30m0s", schedule.Override)
+	assert.Equal(t, 30*time.Minute, u.scheduledInterval(u.updateInterval))
+
+	// An interval without a value restores the configured one
+	require.Equal(t, http.StatusOK, request("POST", "/.unifidns/interval").Code)
+	assert.Equal(t, 5*time.Minute, u.scheduledInterval(u.updateInterval))
+}
+
+func TestScheduleExpires(t *testing.T) {
+	u := newTestAdminPlugin(CreateConfig())
+	u.updateInterval = time.Minute
+
+	u.pause(time.Hour)
+	u.overrideInterval(time.Hour, time.Hour)
+	assert.True(t, u.paused())
+	assert.Equal(t, time.Hour, u.scheduledInterval(time.Minute))
+
+	u.stats.schedule.pausedUntil = time.Now().Add(-time.Second)
+	u.stats.schedule.overrideUntil = time.Now().Add(-time.Second)
+	assert.False(t, u.paused())
+	assert.Equal(t, 2*time.Minute, u.scheduledInterval(2*time.Minute), "the adaptive interval applies again")
+
+	// A pause without a duration lasts until resumed
+	u.pause(0)
+	assert.True(t, u.paused())
+	assert.True(t, u.stats.schedule.pausedUntil.IsZero())
+}
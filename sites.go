@@ -0,0 +1,127 @@
+package traefikunifidns
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// siteAuto detects the site of controllers with a single site.
+const siteAuto = "auto"
+
+// Site is a site of a UniFi controller. Name is the identifier used in API
+// paths, Description the name shown in the UI.
+type Site struct {
+	Name        string `json:"name"`
+	Description string `json:"desc"`
+}
+
+func (s Site) String() string {
+	if s.Description == "" || s.Description == s.Name {
+		return s.Name
+	}
+	return fmt.Sprintf("%s (%s)", s.Name, s.Description)
+}
+
+// GetSites lists the sites of the controller.
+func (c *UniFiClient) GetSites(ctx context.Context) ([]Site, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.baseURL+c.apiPaths().sites, nil)
+	if err != nil {
+		logError("Failed to create sites request: %v", err)
+		return nil, fmt.Errorf("failed to create sites request: %w", err)
+	}
+	if err := c.authorize(req); err != nil {
+		return nil, fmt.Errorf("failed to login before getting sites: %w", err)
+	}
+
+	resp, err := c.do(req, endpointSites)
+	if err != nil {
+		logError("Failed to send sites request: %v", err)
+		return nil, fmt.Errorf("failed to send sites request: %w", err)
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			logError("Failed to close response body: %v", closeErr)
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		logError("Failed to get sites with status code: %d", resp.StatusCode)
+		return nil, newStatusError(resp.StatusCode, "failed to get sites with status: %d")
+	}
+
+	var sites struct {
+		Data []Site `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&sites); err != nil {
+		logError("Failed to decode sites response: %v", err)
+		return nil, fmt.Errorf("failed to decode sites response: %w", err)
+	}
+	return sites.Data, nil
+}
+
+// setSite makes the client check site against the sites of the controller on
+// first use, or detect it with "auto", and expand templates with it.
+func (c *UniFiClient) setSite(site string, templates APIPaths) {
+	c.site = site
+	c.templates = templates
+}
+
+// resolveSite checks the configured site, or detects it, the first time the
+// client needs it, and expands the API paths with it. Failures are retried on
+// the next call.
+func (c *UniFiClient) resolveSite(ctx context.Context) error {
+	c.mu.Lock()
+	done := c.site == "" || c.siteChecked
+	c.mu.Unlock()
+	if done {
+		return nil
+	}
+
+	sites, err := c.GetSites(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to check site %q: %w", c.site, err)
+	}
+	site, err := selectSite(c.site, sites)
+	if err != nil {
+		logError("%s: %v", c.baseURL, err)
+		return err
+	}
+	paths, err := expandAPIPaths(c.templates, site.Name)
+	if err != nil {
+		return err
+	}
+	log.Printf("INFO: Using site %s on %s", site, c.baseURL)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.paths = paths
+	c.siteChecked = true
+	return nil
+}
+
+// selectSite returns the configured site, matched by name or description, or
+// with "auto" the only site of the controller.
+func selectSite(configured string, sites []Site) (Site, error) {
+	names := make([]string, len(sites))
+	for i, site := range sites {
+		names[i] = site.String()
+	}
+	available := strings.Join(names, ", ")
+
+	if configured == siteAuto {
+		if len(sites) != 1 {
+			return Site{}, fmt.Errorf("cannot detect the site: the controller has %d sites (%s), set site to one of them", len(sites), available)
+		}
+		return sites[0], nil
+	}
+	for _, site := range sites {
+		if site.Name == configured || strings.EqualFold(site.Description, configured) {
+			return site, nil
+		}
+	}
+	return Site{}, fmt.Errorf("site %q does not exist, available sites: %s", configured, available)
+}
@@ -0,0 +1,88 @@
+package traefikunifidns
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelectSite(t *testing.T) {
+	sites := []Site{{Name: "default", Description: "Default"}, {Name: "ab12cd34", Description: "Lab"}}
+
+	site, err := selectSite("ab12cd34", sites)
+	require.NoError(t, err)
+	assert.Equal(t, "ab12cd34", site.Name)
+	site, err = selectSite("lab", sites)
+	require.NoError(t, err)
+	assert.Equal(t, "ab12cd34", site.Name, "matched by description")
+
+	_, err = selectSite("office", sites)
+	assert.EqualError(t, err, `site "office" does not exist, available sites: default (Default), ab12cd34 (Lab)`)
+	_, err = selectSite(siteAuto, sites)
+	assert.EqualError(t, err, "cannot detect the site: the controller has 2 sites (default (Default), ab12cd34 (Lab)), set site to one of them")
+
+	site, err = selectSite(siteAuto, sites[1:])
+	require.NoError(t, err)
+	assert.Equal(t, "ab12cd34", site.Name)
+}
+
+func newTestSitesServer(t *testing.T, sites []Site, paths *[]string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*paths = append(*paths, r.URL.Path)
+		switch r.URL.Path {
+		case "/api/auth/login":
+			w.Header().Set("X-Csrf-Token", "token")
+		case "/proxy/network/api/self/sites":
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": sites})
+		case "/proxy/network/v2/api/site/ab12cd34/static-dns":
+			_ = json.NewEncoder(w).Encode([]DNSEntry{})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestClientSite(t *testing.T) {
+	var paths []string
+	server := newTestSitesServer(t, []Site{{Name: "default", Description: "Default"}, {Name: "ab12cd34", Description: "Lab"}}, &paths)
+
+	client := NewUniFiClient(server.URL, "admin", "password", false)
+	client.setSite("Lab", APIPaths{})
+	_, err := client.GetStaticDNSEntries(context.Background())
+	require.NoError(t, err)
+	_, err = client.GetStaticDNSEntries(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"/api/auth/login",
+		"/proxy/network/api/self/sites",
+		"/proxy/network/v2/api/site/ab12cd34/static-dns",
+		"/proxy/network/v2/api/site/ab12cd34/static-dns",
+	}, paths, "the site is checked once")
+
+	// Missing sites fail clearly instead of writing to the default site
+	client = NewUniFiClient(server.URL, "admin", "password", false)
+	client.setSite("office", APIPaths{})
+	_, err = client.GetStaticDNSEntries(context.Background())
+	assert.EqualError(t, err, `site "office" does not exist, available sites: default (Default), ab12cd34 (Lab)`)
+	_, err = client.updateDNSRecord(context.Background(), "app.lan", "192.168.1.10", 0)
+	assert.ErrorContains(t, err, `site "office" does not exist`)
+}
+
+func TestClientSiteAuto(t *testing.T) {
+	var paths []string
+	server := newTestSitesServer(t, []Site{{Name: "ab12cd34", Description: "Lab"}}, &paths)
+
+	client := NewUniFiClient(server.URL, "admin", "password", false)
+	client.setSite(siteAuto, APIPaths{})
+	_, err := client.GetStaticDNSEntries(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "/proxy/network/v2/api/site/ab12cd34/static-dns", client.apiPaths().staticDNS)
+}
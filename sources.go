@@ -0,0 +1,372 @@
+package traefikunifidns
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Hostname source types.
+const (
+	sourceTraefik    = "traefik"
+	sourceStatic     = "static"
+	sourceFile       = "file"
+	sourceDocker     = "docker"
+	sourceKubernetes = "kubernetes"
+)
+
+const (
+	defaultDockerEndpoint     = "unix:///var/run/docker.sock"
+	defaultKubernetesEndpoint = "https://kubernetes.default.svc"
+	kubernetesTokenFile       = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	kubernetesCAFile          = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+	sourceTimeout             = 10 * time.Second
+)
+
+// SourceConfig configures a source of hostnames. Sources are listed in order
+// of precedence: a hostname reported by several sources is published with
+// the router of the first one.
+type SourceConfig struct {
+	Type      string   `json:"type"`                // "traefik", "static", "file", "docker" or "kubernetes"
+	Hostnames []string `json:"hostnames,omitempty"` // Hostnames of a static source
+	Path      string   `json:"path,omitempty"`      // File with one hostname per line
+	Endpoint  string   `json:"endpoint,omitempty"`  // Docker or Kubernetes API, defaults to the local socket or in-cluster API
+}
+
+// HostnameSource supplies the routers whose hostnames are published. Sources
+// other than the Traefik API describe every hostname as a router with a
+// Host rule.
+type HostnameSource interface {
+	Routers(ctx context.Context) ([]TraefikRouter, error)
+	String() string
+}
+
+// newHostnameSource creates the source configured by config. A Traefik
+// source also reads the TCP routers with tcpRouters.
+func newHostnameSource(config SourceConfig, traefikClient *TraefikClient, tcpRouters bool) (HostnameSource, error) {
+	switch config.Type {
+	case sourceTraefik:
+		return &traefikSource{client: traefikClient, tcpRouters: tcpRouters}, nil
+	case sourceStatic:
+		if len(config.Hostnames) == 0 {
+			return nil, fmt.Errorf("static source without hostnames")
+		}
+		return staticSource(config.Hostnames), nil
+	case sourceFile:
+		if config.Path == "" {
+			return nil, fmt.Errorf("file source without a path")
+		}
+		return fileSource(config.Path), nil
+	case sourceDocker:
+		return newDockerSource(config.Endpoint)
+	case sourceKubernetes:
+		return newKubernetesSource(config.Endpoint)
+	}
+	return nil, fmt.Errorf("unknown source type %q", config.Type)
+}
+
+// hostRouter describes hostname as a router with a Host rule.
+func hostRouter(name, hostname string) TraefikRouter {
+	return TraefikRouter{Name: name, Rule: fmt.Sprintf("Host(`%s`)", hostname)}
+}
+
+// traefikSource reads the routers using the middleware from the Traefik API,
+// followed by the TCP routers with tcpRouters.
+type traefikSource struct {
+	client     *TraefikClient
+	tcpRouters bool
+}
+
+func (s *traefikSource) Routers(ctx context.Context) ([]TraefikRouter, error) {
+	routers, err := s.client.GetRouters(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Traefik routers: %w", err)
+	}
+	if !s.tcpRouters {
+		return routers, nil
+	}
+	tcpRouters, err := s.client.GetTCPRouters(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Traefik routers: %w", err)
+	}
+	return append(routers, tcpRouters...), nil
+}
+
+func (s *traefikSource) String() string {
+	return "Traefik API"
+}
+
+// staticSource publishes a fixed list of hostnames.
+type staticSource []string
+
+func (s staticSource) Routers(context.Context) ([]TraefikRouter, error) {
+	routers := make([]TraefikRouter, 0, len(s))
+	for _, hostname := range s {
+		routers = append(routers, hostRouter(hostname+"@static", hostname))
+	}
+	return routers, nil
+}
+
+func (s staticSource) String() string {
+	return "static list"
+}
+
+// fileSource publishes the hostnames listed in a file, one per line. Empty
+// lines and lines starting with "#" are ignored. The file is read every
+// cycle, so it can be changed without restarting Traefik.
+type fileSource string
+
+func (s fileSource) Routers(context.Context) ([]TraefikRouter, error) {
+	f, err := os.Open(string(s))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read hostnames: %w", err)
+	}
+	defer f.Close()
+
+	var routers []TraefikRouter
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		hostname := strings.TrimSpace(scanner.Text())
+		if hostname == "" || strings.HasPrefix(hostname, "#") {
+			continue
+		}
+		routers = append(routers, hostRouter(hostname+"@file", hostname))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read hostnames: %w", err)
+	}
+	return routers, nil
+}
+
+func (s fileSource) String() string {
+	return "file " + string(s)
+}
+
+// dockerSource reads the Traefik router labels of running containers from
+// the Docker Engine API, for containers Traefik itself doesn't see.
+type dockerSource struct {
+	baseURL string
+	client  *http.Client
+}
+
+// newDockerSource connects to endpoint, either a unix:// socket or an
+// http:// URL.
+func newDockerSource(endpoint string) (*dockerSource, error) {
+	if endpoint == "" {
+		endpoint = defaultDockerEndpoint
+	}
+	s := &dockerSource{baseURL: endpoint, client: &http.Client{Timeout: sourceTimeout}}
+	switch {
+	case strings.HasPrefix(endpoint, "unix://"):
+		socket := strings.TrimPrefix(endpoint, "unix://")
+		s.baseURL = "http://docker"
+		s.client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		}
+	case strings.HasPrefix(endpoint, "http://"), strings.HasPrefix(endpoint, "https://"):
+	default:
+		return nil, fmt.Errorf("unsupported Docker endpoint %q", endpoint)
+	}
+	return s, nil
+}
+
+// dockerContainer is the part of a container listed by the Docker API the
+// source needs.
+type dockerContainer struct {
+	Labels map[string]string `json:"Labels"`
+}
+
+func (s *dockerSource) Routers(ctx context.Context) ([]TraefikRouter, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.baseURL+"/containers/json", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Docker containers: %w", err)
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			logError("Failed to close response body: %v", closeErr)
+		}
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to list Docker containers with status: %d", resp.StatusCode)
+	}
+	var containers []dockerContainer
+	if err := json.NewDecoder(resp.Body).Decode(&containers); err != nil {
+		return nil, fmt.Errorf("failed to decode Docker containers: %w", err)
+	}
+
+	var routers []TraefikRouter
+	for _, container := range containers {
+		routers = append(routers, labelRouters(container.Labels)...)
+	}
+	return routers, nil
+}
+
+// labelRouters returns the routers defined by the traefik.http.routers
+// labels of a container, sorted by name.
+func labelRouters(labels map[string]string) []TraefikRouter {
+	if labels["traefik.enable"] == "false" {
+		return nil
+	}
+	const prefix = "traefik.http.routers."
+	var routers []TraefikRouter
+	for label, rule := range labels {
+		if !strings.HasPrefix(label, prefix) || !strings.HasSuffix(label, ".rule") {
+			continue
+		}
+		name := strings.TrimSuffix(strings.TrimPrefix(label, prefix), ".rule")
+		router := TraefikRouter{Name: name + "@docker", Rule: rule}
+		if entryPoints := labels[prefix+name+".entrypoints"]; entryPoints != "" {
+			router.EntryPoints = strings.Split(entryPoints, ",")
+		}
+		routers = append(routers, router)
+	}
+	sort.Slice(routers, func(i, j int) bool { return routers[i].Name < routers[j].Name })
+	return routers
+}
+
+func (s *dockerSource) String() string {
+	return "Docker"
+}
+
+// kubernetesSource reads the hosts of Ingress resources from the Kubernetes
+// API, authenticating with the pod's service account when running in a
+// cluster.
+type kubernetesSource struct {
+	baseURL   string
+	tokenFile string
+	client    *http.Client
+}
+
+func newKubernetesSource(endpoint string) (*kubernetesSource, error) {
+	s := &kubernetesSource{baseURL: strings.TrimSuffix(endpoint, "/"), client: &http.Client{Timeout: sourceTimeout}}
+	if endpoint != "" {
+		return s, nil
+	}
+	s.baseURL = defaultKubernetesEndpoint
+	s.tokenFile = kubernetesTokenFile
+	ca, err := os.ReadFile(kubernetesCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("not running in a Kubernetes cluster, set an endpoint: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificates in %s", kubernetesCAFile)
+	}
+	s.client.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}
+	return s, nil
+}
+
+// kubernetesIngressList is the part of an Ingress list the source needs.
+type kubernetesIngressList struct {
+	Items []struct {
+		Metadata struct {
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+		} `json:"metadata"`
+		Spec struct {
+			Rules []struct {
+				Host string `json:"host"`
+			} `json:"rules"`
+		} `json:"spec"`
+	} `json:"items"`
+}
+
+func (s *kubernetesSource) Routers(ctx context.Context) ([]TraefikRouter, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.baseURL+"/apis/networking.k8s.io/v1/ingresses", nil)
+	if err != nil {
+		return nil, err
+	}
+	if s.tokenFile != "" {
+		// Service account tokens are rotated, so read it for every request
+		token, err := os.ReadFile(s.tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read service account token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Kubernetes ingresses: %w", err)
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			logError("Failed to close response body: %v", closeErr)
+		}
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to list Kubernetes ingresses with status: %d", resp.StatusCode)
+	}
+	var list kubernetesIngressList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("failed to decode Kubernetes ingresses: %w", err)
+	}
+
+	var routers []TraefikRouter
+	for _, item := range list.Items {
+		name := fmt.Sprintf("%s-%s@kubernetes", item.Metadata.Namespace, item.Metadata.Name)
+		for _, rule := range item.Spec.Rules {
+			// Rules without a host match every request and publish nothing
+			if rule.Host != "" && !strings.HasPrefix(rule.Host, "*") {
+				routers = append(routers, hostRouter(name, rule.Host))
+			}
+		}
+	}
+	return routers, nil
+}
+
+func (s *kubernetesSource) String() string {
+	return "Kubernetes"
+}
+
+// hostnameSources returns the configured sources, or the Traefik API alone.
+func (r *reconciler) hostnameSources() []HostnameSource {
+	if len(r.sources) > 0 {
+		return r.sources
+	}
+	return []HostnameSource{&traefikSource{client: r.traefikClient, tcpRouters: r.config.TCPRouters}}
+}
+
+// collectHostnames reads every hostname source in order of precedence. A
+// hostname reported by several sources is kept from the first of them only,
+// and hostnames left out by includeHostnames or excludeHostnames are dropped.
+// Any failing source fails the whole collection, so the records of its
+// hostnames aren't removed as stale.
+func (r *reconciler) collectHostnames(ctx context.Context) ([]routerHostname, error) {
+	var pending []routerHostname
+	claimed := make(map[string]int) // Index of the source each hostname was taken from
+	for i, source := range r.hostnameSources() {
+		routers, err := source.Routers(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range routerHostnames(routers) {
+			if !r.hostnames.allows(p.hostname) {
+				log.Printf("INFO: Skipping hostname %s excluded by includeHostnames or excludeHostnames", p.hostname)
+				continue
+			}
+			if owner, ok := claimed[p.hostname]; ok && owner != i {
+				continue
+			}
+			claimed[p.hostname] = i
+			pending = append(pending, p)
+		}
+	}
+	return pending, nil
+}
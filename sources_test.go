@@ -0,0 +1,154 @@
+package traefikunifidns
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewHostnameSource(t *testing.T) {
+	for _, tc := range []struct {
+		config  SourceConfig
+		wantErr string
+	}{
+		{config: SourceConfig{Type: "static"}, wantErr: "static source without hostnames"},
+		{config: SourceConfig{Type: "file"}, wantErr: "file source without a path"},
+		{config: SourceConfig{Type: "docker", Endpoint: "tcp://docker:2375"}, wantErr: `unsupported Docker endpoint "tcp://docker:2375"`},
+		{config: SourceConfig{Type: "consul"}, wantErr: `unknown source type "consul"`},
+	} {
+		_, err := newHostnameSource(tc.config, nil, false)
+		assert.EqualError(t, err, tc.wantErr)
+	}
+
+	config := CreateConfig()
+	config.Sources = []SourceConfig{{Type: "traefik"}, {Type: "static"}}
+	_, err := New(context.Background(), nil, config, "test")
+	assert.EqualError(t, err, "invalid source 1: static source without hostnames")
+}
+
+func TestFileSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hostnames.txt")
+	require.NoError(t, os.WriteFile(path, []byte("# LAN services\napp.lan\n\n  nas.lan  \n"), 0o600))
+
+	routers, err := fileSource(path).Routers(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []TraefikRouter{
+		{Name: "app.lan@file", Rule: "Host(`app.lan`)"},
+		{Name: "nas.lan@file", Rule: "Host(`nas.lan`)"},
+	}, routers)
+
+	_, err = fileSource(filepath.Join(t.TempDir(), "missing.txt")).Routers(context.Background())
+	assert.Error(t, err)
+}
+
+func TestLabelRouters(t *testing.T) {
+	routers := labelRouters(map[string]string{
+		"traefik.http.routers.web.rule":                      "Host(`web.lan`)",
+		"traefik.http.routers.web.entrypoints":               "web,websecure",
+		"traefik.http.routers.api.rule":                      "Host(`api.lan`) && PathPrefix(`/v1`)",
+		"traefik.http.services.web.loadbalancer.server.port": "80",
+	})
+	assert.Equal(t, []TraefikRouter{
+		{Name: "api@docker", Rule: "Host(`api.lan`) && PathPrefix(`/v1`)"},
+		{Name: "web@docker", Rule: "Host(`web.lan`)", EntryPoints: []string{"web", "websecure"}},
+	}, routers)
+
+	assert.Empty(t, labelRouters(map[string]string{
+		"traefik.enable":                "false",
+		"traefik.http.routers.web.rule": "Host(`web.lan`)",
+	}))
+}
+
+func TestDockerSourceSocket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/containers/json", r.URL.Path)
+		_ = json.NewEncoder(w).Encode([]dockerContainer{
+			{Labels: map[string]string{"traefik.http.routers.app.rule": "Host(`app.lan`)"}},
+			{Labels: map[string]string{"com.example.role": "database"}},
+		})
+	})}
+	go func() { _ = server.Serve(listener) }()
+	defer server.Close()
+
+	source, err := newDockerSource("unix://" + socket)
+	require.NoError(t, err)
+	routers, err := source.Routers(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []TraefikRouter{{Name: "app@docker", Rule: "Host(`app.lan`)"}}, routers)
+}
+
+func TestKubernetesSource(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/apis/networking.k8s.io/v1/ingresses", r.URL.Path)
+		_, _ = w.Write([]byte(`{"items":[
+			{"metadata":{"name":"grafana","namespace":"monitoring"},"spec":{"rules":[{"host":"grafana.lan"},{"host":"*.grafana.lan"},{}]}}
+		]}`))
+	}))
+	defer server.Close()
+
+	source, err := newKubernetesSource(server.URL)
+	require.NoError(t, err)
+	routers, err := source.Routers(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []TraefikRouter{{Name: "monitoring-grafana@kubernetes", Rule: "Host(`grafana.lan`)"}}, routers)
+}
+
+func TestTraefikSourceTCPRouters(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/http/routers":
+			_, _ = w.Write([]byte(`[{"name": "app", "rule": "Host(` + "`app.lan`" + `)", "middlewares": ["traefikunifidns"]}]`))
+		case "/api/tcp/routers":
+			_, _ = w.Write([]byte(`[{"name": "postgres", "rule": "HostSNI(` + "`db.lan`" + `)"}]`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	client := NewTraefikClient(server.URL, false)
+
+	routers, err := (&traefikSource{client: client}).Routers(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, routers, 1, "TCP routers are only read with tcpRouters")
+
+	routers, err = (&traefikSource{client: client, tcpRouters: true}).Routers(context.Background())
+	require.NoError(t, err)
+	var hostnames []string
+	for _, p := range routerHostnames(routers) {
+		hostnames = append(hostnames, p.hostname)
+	}
+	assert.Equal(t, []string{"app.lan", "db.lan"}, hostnames)
+}
+
+func TestCollectHostnames(t *testing.T) {
+	traefikServer := newTestTraefikServer(t, []map[string]interface{}{
+		{"name": "app", "rule": "Host(`app.lan`)", "service": "app", "middlewares": []string{"traefikunifidns"}},
+		{"name": "app-tls", "rule": "Host(`app.lan`)", "service": "app", "middlewares": []string{"traefikunifidns"}},
+		{"name": "wiki", "rule": "Host(`wiki.lan`)", "service": "wiki", "middlewares": []string{"traefikunifidns"}},
+	})
+	traefik := &traefikSource{client: NewTraefikClient(traefikServer.URL, false)}
+	r := &reconciler{sources: []HostnameSource{staticSource{"wiki.lan", "nas.lan"}, traefik}}
+
+	pending, err := r.collectHostnames(context.Background())
+	require.NoError(t, err)
+	var names []string
+	for _, p := range pending {
+		names = append(names, p.router.Name)
+	}
+	assert.Equal(t, []string{"wiki.lan@static", "nas.lan@static", "app", "app-tls"}, names, "earlier sources take precedence, a source keeps all its routers")
+
+	r.sources = append(r.sources, fileSource(filepath.Join(t.TempDir(), "missing.txt")))
+	_, err = r.collectHostnames(context.Background())
+	assert.Error(t, err, "a failing source fails the collection")
+}
@@ -0,0 +1,239 @@
+package traefikunifidns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+)
+
+var srvLabelInvalid = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// srvRecord is the SRV record advertising the service of a router.
+type srvRecord struct {
+	name   string // e.g. _homeassistant._tcp.ha.lan
+	target string
+	port   int
+}
+
+// srvRecordFor returns the name and port of the SRV record advertising the
+// service of router at hostname. Clients reach the service through Traefik,
+// so the port is that of the first entrypoint of the router with a known
+// port; ok is false without one, and for services without servers, such as
+// the internal ones of Traefik.
+func srvRecordFor(hostname string, router TraefikRouter, service TraefikService, ports map[string]int) (name string, port int, ok bool) {
+	if len(service.Servers) == 0 {
+		return "", 0, false
+	}
+	for _, entryPoint := range router.EntryPoints {
+		if port = ports[entryPoint]; port > 0 {
+			break
+		}
+	}
+	if port == 0 {
+		return "", 0, false
+	}
+
+	base, _, _ := strings.Cut(service.Name, "@")
+	label := strings.Trim(srvLabelInvalid.ReplaceAllString(strings.ToLower(base), "-"), "-")
+	if label == "" {
+		return "", 0, false
+	}
+	return "_" + label + "._tcp." + hostname, port, true
+}
+
+// planSRV returns the SRV record published for the service of router next to
+// the record name on the provider of device, pointing at name itself. Only
+// UniFi devices support SRV records, and services is nil without srvRecords.
+// CNAME records get none, as an SRV target must not be an alias.
+func (r *reconciler) planSRV(device int, name string, router TraefikRouter, services []TraefikService, ports map[string]int) (srvRecord, bool) {
+	provider := r.providers[fmt.Sprintf("device-%d", device)]
+	if _, ok := provider.(*UniFiClient); !ok || services == nil || router.Service == "" || r.cnameTarget(name, device) != "" {
+		return srvRecord{}, false
+	}
+	service, found := findService(services, router.Service)
+	if !found {
+		log.Printf("WARN: Service %s of %s not found, skipping SRV record", router.Service, name)
+		return srvRecord{}, false
+	}
+	srvName, port, ok := srvRecordFor(name, router, service, ports)
+	if !ok {
+		log.Printf("INFO: Router of %s has no entrypoint with a known port, skipping SRV record", name)
+		return srvRecord{}, false
+	}
+	return srvRecord{name: srvName, target: name, port: port}, true
+}
+
+// publishSRV publishes the SRV record of job to its UniFi device and returns
+// the outcome, applying the same circuit breaker, dry run, ownership and
+// change quota checks as publishRecord.
+func (r *reconciler) publishSRV(ctx context.Context, job publishJob) hostResult {
+	provider := r.providers[fmt.Sprintf("device-%d", job.device)]
+	client := provider.(*UniFiClient)
+	srv := job.srv
+	result := hostResult{
+		Hostname: srv.name,
+		Device:   provider.String(),
+		Action:   resultSkipped,
+		Type:     "SRV",
+		Value:    fmt.Sprintf("%s:%d", srv.target, srv.port),
+	}
+
+	stats := provider.health()
+	if !stats.allow() {
+		log.Printf("WARN: Skipping %s: circuit breaker for %s is open", srv.name, provider)
+		result.Reason = "circuit breaker open"
+		return result
+	}
+
+	if r.config.Devices[job.device].DryRun {
+		action, err := client.plannedSRVAction(ctx, srv.name, srv.target, srv.port)
+		if err != nil {
+			logError("Failed to plan SRV record %s: %v", srv.name, err)
+			stats.recordFailure(err)
+			result.Action, result.Reason, result.err = resultFailed, err.Error(), err
+			return result
+		}
+		stats.recordSuccess()
+		log.Printf("INFO: DRY RUN: SRV record %s pointing at %s on %s would be %s", srv.name, result.Value, provider, action)
+		result.Reason = "dry run, would be " + action
+		return result
+	}
+
+	if r.registry != nil {
+		claimed, err := r.registry.claim(ctx, client, srv.name, "SRV")
+		if errors.Is(err, errReadOnly) {
+			log.Printf("WARN: Ownership of %s cannot be claimed, %s is read-only", srv.name, provider)
+			result.Reason = "read-only, not owned"
+			return result
+		}
+		if err != nil {
+			logError("Failed to check ownership of %s: %v", srv.name, err)
+			stats.recordFailure(err)
+			result.Action, result.Reason, result.err = resultFailed, err.Error(), err
+			return result
+		}
+		if !claimed {
+			result.Reason = "not owned"
+			return result
+		}
+	}
+
+	reserved, ok := r.reserveChange(srv.name, func() bool {
+		action, err := client.plannedSRVAction(ctx, srv.name, srv.target, srv.port)
+		return err == nil && action == recordUnchanged
+	})
+	if !ok {
+		result.Reason = reasonChangeQuota
+		return result
+	}
+	result.reserved = reserved
+
+	action, err := client.updateSRVRecord(ctx, srv.name, srv.target, srv.port)
+	if errors.Is(err, errReadOnly) {
+		log.Printf("WARN: SRV record %s differs from the desired state, but %s is read-only", srv.name, provider)
+		stats.recordSuccess()
+		result.Reason = "read-only, record differs"
+		return result
+	}
+	if errors.Is(err, errWriteForbidden) {
+		if stats.setDegraded(true) {
+			log.Printf("WARN: DEGRADED: %s rejects writes, only reporting drift until permissions return: %v", provider, err)
+		}
+		stats.recordSuccess()
+		result.Reason = "degraded, record differs"
+		return result
+	}
+	if err != nil {
+		logError("Failed to update SRV record %s: %v", srv.name, err)
+		stats.recordFailure(err)
+		result.Action, result.Reason, result.err = resultFailed, err.Error(), err
+		return result
+	}
+	stats.recordSuccess()
+	result.Action = action
+	return result
+}
+
+// pruneSRV deletes the SRV records that no router advertises anymore from
+// the UniFi devices: those published since the start of the plugin, and with
+// ownerId those owned by this plugin. active holds the record names of the
+// cycle by provider. Like pruning, it stops with errChangeQuota rather than
+// exceed maxChangesPerCycle. It expects r.mu to be held.
+func (r *reconciler) pruneSRV(ctx context.Context, active map[dnsProvider]map[string]bool) error {
+	for id, provider := range r.providers {
+		client, ok := provider.(*UniFiClient)
+		if !ok || r.dryRun(id) || lacksStaticDNS(provider) || provider.health().circuitState() != circuitClosed {
+			continue
+		}
+		deleted, err := r.deleteStaleSRV(ctx, client, active[provider])
+		for _, name := range deleted {
+			r.results = append(r.results, hostResult{Hostname: name, Device: provider.String(), Action: resultDeleted, Type: "SRV"})
+		}
+		r.changes += len(deleted)
+		if errors.Is(err, errChangeQuota) {
+			logError("ALERT: Aborting DNS update cycle: %s: %v", provider, err)
+			return err
+		}
+		if err != nil {
+			logError("Failed to remove stale SRV records from %s: %v", provider, err)
+			provider.health().recordFailure(err)
+		}
+	}
+	return nil
+}
+
+// deleteStaleSRV deletes the SRV records of client that are not in keep and
+// were published or are owned by this plugin, returning their names.
+func (r *reconciler) deleteStaleSRV(ctx context.Context, client *UniFiClient, keep map[string]bool) ([]string, error) {
+	published := r.srvRecords[client]
+	if len(published) == 0 && r.registry == nil {
+		return nil, nil
+	}
+	entries, err := client.GetStaticDNSEntries(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get DNS entries before removing SRV records: %w", err)
+	}
+
+	var stale []DNSEntry
+	for _, entry := range entries {
+		if entry.RecordType != "SRV" || keep[entry.Key] {
+			continue
+		}
+		if published[entry.Key] {
+			stale = append(stale, entry)
+		} else if r.registry != nil {
+			if owner, ok := r.registry.owner(entries, entry.Key); ok && owner == r.registry.ownerID {
+				stale = append(stale, entry)
+			}
+		}
+	}
+	if limit := r.pruneLimit(); limit >= 0 && len(stale) > limit {
+		return nil, fmt.Errorf("%w: %d stale SRV records, %d deletions left", errChangeQuota, len(stale), limit)
+	}
+
+	var deleted []string
+	for _, entry := range stale {
+		log.Printf("INFO: Deleting SRV record %s, no router advertises it anymore", entry.Key)
+		if err := client.deleteDNSEntry(ctx, entry.ID); err != nil {
+			return deleted, err
+		}
+		delete(published, entry.Key)
+		deleted = append(deleted, entry.Key)
+	}
+	return deleted, nil
+}
+
+// rememberSRV records that the SRV record name was published to provider,
+// so it is deleted once its router disappears. It expects r.mu to be held.
+func (r *reconciler) rememberSRV(provider dnsProvider, name string) {
+	if r.srvRecords == nil {
+		r.srvRecords = make(map[dnsProvider]map[string]bool)
+	}
+	if r.srvRecords[provider] == nil {
+		r.srvRecords[provider] = make(map[string]bool)
+	}
+	r.srvRecords[provider][name] = true
+}
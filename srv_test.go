@@ -0,0 +1,212 @@
+package traefikunifidns
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSRVRecordFor(t *testing.T) {
+	ports := map[string]int{"web": 80, "websecure": 443}
+	tests := []struct {
+		name    string
+		router  TraefikRouter
+		service TraefikService
+		srvName string
+		port    int
+		ok      bool
+	}{
+		{
+			name:    "entrypoint port",
+			router:  TraefikRouter{EntryPoints: []string{"websecure"}},
+			service: TraefikService{Name: "Home_Assistant@docker", Servers: []string{"http://192.168.1.30:8123"}},
+			srvName: "_home-assistant._tcp.ha.lan", port: 443, ok: true,
+		},
+		{
+			name:    "first known entrypoint",
+			router:  TraefikRouter{EntryPoints: []string{"udp", "web", "websecure"}},
+			service: TraefikService{Name: "nas@file", Servers: []string{"https://nas.internal"}},
+			srvName: "_nas._tcp.ha.lan", port: 80, ok: true,
+		},
+		{
+			name:    "unknown entrypoint",
+			router:  TraefikRouter{EntryPoints: []string{"udp"}},
+			service: TraefikService{Name: "nas@file", Servers: []string{"https://nas.internal"}},
+		},
+		{name: "no servers", router: TraefikRouter{EntryPoints: []string{"web"}}, service: TraefikService{Name: "api@internal"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srvName, port, ok := srvRecordFor("ha.lan", tt.router, tt.service, ports)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.srvName, srvName)
+			assert.Equal(t, tt.port, port)
+		})
+	}
+}
+
+// newTestSRVTraefikServer serves the routers in *routers together with the
+// services of the ha and api@internal routers.
+func newTestSRVTraefikServer(t *testing.T, routers *[]map[string]interface{}) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var data interface{}
+		switch r.URL.Path {
+		case "/api/http/routers":
+			data = *routers
+		case "/api/entrypoints":
+			data = []map[string]string{{"name": "web", "address": ":80"}, {"name": "websecure", "address": ":443"}}
+		case "/api/http/services":
+			data = []map[string]interface{}{
+				{"name": "ha@docker", "loadBalancer": map[string]interface{}{"servers": []map[string]string{{"url": "http://192.168.1.30:8123"}}}},
+				{"name": "api@internal"},
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		assert.NoError(t, json.NewEncoder(w).Encode(data))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newTestSRVConfig(traefikURL, unifiURL string) *Config {
+	config := CreateConfig()
+	config.TraefikAPIURL = traefikURL
+	config.Devices = []UnifiDeviceConfig{
+		{Host: unifiURL, Username: "admin", Password: "password", Pattern: `\.lan$`},
+	}
+	config.IPOverrides = map[string]string{"ha.lan": "192.168.1.20", "api.lan": "192.168.1.20"}
+	config.SRVRecords = true
+	return config
+}
+
+func findResult(results []hostResult, hostname string) (hostResult, bool) {
+	for _, result := range results {
+		if result.Hostname == hostname {
+			return result, true
+		}
+	}
+	return hostResult{}, false
+}
+
+func TestUpdateDNSSRVRecords(t *testing.T) {
+	routers := []map[string]interface{}{
+		{"name": "ha@docker", "rule": "Host(`ha.lan`)", "service": "ha", "entryPoints": []string{"websecure"}, "middlewares": []string{"traefikunifidns"}},
+		{"name": "api@internal", "rule": "Host(`api.lan`)", "service": "api@internal", "entryPoints": []string{"web"}, "middlewares": []string{"traefikunifidns"}},
+	}
+	traefikServer := newTestSRVTraefikServer(t, &routers)
+	var writes []map[string]interface{}
+	unifiServer := newTestUniFiServer(t, []DNSEntry{}, &writes)
+
+	r, err := newReconciler(newTestSRVConfig(traefikServer.URL, unifiServer.URL))
+	require.NoError(t, err)
+	require.NoError(t, r.updateDNS(context.Background()).Err)
+
+	require.Len(t, writes, 3)
+	assert.Equal(t, "ha.lan", writes[0]["key"])
+	assert.Equal(t, "_ha._tcp.ha.lan", writes[1]["key"])
+	assert.Equal(t, "SRV", writes[1]["record_type"])
+	assert.Equal(t, "ha.lan", writes[1]["value"])
+	assert.Equal(t, float64(443), writes[1]["port"])
+	assert.Equal(t, "api.lan", writes[2]["key"])
+
+	result, ok := findResult(r.results, "_ha._tcp.ha.lan")
+	require.True(t, ok)
+	assert.Equal(t, recordCreated, result.Action)
+	assert.Equal(t, "SRV", result.Type)
+	assert.Equal(t, "ha.lan:443", result.Value)
+}
+
+func TestUpdateDNSSRVRecordsCNAMEMode(t *testing.T) {
+	routers := []map[string]interface{}{
+		{"name": "ha@docker", "rule": "Host(`ha.lan`)", "service": "ha", "entryPoints": []string{"websecure"}, "middlewares": []string{"traefikunifidns"}},
+	}
+	traefikServer := newTestSRVTraefikServer(t, &routers)
+	var writes []map[string]interface{}
+	unifiServer := newTestUniFiServer(t, []DNSEntry{}, &writes)
+
+	config := newTestSRVConfig(traefikServer.URL, unifiServer.URL)
+	config.Devices[0].RecordMode = recordModeCNAME
+	config.Devices[0].CNAMETarget = "traefik.lan"
+	r, err := newReconciler(config)
+	require.NoError(t, err)
+	require.NoError(t, r.updateDNS(context.Background()).Err)
+
+	require.Len(t, writes, 1)
+	assert.Equal(t, "CNAME", writes[0]["record_type"])
+}
+
+func TestUpdateDNSSRVRecordsNotOwned(t *testing.T) {
+	routers := []map[string]interface{}{
+		{"name": "ha@docker", "rule": "Host(`ha.lan`)", "service": "ha", "entryPoints": []string{"websecure"}, "middlewares": []string{"traefikunifidns"}},
+	}
+	traefikServer := newTestSRVTraefikServer(t, &routers)
+	var writes []map[string]interface{}
+	unifiServer := newTestUniFiServer(t, []DNSEntry{
+		{ID: "srv1", Key: "_ha._tcp.ha.lan", RecordType: "SRV", Value: "other.lan", Port: 80},
+	}, &writes)
+
+	config := newTestSRVConfig(traefikServer.URL, unifiServer.URL)
+	config.OwnerID = "traefik"
+	r, err := newReconciler(config)
+	require.NoError(t, err)
+	require.NoError(t, r.updateDNS(context.Background()).Err)
+
+	for _, write := range writes {
+		assert.NotEqual(t, "_ha._tcp.ha.lan", write["key"])
+	}
+	result, ok := findResult(r.results, "_ha._tcp.ha.lan")
+	require.True(t, ok)
+	assert.Equal(t, resultSkipped, result.Action)
+	assert.Equal(t, "not owned", result.Reason)
+}
+
+func TestUpdateDNSSRVRecordsChangeQuota(t *testing.T) {
+	routers := []map[string]interface{}{
+		{"name": "ha@docker", "rule": "Host(`ha.lan`)", "service": "ha", "entryPoints": []string{"websecure"}, "middlewares": []string{"traefikunifidns"}},
+	}
+	traefikServer := newTestSRVTraefikServer(t, &routers)
+	var writes []map[string]interface{}
+	unifiServer := newTestUniFiServer(t, []DNSEntry{}, &writes)
+
+	config := newTestSRVConfig(traefikServer.URL, unifiServer.URL)
+	config.MaxChangesPerCycle = 1
+	r, err := newReconciler(config)
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, r.updateDNS(context.Background()).Err, errChangeQuota)
+	require.Len(t, writes, 1)
+	assert.Equal(t, "ha.lan", writes[0]["key"])
+}
+
+func TestUpdateDNSRemovesStaleSRVRecords(t *testing.T) {
+	routers := []map[string]interface{}{
+		{"name": "ha@docker", "rule": "Host(`ha.lan`)", "service": "ha", "entryPoints": []string{"websecure"}, "middlewares": []string{"traefikunifidns"}},
+	}
+	traefikServer := newTestSRVTraefikServer(t, &routers)
+	var writes []map[string]interface{}
+	unifiServer := newTestUniFiServer(t, []DNSEntry{
+		{ID: "a1", Key: "ha.lan", RecordType: "A", Value: "192.168.1.20"},
+		{ID: "srv1", Key: "_ha._tcp.ha.lan", RecordType: "SRV", Value: "ha.lan", Port: 443},
+	}, &writes)
+
+	r, err := newReconciler(newTestSRVConfig(traefikServer.URL, unifiServer.URL))
+	require.NoError(t, err)
+	require.NoError(t, r.updateDNS(context.Background()).Err)
+	assert.Empty(t, writes)
+
+	routers = nil
+	require.NoError(t, r.updateDNS(context.Background()).Err)
+	assert.Equal(t, []map[string]interface{}{{"deleted": "srv1"}}, writes)
+	result, ok := findResult(r.results, "_ha._tcp.ha.lan")
+	require.True(t, ok)
+	assert.Equal(t, resultDeleted, result.Action)
+}
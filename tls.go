@@ -0,0 +1,66 @@
+package traefikunifidns
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// loadClientCertificate loads the PEM encoded client certificate and key
+// presented to servers requiring mutual TLS. It returns nil without files.
+func loadClientCertificate(certFile, keyFile string) (*tls.Certificate, error) {
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("clientCertFile and clientKeyFile must be set together")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load client certificate: %w", err)
+	}
+	return &cert, nil
+}
+
+// loadCACertPool returns a pool with the PEM encoded CA certificates given
+// inline or in a file, against which server certificates are verified
+// instead of the system roots. It returns nil without certificates.
+func loadCACertPool(caCert, caCertFile string) (*x509.CertPool, error) {
+	if caCert != "" && caCertFile != "" {
+		return nil, fmt.Errorf("caCert and caCertFile can't be set together")
+	}
+	pem := []byte(caCert)
+	if caCertFile != "" {
+		var err error
+		if pem, err = os.ReadFile(caCertFile); err != nil {
+			return nil, fmt.Errorf("failed to read CA certificates: %w", err)
+		}
+	}
+	if len(pem) == 0 {
+		return nil, nil
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no PEM encoded CA certificates found")
+	}
+	return pool, nil
+}
+
+// deviceCACert returns the inline CA certificates and CA file of a device,
+// falling back to the global ones.
+func deviceCACert(config *Config, device UnifiDeviceConfig) (string, string) {
+	if device.CACert != "" || device.CACertFile != "" {
+		return device.CACert, device.CACertFile
+	}
+	return config.CACert, config.CACertFile
+}
+
+// deviceClientCertificateFiles returns the client certificate and key files
+// of a device, falling back to the global ones.
+func deviceClientCertificateFiles(config *Config, device UnifiDeviceConfig) (string, string) {
+	if device.ClientCertFile != "" || device.ClientKeyFile != "" {
+		return device.ClientCertFile, device.ClientKeyFile
+	}
+	return config.ClientCertFile, config.ClientKeyFile
+}
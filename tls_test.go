@@ -0,0 +1,206 @@
+package traefikunifidns
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTestCertificate writes a self-signed certificate and its key to dir.
+func writeTestCertificate(t *testing.T, dir string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "traefikunifidns"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile := filepath.Join(dir, "client.crt")
+	keyFile := filepath.Join(dir, "client.key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return certFile, keyFile
+}
+
+func TestLoadClientCertificate(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t, t.TempDir())
+
+	cert, err := loadClientCertificate("", "")
+	require.NoError(t, err)
+	assert.Nil(t, cert, "no certificate without files")
+
+	_, err = loadClientCertificate(certFile, "")
+	assert.ErrorContains(t, err, "must be set together")
+
+	_, err = loadClientCertificate(certFile, filepath.Join(t.TempDir(), "missing.key"))
+	assert.ErrorContains(t, err, "failed to load client certificate")
+
+	cert, err = loadClientCertificate(certFile, keyFile)
+	require.NoError(t, err)
+	assert.NotNil(t, cert)
+}
+
+func TestDeviceClientCertificateFiles(t *testing.T) {
+	config := &Config{ClientCertFile: "global.crt", ClientKeyFile: "global.key"}
+
+	certFile, keyFile := deviceClientCertificateFiles(config, UnifiDeviceConfig{})
+	assert.Equal(t, "global.crt", certFile)
+	assert.Equal(t, "global.key", keyFile)
+
+	certFile, keyFile = deviceClientCertificateFiles(config, UnifiDeviceConfig{ClientCertFile: "device.crt", ClientKeyFile: "device.key"})
+	assert.Equal(t, "device.crt", certFile)
+	assert.Equal(t, "device.key", keyFile)
+}
+
+func TestMutualTLS(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/http/routers":
+			_, _ = w.Write([]byte("[]"))
+		case "/api/auth/login":
+			w.Header().Set("X-Csrf-Token", "test-csrf-token")
+		default:
+			_, _ = w.Write([]byte(`{"data": []}`))
+		}
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	certFile, keyFile := writeTestCertificate(t, t.TempDir())
+	cert, err := loadClientCertificate(certFile, keyFile)
+	require.NoError(t, err)
+
+	traefikClient := NewTraefikClient(server.URL, true)
+	_, err = traefikClient.GetRouters(context.Background())
+	require.Error(t, err, "the server requires a client certificate")
+	traefikClient.setClientCertificate(*cert)
+	_, err = traefikClient.GetRouters(context.Background())
+	require.NoError(t, err)
+
+	unifiClient := NewUniFiClient(server.URL, "admin", "password", true)
+	err = unifiClient.login(context.Background())
+	require.Error(t, err, "the server requires a client certificate")
+	unifiClient = NewUniFiClient(server.URL, "admin", "password", true)
+	unifiClient.setClientCertificate(*cert)
+	err = unifiClient.login(context.Background())
+	require.NoError(t, err)
+}
+
+func TestNewInvalidClientCertificate(t *testing.T) {
+	certFile, _ := writeTestCertificate(t, t.TempDir())
+
+	config := CreateConfig()
+	config.ClientCertFile = certFile
+	_, err := New(context.Background(), nil, config, "test")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid client certificate")
+
+	config = CreateConfig()
+	config.Devices = []UnifiDeviceConfig{{Host: "https://unifi.lan", Username: "admin", Password: "password", Pattern: `\.lan$`, ClientCertFile: certFile, ClientKeyFile: certFile}}
+	_, err = New(context.Background(), nil, config, "test")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid client certificate for device 0")
+}
+
+func TestLoadCACertPool(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	caPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, []byte(caPEM), 0o600))
+
+	pool, err := loadCACertPool("", "")
+	require.NoError(t, err)
+	assert.Nil(t, pool, "system roots without CA certificates")
+
+	pool, err = loadCACertPool(caPEM, "")
+	require.NoError(t, err)
+	assert.NotNil(t, pool)
+
+	pool, err = loadCACertPool("", caFile)
+	require.NoError(t, err)
+	assert.NotNil(t, pool)
+
+	_, err = loadCACertPool(caPEM, caFile)
+	assert.ErrorContains(t, err, "can't be set together")
+
+	_, err = loadCACertPool("not a certificate", "")
+	assert.ErrorContains(t, err, "no PEM encoded CA certificates")
+
+	_, err = loadCACertPool("", filepath.Join(t.TempDir(), "missing.pem"))
+	assert.ErrorContains(t, err, "failed to read CA certificates")
+}
+
+func TestDeviceCACert(t *testing.T) {
+	config := &Config{CACertFile: "global.pem"}
+
+	caCert, caCertFile := deviceCACert(config, UnifiDeviceConfig{})
+	assert.Empty(t, caCert)
+	assert.Equal(t, "global.pem", caCertFile)
+
+	caCert, caCertFile = deviceCACert(config, UnifiDeviceConfig{CACert: "device"})
+	assert.Equal(t, "device", caCert)
+	assert.Empty(t, caCertFile)
+}
+
+func TestCACertVerification(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/auth/login" {
+			w.Header().Set("X-Csrf-Token", "test-csrf-token")
+			return
+		}
+		_, _ = w.Write([]byte("[]"))
+	}))
+	defer server.Close()
+	pool, err := loadCACertPool(string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})), "")
+	require.NoError(t, err)
+
+	traefikClient := NewTraefikClient(server.URL, false)
+	_, err = traefikClient.GetRouters(context.Background())
+	require.Error(t, err, "the self-signed certificate isn't trusted by default")
+	traefikClient.setRootCAs(pool)
+	_, err = traefikClient.GetRouters(context.Background())
+	require.NoError(t, err)
+
+	unifiClient := NewUniFiClient(server.URL, "admin", "password", false)
+	unifiClient.setRootCAs(pool)
+	require.NoError(t, unifiClient.login(context.Background()))
+}
+
+func TestNewInvalidCACert(t *testing.T) {
+	config := CreateConfig()
+	config.CACert = "not a certificate"
+	_, err := New(context.Background(), nil, config, "test")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid CA certificates")
+
+	config = CreateConfig()
+	config.Devices = []UnifiDeviceConfig{{Host: "https://unifi.lan", Username: "admin", Password: "password", Pattern: `\.lan$`, CACertFile: filepath.Join(t.TempDir(), "missing.pem")}}
+	_, err = New(context.Background(), nil, config, "test")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid CA certificates for device 0")
+}
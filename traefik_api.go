@@ -1,13 +1,18 @@
 package traefikunifidns
 
 import (
+	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -16,13 +21,44 @@ type TraefikRouter struct {
 	Middlewares []string `json:"middlewares"`
 	Service     string   `json:"service"`
 	Name        string   `json:"name"`
+	EntryPoints []string `json:"entryPoints"`
 }
 
+// TraefikService is an HTTP service together with the URLs of its load
+// balancer servers.
+type TraefikService struct {
+	Name    string
+	Servers []string
+}
+
+// defaultMiddlewareName matches the middlewares of the plugin without
+// middlewareName.
+const defaultMiddlewareName = "traefikunifidns"
+
 type TraefikClient struct {
-	client  *http.Client
-	baseURL string
+	client     *http.Client
+	transport  *http.Transport
+	baseURL    string
+	hooks      hooks
+	middleware *regexp.Regexp // Nil to match names containing defaultMiddlewareName
+	username   string         // Basic auth username, unauthenticated when empty
+	password   secret
+	token      secret // Bearer token, takes precedence over username
+	filter     routerFilter
+	rawData    bool // Read routers and services from /api/rawdata
+	rawMu      sync.Mutex
+	raw        *traefikRawData // Kept /api/rawdata response, nil until read
+	stats      deviceStats     // Only the endpoint histograms are used
 }
 
+// Traefik API endpoints the request histograms are broken down by.
+const (
+	endpointRouters     = "routers"
+	endpointServices    = "services"
+	endpointRawData     = "rawdata"
+	endpointEntryPoints = "entrypoints"
+)
+
 func NewTraefikClient(apiURL string, insecureSkipVerify bool) *TraefikClient {
 	log.Printf("INFO: Creating new Traefik client for API URL: %s (insecureSkipVerify: %v)", apiURL, insecureSkipVerify)
 
@@ -33,44 +69,46 @@ func NewTraefikClient(apiURL string, insecureSkipVerify bool) *TraefikClient {
 		},
 	}
 
-	return &TraefikClient{
-		client: &http.Client{
-			Timeout:   10 * time.Second,
-			Transport: transport,
-		},
-		baseURL: apiURL,
+	c := &TraefikClient{baseURL: apiURL, transport: transport}
+	c.client = &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: &hookTransport{next: transport, hooks: &c.hooks},
 	}
+	return c
 }
 
-func (c *TraefikClient) GetRouters() ([]TraefikRouter, error) {
+// fetchRouters returns the routers with a valid rule from the routers
+// endpoint of protocol, "http" or "tcp". Routers of TCP may have no
+// middlewares, since plugins can only be HTTP middlewares.
+func (c *TraefikClient) fetchRouters(ctx context.Context, protocol string) ([]TraefikRouter, error) {
 	// Get router configurations from the Traefik API using direct HTTP
-	url := fmt.Sprintf("%s/api/http/routers", c.baseURL)
+	url := fmt.Sprintf("%s/api/%s/routers", c.baseURL, protocol)
 	log.Printf("INFO: Fetching routers from Traefik API: %s", url)
 
-	resp, err := c.client.Get(url)
+	resp, err := c.get(ctx, endpointRouters, url)
 	if err != nil {
-		log.Printf("ERROR: Failed to get routers from Traefik API: %v", err)
+		logError("Failed to get routers from Traefik API: %v", err)
 		return nil, fmt.Errorf("failed to get routers: %w", err)
 	}
 	defer func() {
 		if closeErr := resp.Body.Close(); closeErr != nil {
 			// If we already have an error, don't override it
 			if err == nil {
-				log.Printf("ERROR: Failed to close response body: %v", closeErr)
+				logError("Failed to close response body: %v", closeErr)
 				err = fmt.Errorf("failed to close response body: %w", closeErr)
 			}
 		}
 	}()
 
 	if resp.StatusCode != http.StatusOK {
-		log.Printf("ERROR: Traefik API returned non-OK status code: %d", resp.StatusCode)
-		return nil, fmt.Errorf("failed to get routers: status code %d", resp.StatusCode)
+		logError("Traefik API returned non-OK status code: %d", resp.StatusCode)
+		return nil, newStatusError(resp.StatusCode, "failed to get routers: status code %d")
 	}
 
 	// First decode into a map to validate the structure
 	var rawRouters []map[string]interface{}
 	if err := json.NewDecoder(resp.Body).Decode(&rawRouters); err != nil {
-		log.Printf("ERROR: Failed to decode router response: %v", err)
+		logError("Failed to decode router response: %v", err)
 		return nil, fmt.Errorf("failed to decode router response: %w", err)
 	}
 
@@ -78,47 +116,93 @@ func (c *TraefikClient) GetRouters() ([]TraefikRouter, error) {
 	var routers []TraefikRouter
 	log.Printf("INFO: Processing %d raw routers from API", len(rawRouters))
 	for _, raw := range rawRouters {
-		router := TraefikRouter{}
+		if router, ok := parseRouter(raw, protocol); ok {
+			routers = append(routers, router)
+		}
+	}
+	return routers, nil
+}
 
-		// Validate required fields
-		rule, ok := raw["rule"].(string)
-		if !ok || rule == "" {
-			log.Printf("WARN: Router has invalid or missing rule, skipping")
-			continue
+// parseRouter converts a router decoded from the Traefik API, reporting
+// whether it is valid.
+func parseRouter(raw map[string]interface{}, protocol string) (TraefikRouter, bool) {
+	router := TraefikRouter{}
+
+	// Validate required fields
+	rule, ok := raw["rule"].(string)
+	if !ok || rule == "" {
+		log.Printf("WARN: Router has invalid or missing rule, skipping")
+		return TraefikRouter{}, false
+	}
+	router.Rule = rule
+
+	// Validate middlewares
+	middlewares, ok := raw["middlewares"].([]interface{})
+	if !ok {
+		// Try to handle case where middlewares might be a single string
+		if singleMiddleware, ok := raw["middlewares"].(string); ok {
+			router.Middlewares = []string{singleMiddleware}
+			log.Printf("INFO: Router %s has single middleware: %s", router.Name, singleMiddleware)
+		} else if protocol != "tcp" || raw["middlewares"] != nil {
+			log.Printf("WARN: Invalid middlewares format in router data, skipping")
+			return TraefikRouter{}, false
 		}
-		router.Rule = rule
-
-		// Validate middlewares
-		middlewares, ok := raw["middlewares"].([]interface{})
-		if !ok {
-			// Try to handle case where middlewares might be a single string
-			if singleMiddleware, ok := raw["middlewares"].(string); ok {
-				router.Middlewares = []string{singleMiddleware}
-				log.Printf("INFO: Router %s has single middleware: %s", router.Name, singleMiddleware)
-			} else {
-				log.Printf("WARN: Invalid middlewares format in router data, skipping")
-				continue
-			}
-		} else {
-			// Convert middlewares to strings
-			for _, m := range middlewares {
-				if mStr, ok := m.(string); ok {
-					router.Middlewares = append(router.Middlewares, mStr)
-				}
+	} else {
+		// Convert middlewares to strings
+		for _, m := range middlewares {
+			if mStr, ok := m.(string); ok {
+				router.Middlewares = append(router.Middlewares, mStr)
 			}
-			log.Printf("INFO: Router %s has %d middlewares: %v", router.Name, len(router.Middlewares), router.Middlewares)
 		}
+		log.Printf("INFO: Router %s has %d middlewares: %v", router.Name, len(router.Middlewares), router.Middlewares)
+	}
 
-		// Optional fields
-		if name, ok := raw["name"].(string); ok {
-			router.Name = name
-		}
-		if service, ok := raw["service"].(string); ok {
-			router.Service = service
+	// Optional fields
+	if name, ok := raw["name"].(string); ok {
+		router.Name = name
+	}
+	if service, ok := raw["service"].(string); ok {
+		router.Service = service
+	}
+	router.EntryPoints = stringList(raw["entryPoints"])
+
+	log.Printf("INFO: Added router %s to processing list", router.Name)
+	return router, true
+}
+
+// stringList returns the strings of a JSON array decoded into an interface.
+func stringList(v interface{}) []string {
+	items, _ := v.([]interface{})
+	var list []string
+	for _, item := range items {
+		if s, ok := item.(string); ok {
+			list = append(list, s)
 		}
+	}
+	return list
+}
 
-		routers = append(routers, router)
-		log.Printf("INFO: Added router %s to processing list", router.Name)
+// routers returns the routers of protocol, "http" or "tcp", from
+// /api/rawdata when set up with setRawData or from their own endpoint
+// otherwise.
+func (c *TraefikClient) routers(ctx context.Context, protocol string) ([]TraefikRouter, error) {
+	if !c.rawData {
+		return c.fetchRouters(ctx, protocol)
+	}
+	raw, err := c.cachedRawData(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if protocol == "tcp" {
+		return raw.tcpRouters, nil
+	}
+	return raw.routers, nil
+}
+
+func (c *TraefikClient) GetRouters(ctx context.Context) ([]TraefikRouter, error) {
+	routers, err := c.routers(ctx, "http")
+	if err != nil {
+		return nil, err
 	}
 
 	// Filter routers that have the UniFi DNS middleware
@@ -128,7 +212,7 @@ func (c *TraefikClient) GetRouters() ([]TraefikRouter, error) {
 		log.Printf("INFO: Checking router %s for UniFi DNS middleware", router.Name)
 		for _, middleware := range router.Middlewares {
 			log.Printf("INFO: Checking middleware: %s", middleware)
-			if strings.Contains(middleware, "traefikunifidns") {
+			if c.pluginMiddleware(middleware) {
 				log.Printf("INFO: Found router with UniFi DNS middleware: %s", router.Name)
 				filteredRouters = append(filteredRouters, router)
 				break
@@ -137,36 +221,352 @@ func (c *TraefikClient) GetRouters() ([]TraefikRouter, error) {
 	}
 
 	log.Printf("INFO: Successfully retrieved %d routers with UniFi DNS middleware from Traefik API", len(filteredRouters))
-	return filteredRouters, nil
+	return c.filter.apply(filteredRouters), nil
 }
 
-// extractHostname extracts the hostname from a Traefik rule
-// Example rule: "Host(`example.com`)"
-func extractHostname(rule string) string {
-	// Match Host(`example.com`) pattern
-	re := regexp.MustCompile(`Host\(` + "`" + `([^` + "`" + `]+)` + "`" + `\)`)
-	matches := re.FindStringSubmatch(rule)
-	if len(matches) > 1 {
-		log.Printf("INFO: Extracted hostname from backtick rule: %s", matches[1])
-		return strings.TrimSpace(matches[1])
+// GetTCPRouters returns the TCP routers with a HostSNI rule. The plugin
+// can't be a middleware of TCP routers, so they are all returned, leaving the
+// selection to the patterns of the devices.
+func (c *TraefikClient) GetTCPRouters(ctx context.Context) ([]TraefikRouter, error) {
+	routers, err := c.routers(ctx, "tcp")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get TCP routers: %w", err)
 	}
 
-	// Match Host('example.com') pattern
-	re = regexp.MustCompile(`Host\('([^']+)'\)`)
-	matches = re.FindStringSubmatch(rule)
-	if len(matches) > 1 {
-		log.Printf("INFO: Extracted hostname from single-quote rule: %s", matches[1])
-		return strings.TrimSpace(matches[1])
+	var sniRouters []TraefikRouter
+	for _, router := range routers {
+		if node, err := parseRule(router.Rule); err == nil && len(sniHostnames(node)) > 0 {
+			sniRouters = append(sniRouters, router)
+		}
 	}
+	log.Printf("INFO: Successfully retrieved %d TCP routers with a HostSNI rule from Traefik API", len(sniRouters))
+	return c.filter.apply(sniRouters), nil
+}
 
-	// Match Host("example.com") pattern
-	re = regexp.MustCompile(`Host\("([^"]+)"\)`)
-	matches = re.FindStringSubmatch(rule)
-	if len(matches) > 1 {
-		log.Printf("INFO: Extracted hostname from double-quote rule: %s", matches[1])
-		return strings.TrimSpace(matches[1])
+// routerFilter selects routers by the provider in their name, e.g. "docker"
+// for "app@docker", and by their entrypoints. Empty lists select every
+// router.
+type routerFilter struct {
+	includeProviders []string // Only routers of these providers
+	excludeProviders []string // Never routers of these providers
+	entryPoints      []string // Only routers bound to one of these entrypoints
+}
+
+// newRouterFilter creates a filter, accepting providers with or without
+// their "@".
+func newRouterFilter(includeProviders, excludeProviders, entryPoints []string) routerFilter {
+	trim := func(providers []string) []string {
+		var trimmed []string
+		for _, provider := range providers {
+			trimmed = append(trimmed, strings.Trim(provider, "@ "))
+		}
+		return trimmed
 	}
+	return routerFilter{includeProviders: trim(includeProviders), excludeProviders: trim(excludeProviders), entryPoints: entryPoints}
+}
 
-	log.Printf("INFO: No hostname found in rule: %s", rule)
-	return ""
+// matches reports whether the filter selects router.
+func (f routerFilter) matches(router TraefikRouter) bool {
+	_, provider, _ := strings.Cut(router.Name, "@")
+	if len(f.includeProviders) > 0 && !containsFold(f.includeProviders, provider) {
+		return false
+	}
+	if containsFold(f.excludeProviders, provider) {
+		return false
+	}
+	if len(f.entryPoints) == 0 {
+		return true
+	}
+	for _, entryPoint := range router.EntryPoints {
+		if containsFold(f.entryPoints, entryPoint) {
+			return true
+		}
+	}
+	return false
+}
+
+// apply returns the routers the filter selects.
+func (f routerFilter) apply(routers []TraefikRouter) []TraefikRouter {
+	if len(f.includeProviders) == 0 && len(f.excludeProviders) == 0 && len(f.entryPoints) == 0 {
+		return routers
+	}
+	var selected []TraefikRouter
+	for _, router := range routers {
+		if f.matches(router) {
+			selected = append(selected, router)
+		} else {
+			log.Printf("INFO: Skipping router %s excluded by the provider or entrypoint filters", router.Name)
+		}
+	}
+	return selected
+}
+
+// setMiddlewareName makes GetRouters return the routers with a middleware
+// whose name matches re, instead of one containing "traefikunifidns".
+func (c *TraefikClient) setMiddlewareName(re *regexp.Regexp) {
+	c.middleware = re
+}
+
+// pluginMiddleware reports whether name is a middleware of the plugin.
+func (c *TraefikClient) pluginMiddleware(name string) bool {
+	if c.middleware == nil {
+		return strings.Contains(name, defaultMiddlewareName)
+	}
+	return c.middleware.MatchString(name)
+}
+
+// setCredentials makes the client authenticate to the Traefik API with a
+// bearer token, or with basic auth when only a username is set.
+func (c *TraefikClient) setCredentials(username, password, token string) {
+	c.username = username
+	c.password = newSecret(password)
+	c.token = newSecret(token)
+}
+
+// setClientCertificate presents cert to a Traefik API requiring mutual TLS.
+func (c *TraefikClient) setClientCertificate(cert tls.Certificate) {
+	c.transport.TLSClientConfig.Certificates = []tls.Certificate{cert}
+}
+
+// setRouterFilter makes GetRouters and GetTCPRouters only return the routers
+// selected by filter.
+func (c *TraefikClient) setRouterFilter(filter routerFilter) {
+	c.filter = filter
+}
+
+// setRootCAs verifies the Traefik API certificate against pool instead of
+// the system roots.
+func (c *TraefikClient) setRootCAs(pool *x509.CertPool) {
+	c.transport.TLSClientConfig.RootCAs = pool
+}
+
+// get sends a GET request for url to the Traefik API, recording its latency
+// and response size in the histograms of endpoint.
+func (c *TraefikClient) get(ctx context.Context, endpoint, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case !c.token.empty():
+		req.Header.Set("Authorization", "Bearer "+c.token.reveal())
+	case c.username != "":
+		req.SetBasicAuth(c.username, c.password.reveal())
+	}
+	start := time.Now()
+	resp, err := c.client.Do(req)
+	c.stats.recordRequest(endpoint, time.Since(start))
+	if err != nil {
+		return nil, err
+	}
+	c.stats.trackResponseSize(endpoint, resp)
+	return resp, nil
+}
+
+// rawService is an HTTP service as reported by the Traefik API.
+type rawService struct {
+	Name         string `json:"name"`
+	LoadBalancer struct {
+		Servers []struct {
+			URL string `json:"url"`
+		} `json:"servers"`
+	} `json:"loadBalancer"`
+}
+
+// service converts the service named name.
+func (raw rawService) service(name string) TraefikService {
+	service := TraefikService{Name: name}
+	for _, server := range raw.LoadBalancer.Servers {
+		if server.URL != "" {
+			service.Servers = append(service.Servers, server.URL)
+		}
+	}
+	return service
+}
+
+// GetServices returns all HTTP services known to Traefik.
+func (c *TraefikClient) GetServices(ctx context.Context) ([]TraefikService, error) {
+	if c.rawData {
+		raw, err := c.cachedRawData(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return raw.services, nil
+	}
+
+	url := fmt.Sprintf("%s/api/http/services", c.baseURL)
+	log.Printf("INFO: Fetching services from Traefik API: %s", url)
+
+	resp, err := c.get(ctx, endpointServices, url)
+	if err != nil {
+		logError("Failed to get services from Traefik API: %v", err)
+		return nil, fmt.Errorf("failed to get services: %w", err)
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			logError("Failed to close response body: %v", closeErr)
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		logError("Traefik API returned non-OK status code: %d", resp.StatusCode)
+		return nil, newStatusError(resp.StatusCode, "failed to get services: status code %d")
+	}
+
+	var rawServices []rawService
+	if err := json.NewDecoder(resp.Body).Decode(&rawServices); err != nil {
+		logError("Failed to decode service response: %v", err)
+		return nil, fmt.Errorf("failed to decode service response: %w", err)
+	}
+
+	services := make([]TraefikService, 0, len(rawServices))
+	for _, raw := range rawServices {
+		services = append(services, raw.service(raw.Name))
+	}
+
+	log.Printf("INFO: Successfully retrieved %d services from Traefik API", len(services))
+	return services, nil
+}
+
+// traefikEntryPoint is an entrypoint as listed by /api/entrypoints.
+type traefikEntryPoint struct {
+	Name    string `json:"name"`
+	Address string `json:"address"` // e.g. ":443" or "192.168.1.10:53/udp"
+}
+
+// getEntryPoints returns the entrypoints of Traefik.
+func (c *TraefikClient) getEntryPoints(ctx context.Context) ([]traefikEntryPoint, error) {
+	url := fmt.Sprintf("%s/api/entrypoints", c.baseURL)
+	log.Printf("INFO: Fetching entrypoints from Traefik API: %s", url)
+
+	resp, err := c.get(ctx, endpointEntryPoints, url)
+	if err != nil {
+		logError("Failed to get entrypoints from Traefik API: %v", err)
+		return nil, fmt.Errorf("failed to get entrypoints: %w", err)
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			logError("Failed to close response body: %v", closeErr)
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		logError("Traefik API returned non-OK status code: %d", resp.StatusCode)
+		return nil, newStatusError(resp.StatusCode, "failed to get entrypoints: status code %d")
+	}
+
+	var entryPoints []traefikEntryPoint
+	if err := json.NewDecoder(resp.Body).Decode(&entryPoints); err != nil {
+		logError("Failed to decode entrypoint response: %v", err)
+		return nil, fmt.Errorf("failed to decode entrypoint response: %w", err)
+	}
+	return entryPoints, nil
+}
+
+// GetEntryPointAddresses returns the IP addresses of the entrypoints bound to
+// a specific address, by entrypoint name. Entrypoints listening on all
+// addresses or on a loopback address are left out.
+func (c *TraefikClient) GetEntryPointAddresses(ctx context.Context) (map[string]string, error) {
+	entryPoints, err := c.getEntryPoints(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	addresses := make(map[string]string)
+	for _, entryPoint := range entryPoints {
+		host, _, err := net.SplitHostPort(entryPoint.Address)
+		if err != nil {
+			continue
+		}
+		ip := net.ParseIP(host)
+		if ip == nil || ip.IsUnspecified() || ip.IsLoopback() {
+			continue
+		}
+		addresses[entryPoint.Name] = ip.String()
+	}
+
+	log.Printf("INFO: Found %d entrypoints bound to a specific address", len(addresses))
+	return addresses, nil
+}
+
+// GetEntryPointPorts returns the TCP ports Traefik listens on, by entrypoint
+// name. UDP entrypoints are left out.
+func (c *TraefikClient) GetEntryPointPorts(ctx context.Context) (map[string]int, error) {
+	entryPoints, err := c.getEntryPoints(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ports := make(map[string]int)
+	for _, entryPoint := range entryPoints {
+		_, port, err := net.SplitHostPort(strings.TrimSuffix(entryPoint.Address, "/tcp"))
+		if err != nil {
+			continue
+		}
+		if n, err := strconv.Atoi(port); err == nil && n > 0 {
+			ports[entryPoint.Name] = n
+		}
+	}
+	return ports, nil
+}
+
+// findService returns the service a router references. Routers may omit the
+// provider suffix of services defined by their own provider.
+func findService(services []TraefikService, name string) (TraefikService, bool) {
+	for _, service := range services {
+		if service.Name == name {
+			return service, true
+		}
+	}
+	for _, service := range services {
+		if base, _, _ := strings.Cut(service.Name, "@"); base == name {
+			return service, true
+		}
+	}
+	return TraefikService{}, false
+}
+
+// sniHostnames returns the hostnames of the HostSNI matchers of a TCP rule,
+// leaving out the catch-all HostSNI(`*`).
+func sniHostnames(node *ruleNode) []string {
+	var hostnames []string
+	for _, arg := range node.matcherArgs("HostSNI") {
+		if arg = strings.TrimSpace(arg); arg != "*" {
+			hostnames = append(hostnames, arg)
+		}
+	}
+	return hostnames
+}
+
+// extractHostnames extracts the hostnames of all Host matchers of an HTTP
+// rule, or HostSNI matchers of a TCP rule, in order and without duplicates.
+// Negated matchers are skipped, and HostRegexp patterns are reported but not
+// published since they don't name a single hostname.
+// Example rule: "Host(`a.example.com`, `b.example.com`) || Host(`c.example.com`)"
+func extractHostnames(rule string) []string {
+	node, err := parseRule(rule)
+	if err != nil {
+		log.Printf("WARN: Failed to parse rule %q: %v", rule, err)
+		return nil
+	}
+	for _, pattern := range node.matcherArgs("HostRegexp", "HostSNIRegexp") {
+		log.Printf("INFO: Skipping HostRegexp pattern %q: no record can be created for a pattern", pattern)
+	}
+
+	var hostnames []string
+	seen := make(map[string]bool)
+	for _, arg := range append(node.matcherArgs("Host", "HostHeader"), sniHostnames(node)...) {
+		hostname := strings.TrimSpace(arg)
+		if hostname != "" && !seen[hostname] {
+			seen[hostname] = true
+			hostnames = append(hostnames, hostname)
+		}
+	}
+
+	if len(hostnames) == 0 {
+		log.Printf("INFO: No hostname found in rule: %s", rule)
+		return nil
+	}
+	log.Printf("INFO: Extracted hostnames from rule: %s", strings.Join(hostnames, ", "))
+	return hostnames
 }
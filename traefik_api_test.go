@@ -1,11 +1,14 @@
 package traefikunifidns
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"regexp"
 	"testing"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -68,7 +71,7 @@ func TestGetRouters(t *testing.T) {
 	}
 
 	// Test GetRouters
-	routers, err := client.GetRouters()
+	routers, err := client.GetRouters(context.Background())
 	if err != nil {
 		t.Fatalf("GetRouters returned error: %v", err)
 	}
@@ -120,7 +123,7 @@ func TestGetRoutersErrors(t *testing.T) {
 			baseURL: "http://invalid-url-that-will-fail:12345",
 		}
 
-		_, err := client.GetRouters()
+		_, err := client.GetRouters(context.Background())
 		if err == nil {
 			t.Error("Expected error for invalid URL, got nil")
 		}
@@ -138,7 +141,7 @@ func TestGetRoutersErrors(t *testing.T) {
 			baseURL: server.URL,
 		}
 
-		_, err := client.GetRouters()
+		_, err := client.GetRouters(context.Background())
 		if err == nil {
 			t.Error("Expected error for non-200 status code, got nil")
 		}
@@ -158,7 +161,7 @@ func TestGetRoutersErrors(t *testing.T) {
 			baseURL: server.URL,
 		}
 
-		_, err := client.GetRouters()
+		_, err := client.GetRouters(context.Background())
 		if err == nil {
 			t.Error("Expected error for invalid JSON, got nil")
 		}
@@ -178,7 +181,7 @@ func TestGetRoutersErrors(t *testing.T) {
 			baseURL: server.URL,
 		}
 
-		routers, err := client.GetRouters()
+		routers, err := client.GetRouters(context.Background())
 		if err != nil {
 			t.Fatalf("GetRouters returned error: %v", err)
 		}
@@ -198,7 +201,7 @@ func TestGetRoutersErrors(t *testing.T) {
 		defer server.Close()
 
 		client := NewTraefikClient(server.URL, false)
-		routers, err := client.GetRouters()
+		routers, err := client.GetRouters(context.Background())
 		if err != nil {
 			t.Errorf("Expected no error for malformed router data, got %v", err)
 		}
@@ -223,72 +226,340 @@ func TestGetRoutersErrors(t *testing.T) {
 			baseURL: server.URL,
 		}
 
-		_, err := client.GetRouters()
+		_, err := client.GetRouters(context.Background())
 		if err == nil {
 			t.Error("Expected error for response body close error, got nil")
 		}
 	})
 }
 
-func TestExtractHostname(t *testing.T) {
+func TestExtractHostnames(t *testing.T) {
 	testCases := []struct {
 		name     string
 		rule     string
-		expected string
+		expected []string
 	}{
 		{
 			name:     "Backtick hostname",
 			rule:     "Host(`example.com`)",
-			expected: "example.com",
+			expected: []string{"example.com"},
 		},
 		{
 			name:     "Single quote hostname",
 			rule:     "Host('test.com')",
-			expected: "test.com",
+			expected: []string{"test.com"},
 		},
 		{
 			name:     "Double quote hostname",
 			rule:     "Host(\"domain.com\")",
-			expected: "domain.com",
+			expected: []string{"domain.com"},
 		},
 		{
 			name:     "No hostname",
 			rule:     "Path(`/api`)",
-			expected: "",
+			expected: nil,
 		},
 		{
 			name:     "Empty rule",
 			rule:     "",
-			expected: "",
+			expected: nil,
 		},
 		{
 			name:     "Invalid host rule",
 			rule:     "Host(example.com)",
-			expected: "",
+			expected: nil,
 		},
 		{
 			name:     "Multiple host rules",
 			rule:     "Host(`example.com`) && Path(`/api`)",
-			expected: "example.com",
+			expected: []string{"example.com"},
 		},
 		{
 			name:     "Host rule with spaces",
 			rule:     "Host(` example.com `)",
-			expected: "example.com",
+			expected: []string{"example.com"},
 		},
 		{
 			name:     "Host rule with special characters",
 			rule:     "Host(`example.com:8080`)",
-			expected: "example.com:8080",
+			expected: []string{"example.com:8080"},
+		},
+		{
+			name:     "Several hostnames in one matcher",
+			rule:     "Host(`a.example.com`, `b.example.com`)",
+			expected: []string{"a.example.com", "b.example.com"},
+		},
+		{
+			name:     "Alternative host matchers",
+			rule:     "(Host(`a.example.com`) || Host('b.example.com')) && PathPrefix(`/api`)",
+			expected: []string{"a.example.com", "b.example.com"},
+		},
+		{
+			name:     "Duplicate hostnames",
+			rule:     "Host(`a.example.com`) || Host(`a.example.com`, `b.example.com`)",
+			expected: []string{"a.example.com", "b.example.com"},
+		},
+		{
+			name:     "Negated host matcher",
+			rule:     "Host(`a.example.com`) && !Host(`b.example.com`)",
+			expected: []string{"a.example.com"},
+		},
+		{
+			name:     "Parentheses inside a quoted argument",
+			rule:     "Host(`a.example.com`) && PathRegexp(`^/(api|v1)/.*`)",
+			expected: []string{"a.example.com"},
+		},
+		{
+			name:     "Host header alias",
+			rule:     "HostHeader(`a.example.com`)",
+			expected: []string{"a.example.com"},
+		},
+		{
+			name:     "Unbalanced parentheses",
+			rule:     "(Host(`a.example.com`)",
+			expected: nil,
+		},
+		{
+			name:     "TCP rule",
+			rule:     "HostSNI(`db.example.com`) || HostSNI(`*`)",
+			expected: []string{"db.example.com"},
+		},
+		{
+			name:     "HostRegexp is not a host matcher",
+			rule:     "HostRegexp(`{sub:[a-z]+}.example.com`)",
+			expected: nil,
 		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			result := extractHostname(tc.rule)
-			if result != tc.expected {
-				t.Errorf("Expected hostname '%s', got '%s'", tc.expected, result)
-			}
+			assert.Equal(t, tc.expected, extractHostnames(tc.rule))
 		})
 	}
 }
+
+func TestGetServices(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/http/services" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`[
+			{"name": "whoami@docker", "loadBalancer": {"servers": [{"url": "http://172.18.0.3:80"}, {"url": ""}]}},
+			{"name": "api@internal"}
+		]`))
+	}))
+	defer server.Close()
+
+	services, err := NewTraefikClient(server.URL, false).GetServices(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []TraefikService{
+		{Name: "whoami@docker", Servers: []string{"http://172.18.0.3:80"}},
+		{Name: "api@internal"},
+	}, services)
+
+	_, err = NewTraefikClient(server.URL+"/missing", false).GetServices(context.Background())
+	assert.EqualError(t, err, "failed to get services: status code 404")
+}
+
+func TestGetEntryPointAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/entrypoints" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`[
+			{"name": "web", "address": ":80"},
+			{"name": "lan", "address": "192.168.1.10:443"},
+			{"name": "any", "address": "0.0.0.0:8443"},
+			{"name": "traefik", "address": "127.0.0.1:8080"},
+			{"name": "udp", "address": "192.168.1.11:53/udp"}
+		]`))
+	}))
+	defer server.Close()
+
+	addresses, err := NewTraefikClient(server.URL, false).GetEntryPointAddresses(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"lan": "192.168.1.10", "udp": "192.168.1.11"}, addresses)
+
+	_, err = NewTraefikClient(server.URL+"/missing", false).GetEntryPointAddresses(context.Background())
+	assert.EqualError(t, err, "failed to get entrypoints: status code 404")
+}
+
+func TestGetEntryPointPorts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`[
+			{"name": "web", "address": ":80"},
+			{"name": "websecure", "address": "192.168.1.10:443/tcp"},
+			{"name": "dns", "address": ":53/udp"}
+		]`))
+	}))
+	defer server.Close()
+
+	ports, err := NewTraefikClient(server.URL, false).GetEntryPointPorts(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"web": 80, "websecure": 443}, ports)
+}
+
+func TestFindService(t *testing.T) {
+	services := []TraefikService{{Name: "whoami@docker"}, {Name: "whoami@file"}, {Name: "api@internal"}}
+
+	service, ok := findService(services, "whoami@file")
+	assert.True(t, ok)
+	assert.Equal(t, "whoami@file", service.Name)
+
+	service, ok = findService(services, "whoami")
+	assert.True(t, ok)
+	assert.Equal(t, "whoami@docker", service.Name)
+
+	_, ok = findService(services, "missing")
+	assert.False(t, ok)
+}
+
+func TestGetTCPRouters(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/tcp/routers", r.URL.Path)
+		_, err := w.Write([]byte(`[
+			{"name": "postgres@docker", "rule": "HostSNI(` + "`db.lan`" + `)", "service": "postgres", "entryPoints": ["postgres"]},
+			{"name": "mqtt@file", "rule": "HostSNI(` + "`mqtt.lan`" + `)", "middlewares": ["allowlist@file"]},
+			{"name": "catchall@file", "rule": "HostSNI(` + "`*`" + `)"},
+			{"name": "clients@file", "rule": "ClientIP(` + "`10.0.0.0/8`" + `)"},
+			{"name": "broken@file", "rule": "HostSNI(` + "`a.lan`" + `)", "middlewares": 42}
+		]`))
+		require.NoError(t, err)
+	}))
+	defer server.Close()
+
+	client := NewTraefikClient(server.URL, false)
+	routers, err := client.GetTCPRouters(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []TraefikRouter{
+		{Name: "postgres@docker", Rule: "HostSNI(`db.lan`)", Service: "postgres", EntryPoints: []string{"postgres"}},
+		{Name: "mqtt@file", Rule: "HostSNI(`mqtt.lan`)", Middlewares: []string{"allowlist@file"}},
+	}, routers)
+}
+
+func TestGetTCPRoutersError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	client := NewTraefikClient(server.URL, false)
+	_, err := client.GetTCPRouters(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to get TCP routers")
+}
+
+func TestTraefikClientCredentials(t *testing.T) {
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		_, _ = w.Write([]byte("[]"))
+	}))
+	defer server.Close()
+	client := NewTraefikClient(server.URL, false)
+
+	_, err := client.GetRouters(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, authorization, "no credentials by default")
+
+	client.setCredentials("admin", "secret", "")
+	_, err = client.GetRouters(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "Basic YWRtaW46c2VjcmV0", authorization)
+
+	client.setCredentials("", "", "api-token")
+	_, err = client.GetServices(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "Bearer api-token", authorization)
+}
+
+func TestNewInvalidTraefikAPICredentials(t *testing.T) {
+	for name, config := range map[string]func(*Config){
+		"token and username":    func(c *Config) { c.TraefikAPIToken = "token"; c.TraefikAPIUsername = "admin" },
+		"password without user": func(c *Config) { c.TraefikAPIPassword = "secret" },
+	} {
+		t.Run(name, func(t *testing.T) {
+			c := CreateConfig()
+			config(c)
+			_, err := New(context.Background(), nil, c, "test")
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "invalid Traefik API credentials")
+		})
+	}
+}
+
+func TestRouterFilter(t *testing.T) {
+	routers := []TraefikRouter{
+		{Name: "app@docker", EntryPoints: []string{"websecure"}},
+		{Name: "nas@file", EntryPoints: []string{"web"}},
+		{Name: "wiki@kubernetescrd", EntryPoints: []string{"web", "websecure"}},
+	}
+	names := func(routers []TraefikRouter) []string {
+		var names []string
+		for _, router := range routers {
+			names = append(names, router.Name)
+		}
+		return names
+	}
+
+	assert.Equal(t, []string{"app@docker", "nas@file", "wiki@kubernetescrd"}, names(newRouterFilter(nil, nil, nil).apply(routers)))
+	assert.Equal(t, []string{"app@docker"}, names(newRouterFilter([]string{"docker@"}, nil, nil).apply(routers)))
+	assert.Equal(t, []string{"app@docker", "wiki@kubernetescrd"}, names(newRouterFilter(nil, []string{"file"}, nil).apply(routers)))
+	assert.Equal(t, []string{"app@docker", "wiki@kubernetescrd"}, names(newRouterFilter(nil, nil, []string{"WebSecure"}).apply(routers)))
+	assert.Equal(t, []string{"wiki@kubernetescrd"}, names(newRouterFilter([]string{"file", "kubernetescrd"}, nil, []string{"websecure"}).apply(routers)))
+}
+
+func TestGetRoutersFiltered(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`[
+			{"name": "app@docker", "rule": "Host(` + "`app.lan`" + `)", "middlewares": ["traefikunifidns@file"], "entryPoints": ["websecure"]},
+			{"name": "nas@file", "rule": "Host(` + "`nas.lan`" + `)", "middlewares": ["traefikunifidns@file"], "entryPoints": ["web"]}
+		]`))
+	}))
+	defer server.Close()
+
+	client := NewTraefikClient(server.URL, false)
+	client.setRouterFilter(newRouterFilter(nil, nil, []string{"websecure"}))
+	routers, err := client.GetRouters(context.Background())
+	require.NoError(t, err)
+	require.Len(t, routers, 1)
+	assert.Equal(t, "app@docker", routers[0].Name)
+}
+
+func TestGetRoutersMiddlewareName(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		routers := []TraefikRouter{
+			{Name: "app", Rule: "Host(`app.lan`)", Middlewares: []string{"lan-dns@file"}},
+			{Name: "nas", Rule: "Host(`nas.lan`)", Middlewares: []string{"traefikunifidns@file"}},
+			{Name: "web", Rule: "Host(`web.lan`)", Middlewares: []string{"auth@file"}},
+		}
+		if err := json.NewEncoder(w).Encode(routers); err != nil {
+			t.Errorf("Failed to encode routers: %v", err)
+		}
+	}))
+	defer server.Close()
+	client := NewTraefikClient(server.URL, false)
+
+	routers, err := client.GetRouters(context.Background())
+	require.NoError(t, err)
+	require.Len(t, routers, 1)
+	assert.Equal(t, "nas", routers[0].Name)
+
+	client.setMiddlewareName(regexp.MustCompile(`^lan-dns@`))
+	routers, err = client.GetRouters(context.Background())
+	require.NoError(t, err)
+	require.Len(t, routers, 1)
+	assert.Equal(t, "app", routers[0].Name)
+}
+
+func TestNewInvalidMiddlewareName(t *testing.T) {
+	config := CreateConfig()
+	config.MiddlewareName = "dns("
+	config.Devices = []UnifiDeviceConfig{{WebhookURL: "http://localhost:8080", Pattern: `\.lan$`}}
+
+	_, err := New(context.Background(), nil, config, "test")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid middlewareName")
+}
@@ -2,30 +2,138 @@ package traefikunifidns
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
 	"regexp"
+	"sort"
+	"strings"
 	"sync"
+	"text/template"
 	"time"
 )
 
 // UnifiDeviceConfig represents configuration for a single UniFi device
 type UnifiDeviceConfig struct {
-	Host                  string `json:"host"`
-	Username              string `json:"username"`
-	Password              string `json:"password"`
-	Pattern               string `json:"pattern"` // Regex pattern to match domain names
-	InsecureSkipVerifyTLS bool   `json:"insecureSkipVerifyTLS,omitempty"`
+	Host                  string            `json:"host"`
+	Username              string            `json:"username"`
+	Password              string            `json:"password"`
+	AuthMethod            string            `json:"authMethod,omitempty"` // "auto" (default), "cookie", "bearer" or "apikey"
+	Token                 string            `json:"token,omitempty"`      // Sent as "Authorization: Bearer" instead of logging in
+	APIKey                string            `json:"apiKey,omitempty"`     // Sent as "X-API-KEY" instead of logging in, UniFi Network 9.x and later
+	Pattern               string            `json:"pattern,omitempty"`    // Deprecated: replaced by patterns in configVersion 2
+	Patterns              []string          `json:"patterns,omitempty"`   // Regex patterns to match domain names, any of them matches
+	Glob                  string            `json:"glob,omitempty"`       // Glob to match domain names instead of a pattern, e.g. "*.example.com"
+	InsecureSkipVerifyTLS bool              `json:"insecureSkipVerifyTLS,omitempty"`
+	AllowInsecureHTTP     bool              `json:"allowInsecureHTTP,omitempty"`     // Allow an http:// host other than localhost
+	WebhookURL            string            `json:"webhookUrl,omitempty"`            // Publish changes to this endpoint instead of a UniFi controller
+	TLSServerName         string            `json:"tlsServerName,omitempty"`         // Name verified against the controller certificate, when connecting by IP
+	ClientCertFile        string            `json:"clientCertFile,omitempty"`        // Client certificate presented to the controller, overrides the global one
+	ClientKeyFile         string            `json:"clientKeyFile,omitempty"`         // Key of clientCertFile
+	CACert                string            `json:"caCert,omitempty"`                // PEM encoded CAs the controller certificate is verified against, overrides the global ones
+	CACertFile            string            `json:"caCertFile,omitempty"`            // File with the PEM encoded CAs, instead of caCert
+	UserAgent             string            `json:"userAgent,omitempty"`             // User-Agent sent to the controller
+	Headers               map[string]string `json:"headers,omitempty"`               // Additional headers sent with every controller request
+	ExtraCookies          map[string]string `json:"extraCookies,omitempty"`          // Cookies sent with every controller request, e.g. gateway session tokens
+	ProxyAuthHeader       string            `json:"proxyAuthHeader,omitempty"`       // "Name: value" header sent with every controller request
+	MaxConcurrentRequests int               `json:"maxConcurrentRequests,omitempty"` // Requests in flight to the controller at once, unlimited by default
+	MaxRetries            int               `json:"maxRetries,omitempty"`            // Retries of requests failing with a network or server error, none by default
+	InitialBackoff        string            `json:"initialBackoff,omitempty"`        // Delay before the first retry, doubled for every further one, defaults to 500ms
+	MaxBackoff            string            `json:"maxBackoff,omitempty"`            // Longest delay between retries, defaults to 10s
+	TargetIP              string            `json:"targetIP,omitempty"`              // Address published on this device instead of the local IP
+	TargetHostname        string            `json:"targetHostname,omitempty"`        // Resolved every cycle and published instead of the local IP
+	TargetWANIP           bool              `json:"targetWanIP,omitempty"`           // Publish the gateway's WAN address reported by this controller
+	RecordMode            string            `json:"recordMode,omitempty"`            // "a" (default) or "cname" to point every hostname at cnameTarget
+	CNAMETarget           string            `json:"cnameTarget,omitempty"`           // Canonical Traefik hostname published hostnames point at in cname mode
+	NameTemplate          string            `json:"nameTemplate,omitempty"`          // Template for published names, overrides the global nameTemplate
+	DryRun                bool              `json:"dryRun,omitempty"`                // Only log the changes this device would receive
+	ReadOnly              bool              `json:"readOnly,omitempty"`              // Reject every write to this controller, reporting drift instead
+	Site                  string            `json:"site,omitempty"`                  // Site name or description, "auto" for the only site, defaults to "default"
+	APIPaths              APIPaths          `json:"apiPaths,omitempty"`              // Overrides the controller API paths, for proxies that rewrite them
+	Default               bool              `json:"default,omitempty"`               // Receives every hostname no other device pattern matches
 }
 
 // Config the plugin configuration.
 type Config struct {
-	Devices               []UnifiDeviceConfig `json:"devices"`
-	UpdateInterval        string              `json:"updateInterval,omitempty"`
-	TraefikAPIURL         string              `json:"traefikApiUrl"`
-	InsecureSkipVerifyTLS bool                `json:"insecureSkipVerifyTLS,omitempty"`
+	ConfigVersion                int                    `json:"configVersion,omitempty"` // Shape of this configuration, older shapes are migrated
+	Devices                      []UnifiDeviceConfig    `json:"devices"`
+	UpdateInterval               string                 `json:"updateInterval,omitempty"`
+	MaxUpdateInterval            string                 `json:"maxUpdateInterval,omitempty"` // Stretch the interval up to this while cycles change nothing
+	UpdateOnStartup              *bool                  `json:"updateOnStartup,omitempty"`   // Run an update when the plugin starts, defaults to true
+	DiscoveryInterval            string                 `json:"discoveryInterval,omitempty"` // Poll Traefik this often and update added or changed hostnames right away
+	TraefikAPIURL                string                 `json:"traefikApiUrl"`
+	InsecureSkipVerifyTLS        bool                   `json:"insecureSkipVerifyTLS,omitempty"`        // Deprecated: replaced by traefikInsecureSkipVerifyTLS and the device setting in configVersion 2
+	TraefikInsecureSkipVerifyTLS bool                   `json:"traefikInsecureSkipVerifyTLS,omitempty"` // Skip TLS verification of the Traefik API
+	ClientCertFile               string                 `json:"clientCertFile,omitempty"`               // Client certificate presented to the Traefik API and controllers requiring mutual TLS
+	ClientKeyFile                string                 `json:"clientKeyFile,omitempty"`                // Key of clientCertFile
+	CACert                       string                 `json:"caCert,omitempty"`                       // PEM encoded CAs the Traefik API and controller certificates are verified against
+	CACertFile                   string                 `json:"caCertFile,omitempty"`                   // File with the PEM encoded CAs, instead of caCert
+	TraefikAPIUsername           string                 `json:"traefikApiUsername,omitempty"`           // Basic auth username of the Traefik API
+	TraefikAPIPassword           string                 `json:"traefikApiPassword,omitempty"`           // Basic auth password of the Traefik API
+	TraefikAPIToken              string                 `json:"traefikApiToken,omitempty"`              // Bearer token of the Traefik API, instead of a username and password
+	MiddlewareName               string                 `json:"middlewareName,omitempty"`               // Regex matching the names of the plugin's middlewares, defaults to "traefikunifidns"
+	IncludeHostnames             []string               `json:"includeHostnames,omitempty"`             // Only publish hostnames matching one of these globs, e.g. "*.lan"
+	ExcludeHostnames             []string               `json:"excludeHostnames,omitempty"`             // Never publish hostnames matching one of these globs
+	IncludeProviders             []string               `json:"includeProviders,omitempty"`             // Only publish routers of these providers, e.g. "docker"
+	ExcludeProviders             []string               `json:"excludeProviders,omitempty"`             // Never publish routers of these providers
+	EntryPoints                  []string               `json:"entryPoints,omitempty"`                  // Only publish routers bound to one of these entrypoints, e.g. "websecure"
+	TCPRouters                   bool                   `json:"tcpRouters,omitempty"`                   // Also publish the HostSNI hostnames of TCP routers
+	TraefikRawData               bool                   `json:"traefikRawData,omitempty"`               // Read routers and services from /api/rawdata in a single request per cycle
+	TTLOverrides                 map[string]int         `json:"ttlOverrides,omitempty"`                 // Per-hostname TTL in seconds
+	NameTemplate                 string                 `json:"nameTemplate,omitempty"`                 // Template applied to discovered hostnames, e.g. "{{.Hostname}}.lan"
+	IPOverrides                  map[string]string      `json:"ipOverrides,omitempty"`                  // Per-hostname fixed target IP
+	TargetIPs                    []string               `json:"targetIPs,omitempty"`                    // Addresses of all Traefik nodes, one A record each
+	EnableIPv6                   bool                   `json:"enableIPv6,omitempty"`                   // Also publish an AAAA record with the local IPv6 address of hostnames pointing at this host
+	NodeHealthCheck              *NodeHealthCheckConfig `json:"nodeHealthCheck,omitempty"`              // Only publish the targetIPs of nodes passing a health check
+	EntryPointTargets            bool                   `json:"entryPointTargets,omitempty"`            // Publish the bind address of a router's entrypoint instead of the local IP
+	VirtualIP                    string                 `json:"virtualIP,omitempty"`                    // Published instead of the local IP while it accepts connections
+	PublicIPURL                  string                 `json:"publicIPURL,omitempty"`                  // Lookup service answering with the public address, published instead of the local IP
+	VirtualIPPort                int                    `json:"virtualIPPort,omitempty"`                // Port probed on the virtual IP, defaults to 443
+	PermissionPreflight          bool                   `json:"permissionPreflight,omitempty"`          // Verify write access to every controller on startup
+	NeverManage                  []string               `json:"neverManage,omitempty"`                  // Hostnames never created, updated or deleted
+	ApexDomains                  []string               `json:"apexDomains,omitempty"`                  // Zone apexes that are never published, e.g. "example.com"
+	AllowedApexDomains           []string               `json:"allowedApexDomains,omitempty"`           // Apexes from apexDomains that may be published anyway
+	AllowedTargetCIDRs           []string               `json:"allowedTargetCIDRs,omitempty"`
+	PreferredSubnets             []string               `json:"preferredSubnets,omitempty"`     // Subnets preferred when picking the local IP
+	TargetInterface              string                 `json:"targetInterface,omitempty"`      // Network interface the local IP is read from, all interfaces by default
+	AllowedSourceCIDRs           []string               `json:"allowedSourceCIDRs,omitempty"`   // Only local IPs inside these ranges are published, any by default
+	ExcludedSourceCIDRs          []string               `json:"excludedSourceCIDRs,omitempty"`  // Local IPs inside these ranges are never published
+	AdminPath                    string                 `json:"adminPath,omitempty"`            // Path prefix for the admin endpoints, disabled when empty
+	AdminToken                   string                 `json:"adminToken,omitempty"`           // Bearer token accepted by the admin endpoints
+	AdminUsername                string                 `json:"adminUsername,omitempty"`        // Basic auth username accepted by the admin endpoints
+	AdminPassword                string                 `json:"adminPassword,omitempty"`        // Basic auth password accepted by the admin endpoints
+	SyncHeader                   string                 `json:"syncHeader,omitempty"`           // Request header carrying syncToken, defaults to "X-UniFiDNS-Sync"
+	SyncToken                    string                 `json:"syncToken,omitempty"`            // Requests with this value in syncHeader queue an update
+	DebugHeader                  bool                   `json:"debugHeader,omitempty"`          // Add X-UniFiDNS-Status with the sync health of the hostname to proxied responses
+	LogRequests                  bool                   `json:"logRequests,omitempty"`          // Log proxied requests
+	LogSampleRate                int                    `json:"logSampleRate,omitempty"`        // With logRequests, log one in this many requests, every request by default
+	MaxStaleness                 string                 `json:"maxStaleness,omitempty"`         // Alert when the last successful update is older than this
+	HeartbeatURL                 string                 `json:"heartbeatUrl,omitempty"`         // Pinged after every cycle, with "/fail" appended on errors
+	CycleTimeout                 string                 `json:"cycleTimeout,omitempty"`         // Deadline for a single update cycle, defaults to the update interval
+	OwnerID                      string                 `json:"ownerId,omitempty"`              // Enables external-dns style TXT ownership records
+	TXTPrefix                    string                 `json:"txtPrefix,omitempty"`            // Prefix for the names of ownership TXT records
+	MQTT                         *MQTTConfig            `json:"mqtt,omitempty"`                 // Publish DNS change events to an MQTT broker
+	PushgatewayURL               string                 `json:"pushgatewayUrl,omitempty"`       // Push metrics to this Prometheus Pushgateway after every cycle
+	PushgatewayJob               string                 `json:"pushgatewayJob,omitempty"`       // Job label for pushed metrics, defaults to "traefikunifidns"
+	ZoneFile                     *ZoneFileConfig        `json:"zoneFile,omitempty"`             // Export the managed records as a zone file snippet
+	UnboundFile                  *UnboundFileConfig     `json:"unboundFile,omitempty"`          // Export the managed records as an Unbound include file
+	AuditLog                     string                 `json:"auditLog,omitempty"`             // Append every record change to this hash-chained JSONL file
+	Report                       *ReportConfig          `json:"report,omitempty"`               // Write a report of every cycle to a rotating file
+	ErrorReportURL               string                 `json:"errorReportUrl,omitempty"`       // POST non-retryable failures to this collector
+	SRVRecords                   bool                   `json:"srvRecords,omitempty"`           // Publish SRV records for the services of routers
+	PrecheckResolver             string                 `json:"precheckResolver,omitempty"`     // DNS server asked before contacting a device, skipped when it already answers the target
+	ConsistencyCheck             bool                   `json:"consistencyCheck,omitempty"`     // Compare the records of hostnames published to several devices
+	HealInconsistencies          bool                   `json:"healInconsistencies,omitempty"`  // Rewrite devices whose records differ from the desired state
+	VerifyResolver               string                 `json:"verifyResolver,omitempty"`       // DNS server expected to serve created and updated records
+	VerifyTimeout                string                 `json:"verifyTimeout,omitempty"`        // How long to wait for verifyResolver to serve a write, defaults to 10s
+	Sources                      []SourceConfig         `json:"sources,omitempty"`              // Hostname sources in order of precedence, defaults to the Traefik API
+	MaxRequestsPerSecond         int                    `json:"maxRequestsPerSecond,omitempty"` // Requests started per second to each UniFi controller, unlimited by default
+	MaxChangesPerCycle           int                    `json:"maxChangesPerCycle,omitempty"`   // Abort a cycle that would create, update or delete more records, unlimited by default
+	MaxParallelDevices           int                    `json:"maxParallelDevices,omitempty"`   // Devices updated at once, 4 by default
+	OrphanGracePeriod            string                 `json:"orphanGracePeriod,omitempty"`    // How long a record stays without a router before it is deleted, deleted right away by default
+	StateFile                    string                 `json:"stateFile,omitempty"`            // File keeping records pending removal and former targetIPs across restarts
 }
 
 // CreateConfig creates the default plugin configuration.
@@ -40,88 +148,692 @@ func CreateConfig() *Config {
 
 // UniFiDNS a UniFi DNS plugin.
 type UniFiDNS struct {
-	next           http.Handler
-	name           string
-	config         *Config
-	unifiClients   map[string]*UniFiClient
-	devicePatterns map[string]*regexp.Regexp
-	traefikClient  *TraefikClient
-	updateInterval time.Duration
-	mu             sync.RWMutex
-	lastUpdate     time.Time
+	requests uint64 // Proxied requests, counted to sample them with logRequests; first for 64-bit alignment
+	*reconciler
+	next http.Handler
+	name string
+}
+
+// reconciler owns the clients and the update loop for one configuration. It
+// is shared by all plugin instances created with an identical configuration.
+type reconciler struct {
+	config            *Config
+	providers         map[string]dnsProvider
+	devicePatterns    map[string]*regexp.Regexp
+	defaultDevice     int                  // Receives hostnames no pattern matches, -1 if none
+	nameTemplates     []*template.Template // Per device, nil when names are published as discovered
+	traefikClient     *TraefikClient
+	updateInterval    time.Duration
+	cycleTimeout      time.Duration
+	discoveryInterval time.Duration // Zero when routers are only read by full cycles
+	maxUpdateInterval time.Duration // Zero when the update interval is fixed
+	maxStaleness      time.Duration
+	allowedTargets    []*net.IPNet
+	preferredNets     []*net.IPNet
+	localSources      sourceFilter // Local addresses that may be published
+	hostnames         hostnameFilter
+	syncCh            chan struct{}
+	scheduleCh        chan struct{} // Wakes the update loop up after a schedule change
+	heartbeat         *heartbeat
+	pushgateway       *pushgateway
+	report            *reportWriter
+	audit             *auditLog
+	errorReporter     errorReporter
+	registry          *txtRegistry
+	mqtt              *mqttPublisher
+	virtualIP         *virtualIP
+	resolver          *dnsResolver // Nil without precheckResolver
+	verifier          *dnsResolver // Nil without verifyResolver
+	verifyTimeout     time.Duration
+	adminToken        secret
+	adminPassword     secret
+	syncToken         secret
+	mu                sync.RWMutex
+	lastUpdate        time.Time
+	results           []hostResult     // Outcome of every hostname in the last cycle
+	ipResolver        IPResolver       // Replaces the local IP detection when set
+	sources           []HostnameSource // Empty for the Traefik API alone
+	targets           map[int][]string // Addresses of devices with their own target, cached for a cycle, guarded by targetsMu
+	targetsMu         sync.Mutex
+	nodeChecker       *nodeChecker
+	liveNodes         []string   // The targetIPs passing the health check of the last cycle
+	localIPv6         string     // Published as AAAA record with enableIPv6, resolved every cycle
+	quotaMu           sync.Mutex // Guards changes and quotaExceeded, devices are updated in parallel
+	changes           int        // Records changed in the running cycle
+	quotaExceeded     bool       // The running cycle needed more changes than maxChangesPerCycle
+	orphanGracePeriod time.Duration
+	pendingRemovals   map[string]map[string]time.Time // When orphaned records were first seen, by device and hostname
+	srvRecords        map[dnsProvider]map[string]bool // SRV records published since the start, by device
+	nodeAddresses     map[string]bool                 // Addresses published from targetIPs, also those since removed
+	knownHosts        map[string]bool                 // Hostnames published in the last complete cycle
+	unmatched         map[string]bool                 // Hostnames no device matched in the last complete cycle
+	routerSignatures  map[string]string               // Router signatures by hostname, as of the last update
+	inconsistencies   []inconsistency                 // Found by the consistency check of the last complete cycle
+	stats             syncStats
+
+	// Registry bookkeeping, guarded by registryMu
+	key        string
+	refs       int
+	clientKeys []string
+	ctx        context.Context
+	cancel     context.CancelFunc
 }
 
 // New created a new UniFi DNS plugin.
 func New(ctx context.Context, next http.Handler, config *Config, name string) (http.Handler, error) {
+	r, created, err := acquireReconciler(config)
+	if err != nil {
+		return nil, err
+	}
+	if created {
+		r.probeCapabilities(ctx)
+	}
+	if created && config.PermissionPreflight {
+		if err := r.preflight(ctx); err != nil {
+			releaseReconciler(r)
+			return nil, err
+		}
+	}
+	go func() {
+		<-ctx.Done()
+		releaseReconciler(r)
+	}()
+
+	u := &UniFiDNS{
+		reconciler: r,
+		next:       next,
+		name:       name,
+	}
+
+	if config.AdminPath != "" && r.adminToken.empty() && config.AdminUsername == "" {
+		log.Printf("WARN: Admin endpoints at %s are enabled without authentication", config.AdminPath)
+	}
+
+	if !created {
+		log.Printf("INFO: Plugin %s shares the update loop of an identical configuration", name)
+		return u, nil
+	}
+
+	// Run initial update
+	if r.updateOnStartup() {
+		if result := r.sync(r.ctx); result.Err != nil {
+			log.Printf("ERROR: Initial DNS update failed: %v", result.Err)
+		}
+	} else {
+		log.Printf("INFO: Skipping initial DNS update, waiting for an update requested at %s/sync", strings.TrimSuffix(config.AdminPath, "/"))
+	}
+
+	// Start the update goroutine
+	go r.updateLoop(r.ctx)
+	log.Printf("INFO: Plugin %s initialized with update interval: %s", Version, r.updateInterval)
+
+	return u, nil
+}
+
+// newReconciler validates config and acquires the clients it references. It
+// expects registryMu to be held.
+func newReconciler(config *Config) (*reconciler, error) {
+	warnings, err := migrateConfig(config)
+	if err != nil {
+		log.Printf("ERROR: Invalid configuration: %v", err)
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	for _, warning := range warnings {
+		log.Printf("WARN: Deprecated configuration: %s, set configVersion %d after updating it", warning, currentConfigVersion)
+	}
+
 	interval, err := time.ParseDuration(config.UpdateInterval)
 	if err != nil {
 		log.Printf("ERROR: Invalid update interval: %v", err)
 		return nil, fmt.Errorf("invalid update interval: %w", err)
 	}
 
-	// Initialize UnifiClients and compile patterns
-	unifiClients := make(map[string]*UniFiClient)
-	devicePatterns := make(map[string]*regexp.Regexp)
+	for i, device := range config.Devices {
+		if device.TargetIP != "" && net.ParseIP(device.TargetIP) == nil {
+			log.Printf("ERROR: Invalid target IP for device %d: %q", i, device.TargetIP)
+			return nil, fmt.Errorf("invalid target IP for device %d: %q", i, device.TargetIP)
+		}
+		if device.MaxConcurrentRequests < 0 {
+			log.Printf("ERROR: Invalid maxConcurrentRequests for device %d: %d", i, device.MaxConcurrentRequests)
+			return nil, fmt.Errorf("invalid maxConcurrentRequests for device %d: %d", i, device.MaxConcurrentRequests)
+		}
+		if device.ProxyAuthHeader != "" {
+			if _, _, ok := parseHeaderLine(device.ProxyAuthHeader); !ok {
+				log.Printf("ERROR: Invalid proxyAuthHeader for device %d, expected \"Name: value\"", i)
+				return nil, fmt.Errorf("invalid proxyAuthHeader for device %d, expected \"Name: value\"", i)
+			}
+		}
+		if err := checkScheme(device.Host, device.AllowInsecureHTTP); err != nil {
+			log.Printf("ERROR: Invalid host for device %d: %v", i, err)
+			return nil, fmt.Errorf("invalid host for device %d: %w", i, err)
+		}
+		if _, err := expandAPIPaths(device.APIPaths, defaultSite); err != nil {
+			log.Printf("ERROR: Invalid apiPaths for device %d: %v", i, err)
+			return nil, fmt.Errorf("invalid apiPaths for device %d: %w", i, err)
+		}
+		if err := validateAuthMethod(device); err != nil {
+			log.Printf("ERROR: Invalid authentication for device %d: %v", i, err)
+			return nil, fmt.Errorf("invalid authentication for device %d: %w", i, err)
+		}
+		if _, err := newRetryPolicy(device); err != nil {
+			log.Printf("ERROR: Invalid retry policy for device %d: %v", i, err)
+			return nil, fmt.Errorf("invalid retry policy for device %d: %w", i, err)
+		}
+		if err := validateRecordMode(device); err != nil {
+			log.Printf("ERROR: Invalid recordMode for device %d: %v", i, err)
+			return nil, fmt.Errorf("invalid recordMode for device %d: %w", i, err)
+		}
+		if device.TargetWANIP && device.WebhookURL != "" {
+			log.Printf("ERROR: Device %d uses targetWanIP without a UniFi controller", i)
+			return nil, fmt.Errorf("device %d uses targetWanIP without a UniFi controller", i)
+		}
+		if _, err := loadClientCertificate(device.ClientCertFile, device.ClientKeyFile); err != nil {
+			log.Printf("ERROR: Invalid client certificate for device %d: %v", i, err)
+			return nil, fmt.Errorf("invalid client certificate for device %d: %w", i, err)
+		}
+		if _, err := loadCACertPool(device.CACert, device.CACertFile); err != nil {
+			log.Printf("ERROR: Invalid CA certificates for device %d: %v", i, err)
+			return nil, fmt.Errorf("invalid CA certificates for device %d: %w", i, err)
+		}
+	}
+
+	clientCert, err := loadClientCertificate(config.ClientCertFile, config.ClientKeyFile)
+	if err != nil {
+		log.Printf("ERROR: Invalid client certificate: %v", err)
+		return nil, fmt.Errorf("invalid client certificate: %w", err)
+	}
+	rootCAs, err := loadCACertPool(config.CACert, config.CACertFile)
+	if err != nil {
+		log.Printf("ERROR: Invalid CA certificates: %v", err)
+		return nil, fmt.Errorf("invalid CA certificates: %w", err)
+	}
+
+	for hostname, ip := range config.IPOverrides {
+		if net.ParseIP(ip) == nil {
+			log.Printf("ERROR: Invalid IP override for %s: %q", hostname, ip)
+			return nil, fmt.Errorf("invalid IP override for %s: %q", hostname, ip)
+		}
+	}
+
+	for _, ip := range config.TargetIPs {
+		if net.ParseIP(ip) == nil {
+			log.Printf("ERROR: Invalid target IP: %q", ip)
+			return nil, fmt.Errorf("invalid target IP: %q", ip)
+		}
+	}
+
+	if config.VirtualIP != "" && net.ParseIP(config.VirtualIP) == nil {
+		log.Printf("ERROR: Invalid virtual IP: %q", config.VirtualIP)
+		return nil, fmt.Errorf("invalid virtual IP: %q", config.VirtualIP)
+	}
+
+	if config.PublicIPURL != "" {
+		if err := validatePublicIPURL(config.PublicIPURL); err != nil {
+			log.Printf("ERROR: Invalid publicIPURL: %v", err)
+			return nil, fmt.Errorf("invalid publicIPURL: %w", err)
+		}
+		if len(config.TargetIPs) > 0 || config.VirtualIP != "" {
+			log.Printf("ERROR: publicIPURL cannot be combined with targetIPs or virtualIP")
+			return nil, fmt.Errorf("publicIPURL cannot be combined with targetIPs or virtualIP")
+		}
+	}
+
+	cycleTimeout := interval
+	if config.CycleTimeout != "" {
+		cycleTimeout, err = time.ParseDuration(config.CycleTimeout)
+		if err != nil {
+			log.Printf("ERROR: Invalid cycle timeout: %v", err)
+			return nil, fmt.Errorf("invalid cycle timeout: %w", err)
+		}
+	}
+
+	var maxUpdateInterval time.Duration
+	if config.MaxUpdateInterval != "" {
+		maxUpdateInterval, err = time.ParseDuration(config.MaxUpdateInterval)
+		if err == nil && maxUpdateInterval < interval {
+			err = fmt.Errorf("shorter than updateInterval %s", interval)
+		}
+		if err != nil {
+			log.Printf("ERROR: Invalid max update interval: %v", err)
+			return nil, fmt.Errorf("invalid max update interval: %w", err)
+		}
+	}
+
+	verifyTimeout := defaultVerifyTimeout
+	if config.VerifyTimeout != "" {
+		verifyTimeout, err = time.ParseDuration(config.VerifyTimeout)
+		if err == nil && verifyTimeout <= 0 {
+			err = fmt.Errorf("must be positive")
+		}
+		if err != nil {
+			log.Printf("ERROR: Invalid verify timeout: %v", err)
+			return nil, fmt.Errorf("invalid verify timeout: %w", err)
+		}
+	}
+
+	var discoveryInterval time.Duration
+	if config.DiscoveryInterval != "" {
+		discoveryInterval, err = time.ParseDuration(config.DiscoveryInterval)
+		if err == nil && discoveryInterval <= 0 {
+			err = fmt.Errorf("must be positive")
+		}
+		if err != nil {
+			log.Printf("ERROR: Invalid discovery interval: %v", err)
+			return nil, fmt.Errorf("invalid discovery interval: %w", err)
+		}
+		if discoveryInterval >= interval {
+			log.Printf("WARN: discoveryInterval %s is not shorter than updateInterval %s, discovery only adds load", discoveryInterval, interval)
+		}
+	}
+
+	var maxStaleness time.Duration
+	if config.MaxStaleness != "" {
+		maxStaleness, err = time.ParseDuration(config.MaxStaleness)
+		if err != nil {
+			log.Printf("ERROR: Invalid max staleness: %v", err)
+			return nil, fmt.Errorf("invalid max staleness: %w", err)
+		}
+	}
+
+	var orphanGracePeriod time.Duration
+	if config.OrphanGracePeriod != "" {
+		orphanGracePeriod, err = time.ParseDuration(config.OrphanGracePeriod)
+		if err == nil && orphanGracePeriod < 0 {
+			err = fmt.Errorf("must not be negative")
+		}
+		if err != nil {
+			log.Printf("ERROR: Invalid orphan grace period: %v", err)
+			return nil, fmt.Errorf("invalid orphan grace period: %w", err)
+		}
+	}
+
+	var state pluginState
+	if config.StateFile != "" {
+		state, err = loadState(config.StateFile)
+		if err != nil {
+			log.Printf("ERROR: Invalid state file: %v", err)
+			return nil, fmt.Errorf("invalid state file: %w", err)
+		}
+	}
+
+	allowedTargets, err := parseCIDRs(config.AllowedTargetCIDRs)
+	if err != nil {
+		log.Printf("ERROR: Invalid allowedTargetCIDRs: %v", err)
+		return nil, fmt.Errorf("invalid allowedTargetCIDRs: %w", err)
+	}
 
+	hostnames, err := newHostnameFilter(config.IncludeHostnames, config.ExcludeHostnames)
+	if err != nil {
+		log.Printf("ERROR: Invalid hostname filter: %v", err)
+		return nil, fmt.Errorf("invalid hostname filter: %w", err)
+	}
+
+	preferredNets, err := parseCIDRs(config.PreferredSubnets)
+	if err != nil {
+		log.Printf("ERROR: Invalid preferredSubnets: %v", err)
+		return nil, fmt.Errorf("invalid preferredSubnets: %w", err)
+	}
+	allowedSources, err := parseCIDRs(config.AllowedSourceCIDRs)
+	if err != nil {
+		log.Printf("ERROR: Invalid allowedSourceCIDRs: %v", err)
+		return nil, fmt.Errorf("invalid allowedSourceCIDRs: %w", err)
+	}
+	excludedSources, err := parseCIDRs(config.ExcludedSourceCIDRs)
+	if err != nil {
+		log.Printf("ERROR: Invalid excludedSourceCIDRs: %v", err)
+		return nil, fmt.Errorf("invalid excludedSourceCIDRs: %w", err)
+	}
+
+	if config.UpdateOnStartup != nil && !*config.UpdateOnStartup && config.AdminPath == "" && config.SyncToken == "" {
+		log.Printf("ERROR: updateOnStartup is disabled without an adminPath or syncToken to request the first update")
+		return nil, fmt.Errorf("updateOnStartup is disabled without an adminPath or syncToken to request the first update")
+	}
+
+	if config.MaxChangesPerCycle < 0 {
+		log.Printf("ERROR: Invalid maxChangesPerCycle: %d", config.MaxChangesPerCycle)
+		return nil, fmt.Errorf("invalid maxChangesPerCycle: %d", config.MaxChangesPerCycle)
+	}
+	if config.LogSampleRate < 0 {
+		log.Printf("ERROR: Invalid logSampleRate: %d", config.LogSampleRate)
+		return nil, fmt.Errorf("invalid logSampleRate: %d", config.LogSampleRate)
+	}
+	if config.MaxParallelDevices < 0 {
+		log.Printf("ERROR: Invalid maxParallelDevices: %d", config.MaxParallelDevices)
+		return nil, fmt.Errorf("invalid maxParallelDevices: %d", config.MaxParallelDevices)
+	}
+	if config.MaxRequestsPerSecond < 0 {
+		log.Printf("ERROR: Invalid maxRequestsPerSecond: %d", config.MaxRequestsPerSecond)
+		return nil, fmt.Errorf("invalid maxRequestsPerSecond: %d", config.MaxRequestsPerSecond)
+	}
+
+	nameTemplates, err := parseNameTemplates(config)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		return nil, err
+	}
+
+	if config.ZoneFile != nil && config.ZoneFile.Path == "" {
+		log.Printf("ERROR: Zone file export is missing a path")
+		return nil, fmt.Errorf("zone file export is missing a path")
+	}
+	if config.UnboundFile != nil && config.UnboundFile.Path == "" {
+		log.Printf("ERROR: Unbound include file export is missing a path")
+		return nil, fmt.Errorf("unbound include file export is missing a path")
+	}
+
+	var report *reportWriter
+	if config.Report != nil {
+		report, err = newReportWriter(*config.Report)
+		if err != nil {
+			log.Printf("ERROR: Invalid report configuration: %v", err)
+			return nil, fmt.Errorf("invalid report configuration: %w", err)
+		}
+	}
+
+	var audit *auditLog
+	if config.AuditLog != "" {
+		audit, err = newAuditLog(config.AuditLog)
+		if err != nil {
+			log.Printf("ERROR: Invalid audit log: %v", err)
+			return nil, fmt.Errorf("invalid audit log: %w", err)
+		}
+	}
+
+	var nodeChecker *nodeChecker
+	if config.NodeHealthCheck != nil {
+		if len(config.TargetIPs) == 0 {
+			log.Printf("ERROR: nodeHealthCheck requires targetIPs")
+			return nil, fmt.Errorf("nodeHealthCheck requires targetIPs")
+		}
+		nodeChecker, err = newNodeChecker(*config.NodeHealthCheck)
+		if err != nil {
+			log.Printf("ERROR: Invalid nodeHealthCheck: %v", err)
+			return nil, fmt.Errorf("invalid nodeHealthCheck: %w", err)
+		}
+	}
+
+	var mqtt *mqttPublisher
+	if config.MQTT != nil {
+		mqtt, err = newMQTTPublisher(*config.MQTT)
+		if err != nil {
+			log.Printf("ERROR: Invalid MQTT configuration: %v", err)
+			return nil, fmt.Errorf("invalid MQTT configuration: %w", err)
+		}
+	}
+
+	// Compile patterns
+	devicePatterns := make(map[string]*regexp.Regexp)
+	defaultDevice := -1
 	for i, device := range config.Devices {
-		if device.Pattern == "" {
+		if device.Default {
+			if defaultDevice >= 0 {
+				log.Printf("ERROR: Devices %d and %d are both marked as default", defaultDevice, i)
+				return nil, fmt.Errorf("devices %d and %d are both marked as default", defaultDevice, i)
+			}
+			defaultDevice = i
+			if len(device.Patterns) == 0 && device.Glob == "" {
+				continue
+			}
+		}
+		if len(device.Patterns) > 0 && device.Glob != "" {
+			log.Printf("ERROR: Device %d has both a pattern and a glob", i)
+			return nil, fmt.Errorf("device %d has both a pattern and a glob", i)
+		}
+		if device.Glob != "" {
+			re, err := compileGlob(device.Glob)
+			if err != nil {
+				log.Printf("ERROR: Invalid glob for device %d: %v", i, err)
+				return nil, fmt.Errorf("invalid glob for device %d: %w", i, err)
+			}
+			devicePatterns[fmt.Sprintf("device-%d", i)] = re
+			continue
+		}
+		if len(device.Patterns) == 0 {
 			log.Printf("ERROR: Device %d is missing a pattern", i)
 			return nil, fmt.Errorf("device %d is missing a pattern", i)
 		}
 
-		// Compile the regex pattern
-		re, err := regexp.Compile(device.Pattern)
+		// Compile the regex patterns
+		re, err := compilePatterns(device.Patterns)
 		if err != nil {
 			log.Printf("ERROR: Invalid pattern for device %d: %v", i, err)
 			return nil, fmt.Errorf("invalid pattern for device %d: %w", i, err)
 		}
+		devicePatterns[fmt.Sprintf("device-%d", i)] = re
+	}
 
-		// Create a client for this device
-		skipVerify := device.InsecureSkipVerifyTLS || config.InsecureSkipVerifyTLS
-		client := NewUniFiClient(device.Host, device.Username, device.Password, skipVerify)
-		clientID := fmt.Sprintf("device-%d", i)
-		unifiClients[clientID] = client
-		devicePatterns[clientID] = re
+	if config.TraefikAPIToken != "" && (config.TraefikAPIUsername != "" || config.TraefikAPIPassword != "") {
+		log.Printf("ERROR: Invalid Traefik API credentials: traefikApiToken can't be combined with traefikApiUsername or traefikApiPassword")
+		return nil, fmt.Errorf("invalid Traefik API credentials: traefikApiToken can't be combined with traefikApiUsername or traefikApiPassword")
+	}
+	if config.TraefikAPIPassword != "" && config.TraefikAPIUsername == "" {
+		log.Printf("ERROR: Invalid Traefik API credentials: traefikApiPassword without traefikApiUsername")
+		return nil, fmt.Errorf("invalid Traefik API credentials: traefikApiPassword without traefikApiUsername")
 	}
 
-	u := &UniFiDNS{
-		next:           next,
-		name:           name,
-		config:         config,
-		unifiClients:   unifiClients,
-		devicePatterns: devicePatterns,
-		traefikClient:  NewTraefikClient(config.TraefikAPIURL, config.InsecureSkipVerifyTLS),
-		updateInterval: interval,
+	if config.AdminUsername != "" && config.AdminPassword == "" {
+		log.Printf("ERROR: Invalid admin credentials: adminUsername without adminPassword")
+		return nil, fmt.Errorf("invalid admin credentials: adminUsername without adminPassword")
 	}
 
-	// Run initial update
-	if err := u.updateDNS(); err != nil {
-		log.Printf("ERROR: Initial DNS update failed: %v", err)
+	var middlewareName *regexp.Regexp
+	if config.MiddlewareName != "" {
+		middlewareName, err = regexp.Compile(config.MiddlewareName)
+		if err != nil {
+			log.Printf("ERROR: Invalid middlewareName: %v", err)
+			return nil, fmt.Errorf("invalid middlewareName: %w", err)
+		}
 	}
 
-	// Start the update goroutine
-	go u.updateLoop(ctx)
-	log.Printf("INFO: Plugin initialized with update interval: %s", interval)
+	// Get a provider for each device. UniFi clients are shared with other
+	// reconcilers using the same controller and credentials.
+	providers := make(map[string]dnsProvider)
+	traefikClient := NewTraefikClient(config.TraefikAPIURL, config.TraefikInsecureSkipVerifyTLS)
+	if middlewareName != nil {
+		traefikClient.setMiddlewareName(middlewareName)
+	}
+	traefikClient.setCredentials(config.TraefikAPIUsername, config.TraefikAPIPassword, config.TraefikAPIToken)
+	if clientCert != nil {
+		traefikClient.setClientCertificate(*clientCert)
+	}
+	if rootCAs != nil {
+		traefikClient.setRootCAs(rootCAs)
+	}
+	if config.TraefikRawData {
+		traefikClient.setRawData()
+	}
+	traefikClient.setRouterFilter(newRouterFilter(config.IncludeProviders, config.ExcludeProviders, config.EntryPoints))
+	var sources []HostnameSource
+	for i, sourceConfig := range config.Sources {
+		source, err := newHostnameSource(sourceConfig, traefikClient, config.TCPRouters)
+		if err != nil {
+			log.Printf("ERROR: Invalid source %d: %v", i, err)
+			return nil, fmt.Errorf("invalid source %d: %w", i, err)
+		}
+		sources = append(sources, source)
+	}
 
-	return u, nil
+	var clientKeys []string
+	for i, device := range config.Devices {
+		skipVerify := device.InsecureSkipVerifyTLS
+		clientID := fmt.Sprintf("device-%d", i)
+		if device.WebhookURL != "" {
+			providers[clientID] = newWebhookProvider(device.WebhookURL, skipVerify)
+			continue
+		}
+		device.ClientCertFile, device.ClientKeyFile = deviceClientCertificateFiles(config, device)
+		device.CACert, device.CACertFile = deviceCACert(config, device)
+		client, key := acquireUniFiClient(device, skipVerify, config.MaxRequestsPerSecond)
+		providers[clientID] = client
+		clientKeys = append(clientKeys, key)
+	}
+
+	r := &reconciler{
+		config:            config,
+		providers:         providers,
+		devicePatterns:    devicePatterns,
+		traefikClient:     traefikClient,
+		sources:           sources,
+		updateInterval:    interval,
+		cycleTimeout:      cycleTimeout,
+		discoveryInterval: discoveryInterval,
+		maxUpdateInterval: maxUpdateInterval,
+		verifyTimeout:     verifyTimeout,
+		orphanGracePeriod: orphanGracePeriod,
+		pendingRemovals:   state.PendingRemovals,
+		maxStaleness:      maxStaleness,
+		allowedTargets:    allowedTargets,
+		preferredNets:     preferredNets,
+		hostnames:         hostnames,
+		localSources:      sourceFilter{allowed: allowedSources, excluded: excludedSources},
+		nameTemplates:     nameTemplates,
+		mqtt:              mqtt,
+		nodeChecker:       nodeChecker,
+		report:            report,
+		audit:             audit,
+		adminToken:        newSecret(config.AdminToken),
+		adminPassword:     newSecret(config.AdminPassword),
+		syncToken:         newSecret(config.SyncToken),
+		syncCh:            make(chan struct{}, 1),
+		scheduleCh:        make(chan struct{}, 1),
+		clientKeys:        clientKeys,
+	}
+	r.stats.startedAt = time.Now()
+	if config.HeartbeatURL != "" {
+		r.heartbeat = newHeartbeat(config.HeartbeatURL)
+	}
+	if config.ErrorReportURL != "" {
+		r.errorReporter = newHTTPErrorReporter(config.ErrorReportURL)
+	}
+	if config.PushgatewayURL != "" {
+		r.pushgateway = newPushgateway(config.PushgatewayURL, config.PushgatewayJob)
+	}
+	if config.VirtualIP != "" {
+		r.virtualIP = newVirtualIP(config.VirtualIP, config.VirtualIPPort)
+	}
+	if config.PrecheckResolver != "" {
+		r.resolver = newDNSResolver(config.PrecheckResolver)
+	}
+	if config.VerifyResolver != "" {
+		r.verifier = newDNSResolver(config.VerifyResolver)
+	}
+	if config.OwnerID != "" {
+		r.registry = &txtRegistry{ownerID: config.OwnerID, prefix: config.TXTPrefix}
+	}
+	r.restoreState(state)
+
+	return r, nil
 }
 
 func (u *UniFiDNS) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if u.isAdminRequest(req) {
+		u.serveAdmin(rw, req)
+		return
+	}
+	u.checkSyncHeader(req)
+	u.setDebugHeader(rw, req)
 	u.next.ServeHTTP(rw, req)
-	log.Printf("INFO: Served HTTP request: %s %s", req.Method, req.URL.Path)
+	u.logRequest(req)
+}
+
+// updateOnStartup reports whether the first update runs when the plugin
+// starts, rather than when it is requested through the admin sync endpoint.
+func (r *reconciler) updateOnStartup() bool {
+	return r.config.UpdateOnStartup == nil || *r.config.UpdateOnStartup
 }
 
-func (u *UniFiDNS) updateLoop(ctx context.Context) {
-	log.Printf("INFO: Starting DNS update loop with interval: %s", u.updateInterval)
-	ticker := time.NewTicker(u.updateInterval)
+func (r *reconciler) updateLoop(ctx context.Context) {
+	// Staleness is also checked between cycles, which may not run at all
+	var staleness <-chan time.Time
+	if interval := r.stalenessCheckInterval(); interval > 0 {
+		stalenessTicker := time.NewTicker(interval)
+		defer stalenessTicker.Stop()
+		staleness = stalenessTicker.C
+	}
+
+	if !r.updateOnStartup() {
+		// Periodic updates only begin after the first requested one
+	waiting:
+		for {
+			select {
+			case <-r.syncCh:
+				log.Printf("INFO: Running first requested DNS update")
+				if result := r.sync(ctx); result.Err != nil {
+					logError("DNS update failed: %v", result.Err)
+				}
+				break waiting
+			case <-staleness:
+				r.watchStaleness()
+			case <-ctx.Done():
+				log.Printf("INFO: Stopping DNS update loop")
+				return
+			}
+		}
+	}
+
+	log.Printf("INFO: Starting DNS update loop with interval: %s", r.updateInterval)
+	ticker := time.NewTicker(r.updateInterval)
 	defer ticker.Stop()
 
+	var discovery <-chan time.Time
+	if r.discoveryInterval > 0 {
+		log.Printf("INFO: Discovering changed Traefik routers every %s", r.discoveryInterval)
+		discoveryTicker := time.NewTicker(r.discoveryInterval)
+		defer discoveryTicker.Stop()
+		discovery = discoveryTicker.C
+	}
+
+	// interval is the adaptive interval, scheduled the one the ticker runs
+	// at, which differs while overridden through the admin endpoint
+	interval := r.updateInterval
+	scheduled := interval
+	reschedule := func(ticked bool) {
+		if next := r.scheduledInterval(interval); next != scheduled {
+			log.Printf("INFO: Next DNS update in %s", next)
+			scheduled = next
+			ticker.Reset(scheduled)
+			ticked = true
+		}
+		if ticked {
+			r.setNextUpdate(scheduled)
+		}
+	}
+	r.setNextUpdate(scheduled)
+
 	for {
 		select {
 		case <-ticker.C:
-			if err := u.updateDNS(); err != nil {
-				log.Printf("ERROR: DNS update failed: %v", err)
+			if r.paused() {
+				reschedule(true)
+				continue
+			}
+			result := r.sync(ctx)
+			if result.Err != nil {
+				logError("DNS update failed: %v", result.Err)
+			}
+			interval = r.adaptInterval(interval, result)
+			reschedule(true)
+		case <-discovery:
+			if !r.paused() && r.discover(ctx) {
+				interval = r.updateInterval
+				reschedule(false)
 			}
+		case <-r.syncCh:
+			if r.paused() {
+				log.Printf("INFO: Skipping requested DNS update, updates are paused")
+				continue
+			}
+			log.Printf("INFO: Running requested DNS update")
+			result := r.sync(ctx)
+			if result.Err != nil {
+				logError("DNS update failed: %v", result.Err)
+			}
+			interval = r.adaptInterval(interval, result)
+			reschedule(false)
+		case <-r.scheduleCh:
+			reschedule(false)
+		case <-staleness:
+			r.watchStaleness()
 		case <-ctx.Done():
 			log.Printf("INFO: Stopping DNS update loop")
 			return
@@ -129,86 +841,726 @@ func (u *UniFiDNS) updateLoop(ctx context.Context) {
 	}
 }
 
-// findMatchingClient returns the unifi client that matches the given hostname
-func (u *UniFiDNS) findMatchingClient(hostname string) (*UniFiClient, bool) {
-	for clientID, pattern := range u.devicePatterns {
-		if pattern.MatchString(hostname) {
-			log.Printf("INFO: Found matching client for hostname: %s", hostname)
-			return u.unifiClients[clientID], true
+// adaptInterval returns the interval until the next update after result.
+// With maxUpdateInterval configured, every cycle without changes doubles the
+// interval up to that maximum, and any change or failure snaps it back to
+// updateInterval.
+func (r *reconciler) adaptInterval(current time.Duration, result SyncResult) time.Duration {
+	if r.maxUpdateInterval <= r.updateInterval || result.Err != nil ||
+		result.Counts.Created+result.Counts.Updated+result.Counts.Deleted+result.Counts.Failed > 0 {
+		return r.updateInterval
+	}
+	if next := 2 * current; next < r.maxUpdateInterval {
+		return next
+	}
+	return r.maxUpdateInterval
+}
+
+// matchingDevices returns the indexes of all devices whose pattern matches
+// the given hostname, in configuration order, or the default device if none
+// does.
+func (r *reconciler) matchingDevices(hostname string) []int {
+	var devices []int
+	for i := range r.config.Devices {
+		if pattern, ok := r.devicePatterns[fmt.Sprintf("device-%d", i)]; ok && pattern.MatchString(hostname) {
+			log.Printf("INFO: Found matching device %d for hostname: %s", i, hostname)
+			devices = append(devices, i)
+		}
+	}
+	if len(devices) == 0 {
+		for i, device := range r.config.Devices {
+			if device.Default {
+				log.Printf("INFO: Using default device %d for hostname: %s", i, hostname)
+				return []int{i}
+			}
+		}
+	}
+	return devices
+}
+
+// targetIPs returns the addresses to publish for hostname on device. IP
+// overrides for the hostname take precedence over the device's targetIP,
+// targetHostname and targetWanIP, followed by the configured targetIPs and
+// finally the detected local IP.
+func (r *reconciler) targetIPs(ctx context.Context, hostname string, device int, defaults []string) ([]string, error) {
+	if ip, ok := r.config.IPOverrides[hostname]; ok {
+		log.Printf("INFO: Using IP override %s for hostname: %s", ip, hostname)
+		return []string{ip}, nil
+	}
+	if ips, ok, err := r.deviceTargets(ctx, device); ok {
+		return ips, err
+	}
+	if len(r.config.TargetIPs) > 0 {
+		return r.nodeTargets(), nil
+	}
+	return defaults, nil
+}
+
+// resolveIPv4 returns the first IPv4 address of hostname.
+func resolveIPv4(hostname string) (string, error) {
+	ips, err := net.LookupIP(hostname)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve target hostname %s: %w", hostname, err)
+	}
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			return ip.String(), nil
+		}
+	}
+	return "", fmt.Errorf("target hostname %s has no IPv4 address", hostname)
+}
+
+// entryPointIP returns the bind address of the first entrypoint of router that
+// is bound to a specific address.
+func entryPointIP(router TraefikRouter, addresses map[string]string) (string, bool) {
+	for _, name := range router.EntryPoints {
+		if ip, ok := addresses[name]; ok {
+			return ip, true
 		}
 	}
-	return nil, false
+	return "", false
 }
 
-func (u *UniFiDNS) updateDNS() error {
-	u.mu.Lock()
-	defer u.mu.Unlock()
+// apexProtected reports whether name is one of the apexDomains without being
+// allowed explicitly.
+func (r *reconciler) apexProtected(name string) bool {
+	name = strings.TrimSuffix(name, ".")
+	return containsFold(r.config.ApexDomains, name) && !containsFold(r.config.AllowedApexDomains, name)
+}
+
+// containsFold reports whether domains contains name, ignoring case and a
+// trailing dot.
+func containsFold(domains []string, name string) bool {
+	for _, domain := range domains {
+		if strings.EqualFold(strings.TrimSuffix(domain, "."), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// targetAllowed reports whether ip may be published. Without configured
+// allowedTargetCIDRs every address is allowed.
+func (r *reconciler) targetAllowed(ip string) bool {
+	if len(r.allowedTargets) == 0 {
+		return true
+	}
+	return ipInNets(net.ParseIP(ip), r.allowedTargets)
+}
+
+// updateDNS runs one update cycle and returns its outcome.
+func (r *reconciler) updateDNS(ctx context.Context) SyncResult {
+	return r.updateHostnames(ctx, nil)
+}
+
+// updateHostnames runs an update cycle limited to the given hostnames, or a
+// full cycle if hostnames is nil, and returns its outcome.
+func (r *reconciler) updateHostnames(ctx context.Context, hostnames []string) SyncResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	start := time.Now()
+	err := r.reconcile(ctx, hostnames)
+	result := newSyncResult(start, r.results, err)
+	result.Hostnames = hostnames
+	result.Inconsistencies = r.inconsistencies
+	return result
+}
 
-	log.Printf("INFO: Starting DNS update cycle")
+// reconcile publishes the hostnames of all routers to their devices and
+// removes stale records, collecting the outcomes in r.results. Given a list
+// of hostnames, only those are published and nothing is removed. It expects
+// r.mu to be held.
+func (r *reconciler) reconcile(ctx context.Context, hostnames []string) error {
+	var only map[string]bool
+	if hostnames != nil {
+		log.Printf("INFO: Starting DNS update for %d changed hostnames", len(hostnames))
+		only = make(map[string]bool, len(hostnames))
+		for _, hostname := range hostnames {
+			only[hostname] = true
+		}
+	} else {
+		log.Printf("INFO: Starting DNS update cycle")
+	}
+	r.results = nil
+	r.targets = nil
+	r.changes = 0
+	r.quotaExceeded = false
+	r.inconsistencies = nil
+	r.probeCapabilities(ctx)
+	r.checkNodes(ctx)
 
-	// Get the local IP address
-	localIP, err := getLocalIP()
+	// Get the addresses published without a device target
+	localIPs, err := r.resolveDefaultTargets(ctx)
 	if err != nil {
-		log.Printf("ERROR: Failed to get local IP: %v", err)
-		return fmt.Errorf("failed to get local IP: %w", err)
+		logError("Failed to get target IP: %v", err)
+		return fmt.Errorf("failed to get target IP: %w", err)
+	}
+
+	r.localIPv6 = ""
+	if r.config.EnableIPv6 {
+		if ip, err := getLocalIPv6(r.config.TargetInterface, r.localSources); err != nil {
+			log.Printf("WARN: Only publishing A records, no local IPv6 address found: %v", err)
+		} else {
+			log.Printf("INFO: Using local IPv6: %s", ip)
+			r.localIPv6 = ip
+		}
 	}
-	log.Printf("INFO: Using local IP: %s", localIP)
 
-	// Get current Traefik routers from the API
-	routers, err := u.traefikClient.GetRouters()
+	// Get the current routers from every hostname source
+	r.traefikClient.resetRawData()
+	pending, err := r.collectHostnames(ctx)
 	if err != nil {
-		log.Printf("ERROR: Failed to get Traefik routers: %v", err)
-		return fmt.Errorf("failed to get Traefik routers: %w", err)
+		logError("Failed to collect hostnames: %v", err)
+		return fmt.Errorf("failed to collect hostnames: %w", err)
 	}
-	log.Printf("INFO: Retrieved %d routers from Traefik API", len(routers))
+	log.Printf("INFO: Retrieved %d hostnames", len(pending))
 
-	// Update DNS records for each router
-	for _, router := range routers {
-		if router.Rule == "" {
-			continue
+	var entryPoints map[string]string
+	if r.config.EntryPointTargets && len(pending) > 0 {
+		entryPoints, err = r.traefikClient.GetEntryPointAddresses(ctx)
+		if err != nil {
+			logError("Failed to get Traefik entrypoints, using the local IP: %v", err)
 		}
+	}
 
-		// Extract hostname from rule (assuming format "Host(`example.com`)"))
-		hostname := extractHostname(router.Rule)
-		if hostname == "" {
-			continue
+	var services []TraefikService
+	var entryPointPorts map[string]int
+	if r.config.SRVRecords {
+		services, err = r.traefikClient.GetServices(ctx)
+		if err == nil {
+			entryPointPorts, err = r.traefikClient.GetEntryPointPorts(ctx)
 		}
+		if err != nil {
+			logError("Failed to get Traefik services, skipping SRV records: %v", err)
+			services = nil
+		}
+	}
+
+	signatures := routerSignatures(pending)
+	if only != nil {
+		filtered := pending[:0]
+		for _, p := range pending {
+			if only[p.hostname] {
+				filtered = append(filtered, p)
+			}
+		}
+		pending = filtered
+	}
+	r.prioritizeNew(pending)
 
+	// Plan the records of each router
+	active := make(map[dnsProvider]map[string]bool)
+	unmatched := make(map[string]bool)
+	var results []hostResult
+	var jobs []publishJob
+	for _, p := range pending {
+		router, hostname := p.router, p.hostname
 		log.Printf("INFO: Processing hostname: %s", hostname)
 
-		// Find the matching UniFi client for this hostname
-		client, found := u.findMatchingClient(hostname)
-		if !found {
-			log.Printf("WARN: No matching UniFi device found for hostname: %s", hostname)
+		routerIPs := localIPs
+		if ip, ok := entryPointIP(router, entryPoints); ok {
+			log.Printf("INFO: Using entrypoint address %s for hostname: %s", ip, hostname)
+			routerIPs = []string{ip}
+		}
+
+		// Publish the hostname to every matching device
+		// Device patterns can't change during the lifetime of a reconciler,
+		// so hostnames without a match aren't evaluated and warned about again
+		var devices []int
+		if !r.unmatched[hostname] {
+			devices = r.matchingDevices(hostname)
+		}
+		if len(devices) == 0 {
+			if !r.unmatched[hostname] {
+				log.Printf("WARN: No matching UniFi device found for hostname: %s", hostname)
+			}
+			unmatched[hostname] = true
+			results = append(results, hostResult{Hostname: hostname, Action: resultSkipped, Reason: reasonNoMatch})
 			continue
 		}
+		for _, device := range devices {
+			provider := r.providers[fmt.Sprintf("device-%d", device)]
+			name, err := r.recordName(hostname, device)
+			if err != nil {
+				logError("Failed to name record for %s: %v", hostname, err)
+				results = append(results, hostResult{Hostname: hostname, Device: provider.String(), Action: resultFailed, Reason: err.Error(), err: err})
+				continue
+			}
+			if active[provider] == nil {
+				active[provider] = make(map[string]bool)
+			}
+			active[provider][name] = true
+
+			if containsFold(r.config.NeverManage, strings.TrimSuffix(name, ".")) {
+				log.Printf("INFO: Skipping %s: listed in neverManage", name)
+				results = append(results, hostResult{Hostname: name, Device: provider.String(), Action: resultSkipped, Reason: "never managed"})
+				continue
+			}
+			if r.apexProtected(name) {
+				log.Printf("WARN: Refusing to publish %s: it is a protected zone apex", name)
+				results = append(results, hostResult{Hostname: name, Device: provider.String(), Action: resultSkipped, Reason: "protected zone apex"})
+				continue
+			}
+			jobs = append(jobs, publishJob{slot: len(results), hostname: hostname, name: name, device: device, routerIPs: routerIPs})
+			results = append(results, hostResult{})
+			if srv, ok := r.planSRV(device, name, router, services, entryPointPorts); ok {
+				active[provider][srv.name] = true
+				jobs = append(jobs, publishJob{slot: len(results), hostname: hostname, name: srv.name, device: device, srv: &srv})
+				results = append(results, hostResult{})
+			}
+		}
+	}
+
+	// Update the DNS records of every device in parallel
+	publishErr := r.publishAll(ctx, jobs, results)
+	for _, result := range results {
+		if result.Hostname != "" {
+			r.results = append(r.results, result)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		logError("DNS update cycle aborted: %v", err)
+		return fmt.Errorf("DNS update cycle aborted: %w", err)
+	}
+	if err := r.quotaError(); err != nil {
+		return err
+	}
+
+	managed := make(map[dnsProvider]int)
+	published := make(map[string]bool)
+	var shared map[string][]sharedRecord
+	if r.config.ConsistencyCheck && only == nil {
+		shared = make(map[string][]sharedRecord)
+	}
+	for _, job := range jobs {
+		provider := r.providers[fmt.Sprintf("device-%d", job.device)]
+		result := results[job.slot]
+		if result.published() {
+			managed[provider]++
+			published[job.hostname] = true
+			if job.srv != nil {
+				r.rememberSRV(provider, job.name)
+			}
+		}
+		if shared != nil && job.srv == nil && r.cnameTarget(job.hostname, job.device) == "" {
+			targets := strings.Split(result.Value, ",")
+			sort.Strings(targets)
+			shared[job.hostname] = append(shared[job.hostname], sharedRecord{
+				provider: provider,
+				device:   job.device,
+				name:     job.name,
+				target:   strings.Join(targets, ","),
+				ttl:      result.TTL,
+			})
+		}
+	}
+
+	if only != nil {
+		r.mergeTargeted(only, published, unmatched, signatures)
+		log.Printf("INFO: Completed DNS update for %d changed hostnames", len(hostnames))
+		return publishErr
+	}
+
+	if shared != nil {
+		r.inconsistencies = r.checkConsistency(ctx, shared)
+	}
+
+	for id, provider := range r.providers {
+		provider.health().setManagedRecords(managed[provider])
+		if p, ok := provider.(pruner); ok && !r.dryRun(id) && !lacksStaticDNS(provider) {
+			// Records listed in neverManage are never deleted either
+			keep := active[provider]
+			if keep == nil {
+				keep = make(map[string]bool)
+			}
+			for _, name := range r.config.NeverManage {
+				keep[name] = true
+			}
+			r.holdOrphans(provider, p, keep)
+			deleted, err := p.prune(ctx, keep, r.pruneLimit())
+			for _, name := range deleted {
+				r.results = append(r.results, hostResult{Hostname: name, Device: provider.String(), Action: resultDeleted})
+			}
+			r.forgetRemoved(provider, deleted)
+			r.changes += len(deleted)
+			if errors.Is(err, errChangeQuota) {
+				logError("ALERT: Aborting DNS update cycle: %s: %v", provider, err)
+				return err
+			}
+			if err != nil {
+				logError("Failed to remove stale records from %s: %v", provider, err)
+				provider.health().recordFailure(err)
+			}
+		}
+	}
+
+	// Without the services of this cycle every SRV record would look stale
+	if services != nil {
+		if err := r.pruneSRV(ctx, active); err != nil {
+			return err
+		}
+	}
+
+	r.saveState()
+
+	r.knownHosts = published
+	r.unmatched = unmatched
+	r.routerSignatures = signatures
+	r.lastUpdate = time.Now()
+	log.Printf("INFO: Completed DNS update cycle. Last update: %s", r.lastUpdate.Format(time.RFC3339))
+	return publishErr
+}
 
-		// Update DNS record
-		if err := client.updateDNSRecord(hostname, localIP); err != nil {
-			log.Printf("ERROR: Failed to update DNS record for %s: %v", hostname, err)
+// routerHostname is a router together with the hostname of its rule.
+type routerHostname struct {
+	router   TraefikRouter
+	hostname string
+}
+
+// routerHostnames returns the routers with a Host rule together with their
+// hostnames, one entry per hostname.
+func routerHostnames(routers []TraefikRouter) []routerHostname {
+	// Extract hostnames from rules (assuming format "Host(`example.com`)"))
+	var pending []routerHostname
+	for _, router := range routers {
+		if router.Rule == "" {
 			continue
 		}
-		log.Printf("INFO: Successfully updated DNS record for %s", hostname)
+		for _, hostname := range extractHostnames(router.Rule) {
+			pending = append(pending, routerHostname{router: router, hostname: hostname})
+		}
+	}
+	return pending
+}
+
+// prioritizeNew moves the hostnames that were not published in the last
+// complete cycle to the front, so freshly deployed services become resolvable
+// before the records of existing ones are verified.
+func (r *reconciler) prioritizeNew(pending []routerHostname) {
+	if r.knownHosts == nil {
+		return
+	}
+	// Hostnames no device matches are cheap to skip and never new
+	known := func(hostname string) bool {
+		return r.knownHosts[hostname] || r.unmatched[hostname]
+	}
+	sort.SliceStable(pending, func(i, j int) bool {
+		return !known(pending[i].hostname) && known(pending[j].hostname)
+	})
+	fresh := 0
+	for _, p := range pending {
+		if !known(p.hostname) {
+			fresh++
+		}
+	}
+	if fresh > 0 {
+		log.Printf("INFO: Processing %d new hostnames first", fresh)
+	}
+}
+
+// publishRecord publishes hostname to the provider of device and returns the
+// outcome.
+func (r *reconciler) publishRecord(ctx context.Context, hostname string, device int, localIPs []string) hostResult {
+	provider := r.providers[fmt.Sprintf("device-%d", device)]
+	result := hostResult{Hostname: hostname, Device: provider.String(), Action: resultSkipped}
+
+	if lacksStaticDNS(provider) {
+		result.Reason = "static DNS unsupported"
+		return result
+	}
+
+	stats := provider.health()
+	if !stats.allow() {
+		log.Printf("WARN: Skipping %s: circuit breaker for %s is open", hostname, provider)
+		result.Reason = "circuit breaker open"
+		return result
+	}
+
+	cname := r.cnameTarget(hostname, device)
+	targets, err := r.recordTargets(ctx, hostname, device, cname, localIPs)
+	if err != nil {
+		logError("Failed to determine target for %s: %v", hostname, err)
+		result.Action, result.Reason, result.err = resultFailed, err.Error(), err
+		return result
+	}
+	result.Type, result.Value = "A", strings.Join(targets, ",")
+	if cname != "" {
+		result.Type = "CNAME"
+	}
+	for _, targetIP := range targets {
+		if cname == "" && !r.targetAllowed(targetIP) {
+			logError("ALERT: Refusing to publish %s for %s: address is outside allowedTargetCIDRs", targetIP, hostname)
+			result.Reason, result.err = "target outside allowedTargetCIDRs", fmt.Errorf("refusing to publish %s for %s: %w", targetIP, hostname, errTargetNotAllowed)
+			return result
+		}
+	}
+
+	result.TTL = r.config.TTLOverrides[hostname]
+	if r.config.Devices[device].DryRun {
+		if cname != "" {
+			log.Printf("INFO: DRY RUN: Would publish %s as CNAME of %s on %s", hostname, cname, provider)
+			result.Reason = "dry run"
+			return result
+		}
+		return r.planRecord(ctx, provider, result, targets)
+	}
+	if r.resolver != nil && cname == "" && r.resolver.resolves(hostname, targets) {
+		log.Printf("INFO: Skipping %s: %s already answers %s", hostname, r.resolver.server, result.Value)
+		result.Action, result.Reason = recordUnchanged, "resolver answers target"
+		return result
+	}
+	if client, ok := provider.(*UniFiClient); ok && r.registry != nil {
+		claimed, err := r.registry.claim(ctx, client, hostname, result.Type)
+		if errors.Is(err, errReadOnly) {
+			log.Printf("WARN: Ownership of %s cannot be claimed, %s is read-only", hostname, provider)
+			result.Reason = "read-only, not owned"
+			return result
+		}
+		if err != nil {
+			logError("Failed to check ownership of %s: %v", hostname, err)
+			stats.recordFailure(err)
+			result.Action, result.Reason, result.err = resultFailed, err.Error(), err
+			return result
+		}
+		if !claimed {
+			result.Reason = "not owned"
+			return result
+		}
+	}
+
+	reserved, ok := r.reserveChange(hostname, func() bool {
+		p, ok := provider.(planner)
+		if !ok || len(targets) != 1 {
+			return false
+		}
+		action, err := r.plannedAction(ctx, p, hostname, targets[0], result.TTL)
+		return err == nil && action == recordUnchanged
+	})
+	if !ok {
+		result.Reason = reasonChangeQuota
+		return result
+	}
+	result.reserved = reserved
+
+	var action string
+	if cp, ok := provider.(cnameRecordProvider); ok && cname != "" {
+		action, err = cp.updateCNAMERecord(ctx, hostname, cname, result.TTL)
+	} else if multi, ok := provider.(multiRecordProvider); ok && len(r.config.TargetIPs) > 0 {
+		// Also called with a single target, so records of nodes removed from
+		// targetIPs are deleted
+		action, err = multi.updateDNSRecords(ctx, hostname, targets, result.TTL, r.staleAddress)
+	} else if pp, ok := provider.(pruningProvider); ok && len(targets) == 1 {
+		action, err = pp.updateDNSRecordPruning(ctx, hostname, targets[0], result.TTL, r.staleAddress)
+	} else {
+		if len(targets) > 1 {
+			log.Printf("WARN: %s supports a single record per hostname, publishing only %s for %s", provider, targets[0], hostname)
+			result.Value = targets[0]
+		}
+		action, err = provider.updateDNSRecord(ctx, hostname, targets[0], result.TTL)
+	}
+	if errors.Is(err, errReadOnly) {
+		log.Printf("WARN: DNS record for %s differs from the desired state, but %s is read-only", hostname, provider)
+		stats.recordSuccess()
+		result.Reason = "read-only, record differs"
+		return result
+	}
+	if errors.Is(err, errWriteForbidden) {
+		if stats.setDegraded(true) {
+			log.Printf("WARN: DEGRADED: %s rejects writes, only reporting drift until permissions return: %v", provider, err)
+		}
+		stats.recordSuccess()
+		result.Reason = "degraded, record differs"
+		return result
+	}
+	if err != nil {
+		logError("Failed to update DNS record for %s: %v", hostname, err)
+		stats.recordFailure(err)
+		result.Action, result.Reason, result.err = resultFailed, err.Error(), err
+		return result
+	}
+	stats.recordSuccess()
+	result.Action = action
+	r.publishIPv6(ctx, provider, &result, targets, localIPs)
+	if result.Action == resultFailed {
+		return result
+	}
+	if result.Action != recordUnchanged && stats.setDegraded(false) {
+		log.Printf("INFO: %s accepts writes again, leaving degraded mode", provider)
+	}
+
+	log.Printf("INFO: Successfully updated DNS record for %s", hostname)
+	if r.verifier != nil && cname == "" && result.changed() && !r.verifier.verify(hostname, strings.Split(result.Value, ","), r.verifyTimeout) {
+		log.Printf("WARN: %s was written to %s, but %s still doesn't serve %s after %s", hostname, provider, r.verifier.server, result.Value, r.verifyTimeout)
+		result.NotPropagated = true
+	}
+	return result
+}
+
+// staleAddress reports whether a further A record of a published hostname
+// with address ip may be deleted. With ownerId all records of a claimed
+// hostname belong to the plugin; without it only those of the addresses
+// published from targetIPs do, now or before they were removed from it.
+// Records added by hand next to them are left alone.
+func (r *reconciler) staleAddress(ip string) bool {
+	if r.registry != nil || r.nodeAddresses[ip] {
+		return true
+	}
+	for _, target := range r.config.TargetIPs {
+		if target == ip {
+			return true
+		}
+	}
+	return false
+}
+
+// plannedAction returns what publishing ip as the A record of hostname with
+// p would do, including the deletion of stale records next to it.
+func (r *reconciler) plannedAction(ctx context.Context, p planner, hostname, ip string, ttl int) (string, error) {
+	if pp, ok := p.(pruningProvider); ok {
+		return pp.plannedPruningAction(ctx, hostname, ip, ttl, r.staleAddress)
+	}
+	return p.plannedAction(ctx, hostname, ip, ttl)
+}
+
+// dryRun reports whether the device with the given provider ID is in dry-run
+// mode.
+func (r *reconciler) dryRun(id string) bool {
+	for i, device := range r.config.Devices {
+		if fmt.Sprintf("device-%d", i) == id {
+			return device.DryRun
+		}
 	}
+	return false
+}
 
-	u.lastUpdate = time.Now()
-	log.Printf("INFO: Completed DNS update cycle. Last update: %s", u.lastUpdate.Format(time.RFC3339))
-	return nil
+// planRecord logs the change publishing result would make on a dry-run device
+// and records it as skipped.
+func (r *reconciler) planRecord(ctx context.Context, provider dnsProvider, result hostResult, targets []string) hostResult {
+	result.Reason = "dry run"
+	p, ok := provider.(planner)
+	if !ok || len(targets) > 1 {
+		log.Printf("INFO: DRY RUN: Would publish %s with IP %s on %s", result.Hostname, result.Value, provider)
+		return result
+	}
+	action, err := r.plannedAction(ctx, p, result.Hostname, targets[0], result.TTL)
+	if err != nil {
+		logError("Failed to plan DNS record for %s: %v", result.Hostname, err)
+		provider.health().recordFailure(err)
+		result.Action, result.Reason, result.err = resultFailed, err.Error(), err
+		return result
+	}
+	provider.health().recordSuccess()
+	log.Printf("INFO: DRY RUN: DNS record for %s with IP %s on %s would be %s", result.Hostname, result.Value, provider, action)
+	result.Reason = "dry run, would be " + action
+	return result
 }
 
-func getLocalIP() (string, error) {
-	addrs, err := net.InterfaceAddrs()
+// getLocalIP returns the first non-loopback IPv4 address of this host, or of
+// the interface iface if set, that sources permits, preferring addresses
+// inside the given subnets when any match.
+func getLocalIP(iface string, preferred []*net.IPNet, sources sourceFilter) (string, error) {
+	addrs, err := interfaceAddrs(iface)
 	if err != nil {
 		return "", err
 	}
+	return selectLocalIP(sources.apply(addrs), preferred)
+}
+
+// interfaceAddrs returns the addresses of the network interface name, or of
+// all interfaces if name is empty.
+func interfaceAddrs(name string) ([]net.Addr, error) {
+	if name == "" {
+		return net.InterfaceAddrs()
+	}
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("interface %s: %w", name, err)
+	}
+	return iface.Addrs()
+}
 
+func selectLocalIP(addrs []net.Addr, preferred []*net.IPNet) (string, error) {
+	var fallback string
 	for _, addr := range addrs {
 		if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() {
 			if ipnet.IP.To4() != nil {
-				return ipnet.IP.String(), nil
+				if len(preferred) == 0 || ipInNets(ipnet.IP, preferred) {
+					return ipnet.IP.String(), nil
+				}
+				if fallback == "" {
+					fallback = ipnet.IP.String()
+				}
 			}
 		}
 	}
 
+	if fallback != "" {
+		log.Printf("WARN: No local IP found in preferred subnets, using %s", fallback)
+		return fallback, nil
+	}
 	return "", fmt.Errorf("no suitable IP address found")
 }
+
+// getLocalIPv6 returns the first global unicast IPv6 address of this host, or
+// of the interface iface if set, that sources permits.
+func getLocalIPv6(iface string, sources sourceFilter) (string, error) {
+	addrs, err := interfaceAddrs(iface)
+	if err != nil {
+		return "", err
+	}
+	return selectLocalIPv6(sources.apply(addrs))
+}
+
+// selectLocalIPv6 skips loopback and link-local addresses, which other hosts
+// cannot reach through DNS.
+func selectLocalIPv6(addrs []net.Addr) (string, error) {
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.To4() == nil && ipnet.IP.IsGlobalUnicast() {
+			return ipnet.IP.String(), nil
+		}
+	}
+	return "", fmt.Errorf("no suitable IPv6 address found")
+}
+
+// compilePatterns combines regex patterns into a single expression matching
+// whatever any of them matches.
+func compilePatterns(patterns []string) (*regexp.Regexp, error) {
+	if len(patterns) == 1 {
+		return regexp.Compile(patterns[0])
+	}
+	parts := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return nil, err
+		}
+		parts = append(parts, "(?:"+pattern+")")
+	}
+	return regexp.Compile(strings.Join(parts, "|"))
+}
+
+// parseCIDRs parses a list of CIDR strings such as "192.168.0.0/16".
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// ipInNets reports whether ip is contained in any of nets.
+func ipInNets(ip net.IP, nets []*net.IPNet) bool {
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
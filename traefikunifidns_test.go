@@ -4,11 +4,15 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -56,8 +60,8 @@ func TestNew(t *testing.T) {
 	u := plugin.(*UniFiDNS)
 	assert.Equal(t, config, u.config)
 	assert.NotNil(t, u.traefikClient)
-	assert.NotNil(t, u.unifiClients)
-	assert.Len(t, u.unifiClients, 1)
+	assert.NotNil(t, u.providers)
+	assert.Len(t, u.providers, 1)
 }
 
 func TestServeHTTP(t *testing.T) {
@@ -113,7 +117,7 @@ func TestUpdateDNS(t *testing.T) {
 	}))
 	defer traefikServer.Close()
 
-	unifiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	unifiServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		switch r.URL.Path {
 		case "/api/auth/login":
 			w.Header().Set("X-Csrf-Token", "test-csrf-token")
@@ -153,13 +157,13 @@ func TestUpdateDNS(t *testing.T) {
 
 	// Run DNS update
 	u := plugin.(*UniFiDNS)
-	err = u.updateDNS()
+	err = u.updateDNS(context.Background()).Err
 	if err != nil {
 		t.Fatalf("updateDNS returned error: %v", err)
 	}
 }
 
-func TestFindMatchingClient(t *testing.T) {
+func TestMatchingDevices(t *testing.T) {
 	config := &Config{
 		Devices: []UnifiDeviceConfig{
 			{
@@ -176,6 +180,13 @@ func TestFindMatchingClient(t *testing.T) {
 				Pattern:               "test.com",
 				InsecureSkipVerifyTLS: true,
 			},
+			{
+				Host:                  "192.168.2.1",
+				Username:              "admin",
+				Password:              "password",
+				Pattern:               `\.com$`,
+				InsecureSkipVerifyTLS: true,
+			},
 		},
 		UpdateInterval: "1m",
 		TraefikAPIURL:  "http://localhost:8080",
@@ -187,34 +198,71 @@ func TestFindMatchingClient(t *testing.T) {
 	u := plugin.(*UniFiDNS)
 
 	tests := []struct {
-		name      string
-		hostname  string
-		want      *UniFiClient
-		wantFound bool
+		name     string
+		hostname string
+		want     []int
 	}{
 		{
-			name:      "exact_match",
-			hostname:  "example.com",
-			want:      u.unifiClients["device-0"],
-			wantFound: true,
+			name:     "split_horizon",
+			hostname: "example.com",
+			want:     []int{0, 2},
+		},
+		{
+			name:     "single_match",
+			hostname: "other.com",
+			want:     []int{2},
 		},
 		{
-			name:      "no_match",
-			hostname:  "unknown.com",
-			want:      nil,
-			wantFound: false,
+			name:     "no_match",
+			hostname: "unknown.org",
+			want:     nil,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, found := u.findMatchingClient(tt.hostname)
-			assert.Equal(t, tt.want, got)
-			assert.Equal(t, tt.wantFound, found)
+			assert.Equal(t, tt.want, u.matchingDevices(tt.hostname))
 		})
 	}
 }
 
+func TestMatchingDevicesDefault(t *testing.T) {
+	config := CreateConfig()
+	config.Devices = []UnifiDeviceConfig{
+		{Host: "192.168.1.1", Username: "admin", Password: "password", Pattern: `\.lab$`},
+		{Host: "192.168.1.2", Username: "admin", Password: "password", Default: true},
+	}
+
+	plugin, err := New(context.Background(), nil, config, "test")
+	require.NoError(t, err)
+	u := plugin.(*UniFiDNS)
+	assert.Equal(t, []int{0}, u.matchingDevices("nas.lab"))
+	assert.Equal(t, []int{1}, u.matchingDevices("app.lan"))
+
+	config = CreateConfig()
+	config.Devices = []UnifiDeviceConfig{
+		{Host: "192.168.1.1", Username: "admin", Password: "password", Default: true},
+		{Host: "192.168.1.2", Username: "admin", Password: "password", Default: true},
+	}
+	_, err = New(context.Background(), nil, config, "test")
+	assert.EqualError(t, err, "devices 0 and 1 are both marked as default")
+}
+
+func TestMatchingDevicesGlob(t *testing.T) {
+	config := CreateConfig()
+	config.Devices = []UnifiDeviceConfig{{Host: "192.168.1.1", Username: "admin", Password: "password", Glob: "*.example.com"}}
+
+	plugin, err := New(context.Background(), nil, config, "test")
+	require.NoError(t, err)
+	u := plugin.(*UniFiDNS)
+	assert.Equal(t, []int{0}, u.matchingDevices("app.example.com"))
+	assert.Nil(t, u.matchingDevices("evilexample.com"))
+
+	config.Devices[0].Pattern = `\.example\.com$`
+	_, err = New(context.Background(), nil, config, "test")
+	assert.EqualError(t, err, "device 0 has both a pattern and a glob")
+}
+
 func TestUpdateLoop(t *testing.T) {
 	config := &Config{
 		Devices: []UnifiDeviceConfig{
@@ -247,8 +295,37 @@ func TestUpdateLoop(t *testing.T) {
 	cancel()
 }
 
+func TestUpdateOnStartupDisabled(t *testing.T) {
+	traefikServer := newTestTraefikServer(t, []map[string]interface{}{
+		{"name": "app", "rule": "Host(`app.lan`)", "middlewares": []string{"traefikunifidns"}},
+	})
+	var changes []webhookChange
+	webhookServer := newTestWebhookServer(t, &changes)
+
+	updateOnStartup := false
+	config := CreateConfig()
+	config.TraefikAPIURL = traefikServer.URL
+	config.Devices = []UnifiDeviceConfig{{WebhookURL: webhookServer.URL, Pattern: `\.lan$`}}
+	config.IPOverrides = map[string]string{"app.lan": "192.168.1.10"}
+	config.UpdateOnStartup = &updateOnStartup
+
+	_, err := New(context.Background(), nil, config, "test")
+	assert.EqualError(t, err, "updateOnStartup is disabled without an adminPath or syncToken to request the first update")
+
+	config.AdminPath = "/.unifidns"
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	plugin, err := New(ctx, nil, config, "test")
+	require.NoError(t, err)
+	u := plugin.(*UniFiDNS)
+	assert.Equal(t, 0, u.status().Cycles)
+
+	require.True(t, u.requestSync())
+	assert.Eventually(t, func() bool { return u.status().Cycles == 1 }, time.Second, 10*time.Millisecond)
+}
+
 func TestGetLocalIP(t *testing.T) {
-	ip, err := getLocalIP()
+	ip, err := getLocalIP("", nil, sourceFilter{})
 	if err != nil {
 		t.Fatalf("getLocalIP returned error: %v", err)
 	}
@@ -263,7 +340,7 @@ func TestGetLocalIP(t *testing.T) {
 
 func TestGetLocalIPExtended(t *testing.T) {
 	// First test the regular function behavior
-	ip, err := getLocalIP()
+	ip, err := getLocalIP("", nil, sourceFilter{})
 	if err != nil {
 		t.Fatalf("getLocalIP returned error: %v", err)
 	}
@@ -402,8 +479,7 @@ func TestUpdateDNSErrors(t *testing.T) {
 
 		// Process all routers
 		for _, router := range routers {
-			hostname := extractHostname(router.Rule)
-			if hostname == "" {
+			if len(extractHostnames(router.Rule)) == 0 {
 				log.Printf("INFO: Skipping router with no hostname: %s", router.Rule)
 				continue
 			}
@@ -426,3 +502,727 @@ func TestUpdateDNSErrors(t *testing.T) {
 		}
 	})
 }
+
+// newTestTraefikServer returns a Traefik API mock serving the given routers.
+func newTestTraefikServer(t *testing.T, routers []map[string]interface{}) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/http/routers" {
+			if err := json.NewEncoder(w).Encode(routers); err != nil {
+				t.Errorf("Failed to encode routers: %v", err)
+			}
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// newTestUniFiServer returns a UniFi controller mock serving the given
+// entries and recording the payload of every write request.
+func newTestUniFiServer(t *testing.T, entries []DNSEntry, writes *[]map[string]interface{}) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/auth/login":
+			w.Header().Set("X-Csrf-Token", "test-csrf-token")
+			w.WriteHeader(http.StatusOK)
+		case r.Method == "GET" && r.URL.Path == "/proxy/network/v2/api/site/default/static-dns":
+			if err := json.NewEncoder(w).Encode(entries); err != nil {
+				t.Errorf("Failed to encode DNS entries: %v", err)
+			}
+		case r.Method == "DELETE" && strings.HasPrefix(r.URL.Path, "/proxy/network/v2/api/site/default/static-dns/"):
+			*writes = append(*writes, map[string]interface{}{"deleted": path.Base(r.URL.Path)})
+			w.WriteHeader(http.StatusOK)
+		case strings.HasPrefix(r.URL.Path, "/proxy/network/v2/api/site/default/static-dns"):
+			var payload map[string]interface{}
+			if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+				t.Errorf("Failed to decode DNS request body: %v", err)
+			}
+			*writes = append(*writes, payload)
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestNewInvalidIPOverride(t *testing.T) {
+	config := CreateConfig()
+	config.IPOverrides = map[string]string{"nas.lan": "not-an-ip"}
+
+	_, err := New(context.Background(), nil, config, "test")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid IP override for nas.lan")
+}
+
+func TestUpdateDNSIPOverride(t *testing.T) {
+	traefikServer := newTestTraefikServer(t, []map[string]interface{}{
+		{"name": "nas", "rule": "Host(`nas.lan`)", "middlewares": []string{"traefikunifidns"}},
+	})
+	var writes []map[string]interface{}
+	unifiServer := newTestUniFiServer(t, []DNSEntry{}, &writes)
+
+	config := CreateConfig()
+	config.TraefikAPIURL = traefikServer.URL
+	config.Devices = []UnifiDeviceConfig{
+		{Host: unifiServer.URL, Username: "admin", Password: "password", Pattern: `\.lan$`},
+	}
+	config.IPOverrides = map[string]string{"nas.lan": "192.168.1.20"}
+
+	plugin, err := New(context.Background(), nil, config, "test")
+	require.NoError(t, err)
+
+	require.Len(t, writes, 1)
+	assert.Equal(t, "nas.lan", writes[0]["key"])
+	assert.Equal(t, "192.168.1.20", writes[0]["value"])
+
+	results := plugin.(*UniFiDNS).results
+	require.Len(t, results, 1)
+	assert.Equal(t, "nas.lan", results[0].Hostname)
+	assert.Equal(t, recordCreated, results[0].Action)
+	assert.Equal(t, "192.168.1.20", results[0].Value)
+
+	// Hostnames without an override keep the detected local IP
+	ips, err := plugin.(*UniFiDNS).targetIPs(context.Background(), "nas.lan", 0, []string{"10.0.0.1"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"192.168.1.20"}, ips)
+	ips, err = plugin.(*UniFiDNS).targetIPs(context.Background(), "other.lan", 0, []string{"10.0.0.1"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.1"}, ips)
+}
+
+func TestUpdateDNSMultipleHostnames(t *testing.T) {
+	traefikServer := newTestTraefikServer(t, []map[string]interface{}{
+		{"name": "app", "rule": "Host(`app.lan`, `www.lan`) || Host(`api.lan`)", "middlewares": []string{"traefikunifidns"}},
+	})
+	var writes []map[string]interface{}
+	unifiServer := newTestUniFiServer(t, []DNSEntry{}, &writes)
+
+	config := CreateConfig()
+	config.TraefikAPIURL = traefikServer.URL
+	config.Devices = []UnifiDeviceConfig{
+		{Host: unifiServer.URL, Username: "admin", Password: "password", Pattern: `\.lan$`},
+	}
+	config.TargetIPs = []string{"192.168.1.20"}
+
+	_, err := New(context.Background(), nil, config, "test")
+	require.NoError(t, err)
+
+	var keys []string
+	for _, write := range writes {
+		keys = append(keys, write["key"].(string))
+	}
+	assert.ElementsMatch(t, []string{"app.lan", "www.lan", "api.lan"}, keys)
+}
+
+func TestNewInvalidAllowedTargetCIDRs(t *testing.T) {
+	config := CreateConfig()
+	config.AllowedTargetCIDRs = []string{"192.168.0.0/33"}
+
+	_, err := New(context.Background(), nil, config, "test")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid allowedTargetCIDRs")
+}
+
+func TestUpdateDNSAllowedTargetCIDRs(t *testing.T) {
+	traefikServer := newTestTraefikServer(t, []map[string]interface{}{
+		{"name": "nas", "rule": "Host(`nas.lan`)", "middlewares": []string{"traefikunifidns"}},
+		{"name": "app", "rule": "Host(`app.lan`)", "middlewares": []string{"traefikunifidns"}},
+	})
+	var writes []map[string]interface{}
+	unifiServer := newTestUniFiServer(t, []DNSEntry{}, &writes)
+
+	config := CreateConfig()
+	config.TraefikAPIURL = traefikServer.URL
+	config.Devices = []UnifiDeviceConfig{
+		{Host: unifiServer.URL, Username: "admin", Password: "password", Pattern: `\.lan$`},
+	}
+	config.IPOverrides = map[string]string{
+		"nas.lan": "192.168.1.20",
+		"app.lan": "172.17.0.2",
+	}
+	config.AllowedTargetCIDRs = []string{"192.168.0.0/16"}
+
+	_, err := New(context.Background(), nil, config, "test")
+	require.NoError(t, err)
+
+	require.Len(t, writes, 1)
+	assert.Equal(t, "nas.lan", writes[0]["key"])
+}
+
+func TestIPInNets(t *testing.T) {
+	nets, err := parseCIDRs([]string{"192.168.0.0/16", "10.0.0.0/8"})
+	require.NoError(t, err)
+
+	assert.True(t, ipInNets(net.ParseIP("192.168.1.20"), nets))
+	assert.True(t, ipInNets(net.ParseIP("10.1.2.3"), nets))
+	assert.False(t, ipInNets(net.ParseIP("172.17.0.2"), nets))
+	assert.False(t, ipInNets(nil, nets))
+}
+
+func TestSelectLocalIPPreferredSubnets(t *testing.T) {
+	addrs := []net.Addr{
+		&net.IPNet{IP: net.ParseIP("127.0.0.1"), Mask: net.CIDRMask(8, 32)},
+		&net.IPNet{IP: net.ParseIP("172.17.0.2"), Mask: net.CIDRMask(16, 32)},
+		&net.IPNet{IP: net.ParseIP("192.168.10.5"), Mask: net.CIDRMask(24, 32)},
+	}
+
+	ip, err := selectLocalIP(addrs, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "172.17.0.2", ip)
+
+	preferred, err := parseCIDRs([]string{"192.168.10.0/24"})
+	require.NoError(t, err)
+	ip, err = selectLocalIP(addrs, preferred)
+	require.NoError(t, err)
+	assert.Equal(t, "192.168.10.5", ip)
+
+	// Falls back to the first candidate when no preferred subnet matches
+	preferred, err = parseCIDRs([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+	ip, err = selectLocalIP(addrs, preferred)
+	require.NoError(t, err)
+	assert.Equal(t, "172.17.0.2", ip)
+
+	_, err = selectLocalIP(addrs[:1], preferred)
+	assert.EqualError(t, err, "no suitable IP address found")
+}
+
+func TestNewCycleTimeout(t *testing.T) {
+	config := CreateConfig()
+	plugin, err := New(context.Background(), nil, config, "test")
+	require.NoError(t, err)
+	assert.Equal(t, 5*time.Minute, plugin.(*UniFiDNS).cycleTimeout)
+
+	config.CycleTimeout = "30s"
+	plugin, err = New(context.Background(), nil, config, "test")
+	require.NoError(t, err)
+	assert.Equal(t, 30*time.Second, plugin.(*UniFiDNS).cycleTimeout)
+
+	config.CycleTimeout = "forever"
+	_, err = New(context.Background(), nil, config, "test")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid cycle timeout")
+}
+
+func TestUpdateDNSCycleDeadline(t *testing.T) {
+	traefikServer := newTestTraefikServer(t, []map[string]interface{}{
+		{"name": "app", "rule": "Host(`app.lan`)", "middlewares": []string{"traefikunifidns"}},
+	})
+	var writes []map[string]interface{}
+	unifiServer := newTestUniFiServer(t, []DNSEntry{}, &writes)
+
+	config := CreateConfig()
+	config.TraefikAPIURL = traefikServer.URL
+	config.Devices = []UnifiDeviceConfig{
+		{Host: unifiServer.URL, Username: "admin", Password: "password", Pattern: `\.lan$`},
+	}
+	plugin, err := New(context.Background(), nil, config, "test")
+	require.NoError(t, err)
+	writes = nil
+
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	err = plugin.(*UniFiDNS).updateDNS(ctx).Err
+	require.Error(t, err)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Empty(t, writes)
+}
+
+func TestTargetIPDevice(t *testing.T) {
+	r := &reconciler{config: CreateConfig()}
+	r.config.IPOverrides = map[string]string{"nas.lan": "192.168.1.20"}
+	r.config.Devices = []UnifiDeviceConfig{
+		{TargetIP: "10.10.0.5"},
+		{TargetHostname: "localhost"},
+		{TargetHostname: "does-not-exist.invalid"},
+		{},
+	}
+
+	ips, err := r.targetIPs(context.Background(), "app.lan", 0, []string{"192.168.1.10"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.10.0.5"}, ips)
+
+	ips, err = r.targetIPs(context.Background(), "app.lan", 1, []string{"192.168.1.10"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"127.0.0.1"}, ips)
+
+	ips, err = r.targetIPs(context.Background(), "nas.lan", 0, []string{"192.168.1.10"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"192.168.1.20"}, ips, "hostname overrides take precedence")
+
+	_, err = r.targetIPs(context.Background(), "app.lan", 2, []string{"192.168.1.10"})
+	assert.Error(t, err)
+
+	r.config.TargetIPs = []string{"192.168.1.11", "192.168.1.12"}
+	ips, err = r.targetIPs(context.Background(), "app.lan", 3, []string{"192.168.1.10"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"192.168.1.11", "192.168.1.12"}, ips, "targetIPs replace the local IP")
+}
+
+func TestTargetIPWAN(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/auth/login":
+			w.Header().Set("X-Csrf-Token", "test-csrf-token")
+		case "/proxy/network/api/s/default/stat/health":
+			requests++
+			_, _ = w.Write([]byte(`{"data":[{"subsystem":"wlan"},{"subsystem":"wan","wan_ip":"203.0.113.7"}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	r := &reconciler{
+		config:    CreateConfig(),
+		providers: map[string]dnsProvider{"device-0": NewUniFiClient(server.URL, "admin", "password", false)},
+	}
+	r.config.Devices = []UnifiDeviceConfig{{Host: server.URL, TargetWANIP: true}}
+
+	for _, hostname := range []string{"app.example.com", "web.example.com"} {
+		ips, err := r.targetIPs(context.Background(), hostname, 0, []string{"192.168.1.10"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"203.0.113.7"}, ips)
+	}
+	assert.Equal(t, 1, requests, "the WAN IP is fetched once per cycle")
+}
+
+func TestNewTargetWANIPWebhook(t *testing.T) {
+	config := CreateConfig()
+	config.Devices = []UnifiDeviceConfig{{WebhookURL: "http://localhost", Pattern: ".*", TargetWANIP: true}}
+
+	_, err := New(context.Background(), nil, config, "test")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "device 0 uses targetWanIP without a UniFi controller")
+}
+
+func TestNewInvalidProxyAuthHeader(t *testing.T) {
+	config := CreateConfig()
+	config.Devices = []UnifiDeviceConfig{{Host: "unifi.lan", Username: "admin", Password: "password", Pattern: ".*", ProxyAuthHeader: "secret-token"}}
+
+	_, err := New(context.Background(), nil, config, "test")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid proxyAuthHeader for device 0")
+	assert.NotContains(t, err.Error(), "secret-token")
+}
+
+func TestNewInsecureHTTP(t *testing.T) {
+	config := CreateConfig()
+	config.Devices = []UnifiDeviceConfig{{Host: "http://unifi.lan", Username: "admin", Password: "password", Pattern: ".*"}}
+
+	_, err := New(context.Background(), nil, config, "test")
+	assert.EqualError(t, err, "invalid host for device 0: http://unifi.lan uses plain HTTP, set allowInsecureHTTP to send credentials unencrypted")
+
+	config.Devices[0].AllowInsecureHTTP = true
+	_, err = newReconciler(config)
+	assert.NoError(t, err)
+}
+
+func TestNewInvalidRequestLimits(t *testing.T) {
+	config := CreateConfig()
+	config.Devices = []UnifiDeviceConfig{{Host: "unifi.lan", Username: "admin", Password: "password", Pattern: ".*", MaxConcurrentRequests: -1}}
+	_, err := New(context.Background(), nil, config, "test")
+	assert.EqualError(t, err, "invalid maxConcurrentRequests for device 0: -1")
+
+	config.Devices[0].MaxConcurrentRequests = 0
+	config.MaxRequestsPerSecond = -1
+	_, err = New(context.Background(), nil, config, "test")
+	assert.EqualError(t, err, "invalid maxRequestsPerSecond: -1")
+}
+
+func TestUpdateDNSSplitHorizon(t *testing.T) {
+	traefikServer := newTestTraefikServer(t, []map[string]interface{}{
+		{"name": "app", "rule": "Host(`app.example.com`)", "middlewares": []string{"traefikunifidns"}},
+	})
+	var lanWrites, dmzWrites []map[string]interface{}
+	lanServer := newTestUniFiServer(t, []DNSEntry{}, &lanWrites)
+	dmzServer := newTestUniFiServer(t, []DNSEntry{}, &dmzWrites)
+
+	config := CreateConfig()
+	config.TraefikAPIURL = traefikServer.URL
+	config.Devices = []UnifiDeviceConfig{
+		{Host: lanServer.URL, Username: "admin", Password: "password", Pattern: `\.example\.com$`, TargetIP: "192.168.1.20"},
+		{Host: dmzServer.URL, Username: "admin", Password: "password", Pattern: `\.example\.com$`, TargetIP: "10.10.0.20"},
+	}
+
+	_, err := New(context.Background(), nil, config, "test")
+	require.NoError(t, err)
+
+	require.Len(t, lanWrites, 1)
+	assert.Equal(t, "192.168.1.20", lanWrites[0]["value"])
+	require.Len(t, dmzWrites, 1)
+	assert.Equal(t, "10.10.0.20", dmzWrites[0]["value"])
+}
+
+func TestNewInvalidDeviceTargetIP(t *testing.T) {
+	config := CreateConfig()
+	config.Devices = []UnifiDeviceConfig{{Host: "192.168.1.1", Pattern: ".*", TargetIP: "nope"}}
+
+	_, err := New(context.Background(), nil, config, "test")
+	assert.EqualError(t, err, `invalid target IP for device 0: "nope"`)
+}
+
+func TestUpdateDNSTargetIPs(t *testing.T) {
+	traefikServer := newTestTraefikServer(t, []map[string]interface{}{
+		{"name": "app", "rule": "Host(`app.lan`)", "middlewares": []string{"traefikunifidns"}},
+	})
+	var writes []map[string]interface{}
+	unifiServer := newTestUniFiServer(t, []DNSEntry{
+		{ID: "1", Key: "app.lan", RecordType: "A", Value: "192.168.1.11"},
+		{ID: "2", Key: "app.lan", RecordType: "A", Value: "192.168.1.13"},
+		{ID: "3", Key: "app.lan", RecordType: "A", Value: "192.168.1.50"},
+	}, &writes)
+
+	config := CreateConfig()
+	config.TraefikAPIURL = traefikServer.URL
+	config.TargetIPs = []string{"192.168.1.11", "192.168.1.12"}
+	config.StateFile = writeTestState(t, pluginState{NodeAddresses: []string{"192.168.1.11", "192.168.1.13"}})
+	config.Devices = []UnifiDeviceConfig{
+		{Host: unifiServer.URL, Username: "admin", Password: "password", Pattern: `\.lan$`},
+	}
+
+	plugin, err := New(context.Background(), nil, config, "test")
+	require.NoError(t, err)
+
+	require.Len(t, writes, 2)
+	assert.Equal(t, map[string]interface{}{"deleted": "2"}, writes[0], "records of removed nodes are deleted, manual ones kept")
+	assert.Equal(t, "192.168.1.12", writes[1]["value"], "records of new nodes are created")
+
+	results := plugin.(*UniFiDNS).results
+	require.Len(t, results, 1)
+	assert.Equal(t, recordUpdated, results[0].Action)
+	assert.Equal(t, "192.168.1.11,192.168.1.12", results[0].Value)
+}
+
+func TestUpdateDNSTargetIPsRemoved(t *testing.T) {
+	traefikServer := newTestTraefikServer(t, []map[string]interface{}{
+		{"name": "app", "rule": "Host(`app.lan`)", "middlewares": []string{"traefikunifidns"}},
+	})
+	var writes []map[string]interface{}
+	unifiServer := newTestUniFiServer(t, []DNSEntry{
+		{ID: "1", Key: "app.lan", RecordType: "A", Value: "192.168.1.50"},
+		{ID: "2", Key: "app.lan", RecordType: "A", Value: "192.168.1.11"},
+		{ID: "3", Key: "app.lan", RecordType: "A", Value: "192.168.1.12"},
+	}, &writes)
+
+	// The records of the nodes once listed in targetIPs are still there, next
+	// to one added by hand
+	config := CreateConfig()
+	config.TraefikAPIURL = traefikServer.URL
+	config.IPOverrides = map[string]string{"app.lan": "192.168.1.12"}
+	config.StateFile = writeTestState(t, pluginState{NodeAddresses: []string{"192.168.1.11", "192.168.1.12"}})
+	config.Devices = []UnifiDeviceConfig{
+		{Host: unifiServer.URL, Username: "admin", Password: "password", Pattern: `\.lan$`},
+	}
+
+	plugin, err := New(context.Background(), nil, config, "test")
+	require.NoError(t, err)
+
+	assert.Equal(t, []map[string]interface{}{{"deleted": "2"}}, writes)
+	results := plugin.(*UniFiDNS).results
+	require.Len(t, results, 1)
+	assert.Equal(t, recordUpdated, results[0].Action)
+	assert.Equal(t, "192.168.1.12", results[0].Value)
+
+	state, err := loadState(config.StateFile)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"192.168.1.11", "192.168.1.12"}, state.NodeAddresses)
+}
+
+func TestUpdateDNSKeepsManualRecords(t *testing.T) {
+	traefikServer := newTestTraefikServer(t, []map[string]interface{}{
+		{"name": "app", "rule": "Host(`app.lan`)", "middlewares": []string{"traefikunifidns"}},
+	})
+	var writes []map[string]interface{}
+	unifiServer := newTestUniFiServer(t, []DNSEntry{
+		{ID: "1", Key: "app.lan", RecordType: "A", Value: "192.168.1.50"},
+		{ID: "2", Key: "app.lan", RecordType: "A", Value: "192.168.1.12"},
+	}, &writes)
+
+	config := CreateConfig()
+	config.TraefikAPIURL = traefikServer.URL
+	config.IPOverrides = map[string]string{"app.lan": "192.168.1.12"}
+	config.Devices = []UnifiDeviceConfig{
+		{Host: unifiServer.URL, Username: "admin", Password: "password", Pattern: `\.lan$`},
+	}
+
+	plugin, err := New(context.Background(), nil, config, "test")
+	require.NoError(t, err)
+
+	assert.Empty(t, writes, "the record added by hand is not deleted")
+	results := plugin.(*UniFiDNS).results
+	require.Len(t, results, 1)
+	assert.Equal(t, recordUnchanged, results[0].Action)
+}
+
+// writeTestState writes state to a state file in a temporary directory and
+// returns its path.
+func writeTestState(t *testing.T, state pluginState) string {
+	t.Helper()
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	data, err := json.Marshal(state)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(statePath, data, 0o600))
+	return statePath
+}
+
+func TestNewInvalidTargetIPs(t *testing.T) {
+	config := CreateConfig()
+	config.TargetIPs = []string{"192.168.1.11", "not-an-ip"}
+
+	_, err := New(context.Background(), nil, config, "test")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `invalid target IP: "not-an-ip"`)
+}
+
+func TestEntryPointIP(t *testing.T) {
+	addresses := map[string]string{"lan": "192.168.1.10", "dmz": "10.10.0.10"}
+
+	ip, ok := entryPointIP(TraefikRouter{EntryPoints: []string{"web", "dmz", "lan"}}, addresses)
+	assert.True(t, ok)
+	assert.Equal(t, "10.10.0.10", ip)
+
+	_, ok = entryPointIP(TraefikRouter{EntryPoints: []string{"web"}}, addresses)
+	assert.False(t, ok)
+}
+
+func TestApexProtected(t *testing.T) {
+	r := &reconciler{config: CreateConfig()}
+	r.config.ApexDomains = []string{"example.com.", "home.lan"}
+	r.config.AllowedApexDomains = []string{"home.lan"}
+
+	assert.True(t, r.apexProtected("example.com"))
+	assert.True(t, r.apexProtected("Example.COM."))
+	assert.False(t, r.apexProtected("app.example.com"))
+	assert.False(t, r.apexProtected("home.lan"), "allowed apexes may be published")
+}
+
+func TestUpdateDNSApexProtection(t *testing.T) {
+	traefikServer := newTestTraefikServer(t, []map[string]interface{}{
+		{"name": "apex", "rule": "Host(`example.com`)", "middlewares": []string{"traefikunifidns"}},
+		{"name": "app", "rule": "Host(`app.example.com`)", "middlewares": []string{"traefikunifidns"}},
+	})
+	var writes []map[string]interface{}
+	unifiServer := newTestUniFiServer(t, []DNSEntry{}, &writes)
+
+	config := CreateConfig()
+	config.TraefikAPIURL = traefikServer.URL
+	config.ApexDomains = []string{"example.com"}
+	config.Devices = []UnifiDeviceConfig{
+		{Host: unifiServer.URL, Username: "admin", Password: "password", Pattern: `.*`},
+	}
+
+	plugin, err := New(context.Background(), nil, config, "test")
+	require.NoError(t, err)
+	require.Len(t, writes, 1)
+	assert.Equal(t, "app.example.com", writes[0]["key"])
+
+	results := plugin.(*UniFiDNS).results
+	require.Len(t, results, 2)
+	assert.Equal(t, resultSkipped, results[0].Action)
+	assert.Equal(t, "protected zone apex", results[0].Reason)
+}
+
+func TestUpdateDNSDryRunDevice(t *testing.T) {
+	traefikServer := newTestTraefikServer(t, []map[string]interface{}{
+		{"name": "app", "rule": "Host(`app.lan`)", "middlewares": []string{"traefikunifidns"}},
+		{"name": "nas", "rule": "Host(`nas.lan`)", "middlewares": []string{"traefikunifidns"}},
+	})
+	var liveWrites, dryWrites []map[string]interface{}
+	liveServer := newTestUniFiServer(t, []DNSEntry{}, &liveWrites)
+	dryServer := newTestUniFiServer(t, []DNSEntry{
+		{ID: "1", Key: "nas.lan", RecordType: "A", Value: "192.168.1.20"},
+	}, &dryWrites)
+
+	config := CreateConfig()
+	config.TraefikAPIURL = traefikServer.URL
+	config.IPOverrides = map[string]string{"app.lan": "192.168.1.10", "nas.lan": "192.168.1.20"}
+	config.Devices = []UnifiDeviceConfig{
+		{Host: liveServer.URL, Username: "admin", Password: "password", Pattern: `\.lan$`},
+		{Host: dryServer.URL, Username: "admin", Password: "password", Pattern: `\.lan$`, DryRun: true},
+	}
+
+	plugin, err := New(context.Background(), nil, config, "test")
+	require.NoError(t, err)
+	assert.Len(t, liveWrites, 2)
+	assert.Empty(t, dryWrites, "dry-run devices are never written to")
+
+	var reasons []string
+	for _, result := range plugin.(*UniFiDNS).results {
+		if result.Device == dryServer.URL {
+			assert.Equal(t, resultSkipped, result.Action)
+			reasons = append(reasons, result.Reason)
+		}
+	}
+	assert.Equal(t, []string{"dry run, would be created", "dry run, would be unchanged"}, reasons)
+}
+
+func TestUpdateDNSReadOnlyDevice(t *testing.T) {
+	traefikServer := newTestTraefikServer(t, []map[string]interface{}{
+		{"name": "app", "rule": "Host(`app.lan`)", "middlewares": []string{"traefikunifidns"}},
+	})
+	var writes []map[string]interface{}
+	unifiServer := newTestUniFiServer(t, []DNSEntry{
+		{ID: "1", Key: "app.lan", RecordType: "A", Value: "192.168.1.20"},
+	}, &writes)
+
+	config := CreateConfig()
+	config.TraefikAPIURL = traefikServer.URL
+	config.IPOverrides = map[string]string{"app.lan": "192.168.1.10"}
+	config.Devices = []UnifiDeviceConfig{
+		{Host: unifiServer.URL, Username: "admin", Password: "password", Pattern: `\.lan$`, ReadOnly: true},
+	}
+
+	plugin, err := New(context.Background(), nil, config, "test")
+	require.NoError(t, err)
+	assert.Empty(t, writes)
+
+	results := plugin.(*UniFiDNS).results
+	require.Len(t, results, 1)
+	assert.Equal(t, resultSkipped, results[0].Action)
+	assert.Equal(t, "read-only, record differs", results[0].Reason)
+}
+
+func TestUpdateDNSDegradedMode(t *testing.T) {
+	traefikServer := newTestTraefikServer(t, []map[string]interface{}{
+		{"name": "app", "rule": "Host(`app.lan`)", "middlewares": []string{"traefikunifidns"}},
+	})
+	forbidden := true
+	unifiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/auth/login":
+			w.Header().Set("X-Csrf-Token", "test-csrf-token")
+		case r.Method == http.MethodGet:
+			_, _ = w.Write([]byte(`[]`))
+		case forbidden:
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer unifiServer.Close()
+
+	config := CreateConfig()
+	config.TraefikAPIURL = traefikServer.URL
+	config.Devices = []UnifiDeviceConfig{
+		{Host: unifiServer.URL, Username: "viewer", Password: "password", Pattern: `\.lan$`},
+	}
+
+	plugin, err := New(context.Background(), nil, config, "test")
+	require.NoError(t, err)
+	u := plugin.(*UniFiDNS)
+	require.Len(t, u.results, 1)
+	assert.Equal(t, resultSkipped, u.results[0].Action)
+	assert.Equal(t, "degraded, record differs", u.results[0].Reason)
+	status := u.status()
+	assert.True(t, status.Devices[0].Degraded)
+	assert.Equal(t, circuitClosed, status.Devices[0].CircuitState, "degraded devices do not count as failing")
+
+	forbidden = false
+	require.NoError(t, u.updateDNS(context.Background()).Err)
+	assert.Equal(t, recordCreated, u.results[0].Action)
+	assert.False(t, u.status().Devices[0].Degraded, "recovers once writes succeed")
+}
+
+func TestUpdateDNSPrioritizesNewHostnames(t *testing.T) {
+	rules := []string{"Host(`app.lan`)", "Host(`nas.lan`)"}
+	traefikServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		routers := make([]map[string]interface{}, 0, len(rules))
+		for _, rule := range rules {
+			routers = append(routers, map[string]interface{}{"rule": rule, "middlewares": []string{"traefikunifidns"}})
+		}
+		_ = json.NewEncoder(w).Encode(routers)
+	}))
+	defer traefikServer.Close()
+	var changes []webhookChange
+	webhookServer := newTestWebhookServer(t, &changes)
+
+	config := CreateConfig()
+	config.TraefikAPIURL = traefikServer.URL
+	config.Devices = []UnifiDeviceConfig{{WebhookURL: webhookServer.URL, Pattern: `\.lan$`}}
+	config.IPOverrides = map[string]string{"app.lan": "192.168.1.10", "nas.lan": "192.168.1.20", "new.lan": "192.168.1.30"}
+
+	plugin, err := New(context.Background(), nil, config, "test")
+	require.NoError(t, err)
+
+	rules = append(rules, "Host(`new.lan`)")
+	require.NoError(t, plugin.(*UniFiDNS).updateDNS(context.Background()).Err)
+
+	var order []string
+	for _, result := range plugin.(*UniFiDNS).results {
+		order = append(order, result.Hostname)
+	}
+	assert.Equal(t, []string{"new.lan", "app.lan", "nas.lan"}, order)
+}
+
+func TestUpdateDNSUnmatchedWarnedOnce(t *testing.T) {
+	var logBuf bytes.Buffer
+	log.SetOutput(&logBuf)
+	defer log.SetOutput(os.Stderr)
+
+	rules := []string{"Host(`app.lan`)", "Host(`other.com`)"}
+	traefikServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		routers := make([]map[string]interface{}, 0, len(rules))
+		for _, rule := range rules {
+			routers = append(routers, map[string]interface{}{"rule": rule, "middlewares": []string{"traefikunifidns"}})
+		}
+		_ = json.NewEncoder(w).Encode(routers)
+	}))
+	defer traefikServer.Close()
+	var changes []webhookChange
+	webhookServer := newTestWebhookServer(t, &changes)
+
+	config := CreateConfig()
+	config.TraefikAPIURL = traefikServer.URL
+	config.Devices = []UnifiDeviceConfig{{WebhookURL: webhookServer.URL, Pattern: `\.lan$`}}
+	config.IPOverrides = map[string]string{"app.lan": "192.168.1.10"}
+
+	plugin, err := New(context.Background(), nil, config, "test")
+	require.NoError(t, err)
+	u := plugin.(*UniFiDNS)
+	require.NoError(t, u.updateDNS(context.Background()).Err)
+
+	warning := "WARN: No matching UniFi device found for hostname: other.com"
+	assert.Equal(t, 1, strings.Count(logBuf.String(), warning))
+	require.Len(t, u.results, 2)
+	assert.Contains(t, u.results, hostResult{Hostname: "other.com", Action: resultSkipped, Reason: reasonNoMatch}, "still reported every cycle")
+
+	// A hostname that disappears is evaluated again once it comes back
+	rules = rules[:1]
+	require.NoError(t, u.updateDNS(context.Background()).Err)
+	rules = append(rules, "Host(`other.com`)")
+	require.NoError(t, u.updateDNS(context.Background()).Err)
+	assert.Equal(t, 2, strings.Count(logBuf.String(), warning))
+}
+
+func TestAdaptInterval(t *testing.T) {
+	r := &reconciler{updateInterval: time.Minute, maxUpdateInterval: 5 * time.Minute}
+	quiet := SyncResult{Counts: actionCounts{Unchanged: 3}}
+
+	assert.Equal(t, 2*time.Minute, r.adaptInterval(time.Minute, quiet))
+	assert.Equal(t, 4*time.Minute, r.adaptInterval(2*time.Minute, quiet))
+	assert.Equal(t, 5*time.Minute, r.adaptInterval(4*time.Minute, quiet))
+	assert.Equal(t, 5*time.Minute, r.adaptInterval(5*time.Minute, quiet))
+
+	// Any change or failure snaps back to the base interval
+	assert.Equal(t, time.Minute, r.adaptInterval(4*time.Minute, SyncResult{Counts: actionCounts{Updated: 1}}))
+	assert.Equal(t, time.Minute, r.adaptInterval(4*time.Minute, SyncResult{Counts: actionCounts{Deleted: 1}}))
+	assert.Equal(t, time.Minute, r.adaptInterval(4*time.Minute, SyncResult{Err: errors.New("unreachable")}))
+
+	// Without a maximum the interval stays fixed
+	r.maxUpdateInterval = 0
+	assert.Equal(t, time.Minute, r.adaptInterval(time.Minute, quiet))
+}
+
+func TestNewInvalidMaxUpdateInterval(t *testing.T) {
+	for _, interval := range []string{"later", "1m"} {
+		config := CreateConfig()
+		config.MaxUpdateInterval = interval
+		_, err := New(context.Background(), nil, config, "test")
+		assert.ErrorContains(t, err, "invalid max update interval")
+	}
+}
@@ -2,28 +2,67 @@ package traefikunifidns
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"net/http/cookiejar"
+	"net/url"
 	"strings"
+	"sync"
 	"time"
 )
 
+// errReadOnly is returned for writes attempted through a read-only client.
+var errReadOnly = errors.New("client is read-only")
+
+// errWriteForbidden is returned when the controller rejects a write with 403
+// Forbidden, typically because the account lacks Network admin rights.
+var errWriteForbidden = errors.New("account may not write static DNS")
+
 type UniFiClient struct {
 	client    *http.Client
 	baseURL   string
 	username  string
-	password  string
+	password  secret
+	mu        sync.Mutex // guards csrfToken, the client may be shared by several reconcilers
 	csrfToken string
+	stats     deviceStats
+
+	authStrategy authStrategy // Nil for username and password authentication
+	paths        apiPaths     // Zero for the default paths
+	site         string       // Site checked on first use, "auto" to detect it, empty for the unchecked default site
+	siteChecked  bool         // Guarded by mu
+	templates    APIPaths     // Expanded into paths once the site is checked
+	staticDNS    string       // Outcome of the static DNS probe, guarded by mu
+	transport    *http.Transport
+	retry        retryPolicy // Zero without retries
+	hooks        hooks
 }
 
 type DNSEntry struct {
-	Key   string `json:"key"`
-	Value string `json:"value"`
-	ID    string `json:"_id"`
+	Key        string `json:"key"`
+	Value      string `json:"value"`
+	ID         string `json:"_id"`
+	TTL        int    `json:"ttl,omitempty"`
+	RecordType string `json:"record_type,omitempty"`
+	Port       int    `json:"port,omitempty"`
+	Priority   int    `json:"priority,omitempty"`
+	Weight     int    `json:"weight,omitempty"`
+}
+
+// hasType reports whether the entry is a record of recordType. Entries
+// without a type are A records.
+func (e DNSEntry) hasType(recordType string) bool {
+	if e.RecordType == "" {
+		return recordType == "A"
+	}
+	return e.RecordType == recordType
 }
 
 func NewUniFiClient(host, username, password string, insecureSkipVerify bool) *UniFiClient {
@@ -48,108 +87,313 @@ func NewUniFiClient(host, username, password string, insecureSkipVerify bool) *U
 		},
 	}
 
-	return &UniFiClient{
-		client: &http.Client{
-			Timeout:   10 * time.Second,
-			Transport: transport,
-			Jar:       jar,
-		},
-		baseURL:  host,
-		username: username,
-		password: password,
+	c := &UniFiClient{
+		baseURL:   host,
+		username:  username,
+		password:  newSecret(password),
+		transport: transport,
+	}
+	c.client = &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: &hookTransport{next: transport, hooks: &c.hooks},
+		Jar:       jar,
+	}
+	return c
+}
+
+// checkScheme rejects plain http:// controller URLs, which send credentials
+// and session cookies unencrypted, unless they point at the local host or
+// allowInsecureHTTP is set.
+func checkScheme(host string, allowInsecureHTTP bool) error {
+	if !strings.HasPrefix(host, "http://") || allowInsecureHTTP {
+		return nil
+	}
+	u, err := url.Parse(host)
+	if err != nil {
+		return err
+	}
+	if isLoopback(u.Hostname()) {
+		return nil
+	}
+	return fmt.Errorf("%s uses plain HTTP, set allowInsecureHTTP to send credentials unencrypted", host)
+}
+
+// isLoopback reports whether hostname refers to the local host.
+func isLoopback(hostname string) bool {
+	if strings.EqualFold(hostname, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(hostname)
+	return ip != nil && ip.IsLoopback()
+}
+
+// setTLSServerName verifies the controller certificate against serverName
+// instead of the host, for controllers reached by IP address. It must be
+// called before setReadOnly.
+func (c *UniFiClient) setTLSServerName(serverName string) {
+	c.transport.TLSClientConfig.ServerName = serverName
+}
+
+// setClientCertificate presents cert to controllers requiring mutual TLS.
+// It must be called before setReadOnly.
+func (c *UniFiClient) setClientCertificate(cert tls.Certificate) {
+	c.transport.TLSClientConfig.Certificates = []tls.Certificate{cert}
+}
+
+// setRootCAs verifies the controller certificate against pool instead of
+// the system roots, for controllers with a self-signed certificate. It must
+// be called before setReadOnly.
+func (c *UniFiClient) setRootCAs(pool *x509.CertPool) {
+	c.transport.TLSClientConfig.RootCAs = pool
+}
+
+// setHeaders sets the User-Agent and additional static headers sent with
+// every request. It must be called before setReadOnly.
+func (c *UniFiClient) setHeaders(userAgent string, headers map[string]string) {
+	h := make(http.Header, len(headers)+1)
+	for name, value := range headers {
+		h.Set(name, value)
+	}
+	if userAgent != "" {
+		h.Set("User-Agent", userAgent)
+	}
+	c.client.Transport = &staticHeaderTransport{next: c.client.Transport, headers: h}
+}
+
+// staticHeaderTransport adds static headers to every request, for reverse proxies
+// and WAFs in front of a console that filter unknown clients.
+type staticHeaderTransport struct {
+	next    http.RoundTripper
+	headers http.Header
+}
+
+func (t *staticHeaderTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for name, values := range t.headers {
+		req.Header[name] = values
+	}
+	return t.next.RoundTrip(req)
+}
+
+// setProxyAuth injects the cookies and the "Name: value" header required by an
+// authenticating gateway in front of the console into every request. It must
+// be called before setReadOnly.
+func (c *UniFiClient) setProxyAuth(cookies map[string]string, header string) {
+	t := &proxyAuthTransport{next: c.client.Transport, cookies: make(map[string]secret, len(cookies))}
+	for name, value := range cookies {
+		t.cookies[name] = newSecret(value)
+	}
+	if name, value, ok := parseHeaderLine(header); ok {
+		t.headerName, t.headerValue = name, newSecret(value)
+	}
+	c.client.Transport = t
+}
+
+// parseHeaderLine splits a "Name: value" header line.
+func parseHeaderLine(line string) (name, value string, ok bool) {
+	name, value, ok = strings.Cut(line, ":")
+	name = strings.TrimSpace(name)
+	return name, strings.TrimSpace(value), ok && name != ""
+}
+
+// proxyAuthTransport adds the tokens of Authelia or Cloudflare Access style
+// gateways to every request.
+type proxyAuthTransport struct {
+	next        http.RoundTripper
+	cookies     map[string]secret
+	headerName  string
+	headerValue secret
+}
+
+func (t *proxyAuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for name, value := range t.cookies {
+		req.AddCookie(&http.Cookie{Name: name, Value: value.reveal()})
+	}
+	if t.headerName != "" {
+		req.Header.Set(t.headerName, t.headerValue.reveal())
+	}
+	return t.next.RoundTrip(req)
+}
+
+// setLimits bounds the requests in flight to the controller to maxConcurrent,
+// if positive, and makes requests wait for limiter, if set. It must be called
+// before setReadOnly, so rejected writes don't use up the limits.
+func (c *UniFiClient) setLimits(maxConcurrent int, limiter *rateLimiter) {
+	t := &limitTransport{next: c.client.Transport, limiter: limiter}
+	if maxConcurrent > 0 {
+		t.slots = make(chan struct{}, maxConcurrent)
+	}
+	c.client.Transport = t
+}
+
+// setReadOnly makes the client reject every request that could change the
+// controller, except logging in.
+func (c *UniFiClient) setReadOnly() {
+	log.Printf("INFO: UniFi client for host %s is read-only", c.baseURL)
+	c.client.Transport = &readOnlyTransport{next: c.client.Transport, loginPath: c.apiPaths().login}
+}
+
+// readOnlyTransport only lets through requests that cannot change anything on
+// the controller.
+type readOnlyTransport struct {
+	next      http.RoundTripper
+	loginPath string
+}
+
+func (t *readOnlyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	switch {
+	case req.Method == http.MethodGet, req.Method == http.MethodHead, req.Method == http.MethodOptions:
+	case isLoginRequest(req, t.loginPath):
+	default:
+		if req.Body != nil {
+			_ = req.Body.Close()
+		}
+		return nil, fmt.Errorf("refusing %s %s: %w", req.Method, req.URL.Path, errReadOnly)
 	}
+	return t.next.RoundTrip(req)
 }
 
-func (c *UniFiClient) login() error {
+// do sends req and records its latency and response size in the device stats
+// of endpoint.
+func (c *UniFiClient) do(req *http.Request, endpoint string) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		start := time.Now()
+		resp, err := c.client.Do(req)
+		c.stats.recordRequest(endpoint, time.Since(start))
+		if delay, ok := c.retryDelay(req, resp, err, attempt); ok {
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+				continue
+			case <-req.Context().Done():
+				timer.Stop()
+				return nil, req.Context().Err()
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		c.stats.trackResponseSize(endpoint, resp)
+		return resp, nil
+	}
+}
+
+func (c *UniFiClient) login(ctx context.Context) error {
 	log.Printf("INFO: Logging in to UniFi controller at %s", c.baseURL)
 
-	loginURL := fmt.Sprintf("%s/api/auth/login", c.baseURL)
+	loginURL := c.baseURL + c.apiPaths().login
 	payload := map[string]string{
 		"username": c.username,
-		"password": c.password,
+		"password": c.password.reveal(),
 	}
 
 	jsonData, err := json.Marshal(payload)
 	if err != nil {
-		log.Printf("ERROR: Failed to marshal login payload: %v", err)
+		logError("Failed to marshal login payload: %v", err)
 		return fmt.Errorf("failed to marshal login payload: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", loginURL, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", loginURL, bytes.NewBuffer(jsonData))
 	if err != nil {
-		log.Printf("ERROR: Failed to create login request: %v", err)
+		logError("Failed to create login request: %v", err)
 		return fmt.Errorf("failed to create login request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := c.client.Do(req)
+	resp, err := c.do(req, endpointLogin)
 	if err != nil {
-		log.Printf("ERROR: Failed to send login request: %v", err)
+		logError("Failed to send login request: %v", err)
 		return fmt.Errorf("failed to send login request: %w", err)
 	}
 	defer func() {
 		if closeErr := resp.Body.Close(); closeErr != nil {
-			log.Printf("ERROR: Failed to close response body: %v", closeErr)
+			logError("Failed to close response body: %v", closeErr)
 		}
 	}()
 
 	if resp.StatusCode != http.StatusOK {
-		log.Printf("ERROR: Login failed with status code: %d", resp.StatusCode)
-		return fmt.Errorf("login failed with status: %d", resp.StatusCode)
+		logError("Login failed with status code: %d", resp.StatusCode)
+		return newStatusError(resp.StatusCode, "login failed with status: %d")
 	}
 
 	// Get and store CSRF token
 	csrfToken := resp.Header.Get("X-Csrf-Token")
 	if csrfToken == "" {
-		log.Printf("ERROR: No CSRF token received in login response")
+		logError("No CSRF token received in login response")
 		return fmt.Errorf("no CSRF token received")
 	}
+	c.mu.Lock()
 	c.csrfToken = csrfToken
+	c.mu.Unlock()
+	c.stats.recordLogin()
 
 	log.Printf("INFO: Successfully logged in to UniFi controller")
 	return nil
 }
 
-func (c *UniFiClient) GetStaticDNSEntries() ([]DNSEntry, error) {
-	log.Printf("INFO: Getting static DNS entries from UniFi controller")
+// hasSession reports whether the client is logged in, or needs no login.
+func (c *UniFiClient) hasSession() bool {
+	return c.auth().authenticated(c)
+}
 
-	// Ensure we're logged in and have a CSRF token
-	if c.csrfToken == "" {
-		if err := c.login(); err != nil {
-			return nil, fmt.Errorf("failed to login before getting DNS entries: %w", err)
-		}
+// session returns the CSRF token of the current session, logging in first if
+// there is none.
+func (c *UniFiClient) session(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	csrfToken := c.csrfToken
+	c.mu.Unlock()
+	if csrfToken != "" {
+		return csrfToken, nil
+	}
+
+	if err := c.login(ctx); err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.csrfToken, nil
+}
+
+func (c *UniFiClient) GetStaticDNSEntries(ctx context.Context) ([]DNSEntry, error) {
+	log.Printf("INFO: Getting static DNS entries from UniFi controller")
+	if err := c.resolveSite(ctx); err != nil {
+		return nil, err
 	}
 
-	dnsURL := fmt.Sprintf("%s/proxy/network/v2/api/site/default/static-dns", c.baseURL)
-	req, err := http.NewRequest("GET", dnsURL, nil)
+	dnsURL := c.baseURL + c.apiPaths().staticDNS
+	req, err := http.NewRequestWithContext(ctx, "GET", dnsURL, nil)
 	if err != nil {
-		log.Printf("ERROR: Failed to create DNS entries request: %v", err)
+		logError("Failed to create DNS entries request: %v", err)
 		return nil, fmt.Errorf("failed to create DNS entries request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-Csrf-Token", c.csrfToken)
+	if err := c.authorize(req); err != nil {
+		return nil, fmt.Errorf("failed to login before getting DNS entries: %w", err)
+	}
 
-	resp, err := c.client.Do(req)
+	resp, err := c.do(req, endpointList)
 	if err != nil {
-		log.Printf("ERROR: Failed to send DNS entries request: %v", err)
+		logError("Failed to send DNS entries request: %v", err)
 		return nil, fmt.Errorf("failed to send DNS entries request: %w", err)
 	}
 	defer func() {
 		if closeErr := resp.Body.Close(); closeErr != nil {
-			log.Printf("ERROR: Failed to close response body: %v", closeErr)
+			logError("Failed to close response body: %v", closeErr)
 		}
 	}()
 
 	if resp.StatusCode != http.StatusOK {
-		log.Printf("ERROR: Failed to get DNS entries with status code: %d", resp.StatusCode)
-		return nil, fmt.Errorf("failed to get DNS entries with status: %d", resp.StatusCode)
+		logError("Failed to get DNS entries with status code: %d", resp.StatusCode)
+		return nil, newStatusError(resp.StatusCode, "failed to get DNS entries with status: %d")
 	}
 
 	var dnsEntries []DNSEntry
 	if err := json.NewDecoder(resp.Body).Decode(&dnsEntries); err != nil {
-		log.Printf("ERROR: Failed to decode DNS entries response: %v", err)
+		logError("Failed to decode DNS entries response: %v", err)
 		return nil, fmt.Errorf("failed to decode DNS entries response: %w", err)
 	}
 
@@ -157,107 +401,469 @@ func (c *UniFiClient) GetStaticDNSEntries() ([]DNSEntry, error) {
 	return dnsEntries, nil
 }
 
-func (c *UniFiClient) updateDNSRecord(hostname, ip string) error {
-	log.Printf("INFO: Checking DNS record for %s", hostname)
+// GetWANIP returns the WAN address of the gateway as reported by the
+// controller's health endpoint.
+func (c *UniFiClient) GetWANIP(ctx context.Context) (string, error) {
+	if err := c.resolveSite(ctx); err != nil {
+		return "", err
+	}
+
+	healthURL := c.baseURL + c.apiPaths().health
+	req, err := http.NewRequestWithContext(ctx, "GET", healthURL, nil)
+	if err != nil {
+		logError("Failed to create health request: %v", err)
+		return "", fmt.Errorf("failed to create health request: %w", err)
+	}
+	if err := c.authorize(req); err != nil {
+		return "", fmt.Errorf("failed to login before getting WAN IP: %w", err)
+	}
+
+	resp, err := c.do(req, endpointHealth)
+	if err != nil {
+		logError("Failed to send health request: %v", err)
+		return "", fmt.Errorf("failed to send health request: %w", err)
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			logError("Failed to close response body: %v", closeErr)
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		logError("Failed to get health with status code: %d", resp.StatusCode)
+		return "", newStatusError(resp.StatusCode, "failed to get health with status: %d")
+	}
+
+	var health struct {
+		Data []struct {
+			Subsystem string `json:"subsystem"`
+			WANIP     string `json:"wan_ip"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&health); err != nil {
+		logError("Failed to decode health response: %v", err)
+		return "", fmt.Errorf("failed to decode health response: %w", err)
+	}
+	for _, subsystem := range health.Data {
+		if subsystem.Subsystem == "wan" && net.ParseIP(subsystem.WANIP) != nil {
+			log.Printf("INFO: Gateway reports WAN IP %s", subsystem.WANIP)
+			return subsystem.WANIP, nil
+		}
+	}
+	return "", fmt.Errorf("controller did not report a WAN IP")
+}
+
+// updateDNSRecord creates or updates the A record for hostname and reports
+// which of the two it did. A ttl of zero leaves the TTL to the controller
+// default and is not compared against the existing record.
+func (c *UniFiClient) updateDNSRecord(ctx context.Context, hostname, ip string, ttl int) (string, error) {
+	return c.updateTypedRecord(ctx, hostname, "A", ip, ttl, nil)
+}
+
+// updateDNSRecordPruning is updateDNSRecord that also deletes the further A
+// records of hostname whose address stale reports.
+func (c *UniFiClient) updateDNSRecordPruning(ctx context.Context, hostname, ip string, ttl int, stale func(ip string) bool) (string, error) {
+	return c.updateTypedRecord(ctx, hostname, "A", ip, ttl, stale)
+}
+
+// updateAAAARecord creates or updates the AAAA record of hostname, like
+// updateDNSRecord does for its A record.
+func (c *UniFiClient) updateAAAARecord(ctx context.Context, hostname, ip string, ttl int) (string, error) {
+	return c.updateTypedRecord(ctx, hostname, "AAAA", ip, ttl, nil)
+}
+
+// updateCNAMERecord points hostname at target with a CNAME record, replacing
+// any A or AAAA records of hostname, which cannot exist next to a CNAME.
+func (c *UniFiClient) updateCNAMERecord(ctx context.Context, hostname, target string, ttl int) (string, error) {
+	return c.updateTypedRecord(ctx, hostname, "CNAME", target, ttl, nil)
+}
+
+// updateTypedRecord creates or updates the record of recordType for
+// hostname. Records that cannot exist next to it are deleted first: address
+// records when writing a CNAME, and a CNAME when writing an address record.
+// Further records of recordType are deleted when stale reports their value,
+// such as the A records of nodes once published with targetIPs; any others,
+// like those added by hand, are left alone.
+func (c *UniFiClient) updateTypedRecord(ctx context.Context, hostname, recordType, value string, ttl int, stale func(value string) bool) (string, error) {
+	log.Printf("INFO: Checking %s record for %s", recordType, hostname)
 
 	// Get existing DNS entries
-	entries, err := c.GetStaticDNSEntries()
+	entries, err := c.GetStaticDNSEntries(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to get DNS entries before update: %w", err)
+		return "", fmt.Errorf("failed to get DNS entries before update: %w", err)
 	}
 
-	// Check if record exists and if IP has changed
-	var existingEntry *DNSEntry
-	for _, entry := range entries {
-		if entry.Key == hostname {
-			existingEntry = &entry
-			if entry.Value == ip {
-				log.Printf("INFO: DNS record for %s already has IP %s, no update needed", hostname, ip)
-				return nil
+	existingEntry, extras := selectRecord(entries, hostname, recordType, value, stale)
+	for _, extra := range extras {
+		log.Printf("INFO: Deleting %s record for %s with value %s", recordType, hostname, extra.Value)
+		if err := c.deleteDNSEntry(ctx, extra.ID); err != nil {
+			return "", err
+		}
+	}
+	if existingEntry != nil {
+		if existingEntry.Value == value && (ttl == 0 || existingEntry.TTL == ttl) {
+			if len(extras) > 0 {
+				return recordUpdated, nil
 			}
-			log.Printf("INFO: Updating DNS record for %s from %s to %s", hostname, entry.Value, ip)
-			break
+			log.Printf("INFO: DNS record for %s already has IP %s, no update needed", hostname, value)
+			return recordUnchanged, nil
+		}
+		if existingEntry.Value != value {
+			log.Printf("INFO: Updating DNS record for %s from %s to %s", hostname, existingEntry.Value, value)
+		} else {
+			log.Printf("INFO: Updating TTL of DNS record for %s from %d to %d", hostname, existingEntry.TTL, ttl)
 		}
 	}
 
-	// Ensure we're logged in and have a CSRF token
-	if c.csrfToken == "" {
-		if err := c.login(); err != nil {
-			return fmt.Errorf("failed to login before updating DNS: %w", err)
+	for _, entry := range entries {
+		if entry.Key == hostname && conflictingTypes(recordType, entry) {
+			log.Printf("INFO: Deleting %s record for %s to publish a %s record", entryType(entry), hostname, recordType)
+			if err := c.deleteDNSEntry(ctx, entry.ID); err != nil {
+				return "", err
+			}
 		}
 	}
 
-	baseURL := fmt.Sprintf("%s/proxy/network/v2/api/site/default/static-dns", c.baseURL)
-	var req *http.Request
+	payload := recordPayload(hostname, recordType, value, ttl)
 
 	if existingEntry != nil {
-		// Update existing record
-		updateURL := fmt.Sprintf("%s/%s", baseURL, existingEntry.ID)
-		payload := map[string]interface{}{
-			"key":         hostname,
-			"record_type": "A",
-			"value":       ip,
-			"enabled":     true,
-			"_id":         existingEntry.ID,
+		if err := c.saveDNSEntry(ctx, existingEntry.ID, payload); err != nil {
+			return "", err
 		}
+		log.Printf("INFO: Successfully updated DNS record for %s to IP %s", hostname, value)
+		return recordUpdated, nil
+	}
 
-		jsonData, err := json.Marshal(payload)
-		if err != nil {
-			log.Printf("ERROR: Failed to marshal DNS update payload: %v", err)
-			return fmt.Errorf("failed to marshal DNS update payload: %w", err)
+	log.Printf("INFO: Creating new DNS record for %s with IP %s", hostname, value)
+	if err := c.saveDNSEntry(ctx, "", payload); err != nil {
+		return "", err
+	}
+	log.Printf("INFO: Successfully created new DNS record for %s with IP %s", hostname, value)
+	return recordCreated, nil
+}
+
+// selectRecord returns the record of recordType for hostname in entries that
+// is updated to value, preferring one that already has it, then a stale one,
+// and the further records stale reports, which are deleted.
+func selectRecord(entries []DNSEntry, hostname, recordType, value string, stale func(value string) bool) (*DNSEntry, []DNSEntry) {
+	isStale := func(entry DNSEntry) bool {
+		return stale != nil && stale(entry.Value)
+	}
+	var existing *DNSEntry
+	for i, entry := range entries {
+		if entry.Key != hostname || !entry.hasType(recordType) {
+			continue
+		}
+		if existing == nil ||
+			existing.Value != value && (entry.Value == value || !isStale(*existing) && isStale(entry)) {
+			existing = &entries[i]
+		}
+	}
+	var extras []DNSEntry
+	for i, entry := range entries {
+		if entry.Key == hostname && entry.hasType(recordType) && &entries[i] != existing && isStale(entry) {
+			extras = append(extras, entry)
 		}
+	}
+	return existing, extras
+}
 
-		req, err = http.NewRequest("PUT", updateURL, bytes.NewBuffer(jsonData))
-		if err != nil {
-			log.Printf("ERROR: Failed to create DNS update request: %v", err)
-			return fmt.Errorf("failed to create DNS update request: %w", err)
+// plannedAction reports what updateDNSRecord would do for hostname without
+// changing anything on the controller.
+func (c *UniFiClient) plannedAction(ctx context.Context, hostname, ip string, ttl int) (string, error) {
+	return c.plannedPruningAction(ctx, hostname, ip, ttl, nil)
+}
+
+// plannedPruningAction reports what updateDNSRecordPruning would do for
+// hostname without changing anything on the controller.
+func (c *UniFiClient) plannedPruningAction(ctx context.Context, hostname, ip string, ttl int, stale func(ip string) bool) (string, error) {
+	entries, err := c.GetStaticDNSEntries(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get DNS entries: %w", err)
+	}
+	existing, extras := selectRecord(entries, hostname, "A", ip, stale)
+	switch {
+	case existing == nil:
+		return recordCreated, nil
+	case existing.Value == ip && (ttl == 0 || existing.TTL == ttl) && len(extras) == 0:
+		return recordUnchanged, nil
+	default:
+		return recordUpdated, nil
+	}
+}
+
+// updateDNSRecords maintains one A record for hostname per address in ips,
+// creating, updating and deleting individual records as needed. Records of
+// other addresses are only deleted when stale reports them.
+func (c *UniFiClient) updateDNSRecords(ctx context.Context, hostname string, ips []string, ttl int, stale func(ip string) bool) (string, error) {
+	log.Printf("INFO: Checking DNS records for %s", hostname)
+
+	entries, err := c.GetStaticDNSEntries(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get DNS entries before update: %w", err)
+	}
+
+	desired := make(map[string]bool, len(ips))
+	for _, ip := range ips {
+		desired[ip] = true
+	}
+
+	existing, changed := false, false
+	kept := make(map[string]bool, len(ips))
+	for _, entry := range entries {
+		if entry.Key == hostname && conflictingTypes("A", entry) {
+			log.Printf("INFO: Deleting %s record for %s to publish A records", entryType(entry), hostname)
+			if err := c.deleteDNSEntry(ctx, entry.ID); err != nil {
+				return "", err
+			}
+			changed = true
+			continue
+		}
+		if entry.Key != hostname || !entry.hasType("A") {
+			continue
+		}
+		existing = true
+		if !desired[entry.Value] && !stale(entry.Value) {
+			continue
+		}
+		if !desired[entry.Value] || kept[entry.Value] {
+			log.Printf("INFO: Deleting DNS record for %s with IP %s", hostname, entry.Value)
+			if err := c.deleteDNSEntry(ctx, entry.ID); err != nil {
+				return "", err
+			}
+			changed = true
+			continue
+		}
+		kept[entry.Value] = true
+		if ttl > 0 && entry.TTL != ttl {
+			log.Printf("INFO: Updating TTL of DNS record for %s with IP %s from %d to %d", hostname, entry.Value, entry.TTL, ttl)
+			if err := c.saveDNSEntry(ctx, entry.ID, aRecordPayload(hostname, entry.Value, ttl)); err != nil {
+				return "", err
+			}
+			changed = true
+		}
+	}
+
+	for _, ip := range ips {
+		if kept[ip] {
+			continue
 		}
-	} else {
-		// Create new record
 		log.Printf("INFO: Creating new DNS record for %s with IP %s", hostname, ip)
-		payload := map[string]interface{}{
-			"key":         hostname,
-			"record_type": "A",
-			"value":       ip,
-			"enabled":     true,
+		if err := c.saveDNSEntry(ctx, "", aRecordPayload(hostname, ip, ttl)); err != nil {
+			return "", err
 		}
+		kept[ip] = true
+		changed = true
+	}
 
-		jsonData, err := json.Marshal(payload)
-		if err != nil {
-			log.Printf("ERROR: Failed to marshal DNS create payload: %v", err)
-			return fmt.Errorf("failed to marshal DNS create payload: %w", err)
+	switch {
+	case !existing:
+		return recordCreated, nil
+	case changed:
+		return recordUpdated, nil
+	default:
+		log.Printf("INFO: DNS records for %s already match, no update needed", hostname)
+		return recordUnchanged, nil
+	}
+}
+
+// conflictingTypes reports whether entry must be removed before a record of
+// recordType can be published for the same name.
+func conflictingTypes(recordType string, entry DNSEntry) bool {
+	if recordType == "CNAME" {
+		return entry.hasType("A") || entry.hasType("AAAA")
+	}
+	return entry.hasType("CNAME")
+}
+
+// entryType returns the record type of entry, A when unset.
+func entryType(entry DNSEntry) string {
+	if entry.RecordType == "" {
+		return "A"
+	}
+	return entry.RecordType
+}
+
+func aRecordPayload(hostname, ip string, ttl int) map[string]interface{} {
+	return recordPayload(hostname, "A", ip, ttl)
+}
+
+func recordPayload(hostname, recordType, ip string, ttl int) map[string]interface{} {
+	payload := map[string]interface{}{
+		"key":         hostname,
+		"record_type": recordType,
+		"value":       ip,
+		"enabled":     true,
+	}
+	if ttl > 0 {
+		payload["ttl"] = ttl
+	}
+	return payload
+}
+
+// updateSRVRecord creates or updates the SRV record name pointing at target
+// and port.
+func (c *UniFiClient) updateSRVRecord(ctx context.Context, name, target string, port int) (string, error) {
+	log.Printf("INFO: Checking SRV record %s", name)
+
+	entries, err := c.GetStaticDNSEntries(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get DNS entries before update: %w", err)
+	}
+
+	payload := map[string]interface{}{
+		"key":         name,
+		"record_type": "SRV",
+		"value":       target,
+		"port":        port,
+		"priority":    0,
+		"weight":      0,
+		"enabled":     true,
+	}
+	for _, entry := range entries {
+		if entry.Key == name && entry.RecordType == "SRV" {
+			if entry.Value == target && entry.Port == port {
+				log.Printf("INFO: SRV record %s already points at %s:%d, no update needed", name, target, port)
+				return recordUnchanged, nil
+			}
+			log.Printf("INFO: Updating SRV record %s from %s:%d to %s:%d", name, entry.Value, entry.Port, target, port)
+			if err := c.saveDNSEntry(ctx, entry.ID, payload); err != nil {
+				return "", err
+			}
+			return recordUpdated, nil
 		}
+	}
 
-		req, err = http.NewRequest("POST", baseURL, bytes.NewBuffer(jsonData))
-		if err != nil {
-			log.Printf("ERROR: Failed to create DNS create request: %v", err)
-			return fmt.Errorf("failed to create DNS create request: %w", err)
+	log.Printf("INFO: Creating SRV record %s pointing at %s:%d", name, target, port)
+	if err := c.saveDNSEntry(ctx, "", payload); err != nil {
+		return "", err
+	}
+	return recordCreated, nil
+}
+
+// plannedSRVAction reports what updateSRVRecord would do for name without
+// changing anything on the controller.
+func (c *UniFiClient) plannedSRVAction(ctx context.Context, name, target string, port int) (string, error) {
+	entries, err := c.GetStaticDNSEntries(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get DNS entries: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.Key == name && entry.RecordType == "SRV" {
+			if entry.Value == target && entry.Port == port {
+				return recordUnchanged, nil
+			}
+			return recordUpdated, nil
 		}
 	}
+	return recordCreated, nil
+}
+
+// createDNSEntry creates an arbitrary static DNS entry, e.g. an ownership TXT
+// record.
+func (c *UniFiClient) createDNSEntry(ctx context.Context, entry DNSEntry) error {
+	log.Printf("INFO: Creating %s record %s", entry.RecordType, entry.Key)
+
+	payload := map[string]interface{}{
+		"key":         entry.Key,
+		"record_type": entry.RecordType,
+		"value":       entry.Value,
+		"enabled":     true,
+	}
+	if entry.TTL > 0 {
+		payload["ttl"] = entry.TTL
+	}
+	if err := c.saveDNSEntry(ctx, "", payload); err != nil {
+		return err
+	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-Csrf-Token", c.csrfToken)
+	log.Printf("INFO: Successfully created %s record %s", entry.RecordType, entry.Key)
+	return nil
+}
+
+// deleteDNSEntry deletes the static DNS entry with the given ID.
+func (c *UniFiClient) deleteDNSEntry(ctx context.Context, id string) error {
+	if err := c.resolveSite(ctx); err != nil {
+		return err
+	}
+
+	deleteURL := c.baseURL + c.apiPaths().staticDNS + "/" + id
+	req, err := http.NewRequestWithContext(ctx, "DELETE", deleteURL, nil)
+	if err != nil {
+		logError("Failed to create DNS delete request: %v", err)
+		return fmt.Errorf("failed to create DNS delete request: %w", err)
+	}
+	if err := c.authorize(req); err != nil {
+		return fmt.Errorf("failed to login before deleting DNS entry: %w", err)
+	}
 
-	resp, err := c.client.Do(req)
+	resp, err := c.do(req, endpointDelete)
 	if err != nil {
-		log.Printf("ERROR: Failed to send DNS request: %v", err)
+		logError("Failed to send DNS request: %v", err)
 		return fmt.Errorf("failed to send DNS request: %w", err)
 	}
 	defer func() {
 		if closeErr := resp.Body.Close(); closeErr != nil {
-			log.Printf("ERROR: Failed to close response body: %v", closeErr)
+			logError("Failed to close response body: %v", closeErr)
 		}
 	}()
 
+	if resp.StatusCode == http.StatusForbidden {
+		return fmt.Errorf("%w: %w", errWriteForbidden, newStatusError(resp.StatusCode, "DNS operation failed with status: %d"))
+	}
 	if resp.StatusCode != http.StatusOK {
-		log.Printf("ERROR: DNS operation failed with status code: %d", resp.StatusCode)
-		return fmt.Errorf("DNS operation failed with status: %d", resp.StatusCode)
+		logError("DNS operation failed with status code: %d", resp.StatusCode)
+		return newStatusError(resp.StatusCode, "DNS operation failed with status: %d")
 	}
+	return nil
+}
 
-	if existingEntry != nil {
-		log.Printf("INFO: Successfully updated DNS record for %s to IP %s", hostname, ip)
-	} else {
-		log.Printf("INFO: Successfully created new DNS record for %s with IP %s", hostname, ip)
+// saveDNSEntry replaces the static DNS entry with the given ID by payload, or
+// creates a new entry if id is empty.
+func (c *UniFiClient) saveDNSEntry(ctx context.Context, id string, payload map[string]interface{}) error {
+	if err := c.resolveSite(ctx); err != nil {
+		return err
+	}
+
+	method, url, endpoint := "POST", c.baseURL+c.apiPaths().staticDNS, endpointCreate
+	if id != "" {
+		method, url, endpoint = "PUT", url+"/"+id, endpointUpdate
+		payload["_id"] = id
+	}
+
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		logError("Failed to marshal DNS %s payload: %v", endpoint, err)
+		return fmt.Errorf("failed to marshal DNS %s payload: %w", endpoint, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		logError("Failed to create DNS %s request: %v", endpoint, err)
+		return fmt.Errorf("failed to create DNS %s request: %w", endpoint, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if err := c.authorize(req); err != nil {
+		return fmt.Errorf("failed to login before updating DNS: %w", err)
+	}
+
+	resp, err := c.do(req, endpoint)
+	if err != nil {
+		logError("Failed to send DNS request: %v", err)
+		return fmt.Errorf("failed to send DNS request: %w", err)
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			logError("Failed to close response body: %v", closeErr)
+		}
+	}()
+
+	if resp.StatusCode == http.StatusForbidden {
+		return fmt.Errorf("%w: %w", errWriteForbidden, newStatusError(resp.StatusCode, "DNS operation failed with status: %d"))
+	}
+	if resp.StatusCode != http.StatusOK {
+		logError("DNS operation failed with status code: %d", resp.StatusCode)
+		return newStatusError(resp.StatusCode, "DNS operation failed with status: %d")
 	}
 	return nil
 }
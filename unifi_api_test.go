@@ -1,12 +1,16 @@
 package traefikunifidns
 
 import (
+	"context"
+	"crypto/x509"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -17,14 +21,25 @@ func TestNewUniFiClient(t *testing.T) {
 	if client.username != "admin" {
 		t.Errorf("Expected username to be 'admin', got '%s'", client.username)
 	}
-	if client.password != "password" {
-		t.Errorf("Expected password to be 'password', got '%s'", client.password)
+	if client.password.reveal() != "password" {
+		t.Errorf("Expected password to be 'password', got '%s'", client.password.reveal())
 	}
 	if client.client.Jar == nil {
 		t.Error("Expected cookie jar to be initialized")
 	}
 }
 
+func TestCheckScheme(t *testing.T) {
+	for _, host := range []string{"unifi.lan", "https://unifi.lan", "http://localhost:8443", "http://127.0.0.1:8080", "http://[::1]"} {
+		assert.NoError(t, checkScheme(host, false), host)
+	}
+	assert.EqualError(t, checkScheme("http://unifi.lan", false), "http://unifi.lan uses plain HTTP, set allowInsecureHTTP to send credentials unencrypted")
+	assert.NoError(t, checkScheme("http://unifi.lan", true))
+
+	client := NewUniFiClient("http://unifi.lan", "admin", "password", false)
+	assert.Equal(t, "http://unifi.lan", client.baseURL, "an explicit scheme is kept")
+}
+
 func TestUniFiClientLogin(t *testing.T) {
 	// Create a test server
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -58,11 +73,11 @@ func TestUniFiClientLogin(t *testing.T) {
 		client:   &http.Client{},
 		baseURL:  server.URL,
 		username: "admin",
-		password: "password",
+		password: newSecret("password"),
 	}
 
 	// Test login
-	err := client.login()
+	err := client.login(context.Background())
 	if err != nil {
 		t.Fatalf("login returned error: %v", err)
 	}
@@ -78,10 +93,10 @@ func TestUniFiClientLoginErrors(t *testing.T) {
 			client:   &http.Client{},
 			baseURL:  "http://invalid-url-that-will-fail:12345",
 			username: "admin",
-			password: "password",
+			password: newSecret("password"),
 		}
 
-		err := client.login()
+		err := client.login(context.Background())
 		if err == nil {
 			t.Error("Expected error for invalid URL, got nil")
 		}
@@ -98,10 +113,10 @@ func TestUniFiClientLoginErrors(t *testing.T) {
 			client:   &http.Client{},
 			baseURL:  server.URL,
 			username: "admin",
-			password: "password",
+			password: newSecret("password"),
 		}
 
-		err := client.login()
+		err := client.login(context.Background())
 		if err == nil {
 			t.Error("Expected error for non-200 status code, got nil")
 		}
@@ -119,10 +134,10 @@ func TestUniFiClientLoginErrors(t *testing.T) {
 			client:   &http.Client{},
 			baseURL:  server.URL,
 			username: "admin",
-			password: "password",
+			password: newSecret("password"),
 		}
 
-		err := client.login()
+		err := client.login(context.Background())
 		if err == nil {
 			t.Error("Expected error for missing CSRF token, got nil")
 		}
@@ -176,11 +191,11 @@ func TestGetStaticDNSEntries(t *testing.T) {
 		client:   &http.Client{},
 		baseURL:  server.URL,
 		username: "admin",
-		password: "password",
+		password: newSecret("password"),
 	}
 
 	// Test GetStaticDNSEntries
-	entries, err := client.GetStaticDNSEntries()
+	entries, err := client.GetStaticDNSEntries(context.Background())
 	if err != nil {
 		t.Fatalf("GetStaticDNSEntries returned error: %v", err)
 	}
@@ -208,10 +223,10 @@ func TestGetStaticDNSEntriesErrors(t *testing.T) {
 			client:   &http.Client{},
 			baseURL:  "http://invalid-url-that-will-fail:12345",
 			username: "admin",
-			password: "password",
+			password: newSecret("password"),
 		}
 
-		_, err := client.GetStaticDNSEntries()
+		_, err := client.GetStaticDNSEntries(context.Background())
 		if err == nil {
 			t.Error("Expected error for invalid URL, got nil")
 		}
@@ -237,10 +252,10 @@ func TestGetStaticDNSEntriesErrors(t *testing.T) {
 			client:   &http.Client{},
 			baseURL:  server.URL,
 			username: "admin",
-			password: "password",
+			password: newSecret("password"),
 		}
 
-		_, err := client.GetStaticDNSEntries()
+		_, err := client.GetStaticDNSEntries(context.Background())
 		if err == nil {
 			t.Error("Expected error for non-200 status code, got nil")
 		}
@@ -373,31 +388,40 @@ func TestUniFiClientUpdateDNSRecord(t *testing.T) {
 		client:   &http.Client{},
 		baseURL:  server.URL,
 		username: "admin",
-		password: "password",
+		password: newSecret("password"),
 	}
 
 	// Test case 1: Update existing record with new IP
 	t.Run("Update existing record with new IP", func(t *testing.T) {
-		err := client.updateDNSRecord("example.com", "192.168.1.200")
+		action, err := client.updateDNSRecord(context.Background(), "example.com", "192.168.1.200", 0)
 		if err != nil {
 			t.Fatalf("updateDNSRecord returned error: %v", err)
 		}
+		if action != recordUpdated {
+			t.Errorf("Expected action %q, got %q", recordUpdated, action)
+		}
 	})
 
 	// Test case 2: No update needed (same IP)
 	t.Run("No update needed - same IP", func(t *testing.T) {
-		err := client.updateDNSRecord("example.com", "192.168.1.100")
+		action, err := client.updateDNSRecord(context.Background(), "example.com", "192.168.1.100", 0)
 		if err != nil {
 			t.Fatalf("updateDNSRecord returned error: %v", err)
 		}
+		if action != recordUnchanged {
+			t.Errorf("Expected action %q, got %q", recordUnchanged, action)
+		}
 	})
 
 	// Test case 3: Update non-existent record
 	t.Run("Update non-existent record", func(t *testing.T) {
-		err := client.updateDNSRecord("newdomain.com", "192.168.1.200")
+		action, err := client.updateDNSRecord(context.Background(), "newdomain.com", "192.168.1.200", 0)
 		if err != nil {
 			t.Fatalf("updateDNSRecord returned error: %v", err)
 		}
+		if action != recordCreated {
+			t.Errorf("Expected action %q, got %q", recordCreated, action)
+		}
 	})
 
 	// Test case 4: Empty DNS entries
@@ -406,7 +430,7 @@ func TestUniFiClientUpdateDNSRecord(t *testing.T) {
 			headers: map[string]string{"X-Test-Empty-DNS": "true"},
 			base:    http.DefaultTransport,
 		}
-		err := client.updateDNSRecord("example.com", "192.168.1.200")
+		_, err := client.updateDNSRecord(context.Background(), "example.com", "192.168.1.200", 0)
 		if err != nil {
 			t.Fatalf("updateDNSRecord returned error: %v", err)
 		}
@@ -418,7 +442,7 @@ func TestUniFiClientUpdateDNSRecord(t *testing.T) {
 			headers: map[string]string{"X-Test-Invalid-JSON": "true"},
 			base:    http.DefaultTransport,
 		}
-		err := client.updateDNSRecord("example.com", "192.168.1.200")
+		_, err := client.updateDNSRecord(context.Background(), "example.com", "192.168.1.200", 0)
 		if err == nil {
 			t.Fatal("Expected error for invalid JSON response, got nil")
 		}
@@ -539,7 +563,7 @@ func TestUniFiClientUpdateDNSRecordErrors(t *testing.T) {
 		client:   &http.Client{},
 		baseURL:  server.URL,
 		username: "admin",
-		password: "password",
+		password: newSecret("password"),
 	}
 
 	// Test case 1: HTTP request error
@@ -548,7 +572,7 @@ func TestUniFiClientUpdateDNSRecordErrors(t *testing.T) {
 			headers: map[string]string{"X-Test-HTTP-Error": "true"},
 			base:    http.DefaultTransport,
 		}
-		err := client.updateDNSRecord("example.com", "192.168.1.200")
+		_, err := client.updateDNSRecord(context.Background(), "example.com", "192.168.1.200", 0)
 		if err == nil {
 			t.Fatal("Expected error for HTTP request error, got nil")
 		}
@@ -563,7 +587,7 @@ func TestUniFiClientUpdateDNSRecordErrors(t *testing.T) {
 			headers: map[string]string{"X-Test-Non-200": "true"},
 			base:    http.DefaultTransport,
 		}
-		err := client.updateDNSRecord("example.com", "192.168.1.200")
+		_, err := client.updateDNSRecord(context.Background(), "example.com", "192.168.1.200", 0)
 		if err == nil {
 			t.Fatal("Expected error for non-200 status code, got nil")
 		}
@@ -578,7 +602,7 @@ func TestUniFiClientUpdateDNSRecordErrors(t *testing.T) {
 			headers: map[string]string{"X-Test-Non-200": "true"},
 			base:    http.DefaultTransport,
 		}
-		err := client.updateDNSRecord("example.com", "192.168.1.200")
+		_, err := client.updateDNSRecord(context.Background(), "example.com", "192.168.1.200", 0)
 		if err == nil {
 			t.Fatal("Expected error for non-200 status code, got nil")
 		}
@@ -587,3 +611,260 @@ func TestUniFiClientUpdateDNSRecordErrors(t *testing.T) {
 		}
 	})
 }
+
+func TestUniFiClientUpdateDNSRecordTTL(t *testing.T) {
+	var puts []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/auth/login":
+			w.Header().Set("X-Csrf-Token", "test-csrf-token")
+			w.WriteHeader(http.StatusOK)
+		case "/proxy/network/v2/api/site/default/static-dns":
+			entries := []DNSEntry{
+				{Key: "pbx.lan", Value: "192.168.1.100", ID: "1", TTL: 3600},
+			}
+			if err := json.NewEncoder(w).Encode(entries); err != nil {
+				t.Errorf("Failed to encode entries: %v", err)
+			}
+		case "/proxy/network/v2/api/site/default/static-dns/1":
+			var payload map[string]interface{}
+			if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+				t.Fatalf("Failed to decode DNS update request body: %v", err)
+			}
+			puts = append(puts, payload)
+			w.WriteHeader(http.StatusOK)
+		default:
+			t.Errorf("Unexpected path: %s", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client := &UniFiClient{
+		client:   &http.Client{},
+		baseURL:  server.URL,
+		username: "admin",
+		password: newSecret("password"),
+	}
+
+	t.Run("No override leaves TTL alone", func(t *testing.T) {
+		puts = nil
+		_, err := client.updateDNSRecord(context.Background(), "pbx.lan", "192.168.1.100", 0)
+		require.NoError(t, err)
+		require.Empty(t, puts)
+	})
+
+	t.Run("Matching override needs no update", func(t *testing.T) {
+		puts = nil
+		_, err := client.updateDNSRecord(context.Background(), "pbx.lan", "192.168.1.100", 3600)
+		require.NoError(t, err)
+		require.Empty(t, puts)
+	})
+
+	t.Run("Differing override updates TTL", func(t *testing.T) {
+		puts = nil
+		_, err := client.updateDNSRecord(context.Background(), "pbx.lan", "192.168.1.100", 60)
+		require.NoError(t, err)
+		require.Len(t, puts, 1)
+		require.Equal(t, float64(60), puts[0]["ttl"])
+		require.Equal(t, "192.168.1.100", puts[0]["value"])
+	})
+}
+
+func TestUpdateSRVRecord(t *testing.T) {
+	var writes []map[string]interface{}
+	server := newTestUniFiServer(t, []DNSEntry{
+		{ID: "srv1", Key: "_ha._tcp.ha.lan", Value: "192.168.1.30", RecordType: "SRV", Port: 8123},
+	}, &writes)
+	client := NewUniFiClient(server.URL, "admin", "password", false)
+
+	action, err := client.updateSRVRecord(context.Background(), "_ha._tcp.ha.lan", "192.168.1.30", 8123)
+	require.NoError(t, err)
+	assert.Equal(t, recordUnchanged, action)
+	assert.Empty(t, writes)
+
+	action, err = client.updateSRVRecord(context.Background(), "_ha._tcp.ha.lan", "192.168.1.30", 8124)
+	require.NoError(t, err)
+	assert.Equal(t, recordUpdated, action)
+	require.Len(t, writes, 1)
+	assert.Equal(t, "srv1", writes[0]["_id"])
+	assert.Equal(t, float64(8124), writes[0]["port"])
+
+	action, err = client.updateSRVRecord(context.Background(), "_nas._tcp.nas.lan", "192.168.1.40", 5000)
+	require.NoError(t, err)
+	assert.Equal(t, recordCreated, action)
+	require.Len(t, writes, 2)
+	assert.Equal(t, "SRV", writes[1]["record_type"])
+}
+
+func TestUpdateDNSRecords(t *testing.T) {
+	var writes []map[string]interface{}
+	server := newTestUniFiServer(t, []DNSEntry{
+		{ID: "a1", Key: "app.lan", Value: "192.168.1.11", RecordType: "A", TTL: 60},
+		{ID: "a2", Key: "app.lan", Value: "192.168.1.12", RecordType: "A", TTL: 60},
+		{ID: "a3", Key: "app.lan", Value: "192.168.1.12", RecordType: "A", TTL: 60},
+	}, &writes)
+	client := NewUniFiClient(server.URL, "admin", "password", false)
+	stale := func(string) bool { return true }
+
+	action, err := client.updateDNSRecords(context.Background(), "app.lan", []string{"192.168.1.11", "192.168.1.12"}, 60, stale)
+	require.NoError(t, err)
+	assert.Equal(t, recordUpdated, action)
+	assert.Equal(t, []map[string]interface{}{{"deleted": "a3"}}, writes, "duplicate records are removed")
+
+	writes = nil
+	action, err = client.updateDNSRecords(context.Background(), "app.lan", []string{"192.168.1.12", "192.168.1.13"}, 300, stale)
+	require.NoError(t, err)
+	assert.Equal(t, recordUpdated, action)
+	require.Len(t, writes, 4)
+	assert.Equal(t, map[string]interface{}{"deleted": "a1"}, writes[0])
+	assert.Equal(t, "a2", writes[1]["_id"])
+	assert.Equal(t, float64(300), writes[1]["ttl"])
+	assert.Equal(t, map[string]interface{}{"deleted": "a3"}, writes[2])
+	assert.Equal(t, "192.168.1.13", writes[3]["value"])
+
+	writes = nil
+	action, err = client.updateDNSRecords(context.Background(), "new.lan", []string{"192.168.1.11", "192.168.1.12"}, 0, stale)
+	require.NoError(t, err)
+	assert.Equal(t, recordCreated, action)
+	assert.Len(t, writes, 2)
+}
+
+func TestGetWANIP(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		want    string
+		wantErr string
+	}{
+		{name: "wan subsystem", status: http.StatusOK, body: `{"data":[{"subsystem":"www"},{"subsystem":"wan","wan_ip":"203.0.113.7"}]}`, want: "203.0.113.7"},
+		{name: "no wan ip", status: http.StatusOK, body: `{"data":[{"subsystem":"wan"}]}`, wantErr: "controller did not report a WAN IP"},
+		{name: "server error", status: http.StatusInternalServerError, wantErr: "failed to get health with status: 500"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/api/auth/login" {
+					w.Header().Set("X-Csrf-Token", "test-csrf-token")
+					return
+				}
+				assert.Equal(t, "/proxy/network/api/s/default/stat/health", r.URL.Path)
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			ip, err := NewUniFiClient(server.URL, "admin", "password", false).GetWANIP(context.Background())
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, ip)
+		})
+	}
+}
+
+func TestUniFiClientReadOnly(t *testing.T) {
+	var writes []map[string]interface{}
+	server := newTestUniFiServer(t, []DNSEntry{
+		{ID: "1", Key: "app.lan", RecordType: "A", Value: "192.168.1.10"},
+	}, &writes)
+	client := NewUniFiClient(server.URL, "admin", "password", false)
+	client.setReadOnly()
+
+	action, err := client.updateDNSRecord(context.Background(), "app.lan", "192.168.1.10", 0)
+	require.NoError(t, err, "reads and logins are allowed")
+	assert.Equal(t, recordUnchanged, action)
+
+	_, err = client.updateDNSRecord(context.Background(), "app.lan", "192.168.1.11", 0)
+	assert.ErrorIs(t, err, errReadOnly)
+	assert.ErrorIs(t, client.deleteDNSEntry(context.Background(), "1"), errReadOnly)
+	assert.Empty(t, writes)
+}
+
+func TestUniFiClientTLSServerName(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Csrf-Token", "test-csrf-token")
+	}))
+	defer server.Close()
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+
+	// The test certificate is valid for example.com, the server is reached
+	// by IP address
+	for serverName, ok := range map[string]bool{"example.com": true, "unifi.lan": false} {
+		client := NewUniFiClient(server.URL, "admin", "password", false)
+		client.setTLSServerName(serverName)
+		client.transport.TLSClientConfig.RootCAs = pool
+
+		err := client.login(context.Background())
+		if ok {
+			assert.NoError(t, err, serverName)
+		} else {
+			assert.Error(t, err, serverName)
+		}
+	}
+}
+
+func TestUniFiClientHeaders(t *testing.T) {
+	var userAgents, apiHeaders []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgents = append(userAgents, r.UserAgent())
+		apiHeaders = append(apiHeaders, r.Header.Get("X-Api-Gateway"))
+		if r.URL.Path == "/api/auth/login" {
+			w.Header().Set("X-Csrf-Token", "test-csrf-token")
+			return
+		}
+		_, _ = w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	client := NewUniFiClient(server.URL, "admin", "password", false)
+	client.setHeaders("traefikunifidns/1.0", map[string]string{"x-api-gateway": "homelab"})
+	_, err := client.GetStaticDNSEntries(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"traefikunifidns/1.0", "traefikunifidns/1.0"}, userAgents)
+	assert.Equal(t, []string{"homelab", "homelab"}, apiHeaders)
+}
+
+func TestUniFiClientProxyAuth(t *testing.T) {
+	var cookies, tokens []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie("CF_Authorization")
+		if err == nil {
+			cookies = append(cookies, cookie.Value)
+		}
+		tokens = append(tokens, r.Header.Get("Remote-Token"))
+		if r.URL.Path == "/api/auth/login" {
+			w.Header().Set("X-Csrf-Token", "test-csrf-token")
+			return
+		}
+		_, _ = w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	client := NewUniFiClient(server.URL, "admin", "password", false)
+	client.setProxyAuth(map[string]string{"CF_Authorization": "jwt"}, "Remote-Token: abc: def")
+	_, err := client.GetStaticDNSEntries(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"jwt", "jwt"}, cookies)
+	assert.Equal(t, []string{"abc: def", "abc: def"}, tokens)
+	assert.NotContains(t, fmt.Sprintf("%+v", client.client.Transport), "jwt")
+}
+
+func TestParseHeaderLine(t *testing.T) {
+	name, value, ok := parseHeaderLine(" Authorization : Bearer x ")
+	assert.True(t, ok)
+	assert.Equal(t, "Authorization", name)
+	assert.Equal(t, "Bearer x", value)
+
+	for _, line := range []string{"", "Authorization", ": value"} {
+		_, _, ok := parseHeaderLine(line)
+		assert.False(t, ok, line)
+	}
+}
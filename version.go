@@ -0,0 +1,22 @@
+package traefikunifidns
+
+import "runtime"
+
+// Version identifies the running build of the plugin. Traefik interprets the
+// plugin from source, so the value committed for a release tag is what runs;
+// the release workflow refuses tags that don't match it. Compiled builds may
+// override it with -ldflags "-X github.com/horknfbr/traefikunifidns.Version=...".
+var Version = "dev"
+
+// versionHeader carries Version on every admin response.
+const versionHeader = "X-UniFiDNS-Version"
+
+// buildInfo describes the running build in the status document.
+type buildInfo struct {
+	Version   string `json:"version"`
+	GoVersion string `json:"goVersion"` // Of the Go toolchain or the Traefik binary running the interpreter
+}
+
+func currentBuild() buildInfo {
+	return buildInfo{Version: Version, GoVersion: runtime.Version()}
+}
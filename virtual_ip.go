@@ -0,0 +1,46 @@
+package traefikunifidns
+
+import (
+	"log"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultVirtualIPPort = 443
+	virtualIPTimeout     = 2 * time.Second
+)
+
+// virtualIP publishes a keepalived style virtual IP while it accepts TCP
+// connections, and falls back to the local node IP while it does not.
+type virtualIP struct {
+	address string // host:port probed before every cycle
+	ip      string
+	down    bool // Whether the last probe failed, to log transitions once
+}
+
+func newVirtualIP(ip string, port int) *virtualIP {
+	if port <= 0 {
+		port = defaultVirtualIPPort
+	}
+	return &virtualIP{address: net.JoinHostPort(ip, strconv.Itoa(port)), ip: ip}
+}
+
+// target returns the virtual IP if it is healthy and localIP otherwise.
+func (v *virtualIP) target(localIP string) string {
+	conn, err := net.DialTimeout("tcp", v.address, virtualIPTimeout)
+	if err != nil {
+		if !v.down {
+			log.Printf("WARN: Virtual IP %s is unhealthy, falling back to local IP %s: %v", v.ip, localIP, err)
+		}
+		v.down = true
+		return localIP
+	}
+	_ = conn.Close()
+	if v.down {
+		log.Printf("INFO: Virtual IP %s recovered", v.ip)
+	}
+	v.down = false
+	return v.ip
+}
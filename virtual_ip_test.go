@@ -0,0 +1,47 @@
+package traefikunifidns
+
+import (
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVirtualIPTarget(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := listener.Addr().(*net.TCPAddr).Port
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+
+	vip := newVirtualIP("127.0.0.1", port)
+	assert.Equal(t, "127.0.0.1:"+strconv.Itoa(port), vip.address)
+	assert.Equal(t, "127.0.0.1", vip.target("192.168.1.10"))
+	assert.False(t, vip.down)
+
+	require.NoError(t, listener.Close())
+	assert.Equal(t, "192.168.1.10", vip.target("192.168.1.10"), "falls back to the local IP")
+	assert.True(t, vip.down)
+}
+
+func TestNewVirtualIPDefaultPort(t *testing.T) {
+	assert.Equal(t, "192.168.1.5:443", newVirtualIP("192.168.1.5", 0).address)
+}
+
+func TestNewInvalidVirtualIP(t *testing.T) {
+	config := CreateConfig()
+	config.VirtualIP = "not-an-ip"
+
+	_, err := newReconciler(config)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `invalid virtual IP: "not-an-ip"`)
+}
@@ -0,0 +1,224 @@
+package traefikunifidns
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Actions sent to webhook providers.
+const (
+	webhookCreate = "create"
+	webhookUpdate = "update"
+	webhookDelete = "delete"
+)
+
+// webhookRecord is the record representation used in webhook payloads.
+type webhookRecord struct {
+	Hostname string `json:"hostname"`
+	Type     string `json:"type"`
+	Value    string `json:"value"`
+	TTL      int    `json:"ttl,omitempty"`
+}
+
+// webhookChange is the JSON document POSTed to a webhook provider for every
+// record change:
+//
+//	{
+//	  "action": "create" | "update" | "delete",
+//	  "record": {"hostname": "app.lan", "type": "A", "value": "192.168.1.10", "ttl": 60},
+//	  "previous": {"hostname": "app.lan", "type": "A", "value": "192.168.1.9"}
+//	}
+//
+// "record" is the desired record for create and update, and the removed
+// record for delete. "previous" is only set for update. "ttl" is omitted when
+// no TTL override applies. Any 2xx response acknowledges the change.
+type webhookChange struct {
+	Action   string         `json:"action"`
+	Record   webhookRecord  `json:"record"`
+	Previous *webhookRecord `json:"previous,omitempty"`
+}
+
+// webhookProvider publishes record changes to a user-supplied HTTP endpoint,
+// bridging to DNS systems without native support. It remembers what it has
+// published so only actual changes are sent.
+type webhookProvider struct {
+	client    *http.Client
+	url       string
+	mu        sync.Mutex
+	published map[string]webhookRecord
+	stats     deviceStats
+}
+
+func newWebhookProvider(url string, insecureSkipVerify bool) *webhookProvider {
+	log.Printf("INFO: Creating new webhook provider for URL: %s (insecureSkipVerify: %v)", url, insecureSkipVerify)
+	return &webhookProvider{
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					InsecureSkipVerify: insecureSkipVerify,
+				},
+			},
+		},
+		url:       url,
+		published: make(map[string]webhookRecord),
+	}
+}
+
+func (w *webhookProvider) health() *deviceStats {
+	return &w.stats
+}
+
+func (w *webhookProvider) String() string {
+	return w.url
+}
+
+// plannedAction compares the desired record against what the provider has
+// published itself.
+func (w *webhookProvider) plannedAction(_ context.Context, hostname, ip string, ttl int) (string, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	previous, exists := w.published[hostname]
+	switch {
+	case !exists:
+		return recordCreated, nil
+	case previous == webhookRecord{Hostname: hostname, Type: "A", Value: ip, TTL: ttl}:
+		return recordUnchanged, nil
+	default:
+		return recordUpdated, nil
+	}
+}
+
+func (w *webhookProvider) updateDNSRecord(ctx context.Context, hostname, ip string, ttl int) (string, error) {
+	record := webhookRecord{Hostname: hostname, Type: "A", Value: ip, TTL: ttl}
+
+	w.mu.Lock()
+	previous, exists := w.published[hostname]
+	w.mu.Unlock()
+
+	change := webhookChange{Action: webhookCreate, Record: record}
+	if exists {
+		if previous == record {
+			log.Printf("INFO: Webhook record for %s already has IP %s, no update needed", hostname, ip)
+			return recordUnchanged, nil
+		}
+		change.Action = webhookUpdate
+		change.Previous = &previous
+	}
+
+	if err := w.send(ctx, change); err != nil {
+		return "", err
+	}
+
+	w.mu.Lock()
+	w.published[hostname] = record
+	w.mu.Unlock()
+	if exists {
+		return recordUpdated, nil
+	}
+	return recordCreated, nil
+}
+
+// records returns the published records of hostnames.
+func (w *webhookProvider) records(hostnames map[string]time.Time) map[string]webhookRecord {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	records := make(map[string]webhookRecord, len(hostnames))
+	for hostname := range hostnames {
+		if record, ok := w.published[hostname]; ok {
+			records[hostname] = record
+		}
+	}
+	return records
+}
+
+// restore adds records published before a restart.
+func (w *webhookProvider) restore(records map[string]webhookRecord) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for hostname, record := range records {
+		w.published[hostname] = record
+	}
+}
+
+func (w *webhookProvider) orphans(active map[string]bool) []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var hostnames []string
+	for hostname := range w.published {
+		if !active[hostname] {
+			hostnames = append(hostnames, hostname)
+		}
+	}
+	return hostnames
+}
+
+func (w *webhookProvider) prune(ctx context.Context, active map[string]bool, limit int) ([]string, error) {
+	w.mu.Lock()
+	var stale []webhookRecord
+	for hostname, record := range w.published {
+		if !active[hostname] {
+			stale = append(stale, record)
+		}
+	}
+	w.mu.Unlock()
+	if limit >= 0 && len(stale) > limit {
+		return nil, fmt.Errorf("%w: %d stale records, %d deletions left", errChangeQuota, len(stale), limit)
+	}
+
+	var deleted []string
+	for _, record := range stale {
+		if err := w.send(ctx, webhookChange{Action: webhookDelete, Record: record}); err != nil {
+			return deleted, err
+		}
+		w.mu.Lock()
+		delete(w.published, record.Hostname)
+		w.mu.Unlock()
+		deleted = append(deleted, record.Hostname)
+	}
+	return deleted, nil
+}
+
+func (w *webhookProvider) send(ctx context.Context, change webhookChange) error {
+	log.Printf("INFO: Sending webhook %s for %s", change.Action, change.Record.Hostname)
+
+	jsonData, err := json.Marshal(change)
+	if err != nil {
+		logError("Failed to marshal webhook payload: %v", err)
+		return fmt.Errorf("failed to marshal webhook payload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", w.url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		logError("Failed to create webhook request: %v", err)
+		return fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	start := time.Now()
+	resp, err := w.client.Do(req)
+	w.stats.recordRequest(change.Action, time.Since(start))
+	if err != nil {
+		logError("Failed to send webhook request: %v", err)
+		return fmt.Errorf("failed to send webhook request: %w", err)
+	}
+	w.stats.trackResponseSize(change.Action, resp)
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			logError("Failed to close response body: %v", closeErr)
+		}
+	}()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		logError("Webhook failed with status code: %d", resp.StatusCode)
+		return newStatusError(resp.StatusCode, "webhook failed with status: %d")
+	}
+	return nil
+}
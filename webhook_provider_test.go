@@ -0,0 +1,164 @@
+package traefikunifidns
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestWebhookServer(t *testing.T, changes *[]webhookChange) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Expected Content-Type 'application/json', got '%s'", r.Header.Get("Content-Type"))
+		}
+		var change webhookChange
+		if err := json.NewDecoder(r.Body).Decode(&change); err != nil {
+			t.Errorf("Failed to decode webhook body: %v", err)
+		}
+		*changes = append(*changes, change)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestWebhookProvider(t *testing.T) {
+	var changes []webhookChange
+	server := newTestWebhookServer(t, &changes)
+	w := newWebhookProvider(server.URL, false)
+
+	for _, tc := range []struct {
+		ip     string
+		ttl    int
+		action string
+	}{
+		{"192.168.1.10", 0, recordCreated},
+		{"192.168.1.10", 0, recordUnchanged},
+		{"192.168.1.11", 60, recordUpdated},
+	} {
+		action, err := w.updateDNSRecord(context.Background(), "app.lan", tc.ip, tc.ttl)
+		require.NoError(t, err)
+		assert.Equal(t, tc.action, action)
+	}
+	deleted, err := w.prune(context.Background(), map[string]bool{"app.lan": true}, -1)
+	require.NoError(t, err)
+	assert.Empty(t, deleted)
+	deleted, err = w.prune(context.Background(), map[string]bool{}, -1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"app.lan"}, deleted)
+
+	require.Len(t, changes, 3)
+	assert.Equal(t, webhookChange{
+		Action: webhookCreate,
+		Record: webhookRecord{Hostname: "app.lan", Type: "A", Value: "192.168.1.10"},
+	}, changes[0])
+	assert.Equal(t, webhookChange{
+		Action:   webhookUpdate,
+		Record:   webhookRecord{Hostname: "app.lan", Type: "A", Value: "192.168.1.11", TTL: 60},
+		Previous: &webhookRecord{Hostname: "app.lan", Type: "A", Value: "192.168.1.10"},
+	}, changes[1])
+	assert.Equal(t, webhookChange{
+		Action: webhookDelete,
+		Record: webhookRecord{Hostname: "app.lan", Type: "A", Value: "192.168.1.11", TTL: 60},
+	}, changes[2])
+	assert.Empty(t, w.published)
+}
+
+func TestWebhookProviderErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	w := newWebhookProvider(server.URL, false)
+	_, err := w.updateDNSRecord(context.Background(), "app.lan", "192.168.1.10", 0)
+	assert.EqualError(t, err, "webhook failed with status: 502")
+	assert.Empty(t, w.published, "failed changes must be retried next cycle")
+
+	w = newWebhookProvider("http://invalid-url-that-will-fail:12345", false)
+	_, err = w.updateDNSRecord(context.Background(), "app.lan", "192.168.1.10", 0)
+	assert.Error(t, err)
+}
+
+func TestUpdateDNSWebhookDevice(t *testing.T) {
+	traefikServer := newTestTraefikServer(t, []map[string]interface{}{
+		{"name": "app", "rule": "Host(`app.lan`)", "middlewares": []string{"traefikunifidns"}},
+	})
+	var changes []webhookChange
+	webhookServer := newTestWebhookServer(t, &changes)
+
+	config := CreateConfig()
+	config.TraefikAPIURL = traefikServer.URL
+	config.Devices = []UnifiDeviceConfig{{WebhookURL: webhookServer.URL, Pattern: `\.lan$`}}
+	config.IPOverrides = map[string]string{"app.lan": "192.168.1.10"}
+
+	plugin, err := New(context.Background(), nil, config, "test")
+	require.NoError(t, err)
+
+	require.Len(t, changes, 1)
+	assert.Equal(t, webhookCreate, changes[0].Action)
+	assert.Equal(t, "app.lan", changes[0].Record.Hostname)
+
+	status := plugin.(*UniFiDNS).status()
+	require.Len(t, status.Devices, 1)
+	assert.Equal(t, webhookServer.URL, status.Devices[0].Host)
+	assert.Equal(t, 1, status.Devices[0].ManagedRecords)
+}
+
+func TestUpdateDNSNeverManage(t *testing.T) {
+	rules := []string{"Host(`app.lan`)", "Host(`gateway.lan`)"}
+	traefikServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		routers := make([]map[string]interface{}, 0, len(rules))
+		for _, rule := range rules {
+			routers = append(routers, map[string]interface{}{"rule": rule, "middlewares": []string{"traefikunifidns"}})
+		}
+		_ = json.NewEncoder(w).Encode(routers)
+	}))
+	defer traefikServer.Close()
+	var changes []webhookChange
+	webhookServer := newTestWebhookServer(t, &changes)
+
+	config := CreateConfig()
+	config.TraefikAPIURL = traefikServer.URL
+	config.Devices = []UnifiDeviceConfig{{WebhookURL: webhookServer.URL, Pattern: `\.lan$`}}
+	config.IPOverrides = map[string]string{"app.lan": "192.168.1.10", "gateway.lan": "192.168.1.1"}
+
+	plugin, err := New(context.Background(), nil, config, "test")
+	require.NoError(t, err)
+	require.Len(t, changes, 2)
+
+	// Records published before the hostname was protected are left alone
+	config.NeverManage = []string{"gateway.lan"}
+	config.IPOverrides["gateway.lan"] = "192.168.1.2"
+	require.NoError(t, plugin.(*UniFiDNS).updateDNS(context.Background()).Err)
+	rules = rules[:1]
+	require.NoError(t, plugin.(*UniFiDNS).updateDNS(context.Background()).Err)
+
+	assert.Len(t, changes, 2, "neither updated nor deleted")
+	results := plugin.(*UniFiDNS).results
+	require.Len(t, results, 1)
+	assert.Equal(t, "app.lan", results[0].Hostname)
+}
+
+func TestWebhookProviderPlannedAction(t *testing.T) {
+	w := newWebhookProvider("http://localhost", false)
+	w.published["app.lan"] = webhookRecord{Hostname: "app.lan", Type: "A", Value: "192.168.1.10"}
+
+	for _, tc := range []struct {
+		hostname, ip, action string
+	}{
+		{"app.lan", "192.168.1.10", recordUnchanged},
+		{"app.lan", "192.168.1.11", recordUpdated},
+		{"nas.lan", "192.168.1.20", recordCreated},
+	} {
+		action, err := w.plannedAction(context.Background(), tc.hostname, tc.ip, 0)
+		require.NoError(t, err)
+		assert.Equal(t, tc.action, action)
+	}
+}